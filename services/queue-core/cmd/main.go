@@ -2,18 +2,27 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
+	"net/netip"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/nats-io/nats.go"
 
 	"queue-core/internal/api"
+	"queue-core/internal/bus"
 	"queue-core/internal/db"
+	"queue-core/internal/mqttbridge"
 	"queue-core/internal/repositories"
 	"queue-core/internal/services"
+	"queue-core/pkg/events"
 )
 
 func main() {
@@ -23,33 +32,320 @@ func main() {
 	}
 
 	// --- DATABASE CONNECTION ---
+	// STORE=memory keeps tickets in process memory instead: no Postgres, and
+	// nothing survives a restart. Customers, feedback, webhooks, per-queue
+	// rules and worker capacity need Postgres and are disabled.
 	connStr := os.Getenv("DATABASE_URL")
-	dbConn, err := db.Connect(connStr)
-	if err != nil {
-		log.Fatalf("DB connection failed: %v", err)
+	var dbConn *sql.DB
+	switch store := envString("STORE", "postgres"); store {
+	case "postgres":
+		conn, err := db.Connect(connStr)
+		if err != nil {
+			log.Fatalf("DB connection failed: %v", err)
+		}
+		dbConn = conn
+	case "memory":
+	default:
+		log.Fatalf("Invalid STORE %q", store)
 	}
 
-	// --- REDIS CONNECTION ---
-	redisConn := db.NewRedisClient() // reads UPSTASH_REDIS_URL and UPSTASH_REDIS_TOKEN from env
-	rdb := redisConn.Client
-
-	// --- REPOSITORIES ---
-	ticketRepo := repositories.NewTicketRepo(dbConn)
+	// --- EVENT BUS ---
+	// EVENT_BUS=nats carries events over NATS JetStream at NATS_URL instead of
+	// Redis; EVENT_BUS=memory keeps them in process memory, the default with
+	// STORE=memory
+	defaultBus := "redis"
+	if dbConn == nil {
+		defaultBus = "memory"
+	}
+	var eventBus bus.EventBus
+	switch transport := envString("EVENT_BUS", defaultBus); transport {
+	case "redis":
+		redisConn := db.NewRedisClient() // reads UPSTASH_REDIS_URL and UPSTASH_REDIS_TOKEN from env
+		eventBus = bus.NewRedis(redisConn.Client)
+	case "nats":
+		nc, err := db.ConnectNATS(envString("NATS_URL", nats.DefaultURL))
+		if err != nil {
+			log.Fatalf("NATS connection failed: %v", err)
+		}
+		natsBus, err := bus.NewNATS(nc)
+		if err != nil {
+			log.Fatalf("JetStream unavailable: %v", err)
+		}
+		natsBus.Replicas = int(envInt("NATS_STREAM_REPLICAS", 1))
+		eventBus = natsBus
+	case "memory":
+		eventBus = bus.NewMemory()
+	default:
+		log.Fatalf("Invalid EVENT_BUS %q", transport)
+	}
 
 	// --- SERVICES ---
 	streamName := "queue.stream"
 	pubSubBase := "queue.%d.broadcast"
-	ticketService := services.NewTicketService(ticketRepo, rdb, streamName, pubSubBase)
+	var ticketService *services.TicketService
+	var memoryRepo *repositories.MemoryTicketRepository
+	if dbConn != nil {
+		ticketService = services.NewTicketService(repositories.NewTicketRepo(dbConn), eventBus, streamName, pubSubBase)
+		ticketService.Queues = repositories.NewQueueRepo(dbConn)
+		// reserve only what advertised worker capacity and stream lag allow;
+		// opt-in, as a queue nobody heartbeats for would never dispatch
+		if os.Getenv("DISPATCH_BY_CAPACITY") == "true" {
+			ticketService.CapacityPolicy = &services.CapacityPolicy{
+				Workers:      repositories.NewWorkerRepo(dbConn),
+				HeartbeatTTL: 30 * time.Second,
+				MaxStreamLag: envInt("MAX_STREAM_LAG", 500),
+			}
+		}
+	} else {
+		memoryRepo = repositories.NewMemoryTicketRepo()
+		ticketService = services.NewTicketService(memoryRepo, eventBus, streamName, pubSubBase)
+		// the store wakes dispatchers as the ticket_ready notification does
+		ticketService.Wakeups = memoryRepo
+	}
+	// reserve in batches while a queue has a deep backlog
+	ticketService.Batch = &services.BatchPolicy{
+		Size:      int(envInt("DISPATCH_BATCH_SIZE", 50)),
+		Threshold: int(envInt("DISPATCH_BATCH_THRESHOLD", 100)),
+	}
+	// repair reservations whose stream entry was lost and pending entries of archived tickets
+	ticketService.Reconcile = &services.ReconcilePolicy{
+		Interval:     time.Minute,
+		Grace:        30 * time.Second,
+		Window:       time.Hour,
+		MissingEntry: services.MissingEntryAction(envString("RECONCILE_MISSING_ENTRY", string(services.MissingEntryReemit))),
+		AckOrphans:   os.Getenv("RECONCILE_ACK_ORPHANS") != "false",
+	}
+	if !ticketService.Reconcile.MissingEntry.Valid() {
+		log.Fatalf("Invalid RECONCILE_MISSING_ENTRY %q", ticketService.Reconcile.MissingEntry)
+	}
+	// EVENT_FORMAT=cloudevents emits CloudEvents 1.0 structured JSON instead
+	ticketService.Encoding = events.Encoding{
+		Format: events.Format(os.Getenv("EVENT_FORMAT")),
+		Source: envString("EVENT_SOURCE", events.DefaultSource),
+	}
+	if !ticketService.Encoding.Format.Valid() {
+		log.Fatalf("Invalid EVENT_FORMAT %q", ticketService.Encoding.Format)
+	}
+	// events per second a replay of the ticket event log re-emits
+	ticketService.Replays.Rate = int(envInt("REPLAY_RATE", int64(services.DefaultReplayPolicy.Rate)))
+	ticketService.Retention = streamRetention(dbConn)
+	// MQTT_URL drives dispensers and display boards of branch MQTT_BRANCH
+	if url := os.Getenv("MQTT_URL"); url != "" {
+		bridge := mqttbridge.NewBridge(nil, ticketService, envString("MQTT_BRANCH", "main"))
+		bridge.ShareGroup = os.Getenv("MQTT_SHARE_GROUP")
+		bridge.MaxDispensing = int(envInt("MQTT_MAX_DISPENSING", int64(bridge.MaxDispensing)))
+		hostname, _ := os.Hostname()
+		bridge.Client = db.NewMQTTClient(url, envString("MQTT_CLIENT_ID", "queue-core-"+hostname), bridge.Subscribe)
+		// connection is retried in the background; publishes queue until then
+		bridge.Client.Connect()
+		ticketService.Displays = bridge
+	}
 
 	// --- API ---
-	apiHandler := api.NewAPI(ticketService, rdb, streamName, pubSubBase)
-
-	// --- Dispatcher ---
+	apiHandler := api.NewAPI(ticketService, eventBus, streamName, pubSubBase)
+	// only the gateway at TRUSTED_PROXIES may assert who the caller is
+	apiHandler.TrustedProxies = envPrefixes("TRUSTED_PROXIES")
+	if len(apiHandler.TrustedProxies) == 0 {
+		log.Println("TRUSTED_PROXIES unset: X-Actor headers are ignored and every caller is a kiosk")
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	go ticketService.StartDispatcher(ctx, 1, 2*time.Second)
+	staticQueues := parseQueueIDs(os.Getenv("DISPATCH_QUEUES"))
+	if dbConn != nil {
+		apiHandler.CustomerService = services.NewCustomerService(repositories.NewCustomerRepo(dbConn))
+		apiHandler.FeedbackService = services.NewFeedbackService(repositories.NewFeedbackRepo(dbConn))
+		// signed event deliveries to subscribed endpoints, retried with backoff
+		webhookService := services.NewWebhookService(repositories.NewWebhookRepo(dbConn))
+		webhookService.Encoding = ticketService.Encoding
+		// internal receivers must be allowed explicitly
+		webhookService.AllowPrivate = envPrefixes("WEBHOOK_ALLOW_PRIVATE")
+		ticketService.Webhooks = webhookService
+		apiHandler.WebhookService = webhookService
+		webhookService.StartDispatcher(ctx, 2*time.Second)
+
+		// --- Dispatcher ---
+		// LISTEN/NOTIFY wakes dispatchers immediately; polling is only a fallback
+		listener := db.NewListener(connStr, db.TicketReadyChannel)
+		go listener.Run(ctx)
+		ticketService.Wakeups = listener
+
+		// one replica leads each queue; the rest stand by and take over on failure
+		elector := services.NewDispatchElector(repositories.NewLeaderRepo(dbConn), ticketService, nodeID())
+		elector.QueueIDs = func(ctx context.Context) ([]int64, error) {
+			ids, err := ticketService.Queues.ListIDs(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return mergeIDs(staticQueues, ids), nil
+		}
+		go elector.Run(ctx)
+	} else {
+		// --- Dispatcher ---
+		// a memory store has exactly one replica: it dispatches every queue,
+		// the DISPATCH_QUEUES ones from the start and any other from its
+		// first ready ticket
+		ready, stop := memoryRepo.SubscribeQueues()
+		go func() {
+			defer stop()
+			started := map[int64]bool{}
+			start := func(q int64) {
+				if !started[q] {
+					started[q] = true
+					ticketService.StartDispatcher(ctx, int(q), 30*time.Second)
+				}
+			}
+			for _, q := range staticQueues {
+				start(q)
+			}
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case q := <-ready:
+					start(q)
+				}
+			}
+		}()
+	}
+
+	// requeue tickets whose pull worker let its claim lease lapse
+	ticketService.StartLeaseReaper(ctx, 5*time.Second)
+
+	if ticketService.Retention != nil {
+		ticketService.StartRetention(ctx)
+	}
 
 	// --- HTTP SERVER ---
 	fmt.Println("Queue-Core running on :8080")
 	log.Fatal(http.ListenAndServe(":8080", apiHandler.Router()))
 }
+
+// streamRetention reads stream retention from STREAM_RETENTION (every
+// stream, e.g. "maxlen:100000" or "age:168h") and STREAM_RETENTION_<KIND>
+// overrides for TICKETS, QUEUE, WORKER_UPDATES and REPLAY. STREAM_ARCHIVE
+// ("postgres" or "file:<dir>") keeps trimmed entries. Nil when nothing is
+// bounded.
+func streamRetention(dbConn *sql.DB) *services.StreamRetention {
+	parse := func(name string) services.RetentionPolicy {
+		p, err := services.ParseRetentionPolicy(os.Getenv(name))
+		if err != nil {
+			log.Fatalf("Invalid %s: %v", name, err)
+		}
+		return p
+	}
+	r := &services.StreamRetention{
+		Default:  parse("STREAM_RETENTION"),
+		Streams:  map[string]services.RetentionPolicy{},
+		Interval: time.Minute,
+	}
+	enabled := r.Default.Enabled()
+	for _, kind := range []services.StreamKind{services.StreamTickets, services.StreamQueue, services.StreamWorkerUpdates, services.StreamReplay} {
+		name := "STREAM_RETENTION_" + strings.ToUpper(string(kind))
+		if os.Getenv(name) != "" {
+			r.Streams[string(kind)] = parse(name)
+			enabled = enabled || r.Streams[string(kind)].Enabled()
+		}
+	}
+	if !enabled {
+		return nil
+	}
+	switch archive := os.Getenv("STREAM_ARCHIVE"); {
+	case archive == "":
+	case archive == "postgres" && dbConn == nil:
+		log.Fatalf("STREAM_ARCHIVE=postgres needs STORE=postgres")
+	case archive == "postgres":
+		r.Archiver = &services.PostgresArchiver{Repo: repositories.NewStreamArchiveRepo(dbConn)}
+	case strings.HasPrefix(archive, "file:"):
+		r.Archiver = &services.FileArchiver{Dir: strings.TrimPrefix(archive, "file:")}
+	default:
+		log.Fatalf("Invalid STREAM_ARCHIVE %q", archive)
+	}
+	return r
+}
+
+// nodeID identifies this replica in dispatcher elections: NODE_ID if set,
+// otherwise hostname and pid.
+func nodeID() string {
+	if id := os.Getenv("NODE_ID"); id != "" {
+		return id
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// parseQueueIDs parses a comma separated id list such as "1,2,5"; it
+// defaults to queue 1 so a fresh install dispatches something.
+func parseQueueIDs(s string) []int64 {
+	if s == "" {
+		return []int64{1}
+	}
+	var ids []int64
+	for _, part := range strings.Split(s, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil {
+			log.Fatalf("Invalid DISPATCH_QUEUES entry %q", part)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// envPrefixes parses an env var holding a comma separated list of CIDRs or
+// addresses such as "10.0.0.0/8,192.168.1.10".
+func envPrefixes(name string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, part := range strings.Split(os.Getenv(name), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !strings.Contains(part, "/") {
+			addr, err := netip.ParseAddr(part)
+			if err != nil {
+				log.Fatalf("Invalid %s entry %q", name, part)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(part)
+		if err != nil {
+			log.Fatalf("Invalid %s entry %q", name, part)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes
+}
+
+// envInt reads an integer env var, falling back to def when unset.
+func envInt(name string, def int64) int64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", name, err)
+	}
+	return n
+}
+
+// envString reads a string env var, falling back to def when unset.
+func envString(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+func mergeIDs(a, b []int64) []int64 {
+	seen := map[int64]bool{}
+	var out []int64
+	for _, id := range append(append([]int64{}, a...), b...) {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
@@ -0,0 +1,47 @@
+// Package actor carries the identity of whoever triggered an operation
+// (kiosk, staff member, customer, worker or the system itself) through a
+// context.Context, so services can enforce role rules without HTTP coupling.
+package actor
+
+import "context"
+
+type Role string
+
+const (
+	RoleKiosk    Role = "kiosk"
+	RoleStaff    Role = "staff"
+	RoleCustomer Role = "customer"
+	RoleWorker   Role = "worker"
+	RoleSystem   Role = "system"
+)
+
+type Actor struct {
+	Role Role   `json:"role"`
+	ID   string `json:"id,omitempty"`
+}
+
+type ctxKey struct{}
+
+// WithContext returns a copy of ctx carrying a.
+func WithContext(ctx context.Context, a Actor) context.Context {
+	return context.WithValue(ctx, ctxKey{}, a)
+}
+
+// FromContext returns the actor carried by ctx. Callers that never declared
+// themselves are treated as a public kiosk, the least privileged role.
+func FromContext(ctx context.Context) Actor {
+	if a, ok := ctx.Value(ctxKey{}).(Actor); ok && a.Role != "" {
+		return a
+	}
+	return Actor{Role: RoleKiosk}
+}
+
+// Declared returns the actor carried by ctx and whether there is one, for
+// callers that must tell an undeclared caller from a declared kiosk.
+func Declared(ctx context.Context) (Actor, bool) {
+	a, ok := ctx.Value(ctxKey{}).(Actor)
+	return a, ok && a.Role != ""
+}
+
+// System is the actor used by background jobs (dispatcher, reapers, ...).
+var System = Actor{Role: RoleSystem}
@@ -2,30 +2,46 @@ package api
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/netip"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/websocket"
-	"github.com/redis/go-redis/v9"
+	"queue-core/internal/actor"
+	"queue-core/internal/bus"
+	"queue-core/internal/metrics"
 	"queue-core/internal/models"
+	"queue-core/internal/repositories"
 	"queue-core/internal/services"
+	"queue-core/pkg/events"
 )
 
 type API struct {
-	TicketService *services.TicketService
-	Rdb           *redis.Client
-	StreamName    string
-	PubSubBase    string
-	upgrader      websocket.Upgrader
+	TicketService   *services.TicketService
+	CustomerService *services.CustomerService // optional; nil disables /customers
+	FeedbackService *services.FeedbackService // optional; nil disables /feedback
+	WebhookService  *services.WebhookService  // optional; nil disables /webhooks
+	// TrustedProxies are the gateways whose X-Actor-* headers are believed;
+	// headers from anyone else are ignored. Empty trusts no one, so every
+	// caller is a kiosk.
+	TrustedProxies []netip.Prefix
+	Bus            bus.EventBus
+	StreamName     string
+	PubSubBase     string
+	upgrader       websocket.Upgrader
 }
 
-func NewAPI(ts *services.TicketService, rdb *redis.Client, streamName, pubSubBase string) *API {
+func NewAPI(ts *services.TicketService, b bus.EventBus, streamName, pubSubBase string) *API {
 	return &API{
 		TicketService: ts,
-		Rdb:           rdb,
+		Bus:           b,
 		StreamName:    streamName,
 		PubSubBase:    pubSubBase,
 		upgrader: websocket.Upgrader{
@@ -38,8 +54,90 @@ func (a *API) Router() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/tickets", a.createTicketHandler)
 	mux.HandleFunc("/tickets/waiting", a.listWaitingHandler)
+	mux.HandleFunc("/tickets/{id}", a.cancelTicketHandler)
+	mux.HandleFunc("/tickets/{id}/complete", a.completeTicketHandler)
+	mux.HandleFunc("/tickets/{id}/ack", a.leaseHandler(leaseAck))
+	mux.HandleFunc("/tickets/{id}/nack", a.leaseHandler(leaseNack))
+	mux.HandleFunc("/tickets/{id}/extend", a.leaseHandler(leaseExtend))
+	mux.HandleFunc("/tickets/{id}/requeue", a.requeueTicketHandler)
+	mux.HandleFunc("/tickets/{id}/retry", a.deadLetterHandler(deadLetterRetry))
+	mux.HandleFunc("/tickets/{id}/discard", a.deadLetterHandler(deadLetterDiscard))
+	mux.HandleFunc("/tickets/{id}/timeline", a.ticketTimelineHandler)
+	mux.HandleFunc("/feedback", a.submitFeedbackHandler)
+	mux.HandleFunc("/feedback/summary", a.feedbackSummaryHandler)
+	mux.HandleFunc("/customers", a.resolveCustomerHandler)
+	mux.HandleFunc("/customers/{id}", a.getCustomerHandler)
+	mux.HandleFunc("/customers/{id}/history", a.customerHistoryHandler)
+	mux.HandleFunc("/queues/{id}", a.queueHandler)
+	mux.HandleFunc("/queues/{id}/close", a.closeSessionHandler)
+	mux.HandleFunc("/queues/{id}/priority-policy", a.priorityPolicyHandler)
+	mux.HandleFunc("/reports/abandonment", a.abandonmentReportHandler)
+	mux.HandleFunc("/queues/{id}/intake-fields", a.intakeFieldsHandler)
+	mux.HandleFunc("/queues/{id}/strategy", a.dispatchStrategyHandler)
+	mux.HandleFunc("/queues/{id}/capacity", a.queueCapacityHandler)
+	mux.HandleFunc("/queues/{id}/claim", a.claimTicketHandler) // as a worker: ?wait=30s&lease=60s
+	mux.HandleFunc("/queues/{id}/dead-letters", a.listDeadLettersHandler)
+	mux.HandleFunc("/queues/{id}/events", a.replayEventsHandler)
+	mux.HandleFunc("/queues/{id}/replay", a.streamReplayHandler)
+	mux.HandleFunc("/queue-groups", a.createQueueGroupHandler)
+	mux.HandleFunc("/queue-groups/{id}", a.queueGroupHandler)
+	mux.HandleFunc("/workers/heartbeat", a.workerHeartbeatHandler)
+	mux.HandleFunc("/workers/{id}", a.workerOfflineHandler)
+	mux.HandleFunc("/webhooks", a.webhooksHandler)
+	mux.HandleFunc("/webhooks/{id}", a.webhookHandler)
+	mux.HandleFunc("/webhooks/{id}/deliveries", a.webhookDeliveriesHandler)
 	mux.HandleFunc("/ws", a.wsHandler) // ?queue_id=1
-	return mux
+	mux.Handle("/metrics", metrics.Default.Handler())
+	return a.withActor(mux)
+}
+
+// headerRoles are the roles a gateway may assert; the system role belongs
+// to background jobs only.
+var headerRoles = map[actor.Role]bool{
+	actor.RoleKiosk: true, actor.RoleStaff: true, actor.RoleCustomer: true, actor.RoleWorker: true,
+}
+
+// withActor reads the caller identity forwarded by the API gateway
+// (X-Actor-Role, X-Actor-ID) into the request context when the request
+// comes from one of TrustedProxies. Requests without the headers, or from
+// anyone else, are treated as public kiosk traffic.
+func (a *API) withActor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if role := actor.Role(r.Header.Get("X-Actor-Role")); role != "" && a.trusted(r) {
+			if !headerRoles[role] {
+				http.Error(w, "invalid actor role", http.StatusForbidden)
+				return
+			}
+			ctx := actor.WithContext(r.Context(), actor.Actor{
+				Role: role,
+				ID:   r.Header.Get("X-Actor-ID"),
+			})
+			r = r.WithContext(ctx)
+		} else {
+			// declared, so the event log names the kiosk
+			r = r.WithContext(actor.WithContext(r.Context(), actor.Actor{Role: actor.RoleKiosk}))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// trusted reports whether r's peer is one of TrustedProxies.
+func (a *API) trusted(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range a.TrustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
 }
 
 func (a *API) createTicketHandler(w http.ResponseWriter, r *http.Request) {
@@ -47,39 +145,1349 @@ func (a *API) createTicketHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
-	var ticket models.Ticket
-	if err := json.NewDecoder(r.Body).Decode(&ticket); err != nil {
+	var req createTicketRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	ticket := req.Ticket
+	if actor.FromContext(r.Context()).Role != actor.RoleStaff {
+		// only a resolved identity links a customer, and tickets start waiting
+		ticket.CustomerID, ticket.Status = nil, ""
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	// link the ticket to a known (or new) customer when identity is supplied
+	if req.Customer != nil && a.CustomerService != nil {
+		c, err := a.CustomerService.Resolve(ctx, req.Customer)
+		if errors.Is(err, services.ErrCustomerIdentityRequired) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed resolve customer", http.StatusInternalServerError)
+			return
+		}
+		ticket.CustomerID = &c.ID
+		if ticket.CustomerName == "" {
+			ticket.CustomerName = c.Name
+		}
+	}
+
+	id, err := a.TicketService.CreateTicket(ctx, &ticket)
+	var verr *services.ValidationError
+	switch {
+	case errors.As(err, &verr):
+		writeValidationError(w, verr)
+		return
+	case errors.Is(err, repositories.ErrActiveTicketLimit):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, services.ErrUnknownPriorityClass):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrPriorityClassForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, services.ErrUnknownQueueGroup), errors.Is(err, services.ErrEmptyQueueGroup):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed create", http.StatusInternalServerError)
+		return
+	}
+	// the token is the customer's only handle for feedback and self-service
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "token": ticket.Token})
+}
+
+// createTicketRequest is the POST /tickets body: a ticket plus an optional
+// customer identity used to link the ticket to a customer record. A ticket
+// with group_id is routed within that queue group; queue_id is then ignored.
+// Only staff may set customer_id or status directly.
+type createTicketRequest struct {
+	models.Ticket
+	Customer *models.Customer `json:"customer,omitempty"`
+}
+
+// cancelTicketHandler cancels a ticket (DELETE /tickets/{id}). Customers
+// authenticate with the X-Ticket-Token header; staff pass ?reason=<code>.
+func (a *API) cancelTicketHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid ticket id", http.StatusBadRequest)
+		return
+	}
+	err = a.TicketService.CancelTicket(r.Context(), id, r.Header.Get("X-Ticket-Token"), r.URL.Query().Get("reason"))
+	switch {
+	case errors.Is(err, services.ErrTicketNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrInvalidTicketToken):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, services.ErrInvalidCancelReason):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrTicketNotActive):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, "failed cancel", http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// queueHandler reads (GET) or replaces (PUT, staff only) a queue's name and
+// per-customer active ticket limit. Body: {"name": "Passports",
+// "max_active_per_customer": 1}; a limit of 0 is unlimited.
+func (a *API) queueHandler(w http.ResponseWriter, r *http.Request) {
+	if a.TicketService.Queues == nil {
+		http.Error(w, "queue policies not enabled", http.StatusNotFound)
+		return
+	}
+	qid, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid queue id", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		q, err := a.TicketService.Queues.GetOrDefault(r.Context(), qid)
+		if err != nil {
+			http.Error(w, "failed get queue", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(q)
+	case http.MethodPut:
+		if actor.FromContext(r.Context()).Role != actor.RoleStaff {
+			http.Error(w, "staff only", http.StatusForbidden)
+			return
+		}
+		var q models.Queue
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+		if q.MaxActivePerCustomer < 0 {
+			http.Error(w, "max_active_per_customer must not be negative", http.StatusBadRequest)
+			return
+		}
+		q.ID = qid
+		if err := a.TicketService.Queues.Upsert(r.Context(), &q); err != nil {
+			http.Error(w, "failed save queue", http.StatusInternalServerError)
+			return
+		}
+		// the strategy settings are kept; answer with the whole row
+		saved, err := a.TicketService.Queues.GetOrDefault(r.Context(), qid)
+		if err != nil {
+			http.Error(w, "failed get queue", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(saved)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// closeSessionHandler cancels every waiting ticket of a queue (staff only).
+func (a *API) closeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if actor.FromContext(r.Context()).Role != actor.RoleStaff {
+		http.Error(w, "staff only", http.StatusForbidden)
+		return
+	}
+	qid, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid queue id", http.StatusBadRequest)
+		return
+	}
+	// the tickets are cancelled by the system, but the event log names the
+	// staff member who closed the session
+	n, err := a.TicketService.CloseSession(r.Context(), qid)
+	if err != nil {
+		http.Error(w, "failed close session", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]int{"cancelled": n})
+}
+
+// abandonmentReportHandler reports cancelled vs archived tickets per queue
+// and arrival hour. Query: ?queue_id=1&from=...&to=... (default last 7 days)
+func (a *API) abandonmentReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var qid int64
+	if qidStr := r.URL.Query().Get("queue_id"); qidStr != "" {
+		var err error
+		if qid, err = strconv.ParseInt(qidStr, 10, 64); err != nil {
+			http.Error(w, "invalid queue_id", http.StatusBadRequest)
+			return
+		}
+	}
+	from, to, err := dateRange(r, 7*24*time.Hour)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	report, err := a.TicketService.Repo.AbandonmentByHour(r.Context(), qid, from, to)
+	if err != nil {
+		http.Error(w, "failed abandonment report", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// completeTicketHandler marks a reserved ticket done (staff, or the worker
+// it is assigned to).
+// Body: {"version": 3, "counter_id": 2, "worker_id": 11}
+func (a *API) completeTicketHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid ticket id", http.StatusBadRequest)
+		return
+	}
+	var body struct {
+		Version   int64  `json:"version"`
+		CounterID *int64 `json:"counter_id"`
+		WorkerID  *int64 `json:"worker_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	ok, err := a.TicketService.CompleteTicket(r.Context(), id, body.Version, body.CounterID, body.WorkerID)
+	if errors.Is(err, services.ErrTicketNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, services.ErrNotTicketWorker) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed complete", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "ticket changed or not reserved", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Claim API limits: waits are capped so long-polls stay under proxy
+// timeouts, and leases default to a minute when not given.
+const (
+	maxClaimWait = 60 * time.Second
+	defaultLease = 60 * time.Second
+)
+
+// claimTicketHandler long-polls for the next ticket of a queue and leases it
+// to the calling worker. Responds 200 with the ticket, or 204 when wait
+// elapsed with nothing to claim. A ?worker_id= must name the caller.
+func (a *API) claimTicketHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	qid, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid queue id", http.StatusBadRequest)
+		return
+	}
+	workerID, lease, err := leaseParams(r)
+	if errors.Is(err, errNotLeaseWorker) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var wait time.Duration
+	if v := r.URL.Query().Get("wait"); v != "" {
+		if wait, err = time.ParseDuration(v); err != nil || wait < 0 {
+			http.Error(w, "invalid wait", http.StatusBadRequest)
+			return
+		}
+	}
+	wait = min(wait, maxClaimWait)
+
+	t, err := a.TicketService.ClaimTicket(r.Context(), qid, workerID, lease, wait)
+	switch {
+	case errors.Is(err, services.ErrInvalidClaim):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrPushDispatched):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case r.Context().Err() != nil:
+		// client went away; nothing to write
+	case err != nil:
+		http.Error(w, "failed claim", http.StatusInternalServerError)
+	case t == nil:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(t)
+	}
+}
+
+type leaseAction int
+
+const (
+	leaseAck leaseAction = iota
+	leaseNack
+	leaseExtend
+)
+
+// leaseHandler serves ack (complete), nack (requeue) and extend on a ticket
+// claimed through the claim API by the calling worker: POST /tickets/{id}/ack.
+// Extend also takes ?lease=60s.
+func (a *API) leaseHandler(action leaseAction) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid ticket id", http.StatusBadRequest)
+			return
+		}
+		workerID, lease, err := leaseParams(r)
+		if errors.Is(err, errNotLeaseWorker) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var expires time.Time
+		switch action {
+		case leaseAck:
+			err = a.TicketService.AckTicket(r.Context(), id, workerID)
+		case leaseNack:
+			err = a.TicketService.NackTicket(r.Context(), id, workerID)
+		case leaseExtend:
+			expires, err = a.TicketService.ExtendLease(r.Context(), id, workerID, lease)
+		}
+		switch {
+		case errors.Is(err, services.ErrTicketNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, services.ErrLeaseNotHeld):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, services.ErrInvalidClaim):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case err != nil:
+			http.Error(w, "failed lease update", http.StatusInternalServerError)
+		case action == leaseExtend:
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "lease_expires_at": expires})
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// errNotLeaseWorker is returned by leaseParams when the caller is not a
+// worker, or names a worker_id other than its own.
+var errNotLeaseWorker = errors.New("only the calling worker may claim or hold its leases")
+
+// leaseParams reads the calling worker's id, which an optional worker_id
+// must match, and the optional lease duration.
+func leaseParams(r *http.Request) (int64, time.Duration, error) {
+	q := r.URL.Query()
+	a := actor.FromContext(r.Context())
+	workerID, err := strconv.ParseInt(a.ID, 10, 64)
+	if a.Role != actor.RoleWorker || err != nil || workerID <= 0 {
+		return 0, 0, errNotLeaseWorker
+	}
+	if v := q.Get("worker_id"); v != "" {
+		named, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, 0, errors.New("invalid worker_id")
+		}
+		if named != workerID {
+			return 0, 0, errNotLeaseWorker
+		}
+	}
+	lease := defaultLease
+	if v := q.Get("lease"); v != "" {
+		if lease, err = time.ParseDuration(v); err != nil || lease <= 0 {
+			return 0, 0, errors.New("invalid lease")
+		}
+	}
+	return workerID, lease, nil
+}
+
+// requeueTicketHandler reports a failed reserved ticket on behalf of a
+// stream worker (POST /tickets/{id}/requeue?reason=timeout, staff only). The
+// ticket backs off or is dead-lettered per the retry policy; the outcome is
+// returned.
+func (a *API) requeueTicketHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if actor.FromContext(r.Context()).Role != actor.RoleStaff {
+		http.Error(w, "staff only", http.StatusForbidden)
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid ticket id", http.StatusBadRequest)
+		return
+	}
+	reason := r.URL.Query().Get("reason")
+	if reason == "" {
+		reason = "worker_failure"
+	}
+	t, err := a.TicketService.RequeueTicket(r.Context(), id, reason)
+	switch {
+	case errors.Is(err, services.ErrTicketNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrTicketNotReserved):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, "failed requeue", http.StatusInternalServerError)
+	default:
+		json.NewEncoder(w).Encode(t)
+	}
+}
+
+// listDeadLettersHandler lists a queue's dead-lettered tickets (staff only).
+func (a *API) listDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if actor.FromContext(r.Context()).Role != actor.RoleStaff {
+		http.Error(w, "staff only", http.StatusForbidden)
+		return
+	}
+	qid, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid queue id", http.StatusBadRequest)
+		return
+	}
+	tickets, err := a.TicketService.ListDeadLetters(r.Context(), qid)
+	if err != nil {
+		http.Error(w, "failed query", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(tickets)
+}
+
+// ticketTimelineHandler lists every state change of a ticket, active or
+// archived, oldest first. Staff only.
+func (a *API) ticketTimelineHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if actor.FromContext(r.Context()).Role != actor.RoleStaff {
+		http.Error(w, "staff only", http.StatusForbidden)
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid ticket id", http.StatusBadRequest)
+		return
+	}
+	timeline, err := a.TicketService.Timeline(r.Context(), id)
+	if errors.Is(err, services.ErrTicketNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed get timeline", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(timeline)
+}
+
+// replayRequest reads a replay's queue, ?from=&to= (default the last 24
+// hours) and optional ?types=ticket.created,ticket.completed.
+func replayRequest(r *http.Request) (services.ReplayRequest, error) {
+	qid, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		return services.ReplayRequest{}, errors.New("invalid queue id")
+	}
+	from, to, err := dateRange(r, 24*time.Hour)
+	if err != nil {
+		return services.ReplayRequest{}, err
+	}
+	req := services.ReplayRequest{QueueID: qid, From: from, To: to}
+	if v := r.URL.Query().Get("types"); v != "" {
+		for _, t := range strings.Split(v, ",") {
+			req.Types = append(req.Types, events.Type(strings.TrimSpace(t)))
+		}
+	}
+	return req, nil
+}
+
+// replayEventsHandler streams a queue's logged events as newline-delimited
+// JSON, marked as replays and throttled to the replay rate. A replay that
+// fails mid-stream ends with an {"error": ...} line instead of an event.
+// Staff only.
+func (a *API) replayEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if actor.FromContext(r.Context()).Role != actor.RoleStaff {
+		http.Error(w, "staff only", http.StatusForbidden)
+		return
+	}
+	req, err := replayRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	started := false
+	_, err = a.TicketService.Replay(r.Context(), req, func(e *events.Envelope) error {
+		b, err := a.TicketService.Encoding.JSON(e)
+		if err != nil {
+			return err
+		}
+		if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			started = true
+		}
+		if _, err := w.Write(append(b, '\n')); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, services.ErrInvalidReplayRange):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil && !started:
+		http.Error(w, "failed replay", http.StatusInternalServerError)
+	case err != nil:
+		// the 200 is already out; end the stream with a line that says so
+		w.Write([]byte(`{"error":"failed replay"}` + "\n"))
+	case !started:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+}
+
+// streamReplayHandler starts a background replay of a queue's logged events
+// onto its replay stream (the queue stream key plus ".replay") and answers
+// 202 with the stream key. Staff only.
+func (a *API) streamReplayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if actor.FromContext(r.Context()).Role != actor.RoleStaff {
+		http.Error(w, "staff only", http.StatusForbidden)
+		return
+	}
+	req, err := replayRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stream, err := a.TicketService.StartStreamReplay(r.Context(), req)
+	switch {
+	case errors.Is(err, services.ErrInvalidReplayRange):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrReplayInProgress):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "failed start replay", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{"stream": stream, "from": req.From, "to": req.To})
+}
+
+type deadLetterAction int
+
+const (
+	deadLetterRetry deadLetterAction = iota
+	deadLetterDiscard
+)
+
+// deadLetterHandler retries (back to waiting) or discards (to history) a
+// dead-lettered ticket: POST /tickets/{id}/retry, POST /tickets/{id}/discard.
+// Staff only.
+func (a *API) deadLetterHandler(action deadLetterAction) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if actor.FromContext(r.Context()).Role != actor.RoleStaff {
+			http.Error(w, "staff only", http.StatusForbidden)
+			return
+		}
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid ticket id", http.StatusBadRequest)
+			return
+		}
+		if action == deadLetterRetry {
+			_, err = a.TicketService.RetryDeadLetter(r.Context(), id)
+		} else {
+			err = a.TicketService.DiscardDeadLetter(r.Context(), id)
+		}
+		switch {
+		case errors.Is(err, services.ErrNotDeadLettered):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			http.Error(w, "failed dead-letter update", http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// submitFeedbackHandler accepts a rating from the customer holding the token.
+// Body: {"token": "...", "rating": 5, "comment": "quick"}
+func (a *API) submitFeedbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if a.FeedbackService == nil {
+		http.Error(w, "feedback not enabled", http.StatusNotFound)
+		return
+	}
+	var body struct {
+		Token   string `json:"token"`
+		Rating  int    `json:"rating"`
+		Comment string `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	f, err := a.FeedbackService.Submit(r.Context(), body.Token, body.Rating, body.Comment)
+	switch {
+	case errors.Is(err, services.ErrInvalidRating):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, services.ErrFeedbackTokenUnknown):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrFeedbackWindowClosed):
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	case errors.Is(err, services.ErrFeedbackAlreadyGiven):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "failed submit feedback", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(f)
+}
+
+// feedbackSummaryHandler aggregates ratings per queue, counter and day.
+// Query: ?queue_id=1&from=2025-01-01&to=2025-02-01 (dates optional, default last 30 days)
+func (a *API) feedbackSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if a.FeedbackService == nil {
+		http.Error(w, "feedback not enabled", http.StatusNotFound)
+		return
+	}
+	var qid int64
+	if qidStr := r.URL.Query().Get("queue_id"); qidStr != "" {
+		var err error
+		if qid, err = strconv.ParseInt(qidStr, 10, 64); err != nil {
+			http.Error(w, "invalid queue_id", http.StatusBadRequest)
+			return
+		}
+	}
+	from, to, err := dateRange(r, 30*24*time.Hour)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	summary, err := a.FeedbackService.Summary(r.Context(), qid, from, to)
+	if err != nil {
+		http.Error(w, "failed summarize feedback", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(summary)
+}
+
+// dateRange parses ?from= and ?to= as RFC 3339 timestamps or YYYY-MM-DD
+// dates. Missing bounds default to [now-lookback, now].
+func dateRange(r *http.Request, lookback time.Duration) (time.Time, time.Time, error) {
+	parse := func(v string) (time.Time, error) {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t, nil
+		}
+		return time.Parse(time.DateOnly, v)
+	}
+	to := time.Now().UTC()
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, err := parse(v)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid to")
+		}
+		to = t
+	}
+	from := to.Add(-lookback)
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := parse(v)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid from")
+		}
+		from = t
+	}
+	return from, to, nil
+}
+
+// writeValidationError responds 422 with the per-field messages.
+func writeValidationError(w http.ResponseWriter, verr *services.ValidationError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  "validation failed",
+		"fields": verr.Fields,
+	})
+}
+
+// attributeFilters collects ?attr.<name>=<value> query params.
+func attributeFilters(r *http.Request) map[string]string {
+	filters := map[string]string{}
+	for key, values := range r.URL.Query() {
+		if name, ok := strings.CutPrefix(key, "attr."); ok && name != "" && len(values) > 0 {
+			filters[name] = values[0]
+		}
+	}
+	return filters
+}
+
+// listWaitingHandler lists waiting tickets; ?attr.<name>=<value> filters on
+// intake attributes.
+func (a *API) listWaitingHandler(w http.ResponseWriter, r *http.Request) {
+	// queue_id as query param
+	qidStr := r.URL.Query().Get("queue_id")
+	if qidStr == "" {
+		http.Error(w, "queue_id required", http.StatusBadRequest)
+		return
+	}
+	qid, err := strconv.Atoi(qidStr)
+	if err != nil {
+		http.Error(w, "invalid queue_id", http.StatusBadRequest)
+		return
+	}
+	tickets, err := a.TicketService.Repo.ListByStatus(r.Context(), qid, "waiting", attributeFilters(r))
+	if err != nil {
+		http.Error(w, "failed list", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(tickets)
+}
+
+// priorityPolicyHandler reads (GET) or replaces (PUT, staff only) the
+// priority classes and call-out lanes of a queue.
+func (a *API) priorityPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	if a.TicketService.Queues == nil {
+		http.Error(w, "queue policies not enabled", http.StatusNotFound)
+		return
+	}
+	qid, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid queue id", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		policy, err := a.TicketService.Queues.GetPriorityPolicy(r.Context(), qid)
+		if err != nil {
+			http.Error(w, "failed get policy", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(policy)
+	case http.MethodPut:
+		if actor.FromContext(r.Context()).Role != actor.RoleStaff {
+			http.Error(w, "staff only", http.StatusForbidden)
+			return
+		}
+		var policy models.PriorityPolicy
+		if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+		if err := services.ValidatePriorityPolicy(&policy); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := a.TicketService.Queues.ReplacePriorityPolicy(r.Context(), qid, &policy); err != nil {
+			http.Error(w, "failed save policy", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(policy)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// intakeFieldsHandler reads (GET) or replaces (PUT, staff only) a queue's
+// intake schema.
+func (a *API) intakeFieldsHandler(w http.ResponseWriter, r *http.Request) {
+	if a.TicketService.Queues == nil {
+		http.Error(w, "queue policies not enabled", http.StatusNotFound)
+		return
+	}
+	qid, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid queue id", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		fields, err := a.TicketService.Queues.ListIntakeFields(r.Context(), qid)
+		if err != nil {
+			http.Error(w, "failed list fields", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(fields)
+	case http.MethodPut:
+		if actor.FromContext(r.Context()).Role != actor.RoleStaff {
+			http.Error(w, "staff only", http.StatusForbidden)
+			return
+		}
+		var fields []models.IntakeField
+		if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+		var verr *services.ValidationError
+		if err := services.ValidateIntakeSchema(fields); errors.As(err, &verr) {
+			writeValidationError(w, verr)
+			return
+		}
+		if err := a.TicketService.Queues.ReplaceIntakeFields(r.Context(), qid, fields); err != nil {
+			http.Error(w, "failed save fields", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(fields)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// dispatchStrategyHandler reads (GET) or selects (PUT, staff only) a queue's
+// dispatch strategy. Body: {"strategy": "skill_match", "config": {"skills": ["visa"]},
+// "strict_order": false, "dispatch_mode": "push"}; a "pull" queue is only
+// served over the claim API.
+func (a *API) dispatchStrategyHandler(w http.ResponseWriter, r *http.Request) {
+	if a.TicketService.Queues == nil {
+		http.Error(w, "queue policies not enabled", http.StatusNotFound)
+		return
+	}
+	qid, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid queue id", http.StatusBadRequest)
+		return
+	}
+
+	type strategyBody struct {
+		Strategy     string          `json:"strategy"`
+		Config       json.RawMessage `json:"config,omitempty"`
+		StrictOrder  bool            `json:"strict_order"`
+		DispatchMode string          `json:"dispatch_mode,omitempty"`
+		Available    []string        `json:"available,omitempty"`
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		q, err := a.TicketService.Queues.GetOrDefault(r.Context(), qid)
+		if err != nil {
+			http.Error(w, "failed get strategy", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(strategyBody{Strategy: q.DispatchStrategy, Config: q.DispatchConfig, StrictOrder: q.StrictOrder, DispatchMode: q.DispatchMode, Available: services.StrategyNames()})
+	case http.MethodPut:
+		if actor.FromContext(r.Context()).Role != actor.RoleStaff {
+			http.Error(w, "staff only", http.StatusForbidden)
+			return
+		}
+		var body strategyBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+		// build once to reject unknown names and bad configs up front
+		if body.Strategy != "" {
+			if _, err := services.NewDispatchStrategy(body.Strategy, body.Config); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		switch body.DispatchMode {
+		case "", models.DispatchPush, models.DispatchPull:
+		default:
+			http.Error(w, "dispatch_mode must be push or pull", http.StatusBadRequest)
+			return
+		}
+		if err := a.TicketService.Queues.SetDispatchStrategy(r.Context(), qid, body.Strategy, body.Config, body.StrictOrder, body.DispatchMode); err != nil {
+			http.Error(w, "failed save strategy", http.StatusInternalServerError)
+			return
+		}
+		if body.DispatchMode == "" {
+			body.DispatchMode = models.DispatchPush
+		}
+		json.NewEncoder(w).Encode(strategyBody{Strategy: body.Strategy, Config: body.Config, StrictOrder: body.StrictOrder, DispatchMode: body.DispatchMode})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// createQueueGroupHandler creates a group of equivalent queues (staff only).
+// Body: {"name": "Passports", "queue_ids": [1, 2, 3]}
+func (a *API) createQueueGroupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if a.TicketService.Queues == nil {
+		http.Error(w, "queue policies not enabled", http.StatusNotFound)
+		return
+	}
+	if actor.FromContext(r.Context()).Role != actor.RoleStaff {
+		http.Error(w, "staff only", http.StatusForbidden)
+		return
+	}
+	var g models.QueueGroup
+	if err := json.NewDecoder(r.Body).Decode(&g); err != nil || g.Name == "" {
 		http.Error(w, "invalid body", http.StatusBadRequest)
 		return
 	}
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-	defer cancel()
-	id, err := a.TicketService.CreateTicket(ctx, &ticket)
+	g.ID = 0
+	if err := a.TicketService.Queues.SaveGroup(r.Context(), &g); err != nil {
+		http.Error(w, "failed save group", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(g)
+}
+
+// queueGroupHandler reads a group with the live projected wait of each
+// member, best first (GET), or replaces its name and members (PUT, staff only).
+func (a *API) queueGroupHandler(w http.ResponseWriter, r *http.Request) {
+	if a.TicketService.Queues == nil {
+		http.Error(w, "queue policies not enabled", http.StatusNotFound)
+		return
+	}
+	gid, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
 	if err != nil {
-		http.Error(w, "failed create", http.StatusInternalServerError)
+		http.Error(w, "invalid group id", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		g, loads, err := a.TicketService.GroupLoads(r.Context(), gid)
+		switch {
+		case errors.Is(err, services.ErrUnknownQueueGroup):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case err != nil && !errors.Is(err, services.ErrEmptyQueueGroup):
+			http.Error(w, "failed get group", http.StatusInternalServerError)
+		default:
+			if loads == nil {
+				loads = []models.QueueLoad{}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"group": g, "loads": loads})
+		}
+	case http.MethodPut:
+		if actor.FromContext(r.Context()).Role != actor.RoleStaff {
+			http.Error(w, "staff only", http.StatusForbidden)
+			return
+		}
+		var g models.QueueGroup
+		if err := json.NewDecoder(r.Body).Decode(&g); err != nil || g.Name == "" {
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+		g.ID = gid
+		err := a.TicketService.Queues.SaveGroup(r.Context(), &g)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, services.ErrUnknownQueueGroup.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed save group", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(g)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// workerHeartbeatHandler records the capacity a worker or counter offers a
+// queue. Body: {"worker_id": 11, "queue_id": 1, "counter_id": 2, "capacity": 1}
+// Workers should repeat it well within the heartbeat TTL. Only the worker
+// itself or staff may send it.
+func (a *API) workerHeartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var worker models.Worker
+	if err := json.NewDecoder(r.Body).Decode(&worker); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	if !actsForWorker(r, worker.WorkerID) {
+		http.Error(w, "only staff or the worker itself", http.StatusForbidden)
 		return
 	}
-	json.NewEncoder(w).Encode(map[string]int64{"id": id})
+	err := a.TicketService.WorkerHeartbeat(r.Context(), &worker)
+	switch {
+	case errors.Is(err, services.ErrCapacityDisabled):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrInvalidCapacity):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		http.Error(w, "failed heartbeat", http.StatusInternalServerError)
+	default:
+		json.NewEncoder(w).Encode(worker)
+	}
 }
 
-func (a *API) listWaitingHandler(w http.ResponseWriter, r *http.Request) {
-	// queue_id as query param
-	qidStr := r.URL.Query().Get("queue_id")
-	if qidStr == "" {
-		http.Error(w, "queue_id required", http.StatusBadRequest)
+// workerOfflineHandler withdraws a worker's capacity (DELETE /workers/{id}?queue_id=1).
+// Only the worker itself or staff may withdraw it.
+func (a *API) workerOfflineHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
-	qid, err := strconv.Atoi(qidStr)
+	if a.TicketService.CapacityPolicy == nil || a.TicketService.CapacityPolicy.Workers == nil {
+		http.Error(w, services.ErrCapacityDisabled.Error(), http.StatusNotFound)
+		return
+	}
+	workerID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid worker id", http.StatusBadRequest)
+		return
+	}
+	if !actsForWorker(r, workerID) {
+		http.Error(w, "only staff or the worker itself", http.StatusForbidden)
+		return
+	}
+	qid, err := strconv.ParseInt(r.URL.Query().Get("queue_id"), 10, 64)
 	if err != nil {
 		http.Error(w, "invalid queue_id", http.StatusBadRequest)
 		return
 	}
-	tickets, err := a.TicketService.Repo.GetByStatus(r.Context(), qid, "waiting")
+	if err := a.TicketService.CapacityPolicy.Workers.Remove(r.Context(), workerID, qid); err != nil {
+		http.Error(w, "failed remove worker", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// actsForWorker reports whether r comes from staff or from worker workerID.
+func actsForWorker(r *http.Request, workerID int64) bool {
+	a := actor.FromContext(r.Context())
+	switch a.Role {
+	case actor.RoleStaff:
+		return true
+	case actor.RoleWorker:
+		return a.ID == strconv.FormatInt(workerID, 10)
+	}
+	return false
+}
+
+// queueCapacityHandler reports the queue's current dispatch budget.
+func (a *API) queueCapacityHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	qid, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
 	if err != nil {
-		http.Error(w, "failed list", http.StatusInternalServerError)
+		http.Error(w, "invalid queue id", http.StatusBadRequest)
 		return
 	}
-	json.NewEncoder(w).Encode(tickets)
+	capacity, err := a.TicketService.Capacity(r.Context(), qid)
+	if err != nil {
+		http.Error(w, "failed get capacity", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(capacity)
+}
+
+// resolveCustomerHandler finds or creates a customer by phone, email or
+// external_id. Only staff get the stored record back; anyone else gets its id.
+func (a *API) resolveCustomerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if a.CustomerService == nil {
+		http.Error(w, "customers not enabled", http.StatusNotFound)
+		return
+	}
+	var c models.Customer
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	customer, err := a.CustomerService.Resolve(r.Context(), &c)
+	if errors.Is(err, services.ErrCustomerIdentityRequired) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed resolve customer", http.StatusInternalServerError)
+		return
+	}
+	if actor.FromContext(r.Context()).Role != actor.RoleStaff {
+		json.NewEncoder(w).Encode(map[string]int64{"id": customer.ID})
+		return
+	}
+	json.NewEncoder(w).Encode(customer)
+}
+
+// getCustomerHandler returns a customer record (staff only).
+
+func (a *API) getCustomerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if a.CustomerService == nil {
+		http.Error(w, "customers not enabled", http.StatusNotFound)
+		return
+	}
+	if actor.FromContext(r.Context()).Role != actor.RoleStaff {
+		http.Error(w, "staff only", http.StatusForbidden)
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid customer id", http.StatusBadRequest)
+		return
+	}
+	customer, err := a.CustomerService.Repo.GetByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "customer not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed get customer", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(customer)
+}
+
+// customerHistoryHandler lists past visits (staff only). Optional ?limit=
+// caps the result (max 100).
+func (a *API) customerHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if a.CustomerService == nil {
+		http.Error(w, "customers not enabled", http.StatusNotFound)
+		return
+	}
+	if actor.FromContext(r.Context()).Role != actor.RoleStaff {
+		http.Error(w, "staff only", http.StatusForbidden)
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid customer id", http.StatusBadRequest)
+		return
+	}
+	limit := 0
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	visits, err := a.CustomerService.History(r.Context(), id, limit)
+	if err != nil {
+		http.Error(w, "failed list history", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(visits)
+}
+
+// webhooksHandler lists (GET) or creates (POST) webhook subscriptions.
+// Body: {"url": "https://crm.example.org/hook", "event_types": ["ticket.completed"], "queue_ids": [1]}
+// The signing secret is generated unless given and only returned here.
+func (a *API) webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	if a.WebhookService == nil {
+		http.Error(w, "webhooks not enabled", http.StatusNotFound)
+		return
+	}
+	if actor.FromContext(r.Context()).Role != actor.RoleStaff {
+		http.Error(w, "staff only", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		subs, err := a.WebhookService.List(r.Context())
+		if err != nil {
+			http.Error(w, "failed list webhooks", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(subs)
+	case http.MethodPost:
+		var sub models.WebhookSubscription
+		if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+		err := a.WebhookService.Create(r.Context(), &sub)
+		if !writeWebhookError(w, err, "failed create webhook") {
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(sub)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// webhookHandler reads (GET), replaces (PUT) or deletes (DELETE) a
+// subscription. PUT with "enabled": true re-enables a disabled endpoint.
+func (a *API) webhookHandler(w http.ResponseWriter, r *http.Request) {
+	if a.WebhookService == nil {
+		http.Error(w, "webhooks not enabled", http.StatusNotFound)
+		return
+	}
+	if actor.FromContext(r.Context()).Role != actor.RoleStaff {
+		http.Error(w, "staff only", http.StatusForbidden)
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid webhook id", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		sub, err := a.WebhookService.Get(r.Context(), id)
+		if err != nil {
+			http.Error(w, "failed get webhook", http.StatusInternalServerError)
+			return
+		}
+		if sub == nil {
+			http.Error(w, "webhook not found", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(sub)
+	case http.MethodPut:
+		var sub models.WebhookSubscription
+		if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+		sub.ID = id
+		ok, err := a.WebhookService.Update(r.Context(), &sub)
+		if !writeWebhookError(w, err, "failed update webhook") {
+			return
+		}
+		if !ok {
+			http.Error(w, "webhook not found", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(sub)
+	case http.MethodDelete:
+		ok, err := a.WebhookService.Delete(r.Context(), id)
+		if err != nil {
+			http.Error(w, "failed delete webhook", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "webhook not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// webhookDeliveriesHandler lists a subscription's delivery log, newest first.
+// Optional ?limit= caps the result (default 100, max 500).
+func (a *API) webhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if a.WebhookService == nil {
+		http.Error(w, "webhooks not enabled", http.StatusNotFound)
+		return
+	}
+	if actor.FromContext(r.Context()).Role != actor.RoleStaff {
+		http.Error(w, "staff only", http.StatusForbidden)
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid webhook id", http.StatusBadRequest)
+		return
+	}
+	limit := 0
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	deliveries, err := a.WebhookService.Deliveries(r.Context(), id, limit)
+	if err != nil {
+		http.Error(w, "failed list deliveries", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// writeWebhookError writes err, if any, and reports whether there was none.
+func writeWebhookError(w http.ResponseWriter, err error, msg string) bool {
+	switch {
+	case errors.Is(err, services.ErrInvalidWebhookURL), errors.Is(err, services.ErrUnknownWebhookEventType),
+		errors.Is(err, services.ErrPrivateWebhookTarget):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	case err != nil:
+		http.Error(w, msg, http.StatusInternalServerError)
+		return false
+	}
+	return true
 }
 
 // wsHandler upgrades and subscribes to a Redis PubSub channel for queue updates.
@@ -106,11 +1514,14 @@ func (a *API) wsHandler(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 
 	pubChName := fmt.Sprintf(a.PubSubBase, qid)
-	ps := a.Rdb.Subscribe(ctx, pubChName)
-	defer ps.Close()
+	sub, err := a.Bus.Subscribe(ctx, pubChName)
+	if err != nil {
+		return
+	}
+	defer sub.Close()
 
 	// consume messages and send to WS
-	ch := ps.Channel()
+	ch := sub.Messages()
 	// ping loop to keep connection alive
 	pingTicker := time.NewTicker(30 * time.Second)
 	defer pingTicker.Stop()
@@ -124,7 +1535,7 @@ func (a *API) wsHandler(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 			// forward raw message to client
-			if err := conn.WriteMessage(websocket.TextMessage, []byte(msg.Payload)); err != nil {
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
 				return
 			}
 		case <-pingTicker.C:
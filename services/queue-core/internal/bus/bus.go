@@ -0,0 +1,104 @@
+// Package bus is the event transport between queue-core, its workers and
+// its websocket frontends: durable streams read through consumer groups,
+// and fire-and-forget broadcast channels. Services depend on EventBus only;
+// Redis is the default implementation.
+package bus
+
+import (
+	"context"
+	"time"
+)
+
+// Message is one stream entry. IDs are "<unix ms>-<seq>" and increase
+// along a stream.
+type Message struct {
+	ID     string
+	Values map[string]interface{}
+}
+
+// Trim bounds a stream as entries are appended. Trimming is approximate:
+// the transport may keep a few entries more. The zero value keeps
+// everything.
+type Trim struct {
+	MaxLen int64  // keep about this many newest entries
+	MinID  string // drop entries older than this id
+}
+
+// Event is one emitted event: appended to Stream when set and broadcast on
+// Channel when set.
+type Event struct {
+	Stream  string
+	Fields  map[string]interface{}
+	Trim    Trim
+	Channel string
+	Payload []byte
+}
+
+// Group describes a consumer group of a stream.
+type Group struct {
+	Name            string
+	Consumers       int64
+	Pending         int64  // delivered but not yet acked
+	LastDeliveredID string // newest entry delivered to the group
+	Lag             int64  // entries not yet delivered
+}
+
+// PendingEntry is an entry delivered to a consumer and not yet acked.
+type PendingEntry struct {
+	ID         string
+	Consumer   string
+	Idle       time.Duration
+	RetryCount int64
+}
+
+// Subscription receives the payloads broadcast on a channel until closed.
+type Subscription interface {
+	Messages() <-chan []byte
+	Close() error
+}
+
+// EventBus publishes queue events and serves their consumers.
+type EventBus interface {
+	// Publish appends fields to stream and returns the entry id.
+	Publish(ctx context.Context, stream string, fields map[string]interface{}, trim Trim) (string, error)
+	// Broadcast sends payload to the current subscribers of channel.
+	Broadcast(ctx context.Context, channel string, payload []byte) error
+	// Emit publishes and broadcasts many events in as few round trips as
+	// the transport allows.
+	Emit(ctx context.Context, events []Event) error
+	// Subscribe receives what is broadcast on channel until ctx is done or
+	// the subscription is closed.
+	Subscribe(ctx context.Context, channel string) (Subscription, error)
+
+	// Streams lists the streams whose key starts with prefix.
+	Streams(ctx context.Context, prefix string) ([]string, error)
+	// Range returns up to count entries of stream from start to end
+	// inclusive, oldest first. "-" and "+" are the stream's ends; a start
+	// of "(<id>" excludes id.
+	Range(ctx context.Context, stream, start, end string, count int64) ([]Message, error)
+	// Entries returns the entries of stream with the given ids, nil where
+	// an entry no longer exists.
+	Entries(ctx context.Context, stream string, ids []string) ([]*Message, error)
+	// Len returns the number of entries in stream.
+	Len(ctx context.Context, stream string) (int64, error)
+	// Size returns the bytes stream uses, where the transport knows.
+	Size(ctx context.Context, stream string) (int64, error)
+	// TrimBefore removes the entries of stream older than minID, exactly.
+	TrimBefore(ctx context.Context, stream, minID string) (int64, error)
+
+	// CreateGroup creates a consumer group reading stream from start ("0"
+	// for every entry, "$" for new ones), creating the stream if need be.
+	// Creating an existing group is not an error.
+	CreateGroup(ctx context.Context, stream, group, start string) error
+	// ReadGroup delivers up to count new entries of stream to consumer,
+	// waiting up to block for one. No entries is not an error.
+	ReadGroup(ctx context.Context, stream, group, consumer string, count int64, block time.Duration) ([]Message, error)
+	// Groups describes the consumer groups of stream; none when the stream
+	// does not exist.
+	Groups(ctx context.Context, stream string) ([]Group, error)
+	// Pending returns up to count entries pending in group for at least
+	// idle, oldest first.
+	Pending(ctx context.Context, stream, group string, idle time.Duration, count int64) ([]PendingEntry, error)
+	// Ack acknowledges entries of group and returns how many were pending.
+	Ack(ctx context.Context, stream, group string, ids ...string) (int64, error)
+}
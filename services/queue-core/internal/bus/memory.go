@@ -0,0 +1,431 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory is an EventBus in process memory with Redis semantics: ids are
+// "<ms>-<seq>" as Redis assigns them, values read back as strings, and
+// consumer groups keep a pending list per entry until acked. Broadcasts
+// reach the subscribers of this process only and are dropped for a
+// subscriber that falls behind. Nothing survives a restart; it is meant
+// for development and tests.
+type Memory struct {
+	mu      sync.Mutex
+	streams map[string]*memStream
+	subs    map[string]map[*memSubscription]struct{} // channel -> subscribers
+	// appended is closed and replaced whenever an entry is appended, to
+	// wake blocked ReadGroup calls.
+	appended chan struct{}
+}
+
+type memStream struct {
+	entries []Message // oldest first
+	lastMS  uint64
+	lastSeq uint64
+	groups  map[string]*memGroup
+}
+
+type memGroup struct {
+	lastDelivered string
+	consumers     map[string]struct{}
+	pending       map[string]*memPending
+}
+
+type memPending struct {
+	consumer  string
+	delivered time.Time
+	count     int64
+}
+
+// errNoGroup is what Redis answers to reading or acking an unknown group.
+var errNoGroup = errors.New("NOGROUP no such key or consumer group")
+
+func NewMemory() *Memory {
+	return &Memory{
+		streams:  map[string]*memStream{},
+		subs:     map[string]map[*memSubscription]struct{}{},
+		appended: make(chan struct{}),
+	}
+}
+
+// fieldString is a stream field value as it reads back from Redis.
+func fieldString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// compareID orders "<ms>-<seq>" ids; a bare "<ms>" is "<ms>-0".
+func compareID(a, b string) int {
+	am, as := parseID(a)
+	bm, bs := parseID(b)
+	switch {
+	case am != bm:
+		if am < bm {
+			return -1
+		}
+		return 1
+	case as != bs:
+		if as < bs {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// stream returns the stream at key, creating it if create is set.
+func (m *Memory) stream(key string, create bool) *memStream {
+	s := m.streams[key]
+	if s == nil && create {
+		s = &memStream{groups: map[string]*memGroup{}}
+		m.streams[key] = s
+	}
+	return s
+}
+
+// publish appends an entry; m.mu is held.
+func (m *Memory) publish(stream string, fields map[string]interface{}, trim Trim) string {
+	s := m.stream(stream, true)
+	ms := uint64(time.Now().UnixMilli())
+	if ms <= s.lastMS {
+		// same millisecond, or the clock went back: ids must still grow
+		ms, s.lastSeq = s.lastMS, s.lastSeq+1
+	} else {
+		s.lastSeq = 0
+	}
+	s.lastMS = ms
+	values := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		values[k] = fieldString(v)
+	}
+	id := strconv.FormatUint(ms, 10) + "-" + strconv.FormatUint(s.lastSeq, 10)
+	s.entries = append(s.entries, Message{ID: id, Values: values})
+
+	if trim.MinID != "" {
+		s.trimBefore(trim.MinID)
+	}
+	if trim.MaxLen > 0 && int64(len(s.entries)) > trim.MaxLen {
+		s.entries = append([]Message(nil), s.entries[int64(len(s.entries))-trim.MaxLen:]...)
+	}
+	close(m.appended)
+	m.appended = make(chan struct{})
+	return id
+}
+
+// trimBefore drops the entries older than minID and returns how many.
+func (s *memStream) trimBefore(minID string) int64 {
+	i := sort.Search(len(s.entries), func(i int) bool { return compareID(s.entries[i].ID, minID) >= 0 })
+	s.entries = append([]Message(nil), s.entries[i:]...)
+	return int64(i)
+}
+
+func (m *Memory) Publish(ctx context.Context, stream string, fields map[string]interface{}, trim Trim) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.publish(stream, fields, trim), nil
+}
+
+func (m *Memory) Broadcast(ctx context.Context, channel string, payload []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.broadcast(channel, payload)
+	return nil
+}
+
+// broadcast hands payload to every subscriber of channel that has room for
+// it; m.mu is held.
+func (m *Memory) broadcast(channel string, payload []byte) {
+	for sub := range m.subs[channel] {
+		select {
+		case sub.ch <- append([]byte(nil), payload...):
+		default:
+		}
+	}
+}
+
+func (m *Memory) Emit(ctx context.Context, events []Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range events {
+		if e.Stream != "" {
+			m.publish(e.Stream, e.Fields, e.Trim)
+		}
+		if e.Channel != "" {
+			m.broadcast(e.Channel, e.Payload)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe(ctx context.Context, channel string) (Subscription, error) {
+	sub := &memSubscription{bus: m, channel: channel, ch: make(chan []byte, 64)}
+	m.mu.Lock()
+	if m.subs[channel] == nil {
+		m.subs[channel] = map[*memSubscription]struct{}{}
+	}
+	m.subs[channel][sub] = struct{}{}
+	m.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		sub.Close()
+	}()
+	return sub, nil
+}
+
+type memSubscription struct {
+	bus     *Memory
+	channel string
+	ch      chan []byte
+	once    sync.Once
+}
+
+func (s *memSubscription) Messages() <-chan []byte { return s.ch }
+
+func (s *memSubscription) Close() error {
+	s.once.Do(func() {
+		s.bus.mu.Lock()
+		defer s.bus.mu.Unlock()
+		delete(s.bus.subs[s.channel], s)
+		close(s.ch)
+	})
+	return nil
+}
+
+func (m *Memory) Streams(ctx context.Context, prefix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []string
+	for key := range m.streams {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func copyMessage(msg Message) Message {
+	values := make(map[string]interface{}, len(msg.Values))
+	for k, v := range msg.Values {
+		values[k] = v
+	}
+	return Message{ID: msg.ID, Values: values}
+}
+
+func (m *Memory) Range(ctx context.Context, stream, start, end string, count int64) ([]Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.stream(stream, false)
+	if s == nil {
+		return nil, nil
+	}
+	from, exclusive := strings.CutPrefix(start, "(")
+	var msgs []Message
+	for _, e := range s.entries {
+		if count > 0 && int64(len(msgs)) >= count {
+			break
+		}
+		if from != "-" {
+			if c := compareID(e.ID, from); c < 0 || (c == 0 && exclusive) {
+				continue
+			}
+		}
+		if end != "+" && compareID(e.ID, end) > 0 {
+			break
+		}
+		msgs = append(msgs, copyMessage(e))
+	}
+	return msgs, nil
+}
+
+func (m *Memory) Entries(ctx context.Context, stream string, ids []string) ([]*Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := make([]*Message, len(ids))
+	s := m.stream(stream, false)
+	if s == nil {
+		return entries, nil
+	}
+	for i, id := range ids {
+		j := sort.Search(len(s.entries), func(j int) bool { return compareID(s.entries[j].ID, id) >= 0 })
+		if j < len(s.entries) && s.entries[j].ID == id {
+			msg := copyMessage(s.entries[j])
+			entries[i] = &msg
+		}
+	}
+	return entries, nil
+}
+
+func (m *Memory) Len(ctx context.Context, stream string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s := m.stream(stream, false); s != nil {
+		return int64(len(s.entries)), nil
+	}
+	return 0, nil
+}
+
+// Size counts the bytes of ids, field names and values, leaving out
+// bookkeeping.
+func (m *Memory) Size(ctx context.Context, stream string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.stream(stream, false)
+	if s == nil {
+		return 0, nil
+	}
+	var size int64
+	for _, e := range s.entries {
+		size += int64(len(e.ID))
+		for k, v := range e.Values {
+			size += int64(len(k) + len(v.(string)))
+		}
+	}
+	return size, nil
+}
+
+func (m *Memory) TrimBefore(ctx context.Context, stream, minID string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s := m.stream(stream, false); s != nil {
+		return s.trimBefore(minID), nil
+	}
+	return 0, nil
+}
+
+func (m *Memory) CreateGroup(ctx context.Context, stream, group, start string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.stream(stream, true)
+	if s.groups[group] != nil {
+		return nil
+	}
+	if start == "$" {
+		start = strconv.FormatUint(s.lastMS, 10) + "-" + strconv.FormatUint(s.lastSeq, 10)
+	}
+	s.groups[group] = &memGroup{lastDelivered: start, consumers: map[string]struct{}{}, pending: map[string]*memPending{}}
+	return nil
+}
+
+func (m *Memory) ReadGroup(ctx context.Context, stream, group, consumer string, count int64, block time.Duration) ([]Message, error) {
+	var timeout <-chan time.Time
+	if block > 0 {
+		t := time.NewTimer(block)
+		defer t.Stop()
+		timeout = t.C
+	}
+	for {
+		m.mu.Lock()
+		msgs, err := m.deliver(stream, group, consumer, count)
+		appended := m.appended
+		m.mu.Unlock()
+		if err != nil || len(msgs) > 0 || timeout == nil {
+			return msgs, err
+		}
+		select {
+		case <-appended:
+		case <-timeout:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// deliver hands up to count entries the group has not seen to consumer;
+// m.mu is held.
+func (m *Memory) deliver(stream, group, consumer string, count int64) ([]Message, error) {
+	s := m.stream(stream, false)
+	if s == nil || s.groups[group] == nil {
+		return nil, errNoGroup
+	}
+	g := s.groups[group]
+	g.consumers[consumer] = struct{}{}
+	now := time.Now()
+	var msgs []Message
+	for _, e := range s.entries {
+		if count > 0 && int64(len(msgs)) >= count {
+			break
+		}
+		if compareID(e.ID, g.lastDelivered) <= 0 {
+			continue
+		}
+		g.lastDelivered = e.ID
+		g.pending[e.ID] = &memPending{consumer: consumer, delivered: now, count: 1}
+		msgs = append(msgs, copyMessage(e))
+	}
+	return msgs, nil
+}
+
+func (m *Memory) Groups(ctx context.Context, stream string) ([]Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.stream(stream, false)
+	if s == nil {
+		return nil, nil
+	}
+	var groups []Group
+	for name, g := range s.groups {
+		info := Group{Name: name, Consumers: int64(len(g.consumers)), Pending: int64(len(g.pending)), LastDeliveredID: g.lastDelivered}
+		for _, e := range s.entries {
+			if compareID(e.ID, g.lastDelivered) > 0 {
+				info.Lag++
+			}
+		}
+		groups = append(groups, info)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups, nil
+}
+
+func (m *Memory) Pending(ctx context.Context, stream, group string, idle time.Duration, count int64) ([]PendingEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.stream(stream, false)
+	if s == nil || s.groups[group] == nil {
+		return nil, errNoGroup
+	}
+	now := time.Now()
+	var entries []PendingEntry
+	for id, p := range s.groups[group].pending {
+		if since := now.Sub(p.delivered); since >= idle {
+			entries = append(entries, PendingEntry{ID: id, Consumer: p.consumer, Idle: since, RetryCount: p.count})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return compareID(entries[i].ID, entries[j].ID) < 0 })
+	if count > 0 && int64(len(entries)) > count {
+		entries = entries[:count]
+	}
+	return entries, nil
+}
+
+func (m *Memory) Ack(ctx context.Context, stream, group string, ids ...string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.stream(stream, false)
+	if s == nil || s.groups[group] == nil {
+		return 0, nil
+	}
+	var acked int64
+	for _, id := range ids {
+		if _, ok := s.groups[group].pending[id]; ok {
+			delete(s.groups[group].pending, id)
+			acked++
+		}
+	}
+	return acked, nil
+}
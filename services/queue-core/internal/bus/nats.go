@@ -0,0 +1,650 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// timeHeader carries the publisher's clock in unix ms, the first half of a
+// NATS entry id.
+const timeHeader = "Queue-Published-Ms"
+
+// NATS carries each stream as a JetStream stream whose only subject is the
+// stream key, consumer groups as durable pull consumers of it, and
+// broadcasts as core NATS subjects.
+//
+// Entry ids keep the Redis shape "<unix ms>-<stream sequence>", with the
+// publisher's clock as the time. JetStream differs from Redis in two ways
+// callers may notice: a durable consumer redelivers entries left unacked
+// past its ack wait, and JetStream does not list a consumer's pending
+// entries, so Pending reports every entry above the ack floor up to the
+// last delivered one, including any acked out of order. Groups report no
+// consumer count, since pull consumers are not tracked by name.
+//
+// JetStream acks an entry through the delivery that carried it, so Ack
+// only acks entries this bus read with ReadGroup; entries another process
+// read are redelivered after the ack wait until their reader acks them.
+type NATS struct {
+	Conn     *nats.Conn
+	JS       jetstream.JetStream
+	Replicas int // of streams this bus creates; 0 is 1
+
+	mu         sync.Mutex
+	streams    map[string]*natsStream           // by stream key
+	deliveries map[natsDeliveryKey]natsDelivery // read by ReadGroup, not yet acked
+}
+
+type natsDeliveryKey struct {
+	stream, group string
+	seq           uint64
+}
+
+// natsDelivery is the latest delivery of an entry to this bus.
+type natsDelivery struct {
+	msg jetstream.Msg
+	at  time.Time
+}
+
+var _ EventBus = (*NATS)(nil)
+
+type natsStream struct {
+	jetstream.Stream
+	maxMsgs int64
+	maxAge  time.Duration
+}
+
+func NewNATS(nc *nats.Conn) (*NATS, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, err
+	}
+	return &NATS{Conn: nc, JS: js, streams: map[string]*natsStream{}, deliveries: map[natsDeliveryKey]natsDelivery{}}, nil
+}
+
+// natsName maps a stream key or group name to a valid JetStream name.
+var natsName = strings.NewReplacer(".", "_", " ", "_", "*", "_", ">", "_", "/", "_", "\\", "_").Replace
+
+// stream returns the JetStream stream of key, or nil when it does not
+// exist yet.
+func (n *NATS) stream(ctx context.Context, key string) (*natsStream, error) {
+	n.mu.Lock()
+	s, ok := n.streams[key]
+	n.mu.Unlock()
+	if ok {
+		return s, nil
+	}
+	js, err := n.JS.Stream(ctx, natsName(key))
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return n.remember(key, js), nil
+}
+
+func (n *NATS) remember(key string, js jetstream.Stream) *natsStream {
+	cfg := js.CachedInfo().Config
+	s := &natsStream{Stream: js, maxMsgs: cfg.MaxMsgs, maxAge: cfg.MaxAge}
+	n.mu.Lock()
+	n.streams[key] = s
+	n.mu.Unlock()
+	return s
+}
+
+// ensure returns the stream of key, creating it or updating its limits to
+// trim. JetStream enforces the limits itself, which makes trimming as
+// approximate as Redis': an age bound is kept to the minute.
+func (n *NATS) ensure(ctx context.Context, key string, trim Trim) (*natsStream, error) {
+	maxMsgs, maxAge := int64(-1), time.Duration(0)
+	if trim.MaxLen > 0 {
+		maxMsgs = trim.MaxLen
+	} else if trim.MinID != "" {
+		ms, _ := parseID(trim.MinID)
+		maxAge = max(time.Since(time.UnixMilli(int64(ms))).Round(time.Minute), time.Minute)
+	}
+
+	s, err := n.stream(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if s != nil && s.maxMsgs == maxMsgs && s.maxAge == maxAge {
+		return s, nil
+	}
+	cfg := jetstream.StreamConfig{
+		Name:      natsName(key),
+		Subjects:  []string{key},
+		Storage:   jetstream.FileStorage,
+		Retention: jetstream.LimitsPolicy,
+		Discard:   jetstream.DiscardOld,
+		MaxMsgs:   maxMsgs,
+		MaxAge:    maxAge,
+		Replicas:  max(n.Replicas, 1),
+	}
+	js, err := n.JS.CreateOrUpdateStream(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return n.remember(key, js), nil
+}
+
+func natsMsg(stream string, fields map[string]interface{}) (*nats.Msg, error) {
+	values := make(map[string]string, len(fields))
+	for k, v := range fields {
+		values[k] = fieldString(v)
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	msg := nats.NewMsg(stream)
+	msg.Data = data
+	msg.Header.Set(timeHeader, strconv.FormatInt(time.Now().UnixMilli(), 10))
+	return msg, nil
+}
+
+func (n *NATS) Publish(ctx context.Context, stream string, fields map[string]interface{}, trim Trim) (string, error) {
+	if _, err := n.ensure(ctx, stream, trim); err != nil {
+		return "", err
+	}
+	msg, err := natsMsg(stream, fields)
+	if err != nil {
+		return "", err
+	}
+	ack, err := n.JS.PublishMsg(ctx, msg)
+	if err != nil {
+		return "", err
+	}
+	return msg.Header.Get(timeHeader) + "-" + strconv.FormatUint(ack.Sequence, 10), nil
+}
+
+func (n *NATS) Broadcast(ctx context.Context, channel string, payload []byte) error {
+	return n.Conn.Publish(channel, payload)
+}
+
+// Emit publishes asynchronously and waits for every stream ack.
+func (n *NATS) Emit(ctx context.Context, events []Event) error {
+	var acks []jetstream.PubAckFuture
+	for _, e := range events {
+		if e.Stream != "" {
+			if _, err := n.ensure(ctx, e.Stream, e.Trim); err != nil {
+				return err
+			}
+			msg, err := natsMsg(e.Stream, e.Fields)
+			if err != nil {
+				return err
+			}
+			ack, err := n.JS.PublishMsgAsync(msg)
+			if err != nil {
+				return err
+			}
+			acks = append(acks, ack)
+		}
+		if e.Channel != "" {
+			if err := n.Conn.Publish(e.Channel, e.Payload); err != nil {
+				return err
+			}
+		}
+	}
+	for _, ack := range acks {
+		select {
+		case <-ack.Ok():
+		case err := <-ack.Err():
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (n *NATS) Subscribe(ctx context.Context, channel string) (Subscription, error) {
+	msgs := make(chan *nats.Msg, 64)
+	ns, err := n.Conn.ChanSubscribe(channel, msgs)
+	if err != nil {
+		return nil, err
+	}
+	// the subscription is live once the server has seen it
+	if err := n.Conn.Flush(); err != nil {
+		ns.Unsubscribe()
+		return nil, err
+	}
+	sub := &natsSubscription{sub: ns, ch: make(chan []byte), done: make(chan struct{})}
+	go func() {
+		defer close(sub.ch)
+		defer sub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sub.done:
+				return
+			case m := <-msgs:
+				select {
+				case sub.ch <- m.Data:
+				case <-ctx.Done():
+					return
+				case <-sub.done:
+					return
+				}
+			}
+		}
+	}()
+	return sub, nil
+}
+
+type natsSubscription struct {
+	sub  *nats.Subscription
+	ch   chan []byte
+	done chan struct{}
+	once sync.Once
+	err  error
+}
+
+func (s *natsSubscription) Messages() <-chan []byte { return s.ch }
+
+func (s *natsSubscription) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+		s.err = s.sub.Unsubscribe()
+	})
+	return s.err
+}
+
+func (n *NATS) Streams(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	list := n.JS.ListStreams(ctx)
+	for info := range list.Info() {
+		if subjects := info.Config.Subjects; len(subjects) == 1 && strings.HasPrefix(subjects[0], prefix) &&
+			info.Config.Name == natsName(subjects[0]) {
+			keys = append(keys, subjects[0])
+		}
+	}
+	return keys, list.Err()
+}
+
+// message converts a stored entry; the id falls back to the server's clock
+// for entries published without timeHeader.
+func message(seq uint64, at time.Time, header nats.Header, data []byte) Message {
+	ms := header.Get(timeHeader)
+	if ms == "" {
+		ms = strconv.FormatInt(at.UnixMilli(), 10)
+	}
+	var values map[string]string
+	_ = json.Unmarshal(data, &values)
+	fields := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		fields[k] = v
+	}
+	return Message{ID: ms + "-" + strconv.FormatUint(seq, 10), Values: fields}
+}
+
+// startSeq resolves a Range bound to the first stream sequence at or after
+// it. Ids from this bus carry their sequence; a bare time ("<ms>" or
+// "<ms>-0") is looked up by timestamp.
+func (n *NATS) startSeq(ctx context.Context, s *natsStream, id string) (uint64, error) {
+	if id == "-" {
+		return 1, nil
+	}
+	ms, seq := parseID(id)
+	if seq > 0 {
+		return seq, nil
+	}
+	at := time.UnixMilli(int64(ms))
+	cons, err := s.OrderedConsumer(ctx, jetstream.OrderedConsumerConfig{
+		DeliverPolicy:     jetstream.DeliverByStartTimePolicy,
+		OptStartTime:      &at,
+		InactiveThreshold: 5 * time.Second,
+	})
+	if err != nil {
+		return 0, err
+	}
+	batch, err := cons.FetchNoWait(1)
+	if err != nil {
+		return 0, err
+	}
+	for m := range batch.Messages() {
+		meta, err := m.Metadata()
+		if err != nil {
+			return 0, err
+		}
+		return meta.Sequence.Stream, nil
+	}
+	// nothing that recent: start past the end
+	info, err := s.Info(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return info.State.LastSeq + 1, nil
+}
+
+func (n *NATS) Range(ctx context.Context, stream, start, end string, count int64) ([]Message, error) {
+	s, err := n.stream(ctx, stream)
+	if s == nil || err != nil {
+		return nil, err
+	}
+	from, exclusive := strings.CutPrefix(start, "(")
+	seq, err := n.startSeq(ctx, s, from)
+	if err != nil {
+		return nil, err
+	}
+	cons, err := s.OrderedConsumer(ctx, jetstream.OrderedConsumerConfig{
+		DeliverPolicy:     jetstream.DeliverByStartSequencePolicy,
+		OptStartSeq:       seq,
+		InactiveThreshold: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// one extra in case the exclusive start is among them
+	batch, err := cons.FetchNoWait(int(count) + 1)
+	if err != nil {
+		return nil, err
+	}
+	var out []Message
+	for m := range batch.Messages() {
+		meta, err := m.Metadata()
+		if err != nil {
+			return nil, err
+		}
+		msg := message(meta.Sequence.Stream, meta.Timestamp, m.Headers(), m.Data())
+		if exclusive && !idLess(from, msg.ID) {
+			continue
+		}
+		if end != "+" && idLess(end, msg.ID) {
+			break
+		}
+		if int64(len(out)) < count {
+			out = append(out, msg)
+		}
+	}
+	if err := batch.Error(); err != nil && !errors.Is(err, jetstream.ErrNoMessages) {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (n *NATS) Entries(ctx context.Context, stream string, ids []string) ([]*Message, error) {
+	out := make([]*Message, len(ids))
+	s, err := n.stream(ctx, stream)
+	if s == nil || err != nil {
+		return out, err
+	}
+	for i, id := range ids {
+		_, seq := parseID(id)
+		raw, err := s.GetMsg(ctx, seq)
+		if errors.Is(err, jetstream.ErrMsgNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		m := message(raw.Sequence, raw.Time, raw.Header, raw.Data)
+		out[i] = &m
+	}
+	return out, nil
+}
+
+func (n *NATS) Len(ctx context.Context, stream string) (int64, error) {
+	s, err := n.stream(ctx, stream)
+	if s == nil || err != nil {
+		return 0, err
+	}
+	info, err := s.Info(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return int64(info.State.Msgs), nil
+}
+
+func (n *NATS) Size(ctx context.Context, stream string) (int64, error) {
+	s, err := n.stream(ctx, stream)
+	if s == nil || err != nil {
+		return 0, err
+	}
+	info, err := s.Info(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return int64(info.State.Bytes), nil
+}
+
+func (n *NATS) TrimBefore(ctx context.Context, stream, minID string) (int64, error) {
+	s, err := n.stream(ctx, stream)
+	if s == nil || err != nil {
+		return 0, err
+	}
+	seq, err := n.startSeq(ctx, s, minID)
+	if err != nil {
+		return 0, err
+	}
+	before, err := s.Info(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if seq <= before.State.FirstSeq {
+		return 0, nil
+	}
+	if err := s.Purge(ctx, jetstream.WithPurgeSequence(seq)); err != nil {
+		return 0, err
+	}
+	after, err := s.Info(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return int64(before.State.Msgs - after.State.Msgs), nil
+}
+
+func (n *NATS) CreateGroup(ctx context.Context, stream, group, start string) error {
+	s, err := n.stream(ctx, stream)
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		if s, err = n.ensure(ctx, stream, Trim{}); err != nil {
+			return err
+		}
+	}
+	if _, err := s.Consumer(ctx, natsName(group)); err == nil {
+		return nil
+	} else if !errors.Is(err, jetstream.ErrConsumerNotFound) {
+		return err
+	}
+	cfg := jetstream.ConsumerConfig{
+		Durable:       natsName(group),
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		DeliverPolicy: jetstream.DeliverAllPolicy,
+	}
+	if start == "$" {
+		cfg.DeliverPolicy = jetstream.DeliverNewPolicy
+	} else if _, seq := parseID(start); seq > 0 {
+		cfg.DeliverPolicy, cfg.OptStartSeq = jetstream.DeliverByStartSequencePolicy, seq+1
+	}
+	_, err = s.CreateConsumer(ctx, cfg)
+	return err
+}
+
+// ReadGroup fetches from the group's durable consumer; consumer names the
+// reader only in the group's pending list on Redis and is not tracked here.
+func (n *NATS) ReadGroup(ctx context.Context, stream, group, consumer string, count int64, block time.Duration) ([]Message, error) {
+	s, err := n.stream(ctx, stream)
+	if s == nil || err != nil {
+		return nil, err
+	}
+	cons, err := s.Consumer(ctx, natsName(group))
+	if err != nil {
+		return nil, err
+	}
+	var batch jetstream.MessageBatch
+	if block > 0 {
+		batch, err = cons.Fetch(int(count), jetstream.FetchMaxWait(block))
+	} else {
+		batch, err = cons.FetchNoWait(int(count))
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []Message
+	for m := range batch.Messages() {
+		meta, err := m.Metadata()
+		if err != nil {
+			return nil, err
+		}
+		n.mu.Lock()
+		n.deliveries[natsDeliveryKey{stream, group, meta.Sequence.Stream}] = natsDelivery{msg: m, at: time.Now()}
+		n.mu.Unlock()
+		out = append(out, message(meta.Sequence.Stream, meta.Timestamp, m.Headers(), m.Data()))
+	}
+	if err := batch.Error(); err != nil && !errors.Is(err, jetstream.ErrNoMessages) && !errors.Is(err, nats.ErrTimeout) {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (n *NATS) Groups(ctx context.Context, stream string) ([]Group, error) {
+	s, err := n.stream(ctx, stream)
+	if s == nil || err != nil {
+		return nil, err
+	}
+	var groups []Group
+	list := s.ListConsumers(ctx)
+	for info := range list.Info() {
+		if info.Config.Durable == "" {
+			continue // ordered consumers of Range
+		}
+		last, err := n.idOf(ctx, s, info.Delivered.Stream)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, Group{
+			Name:            info.Name,
+			Pending:         int64(info.NumAckPending),
+			LastDeliveredID: last,
+			Lag:             int64(info.NumPending),
+		})
+	}
+	return groups, list.Err()
+}
+
+// idOf returns the id of the entry at seq, or "0-<seq>" when it is gone;
+// that id sorts before every live entry, so it never lets retention trim.
+func (n *NATS) idOf(ctx context.Context, s *natsStream, seq uint64) (string, error) {
+	if seq == 0 {
+		return "0-0", nil
+	}
+	raw, err := s.GetMsg(ctx, seq)
+	if errors.Is(err, jetstream.ErrMsgNotFound) {
+		return "0-" + strconv.FormatUint(seq, 10), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return message(raw.Sequence, raw.Time, raw.Header, nil).ID, nil
+}
+
+// Pending walks up from the ack floor. Idle runs from the entry's last
+// delivery to this bus; for entries other processes read, JetStream only
+// reports the consumer's last delivery, which no pending entry is younger
+// than.
+func (n *NATS) Pending(ctx context.Context, stream, group string, idle time.Duration, count int64) ([]PendingEntry, error) {
+	s, err := n.stream(ctx, stream)
+	if s == nil || err != nil {
+		return nil, err
+	}
+	cons, err := s.Consumer(ctx, natsName(group))
+	if err != nil {
+		return nil, err
+	}
+	info := cons.CachedInfo()
+	n.forget(stream, group, info.AckFloor.Stream)
+	if info.NumAckPending == 0 {
+		return nil, nil
+	}
+	var out []PendingEntry
+	for seq := info.AckFloor.Stream + 1; seq <= info.Delivered.Stream && int64(len(out)) < count; seq++ {
+		raw, err := s.GetMsg(ctx, seq)
+		if errors.Is(err, jetstream.ErrMsgNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var since time.Duration
+		n.mu.Lock()
+		d, ok := n.deliveries[natsDeliveryKey{stream, group, seq}]
+		n.mu.Unlock()
+		switch {
+		case ok:
+			since = time.Since(d.at)
+		case info.Delivered.Last != nil:
+			since = time.Since(*info.Delivered.Last)
+		}
+		if since < idle {
+			continue
+		}
+		m := message(raw.Sequence, raw.Time, raw.Header, nil)
+		out = append(out, PendingEntry{ID: m.ID, Consumer: group, Idle: since})
+	}
+	return out, nil
+}
+
+// Ack acks each id through the delivery ReadGroup returned it in. The
+// count is of ids this bus held a delivery of.
+func (n *NATS) Ack(ctx context.Context, stream, group string, ids ...string) (int64, error) {
+	var acked int64
+	for _, id := range ids {
+		_, seq := parseID(id)
+		key := natsDeliveryKey{stream, group, seq}
+		n.mu.Lock()
+		d, ok := n.deliveries[key]
+		delete(n.deliveries, key)
+		n.mu.Unlock()
+		if !ok {
+			continue
+		}
+		if err := d.msg.Ack(); err != nil {
+			return acked, err
+		}
+		acked++
+	}
+	return acked, n.Conn.Flush()
+}
+
+// forget drops the deliveries of group at or below its ack floor: acked,
+// whoever acked them.
+func (n *NATS) forget(stream, group string, ackFloor uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for key := range n.deliveries {
+		if key.stream == stream && key.group == group && key.seq <= ackFloor {
+			delete(n.deliveries, key)
+		}
+	}
+}
+
+// parseID splits "<ms>-<seq>"; a bare "<ms>" has seq 0.
+func parseID(id string) (ms, seq uint64) {
+	m, q, _ := strings.Cut(id, "-")
+	ms, _ = strconv.ParseUint(m, 10, 64)
+	seq, _ = strconv.ParseUint(q, 10, 64)
+	return ms, seq
+}
+
+// idLess orders by sequence when both ids have one: publisher clocks may
+// disagree, the stream sequence never does.
+func idLess(a, b string) bool {
+	am, as := parseID(a)
+	bm, bs := parseID(b)
+	if as > 0 && bs > 0 {
+		return as < bs
+	}
+	return am < bm || (am == bm && as < bs)
+}
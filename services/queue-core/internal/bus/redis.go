@@ -0,0 +1,203 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis carries streams as Redis streams and broadcasts as Redis pub/sub.
+type Redis struct {
+	Client *redis.Client
+}
+
+var _ EventBus = (*Redis)(nil)
+
+func NewRedis(client *redis.Client) *Redis {
+	return &Redis{Client: client}
+}
+
+func xaddArgs(stream string, fields map[string]interface{}, trim Trim) *redis.XAddArgs {
+	args := &redis.XAddArgs{Stream: stream, Values: fields}
+	switch {
+	case trim.MaxLen > 0:
+		args.MaxLen, args.Approx = trim.MaxLen, true
+	case trim.MinID != "":
+		args.MinID, args.Approx = trim.MinID, true
+	}
+	return args
+}
+
+func (r *Redis) Publish(ctx context.Context, stream string, fields map[string]interface{}, trim Trim) (string, error) {
+	return r.Client.XAdd(ctx, xaddArgs(stream, fields, trim)).Result()
+}
+
+func (r *Redis) Broadcast(ctx context.Context, channel string, payload []byte) error {
+	return r.Client.Publish(ctx, channel, payload).Err()
+}
+
+// Emit sends every event in one pipeline.
+func (r *Redis) Emit(ctx context.Context, events []Event) error {
+	_, err := r.Client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, e := range events {
+			if e.Stream != "" {
+				p.XAdd(ctx, xaddArgs(e.Stream, e.Fields, e.Trim))
+			}
+			if e.Channel != "" {
+				p.Publish(ctx, e.Channel, e.Payload)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+func (r *Redis) Subscribe(ctx context.Context, channel string) (Subscription, error) {
+	ps := r.Client.Subscribe(ctx, channel)
+	sub := &redisSubscription{ps: ps, ch: make(chan []byte)}
+	go func() {
+		defer close(sub.ch)
+		for msg := range ps.Channel() {
+			select {
+			case sub.ch <- []byte(msg.Payload):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
+
+type redisSubscription struct {
+	ps *redis.PubSub
+	ch chan []byte
+}
+
+func (s *redisSubscription) Messages() <-chan []byte { return s.ch }
+
+func (s *redisSubscription) Close() error { return s.ps.Close() }
+
+func (r *Redis) Streams(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	iter := r.Client.ScanType(ctx, 0, prefix+"*", 100, "stream").Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+func (r *Redis) Range(ctx context.Context, stream, start, end string, count int64) ([]Message, error) {
+	msgs, err := r.Client.XRangeN(ctx, stream, start, end, count).Result()
+	return messages(msgs), err
+}
+
+// Entries reads every id in one pipeline.
+func (r *Redis) Entries(ctx context.Context, stream string, ids []string) ([]*Message, error) {
+	cmds, err := r.Client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, id := range ids {
+			p.XRangeN(ctx, stream, id, id, 1)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*Message, len(ids))
+	for i, cmd := range cmds {
+		if msgs := cmd.(*redis.XMessageSliceCmd).Val(); len(msgs) > 0 {
+			out[i] = &Message{ID: msgs[0].ID, Values: msgs[0].Values}
+		}
+	}
+	return out, nil
+}
+
+func (r *Redis) Len(ctx context.Context, stream string) (int64, error) {
+	return r.Client.XLen(ctx, stream).Result()
+}
+
+func (r *Redis) Size(ctx context.Context, stream string) (int64, error) {
+	return r.Client.MemoryUsage(ctx, stream).Result()
+}
+
+func (r *Redis) TrimBefore(ctx context.Context, stream, minID string) (int64, error) {
+	return r.Client.XTrimMinID(ctx, stream, minID).Result()
+}
+
+func (r *Redis) CreateGroup(ctx context.Context, stream, group, start string) error {
+	err := r.Client.XGroupCreateMkStream(ctx, stream, group, start).Err()
+	if err != nil && strings.Contains(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// ReadGroup returns at once when block is not positive.
+func (r *Redis) ReadGroup(ctx context.Context, stream, group, consumer string, count int64, block time.Duration) ([]Message, error) {
+	if block <= 0 {
+		block = -1 // go-redis blocks forever on 0
+	}
+	res, err := r.Client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{stream, ">"},
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil || len(res) == 0 {
+		return nil, err
+	}
+	return messages(res[0].Messages), nil
+}
+
+func (r *Redis) Groups(ctx context.Context, stream string) ([]Group, error) {
+	infos, err := r.Client.XInfoGroups(ctx, stream).Result()
+	if err != nil {
+		if strings.Contains(err.Error(), "no such key") {
+			return nil, nil
+		}
+		return nil, err
+	}
+	groups := make([]Group, len(infos))
+	for i, g := range infos {
+		groups[i] = Group{Name: g.Name, Consumers: g.Consumers, Pending: g.Pending,
+			LastDeliveredID: g.LastDeliveredID, Lag: g.Lag}
+	}
+	return groups, nil
+}
+
+func (r *Redis) Pending(ctx context.Context, stream, group string, idle time.Duration, count int64) ([]PendingEntry, error) {
+	pending, err := r.Client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: stream,
+		Group:  group,
+		Idle:   idle,
+		Start:  "-",
+		End:    "+",
+		Count:  count,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]PendingEntry, len(pending))
+	for i, p := range pending {
+		out[i] = PendingEntry{ID: p.ID, Consumer: p.Consumer, Idle: p.Idle, RetryCount: p.RetryCount}
+	}
+	return out, nil
+}
+
+func (r *Redis) Ack(ctx context.Context, stream, group string, ids ...string) (int64, error) {
+	return r.Client.XAck(ctx, stream, group, ids...).Result()
+}
+
+func messages(msgs []redis.XMessage) []Message {
+	out := make([]Message, len(msgs))
+	for i, m := range msgs {
+		out[i] = Message{ID: m.ID, Values: m.Values}
+	}
+	return out
+}
@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// TicketReadyChannel is the NOTIFY channel raised by the notify_ticket_ready trigger.
+const TicketReadyChannel = "ticket_ready"
+
+// Listener holds a dedicated Postgres connection on LISTEN and turns
+// notifications (payload = queue id) into per-queue wakeups.
+type Listener struct {
+	connStr string
+	channel string
+
+	mu   sync.Mutex
+	subs map[int64]map[chan struct{}]struct{}
+}
+
+func NewListener(connStr, channel string) *Listener {
+	return &Listener{connStr: connStr, channel: channel, subs: map[int64]map[chan struct{}]struct{}{}}
+}
+
+// Subscribe returns a channel signalled whenever queueID has a ticket ready.
+// Signals coalesce: a slow reader sees one pending wakeup, not a backlog.
+// Call the returned func to unsubscribe.
+func (l *Listener) Subscribe(queueID int64) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	l.mu.Lock()
+	if l.subs[queueID] == nil {
+		l.subs[queueID] = map[chan struct{}]struct{}{}
+	}
+	l.subs[queueID][ch] = struct{}{}
+	l.mu.Unlock()
+
+	return ch, func() {
+		l.mu.Lock()
+		delete(l.subs[queueID], ch)
+		l.mu.Unlock()
+	}
+}
+
+// wake signals subscribers of queueID, or of every queue when all is true.
+func (l *Listener) wake(queueID int64, all bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for qid, set := range l.subs {
+		if !all && qid != queueID {
+			continue
+		}
+		for ch := range set {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// Run listens until ctx is done, reconnecting with backoff. Every (re)connect
+// wakes all subscribers, since notifications sent while disconnected are lost.
+func (l *Listener) Run(ctx context.Context) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		connected, err := l.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			backoff = time.Second
+		}
+		log.Printf("listener %s: %v; reconnecting in %s", l.channel, err, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// listen reports whether it got as far as LISTEN before failing.
+func (l *Listener) listen(ctx context.Context) (bool, error) {
+	conn, err := pgx.Connect(ctx, l.connStr)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{l.channel}.Sanitize()); err != nil {
+		return false, err
+	}
+	l.wake(0, true)
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return true, err
+		}
+		queueID, err := strconv.ParseInt(n.Payload, 10, 64)
+		if err != nil {
+			continue
+		}
+		l.wake(queueID, false)
+	}
+}
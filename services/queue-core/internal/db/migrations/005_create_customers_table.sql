@@ -0,0 +1,49 @@
+-- 05_create_customers_table.sql
+
+CREATE TABLE customers (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL DEFAULT '',
+    phone TEXT UNIQUE,
+    email TEXT UNIQUE,
+    external_id TEXT UNIQUE,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
+    CHECK (phone IS NOT NULL OR email IS NOT NULL OR external_id IS NOT NULL)
+);
+
+ALTER TABLE tickets ADD COLUMN customer_id BIGINT REFERENCES customers(id);
+ALTER TABLE ticket_history ADD COLUMN customer_id BIGINT;
+
+-- Active-ticket lookups for duplicate-join prevention
+CREATE INDEX idx_tickets_queue_customer
+    ON tickets(queue_id, customer_id)
+    WHERE customer_id IS NOT NULL;
+
+-- Visit history per customer, newest first
+CREATE INDEX idx_ticket_history_customer
+    ON ticket_history(customer_id, archived_at DESC)
+    WHERE customer_id IS NOT NULL;
+
+-- Carry customer_id into history on archive
+CREATE OR REPLACE FUNCTION archive_completed_ticket()
+RETURNS trigger AS $$
+BEGIN
+    -- Only archive when status transitions to 'done'
+    IF NEW.status = 'done' AND OLD.status IS DISTINCT FROM 'done' THEN
+        INSERT INTO ticket_history (
+            id, queue_id, customer_id, customer_name, status, priority,
+            estimated_time, version, created_at, updated_at
+        )
+        VALUES (
+            OLD.id, OLD.queue_id, OLD.customer_id, OLD.customer_name, OLD.status, OLD.priority,
+            OLD.estimated_time, OLD.version, OLD.created_at, OLD.updated_at
+        );
+
+        DELETE FROM tickets WHERE id = OLD.id;
+
+        RETURN NULL; -- Do not update original table
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,10 @@
+-- 06_create_queues_table.sql
+
+-- Optional per-queue rules. Queues without a row use the defaults below.
+CREATE TABLE queues (
+    id BIGINT PRIMARY KEY,
+    name TEXT NOT NULL DEFAULT '',
+    max_active_per_customer INT NOT NULL DEFAULT 0, -- 0 = unlimited
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
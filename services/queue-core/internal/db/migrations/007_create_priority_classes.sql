@@ -0,0 +1,51 @@
+-- 07_create_priority_classes.sql
+
+-- Named priority classes per queue and who may assign them
+CREATE TABLE priority_classes (
+    queue_id BIGINT NOT NULL,
+    name TEXT NOT NULL,
+    priority INT NOT NULL DEFAULT 1,
+    lane TEXT NOT NULL DEFAULT 'standard',
+    assignable_by VARCHAR(20) NOT NULL DEFAULT 'staff' CHECK (assignable_by IN ('kiosk', 'staff')),
+    PRIMARY KEY (queue_id, name)
+);
+
+-- Call-out weights per lane; queues without rows are served strictly FIFO
+CREATE TABLE queue_lanes (
+    queue_id BIGINT NOT NULL,
+    lane TEXT NOT NULL,
+    weight INT NOT NULL DEFAULT 1 CHECK (weight > 0),
+    PRIMARY KEY (queue_id, lane)
+);
+
+ALTER TABLE tickets ADD COLUMN priority_class TEXT;
+ALTER TABLE tickets ADD COLUMN lane TEXT NOT NULL DEFAULT 'standard';
+ALTER TABLE ticket_history ADD COLUMN priority_class TEXT;
+ALTER TABLE ticket_history ADD COLUMN lane TEXT;
+
+CREATE INDEX idx_tickets_waiting_lane
+    ON tickets(queue_id, lane, created_at)
+    WHERE status = 'waiting';
+
+CREATE OR REPLACE FUNCTION archive_completed_ticket()
+RETURNS trigger AS $$
+BEGIN
+    -- Only archive when status transitions to 'done'
+    IF NEW.status = 'done' AND OLD.status IS DISTINCT FROM 'done' THEN
+        INSERT INTO ticket_history (
+            id, queue_id, customer_id, customer_name, status, priority,
+            priority_class, lane, estimated_time, version, created_at, updated_at
+        )
+        VALUES (
+            OLD.id, OLD.queue_id, OLD.customer_id, OLD.customer_name, OLD.status, OLD.priority,
+            OLD.priority_class, OLD.lane, OLD.estimated_time, OLD.version, OLD.created_at, OLD.updated_at
+        );
+
+        DELETE FROM tickets WHERE id = OLD.id;
+
+        RETURN NULL; -- Do not update original table
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,41 @@
+-- 08_add_ticket_attributes.sql
+
+-- Per-queue intake schema: which custom fields a ticket must/may carry
+CREATE TABLE queue_intake_fields (
+    queue_id BIGINT NOT NULL,
+    name TEXT NOT NULL,
+    type VARCHAR(20) NOT NULL CHECK (type IN ('string', 'number', 'boolean', 'enum')),
+    required BOOLEAN NOT NULL DEFAULT FALSE,
+    options JSONB NOT NULL DEFAULT '[]', -- allowed values for enum fields
+    position INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (queue_id, name)
+);
+
+ALTER TABLE tickets ADD COLUMN attributes JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE ticket_history ADD COLUMN attributes JSONB;
+
+-- Attribute filters on listing endpoints
+CREATE INDEX idx_tickets_attributes ON tickets USING GIN (attributes);
+
+CREATE OR REPLACE FUNCTION archive_completed_ticket()
+RETURNS trigger AS $$
+BEGIN
+    -- Only archive when status transitions to 'done'
+    IF NEW.status = 'done' AND OLD.status IS DISTINCT FROM 'done' THEN
+        INSERT INTO ticket_history (
+            id, queue_id, customer_id, customer_name, status, priority,
+            priority_class, lane, attributes, estimated_time, version, created_at, updated_at
+        )
+        VALUES (
+            OLD.id, OLD.queue_id, OLD.customer_id, OLD.customer_name, OLD.status, OLD.priority,
+            OLD.priority_class, OLD.lane, OLD.attributes, OLD.estimated_time, OLD.version, OLD.created_at, OLD.updated_at
+        );
+
+        DELETE FROM tickets WHERE id = OLD.id;
+
+        RETURN NULL; -- Do not update original table
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,50 @@
+-- 09_create_ticket_feedback.sql
+
+-- Customer-held token (for feedback and self-service) and who served the ticket
+ALTER TABLE tickets ADD COLUMN token TEXT UNIQUE;
+ALTER TABLE tickets ADD COLUMN counter_id BIGINT;
+ALTER TABLE tickets ADD COLUMN assigned_worker BIGINT;
+ALTER TABLE ticket_history ADD COLUMN token TEXT;
+ALTER TABLE ticket_history ADD COLUMN counter_id BIGINT;
+ALTER TABLE ticket_history ADD COLUMN assigned_worker BIGINT;
+
+CREATE UNIQUE INDEX idx_ticket_history_token ON ticket_history(token) WHERE token IS NOT NULL;
+
+-- One rating per served ticket
+CREATE TABLE ticket_feedback (
+    ticket_id BIGINT PRIMARY KEY REFERENCES ticket_history(id),
+    queue_id BIGINT NOT NULL,
+    counter_id BIGINT,
+    staff_id BIGINT,
+    rating SMALLINT NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    comment TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- Aggregation per queue, counter and day
+CREATE INDEX idx_ticket_feedback_queue_day ON ticket_feedback(queue_id, created_at);
+
+CREATE OR REPLACE FUNCTION archive_completed_ticket()
+RETURNS trigger AS $$
+BEGIN
+    -- Only archive when status transitions to 'done'
+    IF NEW.status = 'done' AND OLD.status IS DISTINCT FROM 'done' THEN
+        INSERT INTO ticket_history (
+            id, queue_id, customer_id, customer_name, status, priority,
+            priority_class, lane, attributes, token, counter_id, assigned_worker,
+            estimated_time, version, created_at, updated_at
+        )
+        VALUES (
+            OLD.id, OLD.queue_id, OLD.customer_id, OLD.customer_name, OLD.status, OLD.priority,
+            OLD.priority_class, OLD.lane, OLD.attributes, NEW.token, NEW.counter_id, NEW.assigned_worker,
+            OLD.estimated_time, OLD.version, OLD.created_at, OLD.updated_at
+        );
+
+        DELETE FROM tickets WHERE id = OLD.id;
+
+        RETURN NULL; -- Do not update original table
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,39 @@
+-- 10_add_ticket_cancellation.sql
+
+ALTER TABLE tickets ADD COLUMN cancel_reason TEXT;
+ALTER TABLE tickets ADD COLUMN cancelled_by TEXT;
+ALTER TABLE ticket_history ADD COLUMN cancel_reason TEXT;
+ALTER TABLE ticket_history ADD COLUMN cancelled_by TEXT;
+ALTER TABLE ticket_history ADD COLUMN wait_seconds INT; -- wait before abandoning, cancelled tickets only
+
+-- Abandonment reporting per queue and arrival hour
+CREATE INDEX idx_ticket_history_queue_created ON ticket_history(queue_id, created_at);
+
+-- Archive cancelled tickets as well as done ones. From here on history keeps
+-- the final status ('done' / 'cancelled') rather than the pre-archive one.
+CREATE OR REPLACE FUNCTION archive_completed_ticket()
+RETURNS trigger AS $$
+BEGIN
+    IF NEW.status IN ('done', 'cancelled') AND OLD.status IS DISTINCT FROM NEW.status THEN
+        INSERT INTO ticket_history (
+            id, queue_id, customer_id, customer_name, status, priority,
+            priority_class, lane, attributes, token, counter_id, assigned_worker,
+            cancel_reason, cancelled_by, wait_seconds,
+            estimated_time, version, created_at, updated_at
+        )
+        VALUES (
+            OLD.id, OLD.queue_id, OLD.customer_id, OLD.customer_name, NEW.status, OLD.priority,
+            OLD.priority_class, OLD.lane, OLD.attributes, NEW.token, NEW.counter_id, NEW.assigned_worker,
+            NEW.cancel_reason, NEW.cancelled_by,
+            CASE WHEN NEW.status = 'cancelled' THEN EXTRACT(EPOCH FROM NOW() - OLD.created_at)::INT END,
+            OLD.estimated_time, OLD.version, OLD.created_at, OLD.updated_at
+        );
+
+        DELETE FROM tickets WHERE id = OLD.id;
+
+        RETURN NULL; -- Do not update original table
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,19 @@
+-- 11_notify_ticket_ready.sql
+
+-- Wake dispatchers as soon as a ticket becomes reservable: on insert as
+-- waiting, and on any transition back to waiting (requeue). The payload is
+-- the queue id so listeners can wake only that queue's dispatcher.
+CREATE OR REPLACE FUNCTION notify_ticket_ready()
+RETURNS trigger AS $$
+BEGIN
+    IF NEW.status = 'waiting' AND (TG_OP = 'INSERT' OR OLD.status IS DISTINCT FROM 'waiting') THEN
+        PERFORM pg_notify('ticket_ready', NEW.queue_id::text);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER notify_ticket_ready
+AFTER INSERT OR UPDATE OF status ON tickets
+FOR EACH ROW
+EXECUTE FUNCTION notify_ticket_ready();
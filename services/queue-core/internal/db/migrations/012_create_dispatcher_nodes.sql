@@ -0,0 +1,11 @@
+-- 12_create_dispatcher_nodes.sql
+
+-- Live queue-core replicas. Each replica heartbeats here so others can work
+-- out their fair share of queues to lead; queue leadership itself is held
+-- with session-level advisory locks, released the moment a leader's
+-- connection dies.
+CREATE TABLE dispatcher_nodes (
+    node_id TEXT PRIMARY KEY,
+    started_at TIMESTAMPTZ DEFAULT NOW(),
+    heartbeat_at TIMESTAMPTZ DEFAULT NOW()
+);
@@ -0,0 +1,11 @@
+-- 13_add_dispatch_strategy.sql
+
+-- Per-queue dispatch strategy (fifo, priority, shortest_job, weighted_fair,
+-- skill_match, ...) and its JSON config. NULL means weighted_fair over the
+-- queue's lanes, which is plain FIFO when no lanes are configured.
+ALTER TABLE queues ADD COLUMN dispatch_strategy TEXT;
+ALTER TABLE queues ADD COLUMN dispatch_config JSONB NOT NULL DEFAULT '{}';
+
+CREATE INDEX idx_tickets_waiting_priority
+    ON tickets(queue_id, priority DESC, created_at)
+    WHERE status = 'waiting';
@@ -0,0 +1,48 @@
+-- 14_create_workers.sql
+
+-- Capacity advertised by workers / counters per queue. A row counts toward
+-- dispatch capacity only while its heartbeat is fresh.
+CREATE TABLE workers (
+    worker_id BIGINT NOT NULL,
+    queue_id BIGINT NOT NULL,
+    counter_id BIGINT,
+    capacity INT NOT NULL DEFAULT 1 CHECK (capacity >= 0),
+    last_seen_at TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (worker_id, queue_id)
+);
+
+CREATE INDEX idx_workers_queue_seen ON workers(queue_id, last_seen_at);
+
+-- In-flight count per queue for capacity checks
+CREATE INDEX idx_tickets_processing
+    ON tickets(queue_id)
+    WHERE status = 'processing';
+
+-- Also wake dispatchers when capacity frees up: a ticket leaves processing,
+-- or a worker advertises (more) capacity.
+CREATE OR REPLACE FUNCTION notify_ticket_ready()
+RETURNS trigger AS $$
+BEGIN
+    IF NEW.status = 'waiting' AND (TG_OP = 'INSERT' OR OLD.status IS DISTINCT FROM 'waiting') THEN
+        PERFORM pg_notify('ticket_ready', NEW.queue_id::text);
+    ELSIF TG_OP = 'UPDATE' AND OLD.status = 'processing' AND NEW.status IS DISTINCT FROM 'processing' THEN
+        PERFORM pg_notify('ticket_ready', NEW.queue_id::text);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION notify_worker_capacity()
+RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' OR NEW.capacity > OLD.capacity THEN
+        PERFORM pg_notify('ticket_ready', NEW.queue_id::text);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER notify_worker_capacity
+AFTER INSERT OR UPDATE OF capacity ON workers
+FOR EACH ROW
+EXECUTE FUNCTION notify_worker_capacity();
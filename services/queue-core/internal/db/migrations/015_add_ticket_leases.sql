@@ -0,0 +1,11 @@
+-- 15_add_ticket_leases.sql
+
+-- Tickets claimed over HTTP are leased to a worker (assigned_worker) until
+-- lease_expires_at. The worker acks, nacks or extends before expiry; an
+-- expired lease is requeued by the lease reaper. Stream-dispatched tickets
+-- carry no lease.
+ALTER TABLE tickets ADD COLUMN lease_expires_at TIMESTAMPTZ;
+
+CREATE INDEX idx_tickets_lease_expiry
+    ON tickets(lease_expires_at)
+    WHERE status = 'processing' AND lease_expires_at IS NOT NULL;
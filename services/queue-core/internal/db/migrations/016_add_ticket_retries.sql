@@ -0,0 +1,42 @@
+-- 16_add_ticket_retries.sql
+
+-- Failed deliveries (requeue, nack, expired lease) bump attempts and push
+-- available_at out by an exponential backoff; reservation skips tickets not
+-- yet available. After the service-wide retry policy's max attempts a ticket
+-- parks in 'dead_letter' until staff retry or discard it.
+ALTER TABLE tickets ADD COLUMN attempts INT NOT NULL DEFAULT 0;
+ALTER TABLE tickets ADD COLUMN available_at TIMESTAMPTZ; -- NULL: available now
+ALTER TABLE ticket_history ADD COLUMN attempts INT NOT NULL DEFAULT 0;
+
+CREATE INDEX idx_tickets_dead_letter
+    ON tickets(queue_id)
+    WHERE status = 'dead_letter';
+
+-- Archive discarded dead letters too, and keep their attempt count.
+CREATE OR REPLACE FUNCTION archive_completed_ticket()
+RETURNS trigger AS $$
+BEGIN
+    IF NEW.status IN ('done', 'cancelled', 'discarded') AND OLD.status IS DISTINCT FROM NEW.status THEN
+        INSERT INTO ticket_history (
+            id, queue_id, customer_id, customer_name, status, priority,
+            priority_class, lane, attributes, token, counter_id, assigned_worker,
+            cancel_reason, cancelled_by, wait_seconds, attempts,
+            estimated_time, version, created_at, updated_at
+        )
+        VALUES (
+            OLD.id, OLD.queue_id, OLD.customer_id, OLD.customer_name, NEW.status, OLD.priority,
+            OLD.priority_class, OLD.lane, OLD.attributes, NEW.token, NEW.counter_id, NEW.assigned_worker,
+            NEW.cancel_reason, NEW.cancelled_by,
+            CASE WHEN NEW.status = 'cancelled' THEN EXTRACT(EPOCH FROM NOW() - OLD.created_at)::INT END,
+            NEW.attempts,
+            OLD.estimated_time, OLD.version, OLD.created_at, OLD.updated_at
+        );
+
+        DELETE FROM tickets WHERE id = OLD.id;
+
+        RETURN NULL; -- Do not update original table
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
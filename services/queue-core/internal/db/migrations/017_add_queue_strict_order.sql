@@ -0,0 +1,7 @@
+-- 17_add_queue_strict_order.sql
+
+-- Opt-in strict call order: reservers of the queue serialize on a queue-head
+-- advisory lock and wait for a locked head row instead of skipping past it,
+-- so tickets are called in exact strategy order even with several
+-- concurrent reservers (dispatcher, claim API, replicas).
+ALTER TABLE queues ADD COLUMN strict_order BOOLEAN NOT NULL DEFAULT FALSE;
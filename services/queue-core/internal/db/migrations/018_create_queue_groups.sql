@@ -0,0 +1,53 @@
+-- 18_create_queue_groups.sql
+
+-- Groups of equivalent queues. Tickets created for a group are routed to
+-- the member with the lowest projected wait (waiting x average service time
+-- / live servers); the decision is kept on the ticket.
+CREATE TABLE queue_groups (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE TABLE queue_group_members (
+    group_id BIGINT NOT NULL REFERENCES queue_groups(id) ON DELETE CASCADE,
+    queue_id BIGINT NOT NULL,
+    PRIMARY KEY (group_id, queue_id)
+);
+
+ALTER TABLE tickets ADD COLUMN group_id BIGINT;
+ALTER TABLE tickets ADD COLUMN routing JSONB;
+ALTER TABLE ticket_history ADD COLUMN group_id BIGINT;
+ALTER TABLE ticket_history ADD COLUMN routing JSONB;
+
+-- Service-time stats per queue over recent completions
+CREATE INDEX idx_ticket_history_queue_archived ON ticket_history(queue_id, archived_at) WHERE status = 'done';
+
+CREATE OR REPLACE FUNCTION archive_completed_ticket()
+RETURNS trigger AS $$
+BEGIN
+    IF NEW.status IN ('done', 'cancelled', 'discarded') AND OLD.status IS DISTINCT FROM NEW.status THEN
+        INSERT INTO ticket_history (
+            id, queue_id, customer_id, customer_name, status, priority,
+            priority_class, lane, attributes, token, counter_id, assigned_worker,
+            cancel_reason, cancelled_by, wait_seconds, attempts, group_id, routing,
+            estimated_time, version, created_at, updated_at
+        )
+        VALUES (
+            OLD.id, OLD.queue_id, OLD.customer_id, OLD.customer_name, NEW.status, OLD.priority,
+            OLD.priority_class, OLD.lane, OLD.attributes, NEW.token, NEW.counter_id, NEW.assigned_worker,
+            NEW.cancel_reason, NEW.cancelled_by,
+            CASE WHEN NEW.status = 'cancelled' THEN EXTRACT(EPOCH FROM NOW() - OLD.created_at)::INT END,
+            NEW.attempts, OLD.group_id, OLD.routing,
+            OLD.estimated_time, OLD.version, OLD.created_at, OLD.updated_at
+        );
+
+        DELETE FROM tickets WHERE id = OLD.id;
+
+        RETURN NULL; -- Do not update original table
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
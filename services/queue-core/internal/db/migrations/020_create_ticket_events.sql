@@ -0,0 +1,97 @@
+-- 20_create_ticket_events.sql
+
+-- Append-only log of every ticket state change. A trigger writes it in the
+-- transaction of the change itself, so no code path can skip it, and rows
+-- outlive the ticket's archival to ticket_history. No foreign key: archived
+-- tickets are deleted from tickets.
+CREATE TABLE ticket_events (
+    id BIGSERIAL PRIMARY KEY,
+    ticket_id BIGINT NOT NULL,
+    queue_id BIGINT NOT NULL,
+    kind TEXT NOT NULL,            -- created, reserved, requeued, delayed, transferred, ... or the new status
+    from_status TEXT,              -- NULL on creation
+    to_status TEXT NOT NULL,
+    from_queue_id BIGINT,          -- set when the ticket moved queue
+    version BIGINT NOT NULL,       -- the ticket's version after the change
+    attempts INT NOT NULL DEFAULT 0,
+    available_at TIMESTAMPTZ,      -- retry backoff, when delayed
+    actor TEXT NOT NULL,           -- worker:<id>, staff, customer or system
+    reason TEXT,
+    occurred_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_ticket_events_ticket ON ticket_events(ticket_id, id);
+CREATE INDEX idx_ticket_events_queue_time ON ticket_events(queue_id, occurred_at);
+
+-- Nobody rewrites history
+CREATE OR REPLACE FUNCTION ticket_events_append_only()
+RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'ticket_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER ticket_events_append_only
+BEFORE UPDATE OR DELETE ON ticket_events
+FOR EACH ROW
+EXECUTE FUNCTION ticket_events_append_only();
+
+-- The actor is whoever the row names: the canceller for cancellations, the
+-- assigned worker for claims and completions, otherwise the system
+-- (dispatcher, reaper, reconciler). A transaction may name it explicitly
+-- with set_config('queue_core.actor', ..., true).
+CREATE OR REPLACE FUNCTION record_ticket_event()
+RETURNS trigger AS $$
+DECLARE
+    v_kind TEXT;
+    v_from TEXT;
+    v_actor TEXT := NULLIF(current_setting('queue_core.actor', true), '');
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        v_kind := 'created';
+    ELSE
+        IF OLD.status IS NOT DISTINCT FROM NEW.status
+           AND OLD.queue_id IS NOT DISTINCT FROM NEW.queue_id
+           AND OLD.available_at IS NOT DISTINCT FROM NEW.available_at THEN
+            RETURN NULL; -- lease extensions and other bookkeeping
+        END IF;
+        v_from := OLD.status;
+        v_kind := CASE
+            WHEN OLD.queue_id IS DISTINCT FROM NEW.queue_id THEN 'transferred'
+            WHEN NEW.status = 'waiting' AND NEW.available_at > NOW() THEN 'delayed'
+            WHEN NEW.status = 'waiting' AND OLD.status IN ('processing', 'in_progress') THEN 'requeued'
+            WHEN NEW.status = 'waiting' AND OLD.status = 'dead_letter' THEN 'retried'
+            WHEN NEW.status = 'processing' AND NEW.lease_expires_at IS NOT NULL THEN 'claimed'
+            WHEN NEW.status = 'processing' THEN 'reserved'
+            WHEN NEW.status = 'dead_letter' THEN 'dead_lettered'
+            ELSE NEW.status
+        END;
+    END IF;
+
+    IF v_actor IS NULL THEN
+        v_actor := CASE
+            WHEN NEW.status = 'cancelled' AND NEW.cancelled_by IS NOT NULL THEN NEW.cancelled_by
+            WHEN NEW.status IN ('processing', 'in_progress', 'done') AND NEW.assigned_worker IS NOT NULL
+                THEN 'worker:' || NEW.assigned_worker
+            ELSE 'system'
+        END;
+    END IF;
+
+    INSERT INTO ticket_events (
+        ticket_id, queue_id, kind, from_status, to_status, from_queue_id,
+        version, attempts, available_at, actor, reason
+    )
+    VALUES (
+        NEW.id, NEW.queue_id, v_kind, v_from, NEW.status,
+        CASE WHEN TG_OP = 'UPDATE' AND OLD.queue_id IS DISTINCT FROM NEW.queue_id THEN OLD.queue_id END,
+        NEW.version, NEW.attempts, NEW.available_at, v_actor,
+        CASE WHEN NEW.status = 'cancelled' THEN NEW.cancel_reason END
+    );
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER record_ticket_event
+AFTER INSERT OR UPDATE ON tickets
+FOR EACH ROW
+EXECUTE FUNCTION record_ticket_event();
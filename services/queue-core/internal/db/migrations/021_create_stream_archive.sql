@@ -0,0 +1,14 @@
+-- 21_create_stream_archive.sql
+
+-- Redis stream entries trimmed by the retention job, copied here first.
+-- Keyed by stream and entry id so re-archiving an entry is a no-op.
+CREATE TABLE stream_archive (
+    stream TEXT NOT NULL,
+    entry_id TEXT NOT NULL,
+    entry_time TIMESTAMPTZ NOT NULL, -- from the entry id's milliseconds
+    fields JSONB NOT NULL,
+    archived_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (stream, entry_id)
+);
+
+CREATE INDEX idx_stream_archive_time ON stream_archive(stream, entry_time);
@@ -0,0 +1,8 @@
+-- 22_add_queue_dispatch_mode.sql
+
+-- One dispatch mode per queue: 'push' queues are reserved by the dispatcher
+-- and handed to workers over the stream, 'pull' queues are only claimed over
+-- the claim API. Running both on one queue would split its tickets between
+-- two worker pools.
+ALTER TABLE queues ADD COLUMN dispatch_mode TEXT NOT NULL DEFAULT 'push'
+    CHECK (dispatch_mode IN ('push', 'pull'));
@@ -0,0 +1,62 @@
+-- 23_add_ticket_event_snapshot.sql
+
+-- Log the ticket's full event snapshot with each change, so a replay
+-- rebuilds the same ticket a live event carried.
+ALTER TABLE ticket_events ADD COLUMN priority INT;
+ALTER TABLE ticket_events ADD COLUMN lane TEXT;
+ALTER TABLE ticket_events ADD COLUMN attributes JSONB;
+ALTER TABLE ticket_events ADD COLUMN group_id BIGINT;
+ALTER TABLE ticket_events ADD COLUMN assigned_worker BIGINT;
+
+CREATE OR REPLACE FUNCTION record_ticket_event()
+RETURNS trigger AS $$
+DECLARE
+    v_kind TEXT;
+    v_from TEXT;
+    v_actor TEXT := NULLIF(current_setting('queue_core.actor', true), '');
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        v_kind := 'created';
+    ELSE
+        IF OLD.status IS NOT DISTINCT FROM NEW.status
+           AND OLD.queue_id IS NOT DISTINCT FROM NEW.queue_id
+           AND OLD.available_at IS NOT DISTINCT FROM NEW.available_at THEN
+            RETURN NULL; -- lease extensions and other bookkeeping
+        END IF;
+        v_from := OLD.status;
+        v_kind := CASE
+            WHEN OLD.queue_id IS DISTINCT FROM NEW.queue_id THEN 'transferred'
+            WHEN NEW.status = 'waiting' AND NEW.available_at > NOW() THEN 'delayed'
+            WHEN NEW.status = 'waiting' AND OLD.status IN ('processing', 'in_progress') THEN 'requeued'
+            WHEN NEW.status = 'waiting' AND OLD.status = 'dead_letter' THEN 'retried'
+            WHEN NEW.status = 'processing' AND NEW.lease_expires_at IS NOT NULL THEN 'claimed'
+            WHEN NEW.status = 'processing' THEN 'reserved'
+            WHEN NEW.status = 'dead_letter' THEN 'dead_lettered'
+            ELSE NEW.status
+        END;
+    END IF;
+
+    IF v_actor IS NULL THEN
+        v_actor := CASE
+            WHEN NEW.status = 'cancelled' AND NEW.cancelled_by IS NOT NULL THEN NEW.cancelled_by
+            WHEN NEW.status IN ('processing', 'in_progress', 'done') AND NEW.assigned_worker IS NOT NULL
+                THEN 'worker:' || NEW.assigned_worker
+            ELSE 'system'
+        END;
+    END IF;
+
+    INSERT INTO ticket_events (
+        ticket_id, queue_id, kind, from_status, to_status, from_queue_id,
+        version, attempts, available_at, actor, reason,
+        priority, lane, attributes, group_id, assigned_worker
+    )
+    VALUES (
+        NEW.id, NEW.queue_id, v_kind, v_from, NEW.status,
+        CASE WHEN TG_OP = 'UPDATE' AND OLD.queue_id IS DISTINCT FROM NEW.queue_id THEN OLD.queue_id END,
+        NEW.version, NEW.attempts, NEW.available_at, v_actor,
+        CASE WHEN NEW.status = 'cancelled' THEN NEW.cancel_reason END,
+        NEW.priority, NEW.lane, NEW.attributes, NEW.group_id, NEW.assigned_worker
+    );
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,22 @@
+package db
+
+import (
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// NewMQTTClient returns a client of the broker at url (e.g.
+// tcp://broker:1883) that, once connected, keeps reconnecting and calls
+// onConnect after every (re)connection so subscriptions can be restored.
+func NewMQTTClient(url, clientID string, onConnect mqtt.OnConnectHandler) mqtt.Client {
+	opts := mqtt.NewClientOptions().
+		AddBroker(url).
+		SetClientID(clientID).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(5 * time.Second).
+		SetOrderMatters(false).
+		SetOnConnectHandler(onConnect)
+	return mqtt.NewClient(opts)
+}
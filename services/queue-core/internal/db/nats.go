@@ -0,0 +1,17 @@
+package db
+
+import (
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// ConnectNATS connects to the NATS servers in url (comma separated) and
+// keeps reconnecting for as long as the process runs.
+func ConnectNATS(url string) (*nats.Conn, error) {
+	return nats.Connect(url,
+		nats.Name("queue-core"),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(2*time.Second),
+	)
+}
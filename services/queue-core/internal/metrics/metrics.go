@@ -0,0 +1,207 @@
+// Package metrics is a small in-process metrics registry exposed in the
+// Prometheus text format, so operators can scrape queue-core without an
+// extra client library.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type kind string
+
+const (
+	kindCounter   kind = "counter"
+	kindGauge     kind = "gauge"
+	kindHistogram kind = "histogram"
+)
+
+// DefaultBuckets suit latencies from a few milliseconds up to a minute.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Registry holds metric families by name.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*Family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*Family{}}
+}
+
+// Default is the registry served on /metrics.
+var Default = NewRegistry()
+
+// Family is one named metric with any number of label sets.
+type Family struct {
+	name    string
+	help    string
+	kind    kind
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labels string
+	value  float64
+	counts []uint64 // histogram buckets, cumulative on output
+	sum    float64
+	count  uint64
+}
+
+func (r *Registry) family(name, help string, k kind, buckets []float64) *Family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.families[name]; ok {
+		return f
+	}
+	f := &Family{name: name, help: help, kind: k, buckets: buckets, series: map[string]*series{}}
+	r.families[name] = f
+	return f
+}
+
+// NewCounter returns the counter family name, creating it on first use.
+func (r *Registry) NewCounter(name, help string) *Family {
+	return r.family(name, help, kindCounter, nil)
+}
+
+// NewGauge returns the gauge family name, creating it on first use.
+func (r *Registry) NewGauge(name, help string) *Family {
+	return r.family(name, help, kindGauge, nil)
+}
+
+// NewHistogram returns the histogram family name, creating it on first use.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Family {
+	return r.family(name, help, kindHistogram, buckets)
+}
+
+// labelString renders k1, v1, k2, v2, ... pairs as {k1="v1",k2="v2"}.
+func labelString(pairs []string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf("%s=%q", pairs[i], pairs[i+1]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func (f *Family) get(labels []string) *series {
+	key := labelString(labels)
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: key}
+		if f.kind == kindHistogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// Add increments a counter (or gauge) by v. labels are key, value pairs.
+func (f *Family) Add(v float64, labels ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.get(labels).value += v
+}
+
+// Inc increments by one.
+func (f *Family) Inc(labels ...string) {
+	f.Add(1, labels...)
+}
+
+// Set sets a gauge to v.
+func (f *Family) Set(v float64, labels ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.get(labels).value = v
+}
+
+// Observe records v in a histogram.
+func (f *Family) Observe(v float64, labels ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := f.get(labels)
+	for i, b := range f.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// Write writes every family in the Prometheus text exposition format.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	r.mu.Unlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		r.mu.Lock()
+		f := r.families[name]
+		r.mu.Unlock()
+		f.writeTo(w)
+	}
+}
+
+func (f *Family) writeTo(w io.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := f.series[k]
+		if f.kind != kindHistogram {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, s.labels, formatFloat(s.value))
+			continue
+		}
+		for i, b := range f.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, withLabel(s.labels, "le", formatFloat(b)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, withLabel(s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, s.labels, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, s.labels, s.count)
+	}
+}
+
+func withLabel(labels, k, v string) string {
+	extra := fmt.Sprintf("%s=%q", k, v)
+	if labels == "" {
+		return "{" + extra + "}"
+	}
+	return labels[:len(labels)-1] + "," + extra + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprintf("%g", v)
+}
+
+// Handler serves the registry for Prometheus scrapes.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.Write(w)
+	})
+}
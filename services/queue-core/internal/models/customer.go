@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Customer is an optional identity a ticket can be linked to. At least one of
+// Phone, Email or ExternalID identifies the customer across visits.
+type Customer struct {
+	ID         int64     `json:"id"`
+	Name       string    `json:"name"`
+	Phone      *string   `json:"phone,omitempty"`
+	Email      *string   `json:"email,omitempty"`
+	ExternalID *string   `json:"external_id,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// HasIdentity reports whether the customer carries any identifying key.
+func (c *Customer) HasIdentity() bool {
+	return c.Phone != nil || c.Email != nil || c.ExternalID != nil
+}
+
+// Visit is a past ticket of a customer, read from ticket_history.
+type Visit struct {
+	TicketID   int64        `json:"ticket_id"`
+	QueueID    int64        `json:"queue_id"`
+	Status     TicketStatus `json:"status"`
+	Priority   int          `json:"priority"`
+	CreatedAt  time.Time    `json:"created_at"`
+	ArchivedAt time.Time    `json:"archived_at"`
+}
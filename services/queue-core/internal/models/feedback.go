@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// Feedback is a customer's rating of a served ticket.
+type Feedback struct {
+	TicketID  int64     `json:"ticket_id"`
+	QueueID   int64     `json:"queue_id"`
+	CounterID *int64    `json:"counter_id,omitempty"`
+	StaffID   *int64    `json:"staff_id,omitempty"`
+	Rating    int       `json:"rating"` // 1..5
+	Comment   string    `json:"comment,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FeedbackSummary aggregates ratings for one queue, counter and day.
+type FeedbackSummary struct {
+	QueueID       int64     `json:"queue_id"`
+	CounterID     *int64    `json:"counter_id,omitempty"`
+	Day           time.Time `json:"day"`
+	Responses     int       `json:"responses"`
+	AverageRating float64   `json:"average_rating"`
+}
+
+// ServedTicket is the slice of a ticket_history row feedback is stored against.
+type ServedTicket struct {
+	TicketID       int64
+	QueueID        int64
+	CounterID      *int64
+	AssignedWorker *int64
+	ArchivedAt     time.Time
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// Intake field types.
+const (
+	FieldString  = "string"
+	FieldNumber  = "number"
+	FieldBoolean = "boolean"
+	FieldEnum    = "enum"
+)
+
+// IntakeField describes one custom field a queue collects at ticket creation,
+// stored in Ticket.Attributes under Name.
+type IntakeField struct {
+	QueueID  int64    `json:"queue_id"`
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Required bool     `json:"required"`
+	Options  []string `json:"options,omitempty"` // allowed values when Type is enum
+}
+
+// Attributes holds a ticket's custom intake values. It maps to a JSONB
+// column, so it implements driver.Valuer and sql.Scanner.
+type Attributes map[string]interface{}
+
+// Value encodes the attributes as JSON; nil encodes as an empty object.
+func (a Attributes) Value() (driver.Value, error) {
+	if a == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan decodes a JSONB value; NULL leaves the attributes nil.
+func (a *Attributes) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		*a = nil
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("attributes: cannot scan %T", src)
+	}
+	return json.Unmarshal(b, a)
+}
@@ -0,0 +1,30 @@
+package models
+
+// DefaultLane is the lane of tickets that carry no priority class.
+const DefaultLane = "standard"
+
+// PriorityClass is a named priority a queue offers (e.g. elderly, VIP, staff).
+// AssignableBy is the least privileged role allowed to hand it out:
+// "kiosk" lets anyone assign it, "staff" restricts it to staff.
+type PriorityClass struct {
+	QueueID      int64  `json:"queue_id"`
+	Name         string `json:"name"`
+	Priority     int    `json:"priority"`
+	Lane         string `json:"lane"`
+	AssignableBy string `json:"assignable_by"`
+}
+
+// Lane is a call-out lane with its share of calls. Lanes are served by
+// weighted round robin, so weights {standard: 2, priority: 1} call the
+// priority lane every 3rd time.
+type Lane struct {
+	QueueID int64  `json:"queue_id"`
+	Name    string `json:"name"`
+	Weight  int    `json:"weight"`
+}
+
+// PriorityPolicy is the full priority configuration of one queue.
+type PriorityPolicy struct {
+	Classes []PriorityClass `json:"classes"`
+	Lanes   []Lane          `json:"lanes"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Dispatch modes: a push queue is reserved by its dispatcher, a pull queue
+// only by workers claiming over the claim API.
+const (
+	DispatchPush = "push"
+	DispatchPull = "pull"
+)
+
+// Queue holds per-queue rules. A queue without a row in the queues table
+// behaves as if every rule were at its zero value.
+type Queue struct {
+	ID                   int64           `json:"id"`
+	Name                 string          `json:"name"`
+	MaxActivePerCustomer int             `json:"max_active_per_customer"`     // 0 = unlimited
+	DispatchStrategy     string          `json:"dispatch_strategy,omitempty"` // "" = weighted_fair over lanes
+	DispatchConfig       json.RawMessage `json:"dispatch_config,omitempty"`
+	StrictOrder          bool            `json:"strict_order"`            // call in exact order under concurrent reservers
+	DispatchMode         string          `json:"dispatch_mode,omitempty"` // DispatchPush or DispatchPull
+	CreatedAt            time.Time       `json:"created_at"`
+	UpdatedAt            time.Time       `json:"updated_at"`
+}
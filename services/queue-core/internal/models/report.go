@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// AbandonmentRow is the abandonment rate of one queue for tickets that
+// arrived within one hour.
+type AbandonmentRow struct {
+	QueueID                     int64     `json:"queue_id"`
+	Hour                        time.Time `json:"hour"`
+	Archived                    int       `json:"archived"`
+	Cancelled                   int       `json:"cancelled"`
+	AbandonmentRate             float64   `json:"abandonment_rate"`
+	AvgWaitBeforeAbandonSeconds float64   `json:"avg_wait_before_abandon_seconds"`
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// QueueGroup is a set of equivalent queues offering the same service. A
+// ticket created for the group is routed to one member queue.
+type QueueGroup struct {
+	ID       int64   `json:"id"`
+	Name     string  `json:"name"`
+	QueueIDs []int64 `json:"queue_ids"`
+}
+
+// QueueLoad is the projected wait of one queue as seen by the router.
+type QueueLoad struct {
+	QueueID              int64   `json:"queue_id"`
+	Waiting              int     `json:"waiting"`
+	AvgServiceSeconds    float64 `json:"avg_service_seconds"`
+	Servers              int     `json:"servers"`
+	ProjectedWaitSeconds float64 `json:"projected_wait_seconds"`
+}
+
+// RoutingDecision records why a group ticket landed in its queue. It is
+// stored with the ticket as JSONB.
+type RoutingDecision struct {
+	GroupID    int64       `json:"group_id"`
+	QueueID    int64       `json:"queue_id"`
+	Candidates []QueueLoad `json:"candidates"`
+	DecidedAt  time.Time   `json:"decided_at"`
+}
+
+// Value encodes the decision for a JSONB column; nil stores NULL.
+func (d *RoutingDecision) Value() (driver.Value, error) {
+	if d == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan decodes a JSONB value.
+func (d *RoutingDecision) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, d)
+	case string:
+		return json.Unmarshal([]byte(v), d)
+	default:
+		return fmt.Errorf("routing: cannot scan %T", src)
+	}
+}
@@ -7,19 +7,45 @@ type TicketStatus string
 const (
     StatusWaiting    TicketStatus = "waiting"
     StatusInProgress TicketStatus = "in_progress"
+    StatusProcessing TicketStatus = "processing"
     StatusDone       TicketStatus = "done"
+    StatusCancelled  TicketStatus = "cancelled"
+    StatusDeadLetter TicketStatus = "dead_letter" // failed too often; parked for staff
+    StatusDiscarded  TicketStatus = "discarded"   // dead letter dropped by staff
+)
+
+// Cancellation reason codes. Customers cancel with ReasonCustomerRequest,
+// staff pick one of the staff reasons, the system uses the system reasons.
+const (
+    ReasonCustomerRequest = "customer_request"
+    ReasonNoShow          = "no_show"
+    ReasonStaffDecision   = "staff_decision"
+    ReasonDuplicate       = "duplicate"
+    ReasonSessionClosed   = "session_closed"
+    ReasonAdmissionFailed = "admission_failed"
 )
 
 type Ticket struct {
     ID              int64        `json:"id"`
     QueueID         int64        `json:"queue_id"`
+    CustomerID      *int64       `json:"customer_id,omitempty"`
     CustomerName    string       `json:"customer_name"`
     Status          TicketStatus `json:"status"` 
     Priority        int          `json:"priority"`
+    PriorityClass   string       `json:"priority_class,omitempty"`
+    Lane            string       `json:"lane,omitempty"`
+    Attributes      Attributes   `json:"attributes,omitempty"` // per-queue intake fields
     AssignedWorker  int64        `json:"assigned_worker,omitempty"`
+    CounterID       int64        `json:"counter_id,omitempty"`
+    Token           string       `json:"-"` // customer-held secret, only returned on creation
     EstimatedTime   int          `json:"estimated_time"` // seconds
     CreatedAt       time.Time    `json:"created_at"`
     UpdatedAt       time.Time    `json:"updated_at"`
     CompletedAt     *time.Time   `json:"completed_at,omitempty"`
+    LeaseExpiresAt  *time.Time   `json:"lease_expires_at,omitempty"` // set on tickets claimed with a lease
+    Attempts        int          `json:"attempts,omitempty"` // failed deliveries so far
+    AvailableAt     *time.Time   `json:"available_at,omitempty"` // retry backoff; not reservable before
+    GroupID         *int64       `json:"group_id,omitempty"` // set to route the ticket within a queue group
+    Routing         *RoutingDecision `json:"routing,omitempty"` // how a group ticket picked its queue
     Version         int64        `json:"version"` // optimistic locking
 }
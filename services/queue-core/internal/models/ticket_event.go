@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// TicketEvent is one state change of a ticket, from the append-only
+// ticket_events log. Kind is created, reserved, claimed, requeued, delayed,
+// retried, transferred, dead_lettered, or the new status. Priority through
+// AssignedWorker snapshot the ticket after the change; events logged before
+// the snapshot was recorded leave them zero.
+type TicketEvent struct {
+	ID          int64      `json:"id"`
+	TicketID    int64      `json:"ticket_id"`
+	QueueID     int64      `json:"queue_id"`
+	Kind        string     `json:"kind"`
+	FromStatus  *string    `json:"from_status,omitempty"`
+	ToStatus    string     `json:"to_status"`
+	FromQueueID *int64     `json:"from_queue_id,omitempty"`
+	Version     int64      `json:"version"`
+	Attempts    int        `json:"attempts"`
+	AvailableAt *time.Time `json:"available_at,omitempty"`
+	Actor       string     `json:"actor"`
+	Reason      string     `json:"reason,omitempty"`
+	OccurredAt  time.Time  `json:"occurred_at"`
+
+	Priority       int        `json:"priority,omitempty"`
+	Lane           string     `json:"lane,omitempty"`
+	Attributes     Attributes `json:"attributes,omitempty"`
+	GroupID        *int64     `json:"group_id,omitempty"`
+	AssignedWorker int64      `json:"assigned_worker,omitempty"`
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+// Webhook delivery states.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed" // out of attempts
+)
+
+// WebhookSubscription sends matching events to URL. Empty filters match
+// every event type or queue.
+type WebhookSubscription struct {
+	ID                  int64      `json:"id"`
+	URL                 string     `json:"url"`
+	EventTypes          []string   `json:"event_types"`
+	QueueIDs            []int64    `json:"queue_ids"`
+	Secret              string     `json:"secret,omitempty"` // signing key; only returned on creation
+	Enabled             bool       `json:"enabled"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	DisabledAt          *time.Time `json:"disabled_at,omitempty"`
+	DisabledReason      string     `json:"disabled_reason,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+// Matches reports whether the subscription wants an event of eventType on
+// queueID. Disabled subscriptions match nothing.
+func (s *WebhookSubscription) Matches(eventType string, queueID int64) bool {
+	if !s.Enabled {
+		return false
+	}
+	if len(s.EventTypes) > 0 && !slices.Contains(s.EventTypes, eventType) {
+		return false
+	}
+	return len(s.QueueIDs) == 0 || slices.Contains(s.QueueIDs, queueID)
+}
+
+// WebhookDelivery is one event sent (or to be sent) to one subscription.
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	SubscriptionID int64           `json:"subscription_id"`
+	EventID        string          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Body           json.RawMessage `json:"body"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	LastStatusCode *int            `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+
+	// joined from the subscription when a delivery is claimed for sending
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Worker is a worker or counter advertising how many tickets of a queue it
+// can hold in processing at once.
+type Worker struct {
+	WorkerID   int64     `json:"worker_id"`
+	QueueID    int64     `json:"queue_id"`
+	CounterID  *int64    `json:"counter_id,omitempty"`
+	Capacity   int       `json:"capacity"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+// QueueCapacity is a queue's dispatch budget at one point in time.
+type QueueCapacity struct {
+	QueueID   int64 `json:"queue_id"`
+	Capacity  int   `json:"capacity"`   // sum of live workers' capacity
+	InFlight  int   `json:"in_flight"`  // tickets in processing
+	StreamLag int64 `json:"stream_lag"` // undelivered stream entries (worst consumer group)
+	Available int   `json:"available"`  // tickets the dispatcher may reserve now
+}
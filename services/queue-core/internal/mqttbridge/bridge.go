@@ -0,0 +1,250 @@
+// Package mqttbridge connects ticket dispensers and display boards that
+// speak MQTT to queue-core. Ticket events go out under
+// turn/<branch>/<queue>/<event>, the ticket being served is kept as a
+// retained message for boards that come online later, and button presses
+// on a dispenser's command topic create tickets.
+package mqttbridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"queue-core/internal/actor"
+	"queue-core/internal/metrics"
+	"queue-core/internal/models"
+	"queue-core/internal/repositories"
+	"queue-core/internal/services"
+	"queue-core/pkg/events"
+)
+
+// Topic names under turn/<branch>/<queue>/.
+const (
+	TopicIssued     = "issued"      // a ticket was created
+	TopicCalled     = "called"      // a ticket was handed to a worker or claimed
+	TopicServed     = "served"      // a ticket was completed
+	TopicCancelled  = "cancelled"   // a ticket left unserved
+	TopicRequeued   = "requeued"    // a ticket went back to waiting
+	TopicNowServing = "now_serving" // retained: the last ticket called
+	TopicDispense   = "dispense"    // command: a dispenser button was pressed
+	TopicDispensed  = "dispensed"   // the outcome of a dispense command
+)
+
+// eventTopics are the events display hardware cares about.
+var eventTopics = map[events.Type]string{
+	events.TicketCreated:   TopicIssued,
+	events.TicketReserved:  TopicCalled,
+	events.TicketClaimed:   TopicCalled,
+	events.TicketCompleted: TopicServed,
+	events.TicketCancelled: TopicCancelled,
+	events.TicketRequeued:  TopicRequeued,
+}
+
+var (
+	mqttPublished = metrics.Default.NewCounter("queue_mqtt_published_total",
+		"Messages published to MQTT display hardware, by topic.")
+	mqttDispensed = metrics.Default.NewCounter("queue_mqtt_dispensed_total",
+		"Dispenser commands received over MQTT, by result.")
+)
+
+// Bridge publishes ticket events to MQTT and serves dispenser commands. It
+// is a services.EventSink; set it as TicketService.Displays.
+type Bridge struct {
+	Client   mqtt.Client
+	Tickets  *services.TicketService
+	Branch   string          // the <branch> of every topic
+	QoS      byte            // of published messages and the command subscription
+	Encoding events.Encoding // of event payloads
+	// ShareGroup, when set, subscribes to commands as the shared
+	// subscription $share/<ShareGroup>/..., so each press creates one
+	// ticket however many replicas run a bridge.
+	ShareGroup string
+	Timeout    time.Duration // per dispense command
+	// MaxDispensing bounds the dispense commands served at once; presses
+	// beyond it are answered busy rather than queued.
+	MaxDispensing int
+
+	once        sync.Once
+	dispensing  chan struct{} // a slot per dispense command being served
+	unconfirmed chan struct{} // a slot per publish awaiting its broker ack
+}
+
+// maxUnconfirmed bounds the publishes whose failure is watched for at
+// once; past it, publishes go out unwatched.
+const maxUnconfirmed = 256
+
+func NewBridge(client mqtt.Client, ts *services.TicketService, branch string) *Bridge {
+	return &Bridge{Client: client, Tickets: ts, Branch: branch, QoS: 1, Encoding: ts.Encoding, Timeout: 5 * time.Second,
+		MaxDispensing: 32}
+}
+
+func (b *Bridge) init() {
+	b.once.Do(func() {
+		b.dispensing = make(chan struct{}, max(b.MaxDispensing, 1))
+		b.unconfirmed = make(chan struct{}, maxUnconfirmed)
+	})
+}
+
+// Topic is turn/<branch>/<queue>/<name>.
+func (b *Bridge) Topic(queueID int64, name string) string {
+	return fmt.Sprintf("turn/%s/%d/%s", b.Branch, queueID, name)
+}
+
+// NowServing is the retained message of a queue's now_serving topic.
+type NowServing struct {
+	QueueID  int64     `json:"queue_id"`
+	TicketID int64     `json:"ticket_id"`
+	WorkerID int64     `json:"worker_id,omitempty"`
+	CalledAt time.Time `json:"called_at"`
+}
+
+// Notify publishes event on its queue's topic and, for a called ticket,
+// replaces the queue's retained now_serving message.
+func (b *Bridge) Notify(ctx context.Context, event *events.Envelope) {
+	name, ok := eventTopics[event.Type]
+	if !ok || event.Ticket == nil {
+		return
+	}
+	payload, err := b.Encoding.JSON(event)
+	if err != nil {
+		fmt.Printf("mqtt encode error: %v\n", err)
+		return
+	}
+	b.publish(b.Topic(event.QueueID, name), false, payload)
+	if name != TopicCalled {
+		return
+	}
+
+	serving := NowServing{QueueID: event.QueueID, TicketID: event.Ticket.ID,
+		WorkerID: event.Ticket.AssignedWorker, CalledAt: event.OccurredAt}
+	if c, ok := event.Payload.(events.Claimed); ok {
+		serving.WorkerID = c.WorkerID
+	}
+	payload, _ = json.Marshal(serving)
+	b.publish(b.Topic(event.QueueID, TopicNowServing), true, payload)
+}
+
+// publish sends without waiting; failures are logged, as Notify must not
+// block the emitting request.
+func (b *Bridge) publish(topic string, retained bool, payload []byte) {
+	b.init()
+	t := b.Client.Publish(topic, b.QoS, retained, payload)
+	mqttPublished.Inc("topic", topic[strings.LastIndex(topic, "/")+1:])
+	select {
+	case b.unconfirmed <- struct{}{}:
+	default:
+		return // the broker is behind; don't pile up watchers
+	}
+	go func() {
+		defer func() { <-b.unconfirmed }()
+		if t.WaitTimeout(10*time.Second) && t.Error() != nil {
+			fmt.Printf("mqtt publish %s error: %v\n", topic, t.Error())
+		}
+	}()
+}
+
+// Subscribe subscribes c to the dispense commands of every queue of the
+// branch. Use it as the client's OnConnect handler so the subscription is
+// restored after a reconnect.
+func (b *Bridge) Subscribe(c mqtt.Client) {
+	filter := fmt.Sprintf("turn/%s/+/%s", b.Branch, TopicDispense)
+	if b.ShareGroup != "" {
+		filter = "$share/" + b.ShareGroup + "/" + filter
+	}
+	t := c.Subscribe(filter, b.QoS, func(_ mqtt.Client, m mqtt.Message) {
+		b.onDispense(m.Topic(), m.Payload())
+	})
+	if t.WaitTimeout(10*time.Second) && t.Error() != nil {
+		fmt.Printf("mqtt subscribe %s error: %v\n", filter, t.Error())
+	}
+}
+
+// DispenseCommand is the body of a dispense command; every field is
+// optional and an empty body issues a standard ticket.
+type DispenseCommand struct {
+	Dispenser     string            `json:"dispenser"` // echoed in the outcome so a dispenser recognises its own
+	PriorityClass string            `json:"priority_class,omitempty"`
+	Attributes    models.Attributes `json:"attributes,omitempty"`
+}
+
+// DispenseResult is the body published on the dispensed topic.
+type DispenseResult struct {
+	Dispenser string `json:"dispenser,omitempty"`
+	TicketID  int64  `json:"ticket_id,omitempty"`
+	Token     string `json:"token,omitempty"` // for the printed ticket's self-service link
+	Error     string `json:"error,omitempty"`
+}
+
+// onDispense serves a press on turn/<branch>/<queue>/dispense in the
+// background, as handlers must not wait on the client they run in, or
+// answers busy when MaxDispensing presses are already being served.
+func (b *Bridge) onDispense(topic string, payload []byte) {
+	b.init()
+	parts := strings.Split(topic, "/")
+	queueID, err := strconv.ParseInt(parts[len(parts)-2], 10, 64)
+	if err != nil {
+		mqttDispensed.Inc("result", "invalid")
+		return // no queue to answer on
+	}
+	var cmd DispenseCommand
+	if len(payload) > 0 {
+		if err := json.Unmarshal(payload, &cmd); err != nil {
+			mqttDispensed.Inc("result", "invalid")
+			b.reply(queueID, DispenseResult{Error: "invalid command"})
+			return
+		}
+	}
+	select {
+	case b.dispensing <- struct{}{}:
+	default:
+		mqttDispensed.Inc("result", "busy")
+		b.reply(queueID, DispenseResult{Dispenser: cmd.Dispenser, Error: "busy"})
+		return
+	}
+	go func() {
+		defer func() { <-b.dispensing }()
+		b.dispense(queueID, cmd)
+	}()
+}
+
+// dispense creates a ticket for cmd and publishes the outcome on the
+// queue's dispensed topic.
+func (b *Bridge) dispense(queueID int64, cmd DispenseCommand) {
+	ctx, cancel := context.WithTimeout(context.Background(), b.Timeout)
+	defer cancel()
+	ctx = actor.WithContext(ctx, actor.Actor{Role: actor.RoleKiosk, ID: "dispenser:" + cmd.Dispenser})
+	ticket := &models.Ticket{QueueID: queueID, PriorityClass: cmd.PriorityClass, Attributes: cmd.Attributes}
+	id, err := b.Tickets.CreateTicket(ctx, ticket)
+	if err != nil {
+		mqttDispensed.Inc("result", "error")
+		b.reply(queueID, DispenseResult{Dispenser: cmd.Dispenser, Error: dispenseError(err)})
+		return
+	}
+	mqttDispensed.Inc("result", "created")
+	b.reply(queueID, DispenseResult{Dispenser: cmd.Dispenser, TicketID: id, Token: ticket.Token})
+}
+
+func (b *Bridge) reply(queueID int64, res DispenseResult) {
+	payload, _ := json.Marshal(res)
+	b.publish(b.Topic(queueID, TopicDispensed), false, payload)
+}
+
+// dispenseError is the error a dispenser may show: the rule a command
+// broke, or a generic failure.
+func dispenseError(err error) string {
+	var verr *services.ValidationError
+	switch {
+	case errors.As(err, &verr),
+		errors.Is(err, repositories.ErrActiveTicketLimit),
+		errors.Is(err, services.ErrUnknownPriorityClass),
+		errors.Is(err, services.ErrPriorityClassForbidden):
+		return err.Error()
+	}
+	return "failed create"
+}
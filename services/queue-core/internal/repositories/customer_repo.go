@@ -0,0 +1,94 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+
+	"queue-core/internal/models"
+)
+
+type CustomerRepository struct {
+	db *sql.DB
+}
+
+func NewCustomerRepo(db *sql.DB) *CustomerRepository {
+	return &CustomerRepository{db: db}
+}
+
+// Create inserts a customer and fills in its id and timestamps.
+func (r *CustomerRepository) Create(ctx context.Context, c *models.Customer) error {
+	query := `
+        INSERT INTO customers (name, phone, email, external_id, created_at, updated_at)
+        VALUES ($1,$2,$3,$4,NOW(),NOW())
+        RETURNING id, created_at, updated_at
+    `
+	return r.db.QueryRowContext(ctx, query, c.Name, c.Phone, c.Email, c.ExternalID).
+		Scan(&c.ID, &c.CreatedAt, &c.UpdatedAt)
+}
+
+// GetByID returns sql.ErrNoRows if the customer does not exist.
+func (r *CustomerRepository) GetByID(ctx context.Context, id int64) (*models.Customer, error) {
+	c := &models.Customer{}
+	query := `SELECT id, name, phone, email, external_id, created_at, updated_at FROM customers WHERE id=$1`
+	err := r.db.QueryRowContext(ctx, query, id).Scan(
+		&c.ID, &c.Name, &c.Phone, &c.Email, &c.ExternalID, &c.CreatedAt, &c.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// FindByIdentity looks a customer up by phone, email or external id, whichever
+// matches first. Returns (nil, nil) when no customer matches.
+func (r *CustomerRepository) FindByIdentity(ctx context.Context, phone, email, externalID *string) (*models.Customer, error) {
+	query := `
+        SELECT id, name, phone, email, external_id, created_at, updated_at
+        FROM customers
+        WHERE ($1::text IS NOT NULL AND phone=$1)
+           OR ($2::text IS NOT NULL AND email=$2)
+           OR ($3::text IS NOT NULL AND external_id=$3)
+        ORDER BY id ASC
+        LIMIT 1
+    `
+	c := &models.Customer{}
+	err := r.db.QueryRowContext(ctx, query, phone, email, externalID).Scan(
+		&c.ID, &c.Name, &c.Phone, &c.Email, &c.ExternalID, &c.CreatedAt, &c.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ListVisits returns the archived tickets of a customer, newest first.
+func (r *CustomerRepository) ListVisits(ctx context.Context, customerID int64, limit int) ([]*models.Visit, error) {
+	query := `
+        SELECT id, queue_id, status, priority, created_at, archived_at
+        FROM ticket_history
+        WHERE customer_id=$1
+        ORDER BY archived_at DESC
+        LIMIT $2
+    `
+	rows, err := r.db.QueryContext(ctx, query, customerID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var visits []*models.Visit
+	for rows.Next() {
+		v := &models.Visit{}
+		if err := rows.Scan(&v.TicketID, &v.QueueID, &v.Status, &v.Priority, &v.CreatedAt, &v.ArchivedAt); err != nil {
+			return nil, err
+		}
+		visits = append(visits, v)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return visits, nil
+}
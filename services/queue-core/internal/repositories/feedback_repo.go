@@ -0,0 +1,83 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"queue-core/internal/models"
+)
+
+type FeedbackRepository struct {
+	db *sql.DB
+}
+
+func NewFeedbackRepo(db *sql.DB) *FeedbackRepository {
+	return &FeedbackRepository{db: db}
+}
+
+// FindServedByToken returns the served (not cancelled) archived ticket a
+// customer token belongs to, or (nil, nil) when there is none.
+func (r *FeedbackRepository) FindServedByToken(ctx context.Context, token string) (*models.ServedTicket, error) {
+	s := &models.ServedTicket{}
+	err := r.db.QueryRowContext(ctx, `
+        SELECT id, queue_id, counter_id, assigned_worker, archived_at
+        FROM ticket_history
+        WHERE token=$1 AND status NOT IN ('cancelled', 'discarded')
+    `, token).Scan(&s.TicketID, &s.QueueID, &s.CounterID, &s.AssignedWorker, &s.ArchivedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Create stores feedback once per ticket. It returns false when feedback for
+// the ticket already exists.
+func (r *FeedbackRepository) Create(ctx context.Context, f *models.Feedback) (bool, error) {
+	err := r.db.QueryRowContext(ctx, `
+        INSERT INTO ticket_feedback (ticket_id, queue_id, counter_id, staff_id, rating, comment, created_at)
+        VALUES ($1,$2,$3,$4,$5,$6,NOW())
+        ON CONFLICT (ticket_id) DO NOTHING
+        RETURNING created_at
+    `, f.TicketID, f.QueueID, f.CounterID, f.StaffID, f.Rating, f.Comment).Scan(&f.CreatedAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Summarize aggregates ratings per queue, counter and UTC day within
+// [from, to). A zero queueID covers every queue.
+func (r *FeedbackRepository) Summarize(ctx context.Context, queueID int64, from, to time.Time) ([]*models.FeedbackSummary, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT queue_id, counter_id, date_trunc('day', created_at AT TIME ZONE 'UTC') AS day,
+               COUNT(*), AVG(rating)::float8
+        FROM ticket_feedback
+        WHERE ($1 = 0 OR queue_id=$1) AND created_at >= $2 AND created_at < $3
+        GROUP BY queue_id, counter_id, day
+        ORDER BY day ASC, queue_id ASC, counter_id ASC NULLS FIRST
+    `, queueID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := []*models.FeedbackSummary{}
+	for rows.Next() {
+		s := &models.FeedbackSummary{}
+		if err := rows.Scan(&s.QueueID, &s.CounterID, &s.Day, &s.Responses, &s.AverageRating); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return summaries, nil
+}
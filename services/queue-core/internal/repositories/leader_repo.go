@@ -0,0 +1,96 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// dispatchLockClass namespaces dispatcher advisory locks ("turn" in ASCII)
+// so they cannot collide with the per-customer locks taken on ticket insert.
+const dispatchLockClass = 0x7475726E
+
+// LeaderRepository holds per-queue dispatcher leadership as session-level
+// advisory locks on one dedicated connection. Losing the connection loses
+// every lock at once, which is what makes failover fast and safe.
+type LeaderRepository struct {
+	db   *sql.DB
+	conn *sql.Conn
+}
+
+func NewLeaderRepo(db *sql.DB) *LeaderRepository {
+	return &LeaderRepository{db: db}
+}
+
+// Connect (re)opens the dedicated lock session. Any locks held by a previous
+// session are gone once it is closed.
+func (r *LeaderRepository) Connect(ctx context.Context) error {
+	r.Close()
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	r.conn = conn
+	return nil
+}
+
+// Close ends the lock session, releasing every lock it holds.
+func (r *LeaderRepository) Close() {
+	if r.conn != nil {
+		_ = r.conn.Close()
+		r.conn = nil
+	}
+}
+
+// Ping reports whether the lock session is still alive.
+func (r *LeaderRepository) Ping(ctx context.Context) error {
+	if r.conn == nil {
+		return sql.ErrConnDone
+	}
+	return r.conn.PingContext(ctx)
+}
+
+// TryAcquire takes leadership of queueID without blocking.
+func (r *LeaderRepository) TryAcquire(ctx context.Context, queueID int64) (bool, error) {
+	if r.conn == nil {
+		return false, sql.ErrConnDone
+	}
+	var ok bool
+	err := r.conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1, $2)`, dispatchLockClass, queueID).Scan(&ok)
+	return ok, err
+}
+
+// Release gives up leadership of queueID.
+func (r *LeaderRepository) Release(ctx context.Context, queueID int64) error {
+	if r.conn == nil {
+		return sql.ErrConnDone
+	}
+	_, err := r.conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1, $2)`, dispatchLockClass, queueID)
+	return err
+}
+
+// Heartbeat records nodeID as alive.
+func (r *LeaderRepository) Heartbeat(ctx context.Context, nodeID string) error {
+	_, err := r.db.ExecContext(ctx, `
+        INSERT INTO dispatcher_nodes (node_id, started_at, heartbeat_at)
+        VALUES ($1, NOW(), NOW())
+        ON CONFLICT (node_id) DO UPDATE SET heartbeat_at=NOW()
+    `, nodeID)
+	return err
+}
+
+// LiveNodes counts nodes that heartbeated within ttl.
+func (r *LeaderRepository) LiveNodes(ctx context.Context, ttl time.Duration) (int, error) {
+	var n int
+	err := r.db.QueryRowContext(ctx, `
+        SELECT COUNT(*) FROM dispatcher_nodes WHERE heartbeat_at > NOW() - make_interval(secs => $1)
+    `, ttl.Seconds()).Scan(&n)
+	return n, err
+}
+
+// Deregister removes nodeID so the remaining nodes rebalance without waiting
+// for its heartbeat to expire.
+func (r *LeaderRepository) Deregister(ctx context.Context, nodeID string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM dispatcher_nodes WHERE node_id=$1`, nodeID)
+	return err
+}
@@ -0,0 +1,356 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"queue-core/internal/models"
+)
+
+type QueueRepository struct {
+	db *sql.DB
+}
+
+func NewQueueRepo(db *sql.DB) *QueueRepository {
+	return &QueueRepository{db: db}
+}
+
+// GetOrDefault returns the queue's rules, or a zero-valued Queue with only the
+// id set when the queue has no row (every rule disabled).
+func (r *QueueRepository) GetOrDefault(ctx context.Context, id int64) (*models.Queue, error) {
+	q := &models.Queue{}
+	query := `
+        SELECT id, name, max_active_per_customer, COALESCE(dispatch_strategy, ''), dispatch_config, strict_order, dispatch_mode, created_at, updated_at
+        FROM queues WHERE id=$1
+    `
+	var config []byte
+	err := r.db.QueryRowContext(ctx, query, id).Scan(
+		&q.ID, &q.Name, &q.MaxActivePerCustomer, &q.DispatchStrategy, &config, &q.StrictOrder, &q.DispatchMode, &q.CreatedAt, &q.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return &models.Queue{ID: id, DispatchMode: models.DispatchPush}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	q.DispatchConfig = json.RawMessage(config)
+	return q, nil
+}
+
+// SetDispatchStrategy selects the queue's dispatch strategy, its config,
+// whether calls are strictly ordered and the dispatch mode ("" keeps push),
+// creating the queue row if needed. Running dispatchers and claims pick it
+// up on their next pass.
+func (r *QueueRepository) SetDispatchStrategy(ctx context.Context, id int64, name string, config json.RawMessage, strictOrder bool, mode string) error {
+	if len(config) == 0 {
+		config = json.RawMessage(`{}`)
+	}
+	_, err := r.db.ExecContext(ctx, `
+        INSERT INTO queues (id, dispatch_strategy, dispatch_config, strict_order, dispatch_mode, created_at, updated_at)
+        VALUES ($1, NULLIF($2, ''), $3::jsonb, $4, COALESCE(NULLIF($5, ''), 'push'), NOW(), NOW())
+        ON CONFLICT (id) DO UPDATE
+        SET dispatch_strategy=EXCLUDED.dispatch_strategy, dispatch_config=EXCLUDED.dispatch_config,
+            strict_order=EXCLUDED.strict_order, dispatch_mode=EXCLUDED.dispatch_mode, updated_at=NOW()
+    `, id, name, string(config), strictOrder, mode)
+	return err
+}
+
+// Upsert creates or replaces the queue's rules.
+func (r *QueueRepository) Upsert(ctx context.Context, q *models.Queue) error {
+	query := `
+        INSERT INTO queues (id, name, max_active_per_customer, created_at, updated_at)
+        VALUES ($1,$2,$3,NOW(),NOW())
+        ON CONFLICT (id) DO UPDATE
+        SET name=EXCLUDED.name, max_active_per_customer=EXCLUDED.max_active_per_customer, updated_at=NOW()
+        RETURNING created_at, updated_at
+    `
+	return r.db.QueryRowContext(ctx, query, q.ID, q.Name, q.MaxActivePerCustomer).
+		Scan(&q.CreatedAt, &q.UpdatedAt)
+}
+
+// GetPriorityPolicy returns the queue's priority classes and lane weights.
+// Both slices are empty for queues that never configured a policy.
+func (r *QueueRepository) GetPriorityPolicy(ctx context.Context, queueID int64) (*models.PriorityPolicy, error) {
+	p := &models.PriorityPolicy{Classes: []models.PriorityClass{}, Lanes: []models.Lane{}}
+
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT queue_id, name, priority, lane, assignable_by
+        FROM priority_classes
+        WHERE queue_id=$1
+        ORDER BY name ASC
+    `, queueID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c models.PriorityClass
+		if err := rows.Scan(&c.QueueID, &c.Name, &c.Priority, &c.Lane, &c.AssignableBy); err != nil {
+			return nil, err
+		}
+		p.Classes = append(p.Classes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	lanes, err := r.ListLanes(ctx, queueID)
+	if err != nil {
+		return nil, err
+	}
+	p.Lanes = lanes
+	return p, nil
+}
+
+// ListLanes returns the call-out lane weights of a queue.
+func (r *QueueRepository) ListLanes(ctx context.Context, queueID int64) ([]models.Lane, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT queue_id, lane, weight
+        FROM queue_lanes
+        WHERE queue_id=$1
+        ORDER BY lane ASC
+    `, queueID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lanes := []models.Lane{}
+	for rows.Next() {
+		var l models.Lane
+		if err := rows.Scan(&l.QueueID, &l.Name, &l.Weight); err != nil {
+			return nil, err
+		}
+		lanes = append(lanes, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return lanes, nil
+}
+
+// ReplacePriorityPolicy swaps the queue's classes and lanes in one transaction.
+func (r *QueueRepository) ReplacePriorityPolicy(ctx context.Context, queueID int64, p *models.PriorityPolicy) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM priority_classes WHERE queue_id=$1`, queueID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM queue_lanes WHERE queue_id=$1`, queueID); err != nil {
+		return err
+	}
+	for _, c := range p.Classes {
+		_, err := tx.ExecContext(ctx, `
+            INSERT INTO priority_classes (queue_id, name, priority, lane, assignable_by)
+            VALUES ($1,$2,$3,$4,$5)
+        `, queueID, c.Name, c.Priority, c.Lane, c.AssignableBy)
+		if err != nil {
+			return err
+		}
+	}
+	for _, l := range p.Lanes {
+		_, err := tx.ExecContext(ctx, `
+            INSERT INTO queue_lanes (queue_id, lane, weight)
+            VALUES ($1,$2,$3)
+        `, queueID, l.Name, l.Weight)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+// ListIntakeFields returns the queue's intake schema in display order.
+func (r *QueueRepository) ListIntakeFields(ctx context.Context, queueID int64) ([]models.IntakeField, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT queue_id, name, type, required, options
+        FROM queue_intake_fields
+        WHERE queue_id=$1
+        ORDER BY position ASC, name ASC
+    `, queueID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fields := []models.IntakeField{}
+	for rows.Next() {
+		var f models.IntakeField
+		var options []byte
+		if err := rows.Scan(&f.QueueID, &f.Name, &f.Type, &f.Required, &options); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(options, &f.Options); err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// ReplaceIntakeFields swaps the queue's intake schema in one transaction.
+func (r *QueueRepository) ReplaceIntakeFields(ctx context.Context, queueID int64, fields []models.IntakeField) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM queue_intake_fields WHERE queue_id=$1`, queueID); err != nil {
+		return err
+	}
+	for i, f := range fields {
+		options, err := json.Marshal(f.Options)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+            INSERT INTO queue_intake_fields (queue_id, name, type, required, options, position)
+            VALUES ($1,$2,$3,$4,$5::jsonb,$6)
+        `, queueID, f.Name, f.Type, f.Required, string(options), i)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+// ListIDs returns the ids of every configured queue, including queue group
+// members that have no queues row of their own.
+func (r *QueueRepository) ListIDs(ctx context.Context) ([]int64, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT id FROM queues
+        UNION
+        SELECT queue_id FROM queue_group_members
+        ORDER BY 1 ASC
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetGroup returns a queue group with its member queues, or (nil, nil) when
+// there is no such group.
+func (r *QueueRepository) GetGroup(ctx context.Context, id int64) (*models.QueueGroup, error) {
+	g := &models.QueueGroup{QueueIDs: []int64{}}
+	err := r.db.QueryRowContext(ctx, `SELECT id, name FROM queue_groups WHERE id=$1`, id).Scan(&g.ID, &g.Name)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT queue_id FROM queue_group_members
+        WHERE group_id=$1
+        ORDER BY queue_id ASC
+    `, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var qid int64
+		if err := rows.Scan(&qid); err != nil {
+			return nil, err
+		}
+		g.QueueIDs = append(g.QueueIDs, qid)
+	}
+	return g, rows.Err()
+}
+
+// SaveGroup creates the group (ID 0, the new id is set on g) or replaces the
+// name and members of an existing one; sql.ErrNoRows if there is none.
+func (r *QueueRepository) SaveGroup(ctx context.Context, g *models.QueueGroup) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if g.ID == 0 {
+		err = tx.QueryRowContext(ctx, `
+            INSERT INTO queue_groups (name, created_at, updated_at)
+            VALUES ($1, NOW(), NOW())
+            RETURNING id
+        `, g.Name).Scan(&g.ID)
+	} else {
+		var res sql.Result
+		res, err = tx.ExecContext(ctx, `
+            UPDATE queue_groups SET name=$2, updated_at=NOW() WHERE id=$1
+        `, g.ID, g.Name)
+		if err == nil {
+			if n, _ := res.RowsAffected(); n == 0 {
+				err = sql.ErrNoRows
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM queue_group_members WHERE group_id=$1`, g.ID); err != nil {
+		return err
+	}
+	for _, qid := range g.QueueIDs {
+		_, err := tx.ExecContext(ctx, `
+            INSERT INTO queue_group_members (group_id, queue_id)
+            VALUES ($1, $2)
+            ON CONFLICT DO NOTHING
+        `, g.ID, qid)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
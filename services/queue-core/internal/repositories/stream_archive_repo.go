@@ -0,0 +1,53 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// ArchivedEntry is a Redis stream entry kept after trimming.
+type ArchivedEntry struct {
+	ID     string
+	Time   time.Time
+	Fields map[string]interface{}
+}
+
+type StreamArchiveRepository struct {
+	db *sql.DB
+}
+
+func NewStreamArchiveRepo(db *sql.DB) *StreamArchiveRepository {
+	return &StreamArchiveRepository{db: db}
+}
+
+// Insert stores entries of stream in one transaction, skipping entries
+// already archived.
+func (r *StreamArchiveRepository) Insert(ctx context.Context, stream string, entries []ArchivedEntry) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+        INSERT INTO stream_archive (stream, entry_id, entry_time, fields)
+        VALUES ($1, $2, $3, $4::jsonb)
+        ON CONFLICT (stream, entry_id) DO NOTHING
+    `)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, e := range entries {
+		fields, err := json.Marshal(e.Fields)
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.ExecContext(ctx, stream, e.ID, e.Time, string(fields)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,841 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"queue-core/internal/models"
+)
+
+// MemoryTicketRepository keeps tickets in process memory with the semantics
+// of TicketRepository and its triggers: reservations never hand one ticket
+// to two reservers, updates check versions, tickets leaving the active
+// statuses move to an archive, every state change is logged for Timeline
+// and EventLog, and it wakes dispatchers like the ticket_ready
+// notification. One mutex stands in for row locks, so a reservation never
+// waits on nor skips over another. Nothing survives a restart; it is meant
+// for development and tests.
+type MemoryTicketRepository struct {
+	mu      sync.Mutex
+	nextID  int64
+	tickets map[int64]*memTicket
+	history []*memTicket // ticket_history, in archival order
+	events  []*models.TicketEvent
+	subs    map[int64]map[chan struct{}]struct{} // queue id -> wakeup channels
+	ready   map[chan int64]struct{}              // SubscribeQueues channels
+}
+
+// memTicket is a ticket row with the columns models.Ticket does not carry.
+type memTicket struct {
+	models.Ticket
+	cancelReason string
+	cancelledBy  string
+	archivedAt   time.Time
+	waitSeconds  int // cancelled tickets only
+}
+
+func NewMemoryTicketRepo() *MemoryTicketRepository {
+	return &MemoryTicketRepository{tickets: map[int64]*memTicket{}, subs: map[int64]map[chan struct{}]struct{}{},
+		ready: map[chan int64]struct{}{}}
+}
+
+// copyTicket returns a copy of t the caller may keep and modify.
+func copyTicket(t *models.Ticket) *models.Ticket {
+	c := *t
+	c.Attributes = maps.Clone(t.Attributes)
+	return &c
+}
+
+func (r *MemoryTicketRepository) Create(ctx context.Context, t *models.Ticket) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.insert(ctx, t)
+	return nil
+}
+
+// insert stores a copy of t and fills in what the database would.
+func (r *MemoryTicketRepository) insert(ctx context.Context, t *models.Ticket) {
+	r.nextID++
+	now := time.Now()
+	t.ID, t.CreatedAt, t.UpdatedAt, t.Version = r.nextID, now, now, 1
+	if t.Lane == "" {
+		t.Lane = "standard"
+	}
+	row := &memTicket{Ticket: *copyTicket(t)}
+	r.tickets[t.ID] = row
+	r.record(ctx, nil, row)
+	if row.Status == models.StatusWaiting {
+		r.wake(row.QueueID)
+	}
+}
+
+// CreateWithCustomerLimit inserts the ticket only if its customer holds fewer
+// than maxActive active tickets in the same queue.
+func (r *MemoryTicketRepository) CreateWithCustomerLimit(ctx context.Context, t *models.Ticket, maxActive int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t.CustomerID != nil && maxActive > 0 {
+		active := 0
+		for _, row := range r.tickets {
+			if row.QueueID == t.QueueID && row.CustomerID != nil && *row.CustomerID == *t.CustomerID && isActive(row.Status) {
+				active++
+			}
+		}
+		if active >= maxActive {
+			return ErrActiveTicketLimit
+		}
+	}
+	r.insert(ctx, t)
+	return nil
+}
+
+func isActive(s models.TicketStatus) bool {
+	return s == models.StatusWaiting || s == models.StatusProcessing || s == models.StatusInProgress
+}
+
+func isReserved(s models.TicketStatus) bool {
+	return s == models.StatusProcessing || s == models.StatusInProgress
+}
+
+// GetByID returns sql.ErrNoRows for unknown and archived tickets, as
+// TicketRepository does.
+func (r *MemoryTicketRepository) GetByID(ctx context.Context, id int64) (*models.Ticket, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	row, ok := r.tickets[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return copyTicket(&row.Ticket), nil
+}
+
+func (r *MemoryTicketRepository) GetByStatus(ctx context.Context, queueID int, status string) ([]*models.Ticket, error) {
+	return r.ListByStatus(ctx, queueID, status, nil)
+}
+
+// ListByStatus compares attribute values in their text form, like
+// TicketRepository.ListByStatus.
+func (r *MemoryTicketRepository) ListByStatus(ctx context.Context, queueID int, status string, attrs map[string]string) ([]*models.Ticket, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var tickets []*models.Ticket
+	for _, row := range r.sorted(byArrival) {
+		if row.QueueID != int64(queueID) || string(row.Status) != status {
+			continue
+		}
+		match := true
+		for k, v := range attrs {
+			if a, ok := row.Attributes[k]; !ok || a == nil || attrText(a) != v {
+				match = false
+				break
+			}
+		}
+		if match {
+			tickets = append(tickets, copyTicket(&row.Ticket))
+		}
+	}
+	return tickets, nil
+}
+
+// attrText is an attribute value as Postgres' ->> renders it.
+func attrText(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func (r *MemoryTicketRepository) CountByStatus(ctx context.Context, queueID int64, status string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, row := range r.tickets {
+		if row.QueueID == queueID && string(row.Status) == status {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// QueueLoads returns, per queue, the waiting count and the average service
+// time of tickets completed within window.
+func (r *MemoryTicketRepository) QueueLoads(ctx context.Context, queueIDs []int64, window time.Duration) ([]models.QueueLoad, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids := slices.Clone(queueIDs)
+	slices.Sort(ids)
+	since := time.Now().Add(-window)
+	var loads []models.QueueLoad
+	for _, id := range ids {
+		l := models.QueueLoad{QueueID: id}
+		for _, row := range r.tickets {
+			if row.QueueID == id && row.Status == models.StatusWaiting {
+				l.Waiting++
+			}
+		}
+		var total float64
+		var served int
+		for _, h := range r.history {
+			if h.QueueID == id && h.Status == models.StatusDone && !h.archivedAt.Before(since) {
+				total += h.archivedAt.Sub(h.UpdatedAt).Seconds()
+				served++
+			}
+		}
+		if served > 0 {
+			l.AvgServiceSeconds = total / float64(served)
+		}
+		loads = append(loads, l)
+	}
+	return loads, nil
+}
+
+func (r *MemoryTicketRepository) ReserveNext(ctx context.Context, queueID int) (*models.Ticket, error) {
+	return r.Reserve(ctx, queueID, ReserveOptions{})
+}
+
+// Reserve picks the first waiting ticket by opts and sets it to processing.
+// Returns the ticket as picked, with its original version value.
+func (r *MemoryTicketRepository) Reserve(ctx context.Context, queueID int, opts ReserveOptions) (*models.Ticket, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	picked := r.candidates(queueID, opts, 1)
+	if len(picked) == 0 {
+		return nil, nil
+	}
+	row := picked[0]
+	t := copyTicket(&row.Ticket)
+	r.update(ctx, row, func(n *memTicket) {
+		n.Status = models.StatusProcessing
+		if opts.Lease > 0 {
+			expires := time.Now().Add(opts.Lease)
+			n.AssignedWorker, n.LeaseExpiresAt = opts.WorkerID, &expires
+		}
+	})
+	if opts.Lease > 0 {
+		t.AssignedWorker, t.LeaseExpiresAt = row.AssignedWorker, row.LeaseExpiresAt
+	}
+	return t, nil
+}
+
+func (r *MemoryTicketRepository) ReserveBatch(ctx context.Context, queueID, n int) ([]*models.Ticket, error) {
+	return r.ReserveMany(ctx, queueID, n, ReserveOptions{})
+}
+
+// ReserveMany reserves up to n waiting tickets picked by opts and returns
+// them in pick order, with their original version values.
+// Leases are not supported; opts.Lease is ignored.
+func (r *MemoryTicketRepository) ReserveMany(ctx context.Context, queueID, n int, opts ReserveOptions) ([]*models.Ticket, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var tickets []*models.Ticket
+	for _, row := range r.candidates(queueID, opts, n) {
+		version, updatedAt := row.Version, row.UpdatedAt
+		r.update(ctx, row, func(n *memTicket) { n.Status = models.StatusProcessing })
+		t := copyTicket(&row.Ticket)
+		t.Version, t.UpdatedAt = version, updatedAt
+		tickets = append(tickets, t)
+	}
+	return tickets, nil
+}
+
+// candidates returns up to limit reservable tickets of the queue in the
+// order ReserveOptions.clauses gives them.
+func (r *MemoryTicketRepository) candidates(queueID int, opts ReserveOptions, limit int) []*memTicket {
+	now := time.Now()
+	attr := opts.SkillAttribute
+	if attr == "" {
+		attr = "skill"
+	}
+	var rows []*memTicket
+	for _, row := range r.tickets {
+		if row.QueueID != int64(queueID) || row.Status != models.StatusWaiting ||
+			(row.AvailableAt != nil && row.AvailableAt.After(now)) {
+			continue
+		}
+		if len(opts.Skills) > 0 {
+			if skill := attrText(row.Attributes[attr]); skill != "" && !slices.Contains(opts.Skills, skill) {
+				continue
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	lane := func(t *memTicket) int {
+		if i := slices.Index(opts.Lanes, t.Lane); i >= 0 {
+			return i
+		}
+		return len(opts.Lanes) // NULLS LAST
+	}
+	job := func(t *memTicket) int {
+		if t.EstimatedTime == 0 {
+			return math.MaxInt // unknown last
+		}
+		return t.EstimatedTime
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if len(opts.Lanes) > 0 {
+			if la, lb := lane(a), lane(b); la != lb {
+				return la < lb
+			}
+			if a.Priority != b.Priority {
+				return a.Priority > b.Priority
+			}
+		}
+		switch opts.Order {
+		case OrderPriority:
+			if a.Priority != b.Priority {
+				return a.Priority > b.Priority
+			}
+		case OrderShortestJob:
+			if ja, jb := job(a), job(b); ja != jb {
+				return ja < jb
+			}
+		}
+		return byArrival(a, b)
+	})
+	if len(rows) > limit {
+		rows = rows[:limit]
+	}
+	return rows
+}
+
+func byArrival(a, b *memTicket) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.ID < b.ID
+}
+
+// sorted returns the active tickets ordered by less.
+func (r *MemoryTicketRepository) sorted(less func(a, b *memTicket) bool) []*memTicket {
+	rows := make([]*memTicket, 0, len(r.tickets))
+	for _, row := range r.tickets {
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool { return less(rows[i], rows[j]) })
+	return rows
+}
+
+// update applies change to row as one UPDATE would: it bumps updated_at and
+// version, then runs what the triggers on tickets run.
+func (r *MemoryTicketRepository) update(ctx context.Context, row *memTicket, change func(*memTicket)) {
+	old := *row
+	change(row)
+	row.UpdatedAt = time.Now()
+	row.Version++
+	r.record(ctx, &old, row)
+
+	if row.Status == models.StatusWaiting && old.Status != models.StatusWaiting {
+		r.wake(row.QueueID)
+	}
+	switch row.Status {
+	case models.StatusDone, models.StatusCancelled, models.StatusDiscarded:
+		if old.Status != row.Status {
+			r.archive(row, &old)
+		}
+	}
+}
+
+// archive moves row to the history as archive_completed_ticket does,
+// keeping the version and updated_at it had before its final change.
+func (r *MemoryTicketRepository) archive(row, old *memTicket) {
+	h := *row
+	h.Version, h.UpdatedAt, h.archivedAt = old.Version, old.UpdatedAt, time.Now()
+	if h.Status == models.StatusCancelled {
+		h.waitSeconds = int(h.archivedAt.Sub(h.CreatedAt).Seconds())
+	}
+	delete(r.tickets, row.ID)
+	r.history = append(r.history, &h)
+}
+
+// record appends the ticket_events row record_ticket_event would write for
+// a change from old (nil on creation) to row, naming ctx's actor as
+// nameActor does.
+func (r *MemoryTicketRepository) record(ctx context.Context, old, row *memTicket) {
+	e := &models.TicketEvent{
+		ID:          int64(len(r.events) + 1),
+		TicketID:    row.ID,
+		QueueID:     row.QueueID,
+		Kind:        "created",
+		ToStatus:    string(row.Status),
+		Version:     row.Version,
+		Attempts:    row.Attempts,
+		AvailableAt: row.AvailableAt,
+		Actor:       "system",
+		OccurredAt:  time.Now(),
+
+		Priority:       row.Priority,
+		Lane:           row.Lane,
+		Attributes:     row.Attributes,
+		GroupID:        row.GroupID,
+		AssignedWorker: row.AssignedWorker,
+	}
+	if old != nil {
+		if old.Status == row.Status && old.QueueID == row.QueueID && sameTime(old.AvailableAt, row.AvailableAt) {
+			return // lease extensions and other bookkeeping
+		}
+		from := string(old.Status)
+		e.FromStatus = &from
+		switch {
+		case old.QueueID != row.QueueID:
+			e.Kind = "transferred"
+			e.FromQueueID = &old.QueueID
+		case row.Status == models.StatusWaiting && row.AvailableAt != nil && row.AvailableAt.After(e.OccurredAt):
+			e.Kind = "delayed"
+		case row.Status == models.StatusWaiting && isReserved(old.Status):
+			e.Kind = "requeued"
+		case row.Status == models.StatusWaiting && old.Status == models.StatusDeadLetter:
+			e.Kind = "retried"
+		case row.Status == models.StatusProcessing && row.LeaseExpiresAt != nil:
+			e.Kind = "claimed"
+		case row.Status == models.StatusProcessing:
+			e.Kind = "reserved"
+		case row.Status == models.StatusDeadLetter:
+			e.Kind = "dead_lettered"
+		default:
+			e.Kind = string(row.Status)
+		}
+	}
+	switch {
+	case actorName(ctx) != "":
+		e.Actor = actorName(ctx)
+	case row.Status == models.StatusCancelled && row.cancelledBy != "":
+		e.Actor = row.cancelledBy
+	case (isReserved(row.Status) || row.Status == models.StatusDone) && row.AssignedWorker != 0:
+		e.Actor = "worker:" + strconv.FormatInt(row.AssignedWorker, 10)
+	}
+	if row.Status == models.StatusCancelled {
+		e.Reason = row.cancelReason
+	}
+	r.events = append(r.events, e)
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// UpdateStatus uses the optimistic locking contract of
+// TicketRepository.UpdateStatus.
+func (r *MemoryTicketRepository) UpdateStatus(ctx context.Context, id int64, oldStatus, newStatus string, expectedVersion int64) (bool, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	row, ok := r.tickets[id]
+	if !ok || string(row.Status) != oldStatus || row.Version != expectedVersion {
+		return false, 0, nil
+	}
+	r.update(ctx, row, func(n *memTicket) { n.Status = models.TicketStatus(newStatus) })
+	return true, row.Version, nil
+}
+
+// Complete marks a reserved ticket done, recording which counter and worker
+// served it, and archives it.
+func (r *MemoryTicketRepository) Complete(ctx context.Context, id, expectedVersion int64, counterID, workerID *int64) (bool, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	row, ok := r.tickets[id]
+	if !ok || row.Version != expectedVersion || !isReserved(row.Status) {
+		return false, 0, nil
+	}
+	r.update(ctx, row, func(n *memTicket) {
+		n.Status = models.StatusDone
+		if counterID != nil {
+			n.CounterID = *counterID
+		}
+		if workerID != nil {
+			n.AssignedWorker = *workerID
+		}
+	})
+	return true, row.Version, nil
+}
+
+// leased returns the ticket if workerID holds its lease.
+func (r *MemoryTicketRepository) leased(id, workerID int64) (*memTicket, bool) {
+	row, ok := r.tickets[id]
+	if !ok || row.AssignedWorker != workerID || row.Status != models.StatusProcessing || row.LeaseExpiresAt == nil {
+		return nil, false
+	}
+	return row, true
+}
+
+// AckLease completes a ticket leased to workerID. Returns false when the
+// worker no longer holds the lease.
+func (r *MemoryTicketRepository) AckLease(ctx context.Context, id, workerID int64) (bool, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	row, ok := r.leased(id, workerID)
+	if !ok || !row.LeaseExpiresAt.After(time.Now()) {
+		return false, 0, nil
+	}
+	r.update(ctx, row, func(n *memTicket) { n.Status, n.LeaseExpiresAt = models.StatusDone, nil })
+	return true, row.Version, nil
+}
+
+// NackLease gives a ticket leased to workerID back to the queue under the
+// retry policy. Returns nil when the worker no longer holds the lease.
+func (r *MemoryTicketRepository) NackLease(ctx context.Context, id, workerID int64, p RetryPolicy) (*models.Ticket, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	row, ok := r.leased(id, workerID)
+	if !ok {
+		return nil, nil
+	}
+	r.update(ctx, row, p.requeue)
+	return requeued(row), nil
+}
+
+// requeue applies a failed delivery under the policy, as requeueSet does.
+func (p RetryPolicy) requeue(t *memTicket) {
+	t.Attempts++
+	if p.MaxAttempts > 0 && t.Attempts >= p.MaxAttempts {
+		t.Status, t.AvailableAt = models.StatusDeadLetter, nil
+	} else {
+		maxDelay := p.MaxDelay
+		if maxDelay <= 0 {
+			maxDelay = p.BaseDelay
+		}
+		delay := time.Duration(math.Min(float64(p.BaseDelay)*math.Pow(2, float64(t.Attempts-1)), float64(maxDelay)))
+		at := time.Now().Add(delay)
+		t.Status, t.AvailableAt = models.StatusWaiting, &at
+	}
+	t.AssignedWorker, t.LeaseExpiresAt = 0, nil
+}
+
+// requeued is what every requeue returns; see requeuedColumns.
+func requeued(row *memTicket) *models.Ticket {
+	return &models.Ticket{ID: row.ID, QueueID: row.QueueID, Status: row.Status, Attempts: row.Attempts,
+		AvailableAt: row.AvailableAt, Version: row.Version}
+}
+
+// ExtendLease pushes the expiry of an unexpired lease held by workerID to
+// lease from now. Returns false when the worker no longer holds the lease.
+func (r *MemoryTicketRepository) ExtendLease(ctx context.Context, id, workerID int64, lease time.Duration) (bool, time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	row, ok := r.leased(id, workerID)
+	if !ok || !row.LeaseExpiresAt.After(time.Now()) {
+		return false, time.Time{}, nil
+	}
+	expires := time.Now().Add(lease)
+	row.LeaseExpiresAt = &expires
+	return true, expires, nil
+}
+
+// RequeueExpiredLeases returns every ticket whose lease has expired to the
+// queue under the retry policy, with the worker that lost the lease.
+func (r *MemoryTicketRepository) RequeueExpiredLeases(ctx context.Context, p RetryPolicy) ([]*models.Ticket, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	var tickets []*models.Ticket
+	for _, row := range r.sorted(byArrival) {
+		if row.Status != models.StatusProcessing || row.LeaseExpiresAt == nil || row.LeaseExpiresAt.After(now) {
+			continue
+		}
+		worker := row.AssignedWorker
+		r.update(ctx, row, p.requeue)
+		t := requeued(row)
+		t.AssignedWorker = worker
+		tickets = append(tickets, t)
+	}
+	return tickets, nil
+}
+
+// RetryDeadLetter sends a dead-lettered ticket back to waiting with a fresh
+// attempt count. Returns nil when the ticket is not dead-lettered.
+func (r *MemoryTicketRepository) RetryDeadLetter(ctx context.Context, id int64) (*models.Ticket, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	row, ok := r.tickets[id]
+	if !ok || row.Status != models.StatusDeadLetter {
+		return nil, nil
+	}
+	r.update(ctx, row, func(n *memTicket) { n.Status, n.Attempts, n.AvailableAt = models.StatusWaiting, 0, nil })
+	return requeued(row), nil
+}
+
+// DiscardDeadLetter drops a dead-lettered ticket to the archive as
+// discarded. Returns nil when the ticket is not dead-lettered.
+func (r *MemoryTicketRepository) DiscardDeadLetter(ctx context.Context, id int64) (*models.Ticket, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	row, ok := r.tickets[id]
+	if !ok || row.Status != models.StatusDeadLetter {
+		return nil, nil
+	}
+	r.update(ctx, row, func(n *memTicket) { n.Status = models.StatusDiscarded })
+	return requeued(row), nil
+}
+
+// ActiveAhead counts the active tickets t's customer holds in t's queue
+// that were issued before t.
+func (r *MemoryTicketRepository) ActiveAhead(ctx context.Context, t *models.Ticket) (int, error) {
+	if t.CustomerID == nil {
+		return 0, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, row := range r.tickets {
+		if row.QueueID == t.QueueID && row.CustomerID != nil && *row.CustomerID == *t.CustomerID &&
+			row.ID < t.ID && isActive(row.Status) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Cancel moves an active ticket to cancelled with a reason code and archives
+// it. Returns false when the ticket is no longer active.
+func (r *MemoryTicketRepository) Cancel(ctx context.Context, id int64, reason, cancelledBy string) (bool, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	row, ok := r.tickets[id]
+	if !ok || !isActive(row.Status) {
+		return false, 0, nil
+	}
+	r.update(ctx, row, cancel(reason, cancelledBy))
+	return true, row.Version, nil
+}
+
+func cancel(reason, cancelledBy string) func(*memTicket) {
+	return func(t *memTicket) {
+		t.Status, t.cancelReason, t.cancelledBy = models.StatusCancelled, reason, cancelledBy
+	}
+}
+
+// CancelWaiting cancels every waiting ticket of a queue and returns them
+// as cancelled.
+func (r *MemoryTicketRepository) CancelWaiting(ctx context.Context, queueID int64, reason, cancelledBy string) ([]*models.Ticket, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var tickets []*models.Ticket
+	for _, row := range r.sorted(byArrival) {
+		if row.QueueID != queueID || row.Status != models.StatusWaiting {
+			continue
+		}
+		r.update(ctx, row, cancel(reason, cancelledBy))
+		tickets = append(tickets, copyTicket(&row.Ticket))
+	}
+	return tickets, nil
+}
+
+// AbandonmentByHour reports, per queue and arrival hour within [from, to),
+// how many archived tickets were cancelled rather than served. A zero
+// queueID covers every queue.
+func (r *MemoryTicketRepository) AbandonmentByHour(ctx context.Context, queueID int64, from, to time.Time) ([]*models.AbandonmentRow, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	type key struct {
+		queueID int64
+		hour    time.Time
+	}
+	rows := map[key]*models.AbandonmentRow{}
+	waits := map[key]int{}
+	for _, h := range r.history {
+		if (queueID != 0 && h.QueueID != queueID) || h.CreatedAt.Before(from) || !h.CreatedAt.Before(to) {
+			continue
+		}
+		k := key{h.QueueID, h.CreatedAt.UTC().Truncate(time.Hour)}
+		a := rows[k]
+		if a == nil {
+			a = &models.AbandonmentRow{QueueID: k.queueID, Hour: k.hour}
+			rows[k] = a
+		}
+		a.Archived++
+		if h.Status == models.StatusCancelled {
+			a.Cancelled++
+			waits[k] += h.waitSeconds
+		}
+	}
+
+	report := []*models.AbandonmentRow{}
+	for k, a := range rows {
+		a.AbandonmentRate = float64(a.Cancelled) / float64(a.Archived)
+		if a.Cancelled > 0 {
+			a.AvgWaitBeforeAbandonSeconds = float64(waits[k]) / float64(a.Cancelled)
+		}
+		report = append(report, a)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if !report[i].Hour.Equal(report[j].Hour) {
+			return report[i].Hour.Before(report[j].Hour)
+		}
+		return report[i].QueueID < report[j].QueueID
+	})
+	return report, nil
+}
+
+// Archive moves a ticket to the archive whatever its status.
+func (r *MemoryTicketRepository) Archive(ctx context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if row, ok := r.tickets[id]; ok {
+		r.archive(row, row)
+	}
+	return nil
+}
+
+// RequeueToWaiting returns a failed reserved ticket to the queue under the
+// retry policy.
+func (r *MemoryTicketRepository) RequeueToWaiting(ctx context.Context, id int64, p RetryPolicy) (*models.Ticket, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	row, ok := r.tickets[id]
+	if !ok || !isReserved(row.Status) || row.LeaseExpiresAt != nil {
+		return nil, nil
+	}
+	r.update(ctx, row, p.requeue)
+	return requeued(row), nil
+}
+
+// UnleasedReservations returns the queue's tickets the dispatcher reserved
+// between window and grace ago, oldest first.
+func (r *MemoryTicketRepository) UnleasedReservations(ctx context.Context, queueID int64, grace, window time.Duration) ([]*models.Ticket, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	var tickets []*models.Ticket
+	for _, row := range r.sorted(func(a, b *memTicket) bool {
+		if !a.UpdatedAt.Equal(b.UpdatedAt) {
+			return a.UpdatedAt.Before(b.UpdatedAt)
+		}
+		return a.ID < b.ID
+	}) {
+		if row.QueueID == queueID && row.Status == models.StatusProcessing && row.LeaseExpiresAt == nil &&
+			!row.UpdatedAt.After(now.Add(-grace)) && row.UpdatedAt.After(now.Add(-window)) {
+			tickets = append(tickets, copyTicket(&row.Ticket))
+		}
+	}
+	return tickets, nil
+}
+
+// ActiveIDs reports which of ids are still active; the rest have been
+// archived.
+func (r *MemoryTicketRepository) ActiveIDs(ctx context.Context, ids []int64) (map[int64]bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	active := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		if _, ok := r.tickets[id]; ok {
+			active[id] = true
+		}
+	}
+	return active, nil
+}
+
+// ReleaseReservation puts a dispatcher reservation back to waiting without
+// counting an attempt. Returns false when the ticket changed since
+// expectedVersion.
+func (r *MemoryTicketRepository) ReleaseReservation(ctx context.Context, id, expectedVersion int64) (bool, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	row, ok := r.tickets[id]
+	if !ok || row.Version != expectedVersion || row.Status != models.StatusProcessing {
+		return false, 0, nil
+	}
+	r.update(ctx, row, func(n *memTicket) { n.Status = models.StatusWaiting })
+	return true, row.Version, nil
+}
+
+// Timeline returns a ticket's state changes in order, for active and
+// archived tickets alike. Empty when the ticket never existed.
+func (r *MemoryTicketRepository) Timeline(ctx context.Context, ticketID int64) ([]*models.TicketEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	timeline := []*models.TicketEvent{}
+	for _, e := range r.events {
+		if e.TicketID == ticketID {
+			c := *e
+			timeline = append(timeline, &c)
+		}
+	}
+	return timeline, nil
+}
+
+// EventLog returns up to limit events of a queue recorded in [from, to) with
+// ids above afterID, in log order.
+func (r *MemoryTicketRepository) EventLog(ctx context.Context, queueID int64, from, to time.Time, afterID int64, limit int) ([]*models.TicketEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var page []*models.TicketEvent
+	for _, e := range r.events[min(int(max(afterID, 0)), len(r.events)):] {
+		if len(page) >= limit {
+			break
+		}
+		if e.QueueID == queueID && !e.OccurredAt.Before(from) && e.OccurredAt.Before(to) {
+			c := *e
+			page = append(page, &c)
+		}
+	}
+	return page, nil
+}
+
+// Subscribe signals when a ticket of queueID becomes reservable, so the
+// repository can serve as the dispatcher's WakeupSource.
+func (r *MemoryTicketRepository) Subscribe(queueID int64) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	r.mu.Lock()
+	if r.subs[queueID] == nil {
+		r.subs[queueID] = map[chan struct{}]struct{}{}
+	}
+	r.subs[queueID][ch] = struct{}{}
+	r.mu.Unlock()
+
+	return ch, func() {
+		r.mu.Lock()
+		delete(r.subs[queueID], ch)
+		r.mu.Unlock()
+	}
+}
+
+// SubscribeQueues receives the queue of every ticket that becomes
+// reservable, whichever queue it is in, so a lone replica can start a
+// queue's dispatcher when the queue first has work. Like Subscribe's,
+// signals are dropped rather than block a write.
+func (r *MemoryTicketRepository) SubscribeQueues() (<-chan int64, func()) {
+	ch := make(chan int64, 64)
+	r.mu.Lock()
+	r.ready[ch] = struct{}{}
+	r.mu.Unlock()
+
+	return ch, func() {
+		r.mu.Lock()
+		delete(r.ready, ch)
+		r.mu.Unlock()
+	}
+}
+
+// wake signals the subscribers of queueID without blocking; r.mu is held.
+func (r *MemoryTicketRepository) wake(queueID int64) {
+	for ch := range r.subs[queueID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	for ch := range r.ready {
+		select {
+		case ch <- queueID:
+		default:
+		}
+	}
+}
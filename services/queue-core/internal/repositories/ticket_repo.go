@@ -4,10 +4,95 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
 
+	"queue-core/internal/actor"
 	"queue-core/internal/models"
 )
 
+// ErrActiveTicketLimit is returned when a customer already holds the maximum
+// number of active tickets allowed in a queue.
+var ErrActiveTicketLimit = errors.New("customer already has an active ticket in this queue")
+
+// ticketColumns is the column list shared by every SELECT that feeds scanTicket.
+const ticketColumns = `id, queue_id, customer_id, customer_name, status, priority, COALESCE(priority_class, ''), lane, attributes,
+    COALESCE(counter_id, 0), COALESCE(assigned_worker, 0), COALESCE(token, ''), created_at, updated_at, estimated_time, version,
+    attempts, available_at, group_id, routing`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTicket(row rowScanner, t *models.Ticket) error {
+	return row.Scan(
+		&t.ID, &t.QueueID, &t.CustomerID, &t.CustomerName, &t.Status, &t.Priority, &t.PriorityClass, &t.Lane, &t.Attributes,
+		&t.CounterID, &t.AssignedWorker, &t.Token,
+		&t.CreatedAt, &t.UpdatedAt, &t.EstimatedTime, &t.Version,
+		&t.Attempts, &t.AvailableAt, &t.GroupID, &t.Routing,
+	)
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// dbtx is satisfied by both *sql.DB and *sql.Tx.
+type dbtx interface {
+	queryRower
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// actorName is how ctx's actor is recorded in ticket_events: role, or
+// role:id when the actor has an id (worker:11). A ctx that declares no
+// actor names "", which leaves the record_ticket_event trigger to infer it.
+func actorName(ctx context.Context) string {
+	a, ok := actor.Declared(ctx)
+	if !ok {
+		return ""
+	}
+	if a.ID == "" {
+		return string(a.Role)
+	}
+	return string(a.Role) + ":" + a.ID
+}
+
+// nameActor sets queue_core.actor for the rest of tx, so ticket_events rows
+// written by the trigger name ctx's actor.
+func nameActor(ctx context.Context, tx *sql.Tx) error {
+	name := actorName(ctx)
+	if name == "" {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `SELECT set_config('queue_core.actor', $1, true)`, name)
+	return err
+}
+
+// mutate runs a ticket change in a transaction naming ctx's actor, or
+// straight on the pool when there is no actor to name.
+func (r *TicketRepository) mutate(ctx context.Context, fn func(q dbtx) error) error {
+	if actorName(ctx) == "" {
+		return fn(r.db)
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := nameActor(ctx, tx); err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 type TicketRepository struct {
 	db *sql.DB
 }
@@ -18,23 +103,74 @@ func NewTicketRepo(db *sql.DB) *TicketRepository {
 
 // Create ticket
 func (r *TicketRepository) Create(ctx context.Context, t *models.Ticket) error {
+	return r.mutate(ctx, func(q dbtx) error { return insertTicket(ctx, q, t) })
+}
+
+func insertTicket(ctx context.Context, q queryRower, t *models.Ticket) error {
 	query := `
-        INSERT INTO tickets (queue_id, customer_name, status, priority, estimated_time, created_at, updated_at)
-        VALUES ($1,$2,$3,$4,$5,NOW(),NOW())
-        RETURNING id, created_at, updated_at, version
+        INSERT INTO tickets (queue_id, customer_name, status, priority, estimated_time, customer_id, priority_class, lane, attributes, token, group_id, routing, created_at, updated_at)
+        VALUES ($1,$2,$3,$4,$5,$6,NULLIF($7,''),COALESCE(NULLIF($8,''),'standard'),$9::jsonb,NULLIF($10,''),$11,$12::jsonb,NOW(),NOW())
+        RETURNING id, lane, created_at, updated_at, version
     `
-	return r.db.QueryRowContext(ctx, query, t.QueueID, t.CustomerName, t.Status, t.Priority, t.EstimatedTime).
-		Scan(&t.ID, &t.CreatedAt, &t.UpdatedAt, &t.Version)
+	return q.QueryRowContext(ctx, query, t.QueueID, t.CustomerName, t.Status, t.Priority, t.EstimatedTime, t.CustomerID, t.PriorityClass, t.Lane, t.Attributes, t.Token, t.GroupID, t.Routing).
+		Scan(&t.ID, &t.Lane, &t.CreatedAt, &t.UpdatedAt, &t.Version)
+}
+
+// CreateWithCustomerLimit inserts the ticket only if its customer holds fewer
+// than maxActive active tickets in the same queue. The check and the insert
+// run in one transaction guarded by a per-customer advisory lock, so two
+// concurrent joins by the same customer cannot both slip through.
+func (r *TicketRepository) CreateWithCustomerLimit(ctx context.Context, t *models.Ticket, maxActive int) error {
+	if t.CustomerID == nil || maxActive <= 0 {
+		return r.Create(ctx, t)
+	}
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if err := nameActor(ctx, tx); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, *t.CustomerID); err != nil {
+		return err
+	}
+
+	var active int
+	err = tx.QueryRowContext(ctx, `
+        SELECT COUNT(*)
+        FROM tickets
+        WHERE queue_id=$1 AND customer_id=$2 AND status IN ('waiting','processing','in_progress')
+    `, t.QueueID, *t.CustomerID).Scan(&active)
+	if err != nil {
+		return err
+	}
+	if active >= maxActive {
+		return ErrActiveTicketLimit
+	}
+
+	if err := insertTicket(ctx, tx, t); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	committed = true
+	return nil
 }
 
 // Get ticket by ID
 func (r *TicketRepository) GetByID(ctx context.Context, id int64) (*models.Ticket, error) {
 	t := &models.Ticket{}
-	query := `SELECT id, queue_id, customer_name, status, priority, created_at, updated_at, estimated_time, version FROM tickets WHERE id=$1`
-	err := r.db.QueryRowContext(ctx, query, id).Scan(
-		&t.ID, &t.QueueID, &t.CustomerName, &t.Status, &t.Priority, &t.CreatedAt, &t.UpdatedAt, &t.EstimatedTime, &t.Version,
-	)
-	if err != nil {
+	query := `SELECT ` + ticketColumns + ` FROM tickets WHERE id=$1`
+	if err := scanTicket(r.db.QueryRowContext(ctx, query, id), t); err != nil {
 		return nil, err
 	}
 	return t, nil
@@ -42,13 +178,31 @@ func (r *TicketRepository) GetByID(ctx context.Context, id int64) (*models.Ticke
 
 // GetByStatus — also return version (for API listing if needed)
 func (r *TicketRepository) GetByStatus(ctx context.Context, queueID int, status string) ([]*models.Ticket, error) {
+	return r.ListByStatus(ctx, queueID, status, nil)
+}
+
+// ListByStatus is GetByStatus narrowed by attribute equality filters. Values
+// are compared in their text form, so {"seats": "2"} matches a numeric 2.
+func (r *TicketRepository) ListByStatus(ctx context.Context, queueID int, status string, attrs map[string]string) ([]*models.Ticket, error) {
+	args := []any{queueID, status}
+	filter := ""
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, k, attrs[k])
+		filter += fmt.Sprintf(" AND attributes->>$%d = $%d", len(args)-1, len(args))
+	}
+
 	query := `
-        SELECT id, queue_id, customer_name, status, priority, created_at, updated_at, estimated_time, version
+        SELECT ` + ticketColumns + `
         FROM tickets
-        WHERE queue_id=$1 AND status=$2
+        WHERE queue_id=$1 AND status=$2` + filter + `
         ORDER BY created_at ASC
     `
-	rows, err := r.db.QueryContext(ctx, query, queueID, status)
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -57,10 +211,7 @@ func (r *TicketRepository) GetByStatus(ctx context.Context, queueID int, status
 	var tickets []*models.Ticket
 	for rows.Next() {
 		t := &models.Ticket{}
-		if err := rows.Scan(
-			&t.ID, &t.QueueID, &t.CustomerName, &t.Status, &t.Priority,
-			&t.CreatedAt, &t.UpdatedAt, &t.EstimatedTime, &t.Version,
-		); err != nil {
+		if err := scanTicket(rows, t); err != nil {
 			return nil, err
 		}
 		tickets = append(tickets, t)
@@ -71,9 +222,138 @@ func (r *TicketRepository) GetByStatus(ctx context.Context, queueID int, status
 	return tickets, nil
 }
 
+// CountByStatus counts the tickets of a queue in one status.
+func (r *TicketRepository) CountByStatus(ctx context.Context, queueID int64, status string) (int, error) {
+	var n int
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM tickets WHERE queue_id=$1 AND status=$2`, queueID, status).Scan(&n)
+	return n, err
+}
+
+// QueueLoads returns, per queue, the waiting count and the average service
+// time (reservation to completion) of tickets completed within window.
+// Servers and ProjectedWaitSeconds are left for the caller.
+func (r *TicketRepository) QueueLoads(ctx context.Context, queueIDs []int64, window time.Duration) ([]models.QueueLoad, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT q.id,
+            (SELECT COUNT(*) FROM tickets t WHERE t.queue_id = q.id AND t.status = 'waiting'),
+            COALESCE((
+                SELECT AVG(EXTRACT(EPOCH FROM h.archived_at - h.updated_at))
+                FROM ticket_history h
+                WHERE h.queue_id = q.id AND h.status = 'done' AND h.archived_at >= NOW() - make_interval(secs => $2)
+            ), 0)
+        FROM unnest($1::bigint[]) AS q(id)
+        ORDER BY q.id ASC
+    `, queueIDs, window.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var loads []models.QueueLoad
+	for rows.Next() {
+		var l models.QueueLoad
+		if err := rows.Scan(&l.QueueID, &l.Waiting, &l.AvgServiceSeconds); err != nil {
+			return nil, err
+		}
+		loads = append(loads, l)
+	}
+	return loads, rows.Err()
+}
+
 // ReserveNext - atomically pick one waiting ticket and set it to processing.
 // Returns ticket with its original version value (pre-update).
 func (r *TicketRepository) ReserveNext(ctx context.Context, queueID int) (*models.Ticket, error) {
+	return r.Reserve(ctx, queueID, ReserveOptions{})
+}
+
+// ReserveOrder is the ordering a reservation uses to pick among waiting tickets.
+type ReserveOrder int
+
+const (
+	OrderFIFO        ReserveOrder = iota // arrival order
+	OrderPriority                        // highest priority, then arrival
+	OrderShortestJob                     // lowest estimated_time (unknown last), then arrival
+)
+
+// ReserveOptions narrows and orders the candidates of Reserve.
+type ReserveOptions struct {
+	Order ReserveOrder
+	// Lanes, if set, is a call-out preference: tickets of Lanes[0] first,
+	// then Lanes[1], ..., then lanes not listed. Applied before Order.
+	Lanes []string
+	// Skills, if set, restricts candidates to tickets whose SkillAttribute
+	// is missing/empty or one of Skills.
+	Skills         []string
+	SkillAttribute string
+	// Lease, if set, leases the reserved ticket to WorkerID until it
+	// expires; the lease reaper requeues it unless acked or extended.
+	Lease    time.Duration
+	WorkerID int64
+	// Strict serializes the queue's reservers on a queue-head lock and waits
+	// for a locked candidate instead of skipping it, so tickets are reserved
+	// in exact order. Costs concurrency; see lockQueueHead.
+	Strict bool
+}
+
+// queueHeadLockClass namespaces strict-order advisory locks ("head" in
+// ASCII) apart from dispatcher leadership and per-customer locks.
+const queueHeadLockClass = 0x68656164
+
+// lockQueueHead takes the queue's head lock for the rest of tx. With it held
+// no other strict reserver of the queue can pick concurrently, and picking
+// with plain FOR UPDATE waits out rows locked by other writers (cancel,
+// complete) rather than passing over them.
+func lockQueueHead(ctx context.Context, tx *sql.Tx, queueID int) error {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, $2)`, queueHeadLockClass, queueID)
+	return err
+}
+
+// lockClause is the row-locking clause of a reservation pick.
+func (o ReserveOptions) lockClause() string {
+	if o.Strict {
+		return "FOR UPDATE"
+	}
+	return "FOR UPDATE SKIP LOCKED"
+}
+
+// clauses renders the options as extra WHERE conditions and an ORDER BY,
+// with positional args numbered from firstArg.
+func (o ReserveOptions) clauses(firstArg int) (where, orderBy string, args []any) {
+	n := firstArg
+	next := func(v any) string {
+		args = append(args, v)
+		s := fmt.Sprintf("$%d", n)
+		n++
+		return s
+	}
+
+	if len(o.Skills) > 0 {
+		attr := o.SkillAttribute
+		if attr == "" {
+			attr = "skill"
+		}
+		a := next(attr)
+		where = fmt.Sprintf(" AND (COALESCE(attributes->>%s, '') = '' OR attributes->>%s = ANY(%s::text[]))", a, a, next(o.Skills))
+	}
+
+	var order []string
+	if len(o.Lanes) > 0 {
+		order = append(order, fmt.Sprintf("array_position(%s::text[], lane) ASC NULLS LAST", next(o.Lanes)), "priority DESC")
+	}
+	switch o.Order {
+	case OrderPriority:
+		order = append(order, "priority DESC")
+	case OrderShortestJob:
+		order = append(order, "NULLIF(estimated_time, 0) ASC NULLS LAST")
+	}
+	order = append(order, "created_at ASC", "id ASC")
+	return where, "ORDER BY " + strings.Join(order, ", "), args
+}
+
+// Reserve atomically picks the first waiting ticket by opts and sets it to
+// processing. Returns ticket with its original version value (pre-update).
+func (r *TicketRepository) Reserve(ctx context.Context, queueID int, opts ReserveOptions) (*models.Ticket, error) {
+	where, orderBy, args := opts.clauses(2)
 	// Begin a short-lived transaction
 	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
 	if err != nil {
@@ -87,19 +367,25 @@ func (r *TicketRepository) ReserveNext(ctx context.Context, queueID int) (*model
 		}
 	}()
 
+	if err := nameActor(ctx, tx); err != nil {
+		return nil, err
+	}
+	if opts.Strict {
+		if err := lockQueueHead(ctx, tx, queueID); err != nil {
+			return nil, err
+		}
+	}
+
 	q := `
-        SELECT id, queue_id, customer_name, status, priority, created_at, updated_at, estimated_time, version
+        SELECT ` + ticketColumns + `
         FROM tickets
-        WHERE queue_id=$1 AND status='waiting'
-        ORDER BY created_at ASC
-        FOR UPDATE SKIP LOCKED
+        WHERE queue_id=$1 AND status='waiting' AND (available_at IS NULL OR available_at <= NOW())` + where + `
+        ` + orderBy + `
+        ` + opts.lockClause() + `
         LIMIT 1
     `
 	t := &models.Ticket{}
-	err = tx.QueryRowContext(ctx, q, queueID).Scan(
-		&t.ID, &t.QueueID, &t.CustomerName, &t.Status, &t.Priority,
-		&t.CreatedAt, &t.UpdatedAt, &t.EstimatedTime, &t.Version,
-	)
+	err = scanTicket(tx.QueryRowContext(ctx, q, append([]any{queueID}, args...)...), t)
 	if err == sql.ErrNoRows {
 		// nothing to reserve
 		_ = tx.Rollback()
@@ -110,11 +396,23 @@ func (r *TicketRepository) ReserveNext(ctx context.Context, queueID int) (*model
 	}
 
 	// Update to processing and bump version
-	_, err = tx.ExecContext(ctx, `
+	if opts.Lease > 0 {
+		var expires time.Time
+		err = tx.QueryRowContext(ctx, `
+        UPDATE tickets
+        SET status='processing', assigned_worker=$2, lease_expires_at=NOW() + make_interval(secs => $3),
+            updated_at=NOW(), version=version+1
+        WHERE id = $1
+        RETURNING lease_expires_at
+    `, t.ID, opts.WorkerID, opts.Lease.Seconds()).Scan(&expires)
+		t.AssignedWorker, t.LeaseExpiresAt = opts.WorkerID, &expires
+	} else {
+		_, err = tx.ExecContext(ctx, `
         UPDATE tickets
         SET status='processing', updated_at=NOW(), version=version+1
         WHERE id = $1
     `, t.ID)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -128,6 +426,104 @@ func (r *TicketRepository) ReserveNext(ctx context.Context, queueID int) (*model
 	return t, nil
 }
 
+// reservedColumns mirrors ticketColumns for ReserveMany's UPDATE, taking
+// updated_at and version from before the update like Reserve returns them.
+const reservedColumns = `t.id, t.queue_id, t.customer_id, t.customer_name, t.status, t.priority, COALESCE(t.priority_class, ''), t.lane, t.attributes,
+    COALESCE(t.counter_id, 0), COALESCE(t.assigned_worker, 0), COALESCE(t.token, ''), t.created_at, old.updated_at, t.estimated_time, old.version,
+    t.attempts, t.available_at, t.group_id, t.routing`
+
+// ReserveBatch reserves up to n waiting tickets in arrival order; see ReserveMany.
+func (r *TicketRepository) ReserveBatch(ctx context.Context, queueID, n int) ([]*models.Ticket, error) {
+	return r.ReserveMany(ctx, queueID, n, ReserveOptions{})
+}
+
+// ReserveMany reserves up to n waiting tickets picked by opts in a single
+// statement (SKIP LOCKED, so concurrent reservers never collide; or under
+// the queue-head lock with opts.Strict) and returns them in pick order, with
+// their original version values (pre-update).
+// Leases are not supported; opts.Lease is ignored.
+func (r *TicketRepository) ReserveMany(ctx context.Context, queueID, n int, opts ReserveOptions) ([]*models.Ticket, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	where, orderBy, args := opts.clauses(3)
+	q := `
+        UPDATE tickets t
+        SET status='processing', updated_at=NOW(), version=t.version+1
+        FROM (
+            -- window functions cannot share a level with FOR UPDATE; number
+            -- the locked rows in their pick order one level up
+            SELECT id, updated_at, version, ROW_NUMBER() OVER () AS pick
+            FROM (
+                SELECT id, updated_at, version
+                FROM tickets
+                WHERE queue_id=$1 AND status='waiting' AND (available_at IS NULL OR available_at <= NOW())` + where + `
+                ` + orderBy + `
+                ` + opts.lockClause() + `
+                LIMIT $2
+            ) locked
+        ) old
+        WHERE t.id = old.id
+        RETURNING ` + reservedColumns + `, old.pick
+    `
+	if !opts.Strict {
+		return scanBatch(r.db.QueryContext(ctx, q, append([]any{queueID, n}, args...)...))
+	}
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if err := lockQueueHead(ctx, tx, queueID); err != nil {
+		return nil, err
+	}
+	tickets, err := scanBatch(tx.QueryContext(ctx, q, append([]any{queueID, n}, args...)...))
+	if err != nil {
+		return nil, err
+	}
+	return tickets, tx.Commit()
+}
+
+// scanBatch reads ReserveMany's rows (reservedColumns plus pick) and
+// returns the tickets in pick order.
+func scanBatch(rows *sql.Rows, err error) ([]*models.Ticket, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type picked struct {
+		t    *models.Ticket
+		pick int64
+	}
+	var batch []picked
+	for rows.Next() {
+		p := picked{t: &models.Ticket{}}
+		err := rows.Scan(
+			&p.t.ID, &p.t.QueueID, &p.t.CustomerID, &p.t.CustomerName, &p.t.Status, &p.t.Priority, &p.t.PriorityClass, &p.t.Lane, &p.t.Attributes,
+			&p.t.CounterID, &p.t.AssignedWorker, &p.t.Token,
+			&p.t.CreatedAt, &p.t.UpdatedAt, &p.t.EstimatedTime, &p.t.Version,
+			&p.t.Attempts, &p.t.AvailableAt, &p.t.GroupID, &p.t.Routing, &p.pick,
+		)
+		if err != nil {
+			return nil, err
+		}
+		batch = append(batch, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// RETURNING order is unspecified; restore the pick order
+	sort.Slice(batch, func(i, j int) bool { return batch[i].pick < batch[j].pick })
+	tickets := make([]*models.Ticket, len(batch))
+	for i, p := range batch {
+		tickets[i] = p.t
+	}
+	return tickets, nil
+}
+
 // UpdateStatus uses optimistic locking. It expects the caller to pass the CURRENT
 // version value as seen by caller. If update succeeds, returns true and newVersion.
 func (r *TicketRepository) UpdateStatus(ctx context.Context, id int64, oldStatus, newStatus string, expectedVersion int64) (bool, int64, error) {
@@ -138,7 +534,33 @@ func (r *TicketRepository) UpdateStatus(ctx context.Context, id int64, oldStatus
         RETURNING version
     `
 	var newVersion int64
-	err := r.db.QueryRowContext(ctx, q, newStatus, id, oldStatus, expectedVersion).Scan(&newVersion)
+	err := r.mutate(ctx, func(tx dbtx) error {
+		return tx.QueryRowContext(ctx, q, newStatus, id, oldStatus, expectedVersion).Scan(&newVersion)
+	})
+	if err == sql.ErrNoRows {
+		return false, 0, nil
+	}
+	if err != nil {
+		return false, 0, err
+	}
+	return true, newVersion, nil
+}
+
+// Complete marks a reserved ticket done, recording which counter and worker
+// served it; the archive trigger then moves it to ticket_history. Uses the
+// same optimistic locking contract as UpdateStatus.
+func (r *TicketRepository) Complete(ctx context.Context, id, expectedVersion int64, counterID, workerID *int64) (bool, int64, error) {
+	q := `
+        UPDATE tickets
+        SET status='done', counter_id=COALESCE($3, counter_id), assigned_worker=COALESCE($4, assigned_worker),
+            updated_at=NOW(), version=version+1
+        WHERE id=$1 AND version=$2 AND status IN ('processing','in_progress')
+        RETURNING version
+    `
+	var newVersion int64
+	err := r.mutate(ctx, func(tx dbtx) error {
+		return tx.QueryRowContext(ctx, q, id, expectedVersion, counterID, workerID).Scan(&newVersion)
+	})
 	if err == sql.ErrNoRows {
 		return false, 0, nil
 	}
@@ -148,12 +570,289 @@ func (r *TicketRepository) UpdateStatus(ctx context.Context, id int64, oldStatus
 	return true, newVersion, nil
 }
 
+// AckLease completes a ticket leased to workerID; the archive trigger then
+// moves it to ticket_history. Returns false when the worker no longer holds
+// the lease (already acked, nacked, or requeued after expiry).
+func (r *TicketRepository) AckLease(ctx context.Context, id, workerID int64) (bool, int64, error) {
+	q := `
+        UPDATE tickets
+        SET status='done', lease_expires_at=NULL, updated_at=NOW(), version=version+1
+        WHERE id=$1 AND assigned_worker=$2 AND status='processing' AND lease_expires_at > NOW()
+        RETURNING version
+    `
+	return r.updateLease(ctx, q, id, workerID)
+}
+
+// NackLease gives a ticket leased to workerID back to the queue under the
+// retry policy (backoff, or dead_letter once out of attempts). Returns nil
+// when the worker no longer holds the lease.
+func (r *TicketRepository) NackLease(ctx context.Context, id, workerID int64, p RetryPolicy) (*models.Ticket, error) {
+	set, args := p.requeueSet(3)
+	q := `
+        UPDATE tickets
+        SET ` + set + `
+        WHERE id=$1 AND assigned_worker=$2 AND status='processing' AND lease_expires_at IS NOT NULL
+        RETURNING ` + requeuedColumns
+	var t *models.Ticket
+	err := r.mutate(ctx, func(tx dbtx) (err error) {
+		t, err = scanRequeued(tx.QueryRowContext(ctx, q, append([]any{id, workerID}, args...)...))
+		return err
+	})
+	return t, err
+}
+
+func (r *TicketRepository) updateLease(ctx context.Context, q string, id, workerID int64) (bool, int64, error) {
+	var newVersion int64
+	err := r.mutate(ctx, func(tx dbtx) error {
+		return tx.QueryRowContext(ctx, q, id, workerID).Scan(&newVersion)
+	})
+	if err == sql.ErrNoRows {
+		return false, 0, nil
+	}
+	if err != nil {
+		return false, 0, err
+	}
+	return true, newVersion, nil
+}
+
+// ExtendLease pushes the expiry of an unexpired lease held by workerID to
+// lease from now. Returns false when the worker no longer holds the lease.
+func (r *TicketRepository) ExtendLease(ctx context.Context, id, workerID int64, lease time.Duration) (bool, time.Time, error) {
+	q := `
+        UPDATE tickets
+        SET lease_expires_at=NOW() + make_interval(secs => $3)
+        WHERE id=$1 AND assigned_worker=$2 AND status='processing' AND lease_expires_at > NOW()
+        RETURNING lease_expires_at
+    `
+	var expires time.Time
+	err := r.db.QueryRowContext(ctx, q, id, workerID, lease.Seconds()).Scan(&expires)
+	if err == sql.ErrNoRows {
+		return false, time.Time{}, nil
+	}
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	return true, expires, nil
+}
+
+// RequeueExpiredLeases returns every ticket whose lease has expired to the
+// queue under the retry policy. The returned tickets carry the requeue
+// outcome (see requeuedColumns) plus the worker that lost the lease.
+func (r *TicketRepository) RequeueExpiredLeases(ctx context.Context, p RetryPolicy) ([]*models.Ticket, error) {
+	set, args := p.requeueSet(1)
+	rows, err := r.db.QueryContext(ctx, `
+        UPDATE tickets t
+        SET `+set+`
+        FROM (
+            SELECT id, assigned_worker FROM tickets
+            WHERE status='processing' AND lease_expires_at <= NOW()
+            FOR UPDATE SKIP LOCKED
+        ) expired
+        WHERE t.id = expired.id
+        RETURNING t.id, t.queue_id, t.status, t.attempts, t.available_at, t.version, COALESCE(expired.assigned_worker, 0)
+    `, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tickets []*models.Ticket
+	for rows.Next() {
+		t := &models.Ticket{}
+		if err := rows.Scan(&t.ID, &t.QueueID, &t.Status, &t.Attempts, &t.AvailableAt, &t.Version, &t.AssignedWorker); err != nil {
+			return nil, err
+		}
+		tickets = append(tickets, t)
+	}
+	return tickets, rows.Err()
+}
+
+// RetryPolicy decides where a failed ticket goes: back to waiting after an
+// exponential backoff, or to dead_letter once it has failed MaxAttempts
+// times. MaxAttempts 0 retries forever.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration // backoff after the first failure, doubled per attempt
+	MaxDelay    time.Duration // backoff cap
+}
+
+// DefaultRetryPolicy backs off 5s, 10s, 20s, 40s and dead-letters on the
+// fifth failure.
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 5, BaseDelay: 5 * time.Second, MaxDelay: 5 * time.Minute}
+
+// requeuedColumns is what every requeue returns; see scanRequeued.
+const requeuedColumns = `id, queue_id, status, attempts, available_at, version`
+
+// requeueSet renders the SET clause of a failed delivery under the policy,
+// with positional args numbered from firstArg. Every expression sees the
+// pre-update attempts, so attempts+1 is the count after this failure.
+func (p RetryPolicy) requeueSet(firstArg int) (string, []any) {
+	maxN, base, ceiling := fmt.Sprintf("$%d", firstArg), fmt.Sprintf("$%d", firstArg+1), fmt.Sprintf("$%d", firstArg+2)
+	dead := fmt.Sprintf("%s::int > 0 AND attempts+1 >= %s::int", maxN, maxN)
+	set := `attempts=attempts+1,
+            status=CASE WHEN ` + dead + ` THEN 'dead_letter' ELSE 'waiting' END,
+            available_at=CASE WHEN ` + dead + ` THEN NULL
+                ELSE NOW() + make_interval(secs => LEAST(` + base + `::float8 * power(2, attempts), ` + ceiling + `::float8)) END,
+            assigned_worker=NULL, lease_expires_at=NULL, updated_at=NOW(), version=version+1`
+	maxDelay := p.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = p.BaseDelay
+	}
+	return set, []any{p.MaxAttempts, p.BaseDelay.Seconds(), maxDelay.Seconds()}
+}
+
+// scanRequeued reads a requeueing UPDATE's requeuedColumns. Returns nil when
+// no row matched.
+func scanRequeued(row *sql.Row) (*models.Ticket, error) {
+	t := &models.Ticket{}
+	err := row.Scan(&t.ID, &t.QueueID, &t.Status, &t.Attempts, &t.AvailableAt, &t.Version)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// RetryDeadLetter sends a dead-lettered ticket back to waiting with a fresh
+// attempt count. Returns nil when the ticket is not dead-lettered.
+func (r *TicketRepository) RetryDeadLetter(ctx context.Context, id int64) (*models.Ticket, error) {
+	return r.requeueWith(ctx, `
+        UPDATE tickets
+        SET status='waiting', attempts=0, available_at=NULL, updated_at=NOW(), version=version+1
+        WHERE id=$1 AND status='dead_letter'
+        RETURNING `+requeuedColumns, id)
+}
+
+// DiscardDeadLetter drops a dead-lettered ticket; the archive trigger moves
+// it to ticket_history as discarded. Returns nil when the ticket is not
+// dead-lettered.
+func (r *TicketRepository) DiscardDeadLetter(ctx context.Context, id int64) (*models.Ticket, error) {
+	return r.requeueWith(ctx, `
+        UPDATE tickets
+        SET status='discarded', updated_at=NOW(), version=version+1
+        WHERE id=$1 AND status='dead_letter'
+        RETURNING `+requeuedColumns, id)
+}
+
+// requeueWith runs one requeueing UPDATE as ctx's actor; see scanRequeued.
+func (r *TicketRepository) requeueWith(ctx context.Context, q string, args ...any) (*models.Ticket, error) {
+	var t *models.Ticket
+	err := r.mutate(ctx, func(tx dbtx) (err error) {
+		t, err = scanRequeued(tx.QueryRowContext(ctx, q, args...))
+		return err
+	})
+	return t, err
+}
+
+// ActiveAhead counts the active tickets t's customer holds in t's queue
+// that were issued before t.
+func (r *TicketRepository) ActiveAhead(ctx context.Context, t *models.Ticket) (int, error) {
+	if t.CustomerID == nil {
+		return 0, nil
+	}
+	var n int
+	err := r.db.QueryRowContext(ctx, `
+        SELECT COUNT(*)
+        FROM tickets
+        WHERE queue_id=$1 AND customer_id=$2 AND id<$3 AND status IN ('waiting','processing','in_progress')
+    `, t.QueueID, *t.CustomerID, t.ID).Scan(&n)
+	return n, err
+}
+
+// Cancel moves an active ticket to cancelled with a reason code; the archive
+// trigger then moves it to ticket_history with its wait duration. Returns
+// false when the ticket is no longer active.
+func (r *TicketRepository) Cancel(ctx context.Context, id int64, reason, cancelledBy string) (bool, int64, error) {
+	q := `
+        UPDATE tickets
+        SET status='cancelled', cancel_reason=$2, cancelled_by=$3, updated_at=NOW(), version=version+1
+        WHERE id=$1 AND status IN ('waiting','processing','in_progress')
+        RETURNING version
+    `
+	var newVersion int64
+	err := r.mutate(ctx, func(tx dbtx) error {
+		return tx.QueryRowContext(ctx, q, id, reason, cancelledBy).Scan(&newVersion)
+	})
+	if err == sql.ErrNoRows {
+		return false, 0, nil
+	}
+	if err != nil {
+		return false, 0, err
+	}
+	return true, newVersion, nil
+}
+
+// CancelWaiting cancels every waiting ticket of a queue (e.g. on session
+// close) and returns them as cancelled.
+func (r *TicketRepository) CancelWaiting(ctx context.Context, queueID int64, reason, cancelledBy string) ([]*models.Ticket, error) {
+	var tickets []*models.Ticket
+	err := r.mutate(ctx, func(tx dbtx) error {
+		rows, err := tx.QueryContext(ctx, `
+            UPDATE tickets
+            SET status='cancelled', cancel_reason=$2, cancelled_by=$3, updated_at=NOW(), version=version+1
+            WHERE queue_id=$1 AND status='waiting'
+            RETURNING `+ticketColumns+`
+        `, queueID, reason, cancelledBy)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			t := &models.Ticket{}
+			if err := scanTicket(rows, t); err != nil {
+				return err
+			}
+			tickets = append(tickets, t)
+		}
+		return rows.Err()
+	})
+	return tickets, err
+}
+
+// AbandonmentByHour reports, per queue and arrival hour within [from, to),
+// how many archived tickets were cancelled rather than served. A zero
+// queueID covers every queue.
+func (r *TicketRepository) AbandonmentByHour(ctx context.Context, queueID int64, from, to time.Time) ([]*models.AbandonmentRow, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT queue_id, date_trunc('hour', created_at AT TIME ZONE 'UTC') AS hour,
+               COUNT(*),
+               COUNT(*) FILTER (WHERE status='cancelled'),
+               COALESCE(AVG(wait_seconds) FILTER (WHERE status='cancelled'), 0)::float8
+        FROM ticket_history
+        WHERE ($1 = 0 OR queue_id=$1) AND created_at >= $2 AND created_at < $3
+        GROUP BY queue_id, hour
+        ORDER BY hour ASC, queue_id ASC
+    `, queueID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := []*models.AbandonmentRow{}
+	for rows.Next() {
+		a := &models.AbandonmentRow{}
+		if err := rows.Scan(&a.QueueID, &a.Hour, &a.Archived, &a.Cancelled, &a.AvgWaitBeforeAbandonSeconds); err != nil {
+			return nil, err
+		}
+		if a.Archived > 0 {
+			a.AbandonmentRate = float64(a.Cancelled) / float64(a.Archived)
+		}
+		report = append(report, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
 // Optional: Move ticket to history (archival). Not strictly required but recommended.
 func (r *TicketRepository) Archive(ctx context.Context, id int64) error {
 	// simplistic example; adapt to your schema
 	_, err := r.db.ExecContext(ctx, `
-        INSERT INTO ticket_history (id, queue_id, customer_name, status, priority, estimated_time, version, created_at, updated_at)
-        SELECT id, queue_id, customer_name, status, priority, estimated_time, version, created_at, updated_at
+        INSERT INTO ticket_history (id, queue_id, customer_id, customer_name, status, priority, priority_class, lane, attributes, token, counter_id, assigned_worker, cancel_reason, cancelled_by, attempts, group_id, routing, estimated_time, version, created_at, updated_at)
+        SELECT id, queue_id, customer_id, customer_name, status, priority, priority_class, lane, attributes, token, counter_id, assigned_worker, cancel_reason, cancelled_by, attempts, group_id, routing, estimated_time, version, created_at, updated_at
         FROM tickets WHERE id=$1
     `, id)
 	if err != nil {
@@ -163,19 +862,150 @@ func (r *TicketRepository) Archive(ctx context.Context, id int64) error {
 	return err
 }
 
-// Optional helper to requeue a ticket to waiting (e.g., on failure)
-func (r *TicketRepository) RequeueToWaiting(ctx context.Context, id int64) error {
-	res, err := r.db.ExecContext(ctx, `
+// RequeueToWaiting returns a failed reserved ticket to the queue under the
+// retry policy: waiting after a backoff, or dead_letter once out of attempts.
+// Claimed tickets are nacked by their worker instead. Returns nil when the
+// ticket is not a dispatcher reservation.
+func (r *TicketRepository) RequeueToWaiting(ctx context.Context, id int64, p RetryPolicy) (*models.Ticket, error) {
+	set, args := p.requeueSet(2)
+	return r.requeueWith(ctx, `
+        UPDATE tickets
+        SET `+set+`
+        WHERE id=$1 AND status IN ('processing','in_progress') AND lease_expires_at IS NULL
+        RETURNING `+requeuedColumns, append([]any{id}, args...)...)
+}
+
+// UnleasedReservations returns the queue's tickets the dispatcher reserved
+// (processing, no claim lease) between window and grace ago, oldest first:
+// each of them should have a ticket.reserved entry on the queue stream.
+func (r *TicketRepository) UnleasedReservations(ctx context.Context, queueID int64, grace, window time.Duration) ([]*models.Ticket, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT `+ticketColumns+`
+        FROM tickets
+        WHERE queue_id=$1 AND status='processing' AND lease_expires_at IS NULL
+          AND updated_at <= NOW() - make_interval(secs => $2)
+          AND updated_at > NOW() - make_interval(secs => $3)
+        ORDER BY updated_at ASC, id ASC
+    `, queueID, grace.Seconds(), window.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tickets []*models.Ticket
+	for rows.Next() {
+		t := &models.Ticket{}
+		if err := scanTicket(rows, t); err != nil {
+			return nil, err
+		}
+		tickets = append(tickets, t)
+	}
+	return tickets, rows.Err()
+}
+
+// ActiveIDs reports which of ids still have a ticket row; the rest have been
+// archived to ticket_history.
+func (r *TicketRepository) ActiveIDs(ctx context.Context, ids []int64) (map[int64]bool, error) {
+	active := make(map[int64]bool, len(ids))
+	if len(ids) == 0 {
+		return active, nil
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT id FROM tickets WHERE id = ANY($1::bigint[])`, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		active[id] = true
+	}
+	return active, rows.Err()
+}
+
+// ReleaseReservation puts a dispatcher reservation back to waiting without
+// counting an attempt, since no worker ever saw it. Returns false when the
+// ticket changed since expectedVersion.
+func (r *TicketRepository) ReleaseReservation(ctx context.Context, id, expectedVersion int64) (bool, int64, error) {
+	var newVersion int64
+	err := r.db.QueryRowContext(ctx, `
         UPDATE tickets
         SET status='waiting', updated_at=NOW(), version=version+1
-        WHERE id=$1
-    `, id)
+        WHERE id=$1 AND version=$2 AND status='processing'
+        RETURNING version
+    `, id, expectedVersion).Scan(&newVersion)
+	if err == sql.ErrNoRows {
+		return false, 0, nil
+	}
 	if err != nil {
-		return err
+		return false, 0, err
+	}
+	return true, newVersion, nil
+}
+
+const ticketEventColumns = `id, ticket_id, queue_id, kind, from_status, to_status, from_queue_id,
+    version, attempts, available_at, actor, COALESCE(reason, ''), occurred_at,
+    COALESCE(priority, 0), COALESCE(lane, ''), attributes, group_id, COALESCE(assigned_worker, 0)`
+
+func scanTicketEvent(row rowScanner) (*models.TicketEvent, error) {
+	e := &models.TicketEvent{}
+	err := row.Scan(&e.ID, &e.TicketID, &e.QueueID, &e.Kind, &e.FromStatus, &e.ToStatus, &e.FromQueueID,
+		&e.Version, &e.Attempts, &e.AvailableAt, &e.Actor, &e.Reason, &e.OccurredAt,
+		&e.Priority, &e.Lane, &e.Attributes, &e.GroupID, &e.AssignedWorker)
+	return e, err
+}
+
+// Timeline returns a ticket's state changes in order from the ticket_events
+// log, which the record_ticket_event trigger keeps for active and archived
+// tickets alike. Empty when the ticket never existed.
+func (r *TicketRepository) Timeline(ctx context.Context, ticketID int64) ([]*models.TicketEvent, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT `+ticketEventColumns+`
+        FROM ticket_events
+        WHERE ticket_id=$1
+        ORDER BY id ASC
+    `, ticketID)
+	if err != nil {
+		return nil, err
 	}
-	n, _ := res.RowsAffected()
-	if n == 0 {
-		return errors.New("requeue failed: no rows updated")
+	defer rows.Close()
+
+	timeline := []*models.TicketEvent{}
+	for rows.Next() {
+		e, err := scanTicketEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		timeline = append(timeline, e)
 	}
-	return nil
+	return timeline, rows.Err()
+}
+
+// EventLog returns up to limit ticket_events of a queue recorded in
+// [from, to) with ids above afterID, in log order. Paging on the id keeps
+// pages stable while the log grows.
+func (r *TicketRepository) EventLog(ctx context.Context, queueID int64, from, to time.Time, afterID int64, limit int) ([]*models.TicketEvent, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT `+ticketEventColumns+`
+        FROM ticket_events
+        WHERE queue_id=$1 AND occurred_at >= $2 AND occurred_at < $3 AND id > $4
+        ORDER BY id ASC
+        LIMIT $5
+    `, queueID, from, to, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var page []*models.TicketEvent
+	for rows.Next() {
+		e, err := scanTicketEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		page = append(page, e)
+	}
+	return page, rows.Err()
 }
@@ -0,0 +1,279 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"queue-core/internal/models"
+)
+
+type WebhookRepository struct {
+	db *sql.DB
+}
+
+func NewWebhookRepo(db *sql.DB) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+const subscriptionColumns = `id, url, event_types, queue_ids, secret, enabled, consecutive_failures,
+    disabled_at, COALESCE(disabled_reason, ''), created_at, updated_at`
+
+func scanSubscription(row rowScanner) (*models.WebhookSubscription, error) {
+	s := &models.WebhookSubscription{}
+	var types, queues []byte
+	err := row.Scan(&s.ID, &s.URL, &types, &queues, &s.Secret, &s.Enabled, &s.ConsecutiveFailures,
+		&s.DisabledAt, &s.DisabledReason, &s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(types, &s.EventTypes); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(queues, &s.QueueIDs); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// filters renders the subscription's filters as JSONB args, empty lists
+// rather than null.
+func filters(s *models.WebhookSubscription) (string, string) {
+	if s.EventTypes == nil {
+		s.EventTypes = []string{}
+	}
+	if s.QueueIDs == nil {
+		s.QueueIDs = []int64{}
+	}
+	types, _ := json.Marshal(s.EventTypes)
+	queues, _ := json.Marshal(s.QueueIDs)
+	return string(types), string(queues)
+}
+
+// ListSubscriptions returns every subscription, oldest first.
+func (r *WebhookRepository) ListSubscriptions(ctx context.Context) ([]*models.WebhookSubscription, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+subscriptionColumns+` FROM webhook_subscriptions ORDER BY id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := []*models.WebhookSubscription{}
+	for rows.Next() {
+		s, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, s)
+	}
+	return subs, rows.Err()
+}
+
+// GetSubscription returns a subscription, or (nil, nil) when there is none.
+func (r *WebhookRepository) GetSubscription(ctx context.Context, id int64) (*models.WebhookSubscription, error) {
+	s, err := scanSubscription(r.db.QueryRowContext(ctx, `SELECT `+subscriptionColumns+` FROM webhook_subscriptions WHERE id=$1`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return s, err
+}
+
+// CreateSubscription inserts s, enabled, and sets its id and timestamps.
+func (r *WebhookRepository) CreateSubscription(ctx context.Context, s *models.WebhookSubscription) error {
+	types, queues := filters(s)
+	s.Enabled = true
+	return r.db.QueryRowContext(ctx, `
+        INSERT INTO webhook_subscriptions (url, event_types, queue_ids, secret, created_at, updated_at)
+        VALUES ($1, $2::jsonb, $3::jsonb, $4, NOW(), NOW())
+        RETURNING id, created_at, updated_at
+    `, s.URL, types, queues, s.Secret).Scan(&s.ID, &s.CreatedAt, &s.UpdatedAt)
+}
+
+// UpdateSubscription replaces url, filters and enabled. Enabling clears the
+// failure count and disabled reason. Returns false when there is no such
+// subscription.
+func (r *WebhookRepository) UpdateSubscription(ctx context.Context, s *models.WebhookSubscription) (bool, error) {
+	types, queues := filters(s)
+	updated, err := scanSubscription(r.db.QueryRowContext(ctx, `
+        UPDATE webhook_subscriptions
+        SET url=$2, event_types=$3::jsonb, queue_ids=$4::jsonb, enabled=$5,
+            consecutive_failures = CASE WHEN $5 THEN 0 ELSE consecutive_failures END,
+            disabled_at = CASE WHEN $5 THEN NULL ELSE COALESCE(disabled_at, NOW()) END,
+            disabled_reason = CASE WHEN $5 THEN NULL ELSE disabled_reason END,
+            updated_at=NOW()
+        WHERE id=$1
+        RETURNING `+subscriptionColumns, s.ID, s.URL, types, queues, s.Enabled))
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	*s = *updated
+	return true, nil
+}
+
+// DeleteSubscription removes a subscription and its delivery log.
+func (r *WebhookRepository) DeleteSubscription(ctx context.Context, id int64) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM webhook_subscriptions WHERE id=$1`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// EnqueueDeliveries queues one delivery of body per subscription.
+func (r *WebhookRepository) EnqueueDeliveries(ctx context.Context, subscriptionIDs []int64, eventID, eventType string, body []byte) error {
+	_, err := r.db.ExecContext(ctx, `
+        INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, body, next_attempt_at, created_at)
+        SELECT sub, $2, $3, $4, NOW(), NOW()
+        FROM unnest($1::bigint[]) AS sub
+    `, subscriptionIDs, eventID, eventType, string(body))
+	return err
+}
+
+const deliveryColumns = `d.id, d.subscription_id, d.event_id, d.event_type, d.body, d.status, d.attempts,
+    d.next_attempt_at, d.last_status_code, COALESCE(d.last_error, ''), d.created_at, d.delivered_at`
+
+func scanDelivery(row rowScanner, extra ...any) (*models.WebhookDelivery, error) {
+	d := &models.WebhookDelivery{}
+	var body string
+	dest := []any{&d.ID, &d.SubscriptionID, &d.EventID, &d.EventType, &body, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.DeliveredAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	d.Body = json.RawMessage(body)
+	return d, nil
+}
+
+// ClaimDueDeliveries takes up to limit pending deliveries that are due, for
+// enabled subscriptions, counting the attempt and pushing next_attempt_at
+// out by lease so no other replica sends them meanwhile (and a crashed
+// sender's claims come due again).
+func (r *WebhookRepository) ClaimDueDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*models.WebhookDelivery, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        UPDATE webhook_deliveries d
+        SET attempts = d.attempts + 1, next_attempt_at = NOW() + make_interval(secs => $2)
+        FROM (
+            SELECT wd.id
+            FROM webhook_deliveries wd
+            JOIN webhook_subscriptions ws ON ws.id = wd.subscription_id AND ws.enabled
+            WHERE wd.status = 'pending' AND wd.next_attempt_at <= NOW()
+            ORDER BY wd.next_attempt_at ASC
+            LIMIT $1
+            FOR UPDATE OF wd SKIP LOCKED
+        ) due, webhook_subscriptions s
+        WHERE d.id = due.id AND s.id = d.subscription_id
+        RETURNING `+deliveryColumns+`, s.url, s.secret
+    `, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*models.WebhookDelivery
+	for rows.Next() {
+		var url, secret string
+		d, err := scanDelivery(rows, &url, &secret)
+		if err != nil {
+			return nil, err
+		}
+		d.URL, d.Secret = url, secret
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// MarkDelivered records a successful attempt and resets the subscription's
+// failure count.
+func (r *WebhookRepository) MarkDelivered(ctx context.Context, d *models.WebhookDelivery, statusCode int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+        UPDATE webhook_deliveries
+        SET status='delivered', last_status_code=$2, last_error=NULL, next_attempt_at=NULL, delivered_at=NOW()
+        WHERE id=$1
+    `, d.ID, statusCode); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+        UPDATE webhook_subscriptions SET consecutive_failures=0 WHERE id=$1 AND consecutive_failures <> 0
+    `, d.SubscriptionID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MarkFailed records a failed attempt: retried after retryIn, or failed for
+// good when retryIn is negative. The subscription's failure count goes up
+// and it is disabled once the count reaches disableAfter (0 never
+// disables). Returns whether this failure disabled it.
+func (r *WebhookRepository) MarkFailed(ctx context.Context, d *models.WebhookDelivery, statusCode *int, errMsg string, retryIn time.Duration, disableAfter int) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var retrySecs any // nil gives up
+	if retryIn >= 0 {
+		retrySecs = retryIn.Seconds()
+	}
+	if _, err := tx.ExecContext(ctx, `
+        UPDATE webhook_deliveries
+        SET status = CASE WHEN $4::float8 IS NULL THEN 'failed' ELSE 'pending' END,
+            next_attempt_at = NOW() + make_interval(secs => $4::float8),
+            last_status_code=$2, last_error=$3
+        WHERE id=$1
+    `, d.ID, statusCode, errMsg, retrySecs); err != nil {
+		return false, err
+	}
+	var disabled bool
+	err = tx.QueryRowContext(ctx, `
+        UPDATE webhook_subscriptions
+        SET consecutive_failures = consecutive_failures + 1,
+            enabled = enabled AND NOT ($2 > 0 AND consecutive_failures + 1 >= $2),
+            disabled_at = CASE WHEN enabled AND $2 > 0 AND consecutive_failures + 1 >= $2 THEN NOW() ELSE disabled_at END,
+            disabled_reason = CASE WHEN enabled AND $2 > 0 AND consecutive_failures + 1 >= $2
+                THEN 'disabled after ' || (consecutive_failures + 1) || ' consecutive failures' ELSE disabled_reason END,
+            updated_at=NOW()
+        WHERE id=$1
+        RETURNING NOT enabled AND disabled_at = NOW() -- NOW() is the transaction's time: disabled just now
+    `, d.SubscriptionID, disableAfter).Scan(&disabled)
+	if err != nil {
+		return false, err
+	}
+	return disabled, tx.Commit()
+}
+
+// ListDeliveries returns a subscription's most recent deliveries, newest first.
+func (r *WebhookRepository) ListDeliveries(ctx context.Context, subscriptionID int64, limit int) ([]*models.WebhookDelivery, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT `+deliveryColumns+`
+        FROM webhook_deliveries d
+        WHERE d.subscription_id=$1
+        ORDER BY d.created_at DESC, d.id DESC
+        LIMIT $2
+    `, subscriptionID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []*models.WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,45 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"queue-core/internal/models"
+)
+
+type WorkerRepository struct {
+	db *sql.DB
+}
+
+func NewWorkerRepo(db *sql.DB) *WorkerRepository {
+	return &WorkerRepository{db: db}
+}
+
+// Heartbeat records the worker's current capacity for its queue.
+func (r *WorkerRepository) Heartbeat(ctx context.Context, w *models.Worker) error {
+	return r.db.QueryRowContext(ctx, `
+        INSERT INTO workers (worker_id, queue_id, counter_id, capacity, last_seen_at)
+        VALUES ($1,$2,$3,$4,NOW())
+        ON CONFLICT (worker_id, queue_id) DO UPDATE
+        SET counter_id=EXCLUDED.counter_id, capacity=EXCLUDED.capacity, last_seen_at=NOW()
+        RETURNING last_seen_at
+    `, w.WorkerID, w.QueueID, w.CounterID, w.Capacity).Scan(&w.LastSeenAt)
+}
+
+// Remove takes a worker offline for a queue.
+func (r *WorkerRepository) Remove(ctx context.Context, workerID, queueID int64) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM workers WHERE worker_id=$1 AND queue_id=$2`, workerID, queueID)
+	return err
+}
+
+// Capacity sums the capacity of workers of queueID seen within ttl.
+func (r *WorkerRepository) Capacity(ctx context.Context, queueID int64, ttl time.Duration) (int, error) {
+	var n int
+	err := r.db.QueryRowContext(ctx, `
+        SELECT COALESCE(SUM(capacity), 0)
+        FROM workers
+        WHERE queue_id=$1 AND last_seen_at > NOW() - make_interval(secs => $2)
+    `, queueID, ttl.Seconds()).Scan(&n)
+	return n, err
+}
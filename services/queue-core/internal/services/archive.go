@@ -0,0 +1,74 @@
+package services
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"queue-core/internal/bus"
+	"queue-core/internal/repositories"
+)
+
+// PostgresArchiver keeps trimmed stream entries in the stream_archive table.
+type PostgresArchiver struct {
+	Repo *repositories.StreamArchiveRepository
+}
+
+func (a *PostgresArchiver) Name() string { return "postgres" }
+
+func (a *PostgresArchiver) Archive(ctx context.Context, stream string, entries []bus.Message) error {
+	archived := make([]repositories.ArchivedEntry, len(entries))
+	for i, m := range entries {
+		ms, _ := parseStreamID(m.ID)
+		archived[i] = repositories.ArchivedEntry{ID: m.ID, Time: time.UnixMilli(int64(ms)).UTC(), Fields: m.Values}
+	}
+	return a.Repo.Insert(ctx, stream, archived)
+}
+
+// FileArchiver writes trimmed stream entries as gzipped JSON lines, one
+// file per trimmed page: <Dir>/<stream>/<first id>_<last id>.jsonl.gz.
+// Rewriting a page replaces its file, so archiving is idempotent.
+type FileArchiver struct {
+	Dir string
+}
+
+func (a *FileArchiver) Name() string { return "file" }
+
+func (a *FileArchiver) Archive(ctx context.Context, stream string, entries []bus.Message) error {
+	dir := filepath.Join(a.Dir, strings.ReplaceAll(stream, string(filepath.Separator), "_"))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	name := filepath.Join(dir, entries[0].ID+"_"+entries[len(entries)-1].ID+".jsonl.gz")
+
+	// write aside and rename so a crash never leaves a truncated archive
+	tmp, err := os.CreateTemp(dir, ".archive-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	zw := gzip.NewWriter(tmp)
+	enc := json.NewEncoder(zw)
+	for _, m := range entries {
+		if err := enc.Encode(map[string]interface{}{"id": m.ID, "fields": m.Values}); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
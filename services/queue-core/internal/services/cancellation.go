@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"queue-core/internal/actor"
+	"queue-core/internal/models"
+	"queue-core/pkg/events"
+)
+
+var (
+	ErrTicketNotFound      = errors.New("ticket not found")
+	ErrTicketNotActive     = errors.New("ticket is no longer active")
+	ErrInvalidTicketToken  = errors.New("invalid ticket token")
+	ErrInvalidCancelReason = errors.New("invalid cancellation reason")
+)
+
+// staffCancelReasons and systemCancelReasons are the codes each caller may use.
+var (
+	staffCancelReasons = map[string]bool{
+		models.ReasonNoShow:          true,
+		models.ReasonStaffDecision:   true,
+		models.ReasonDuplicate:       true,
+		models.ReasonCustomerRequest: true,
+	}
+	systemCancelReasons = map[string]bool{
+		models.ReasonSessionClosed:   true,
+		models.ReasonAdmissionFailed: true,
+	}
+)
+
+// CancelTicket cancels an active ticket on behalf of the actor in ctx:
+//   - staff must give one of the staff reason codes,
+//   - the system must give one of the system reason codes,
+//   - anyone else is treated as the customer and must present the ticket
+//     token; their reason is always customer_request.
+func (s *TicketService) CancelTicket(ctx context.Context, id int64, token, reason string) error {
+	t, err := s.Repo.GetByID(ctx, id)
+	if err == sql.ErrNoRows {
+		return ErrTicketNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	a := actor.FromContext(ctx)
+	switch a.Role {
+	case actor.RoleStaff:
+		if !staffCancelReasons[reason] {
+			return ErrInvalidCancelReason
+		}
+	case actor.RoleSystem:
+		if !systemCancelReasons[reason] {
+			return ErrInvalidCancelReason
+		}
+	default:
+		if t.Token == "" || subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) != 1 {
+			return ErrInvalidTicketToken
+		}
+		a.Role = actor.RoleCustomer
+		reason = models.ReasonCustomerRequest
+	}
+
+	ok, newVersion, err := s.Repo.Cancel(actor.WithContext(ctx, a), id, reason, string(a.Role))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrTicketNotActive
+	}
+	s.emitQueueEvent(ctx, t.QueueID, cancelledEvent(t, newVersion, reason, a.Role))
+	return nil
+}
+
+// CloseSession cancels every waiting ticket of a queue as the system, e.g.
+// when the branch closes for the day; the event log names ctx's actor.
+// Returns the number cancelled.
+func (s *TicketService) CloseSession(ctx context.Context, queueID int64) (int, error) {
+	cancelled, err := s.Repo.CancelWaiting(ctx, queueID, models.ReasonSessionClosed, string(actor.RoleSystem))
+	if err != nil {
+		return 0, err
+	}
+	for _, t := range cancelled {
+		s.emitQueueEvent(ctx, queueID, cancelledEvent(t, t.Version, models.ReasonSessionClosed, actor.RoleSystem))
+	}
+	return len(cancelled), nil
+}
+
+// admit re-checks the queue's active-ticket limit for freshly reserved
+// tickets, which may have been issued under a higher limit, and cancels those
+// over it as the system with admission_failed. A customer's earliest tickets
+// keep their place. Returns the tickets that may go to workers.
+func (s *TicketService) admit(ctx context.Context, reserved []*models.Ticket, maxActive int) []*models.Ticket {
+	if maxActive <= 0 {
+		return reserved
+	}
+	admitted := reserved[:0:0]
+	for _, t := range reserved {
+		if t.CustomerID != nil {
+			ahead, err := s.Repo.ActiveAhead(ctx, t)
+			if err != nil {
+				fmt.Printf("admission check error: %v\n", err)
+			} else if ahead >= maxActive {
+				s.failAdmission(ctx, t)
+				continue
+			}
+		}
+		admitted = append(admitted, t)
+	}
+	return admitted
+}
+
+// failAdmission cancels a ticket whose admission failed after it was written.
+func (s *TicketService) failAdmission(ctx context.Context, t *models.Ticket) {
+	ok, newVersion, err := s.Repo.Cancel(actor.WithContext(ctx, actor.System), t.ID, models.ReasonAdmissionFailed, string(actor.RoleSystem))
+	if err != nil {
+		fmt.Printf("admission cancel error: %v\n", err)
+		return
+	}
+	if ok {
+		s.emitQueueEvent(ctx, t.QueueID, cancelledEvent(t, newVersion, models.ReasonAdmissionFailed, actor.RoleSystem))
+	}
+}
+
+// cancelledEvent is the event of t's cancellation at version.
+func cancelledEvent(t *models.Ticket, version int64, reason string, by actor.Role) *events.Envelope {
+	snap := ticketSnapshot(t)
+	snap.Status, snap.Version = string(models.StatusCancelled), version
+	return events.New(t.QueueID, snap, events.Cancelled{Reason: reason, CancelledBy: string(by)})
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"queue-core/internal/metrics"
+	"queue-core/internal/models"
+	"queue-core/internal/repositories"
+)
+
+var (
+	ErrCapacityDisabled = errors.New("capacity tracking not enabled")
+	ErrInvalidCapacity  = errors.New("capacity must not be negative")
+)
+
+// unlimited is the budget of a dispatcher without capacity tracking.
+const unlimited = -1
+
+var (
+	dispatchCapacityAvailable = metrics.Default.NewGauge("queue_dispatch_capacity_available",
+		"Tickets the dispatcher may reserve given worker capacity and stream lag.")
+	dispatchStreamLag = metrics.Default.NewGauge("queue_stream_consumer_lag",
+		"Undelivered entries of the queue stream for its slowest consumer group.")
+)
+
+// CapacityPolicy configures demand-driven dispatch.
+type CapacityPolicy struct {
+	Workers      *repositories.WorkerRepository
+	HeartbeatTTL time.Duration // a worker counts only if seen within this window
+	MaxStreamLag int64         // stop reserving while consumers lag this far behind; 0 disables
+}
+
+// Capacity reports the dispatch budget of a queue: live worker capacity
+// minus tickets already in processing, further capped by consumer lag on the
+// queue stream so a stalled consumer group applies backpressure.
+func (s *TicketService) Capacity(ctx context.Context, queueID int64) (*models.QueueCapacity, error) {
+	c := &models.QueueCapacity{QueueID: queueID}
+	p := s.CapacityPolicy
+	if p == nil || p.Workers == nil {
+		c.Available = unlimited
+		return c, nil
+	}
+
+	var err error
+	if c.Capacity, err = p.Workers.Capacity(ctx, queueID, p.HeartbeatTTL); err != nil {
+		return nil, err
+	}
+	if c.InFlight, err = s.Repo.CountByStatus(ctx, queueID, string(models.StatusProcessing)); err != nil {
+		return nil, err
+	}
+	c.Available = max(c.Capacity-c.InFlight, 0)
+
+	if p.MaxStreamLag > 0 {
+		lag, err := s.streamLag(ctx, queueID)
+		if err != nil {
+			// lag is advisory; a Redis hiccup must not stop dispatch
+			fmt.Printf("stream lag error: %v\n", err)
+		}
+		c.StreamLag = lag
+		c.Available = min(c.Available, int(max(p.MaxStreamLag-lag, 0)))
+	}
+
+	label := strconv.FormatInt(queueID, 10)
+	dispatchCapacityAvailable.Set(float64(c.Available), "queue_id", label)
+	dispatchStreamLag.Set(float64(c.StreamLag), "queue_id", label)
+	return c, nil
+}
+
+// streamLag returns the largest undelivered backlog among the consumer
+// groups of the queue stream. A stream without groups has no lag.
+func (s *TicketService) streamLag(ctx context.Context, queueID int64) (int64, error) {
+	groups, err := s.Bus.Groups(ctx, fmt.Sprintf("%s.%d", s.StreamName, queueID))
+	if err != nil {
+		return 0, err
+	}
+	var lag int64
+	for _, g := range groups {
+		lag = max(lag, g.Lag)
+	}
+	return lag, nil
+}
+
+// WorkerHeartbeat records a worker's advertised capacity.
+func (s *TicketService) WorkerHeartbeat(ctx context.Context, w *models.Worker) error {
+	if s.CapacityPolicy == nil || s.CapacityPolicy.Workers == nil {
+		return ErrCapacityDisabled
+	}
+	if w.Capacity < 0 {
+		return ErrInvalidCapacity
+	}
+	return s.CapacityPolicy.Workers.Heartbeat(ctx, w)
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"queue-core/internal/metrics"
+	"queue-core/internal/models"
+	"queue-core/internal/repositories"
+	"queue-core/pkg/events"
+)
+
+var (
+	ErrLeaseNotHeld = errors.New("ticket is not leased to this worker")
+	ErrInvalidClaim = errors.New("worker_id and a positive lease are required")
+	// ErrPushDispatched rejects claims on a queue its dispatcher serves.
+	ErrPushDispatched = errors.New("queue is dispatched by push; set its dispatch_mode to pull to claim")
+)
+
+// claimPollInterval bounds how long a long-poll claim can miss a ticket
+// whose notification was lost (or when no WakeupSource is configured).
+const claimPollInterval = 2 * time.Second
+
+var (
+	leaseClaims = metrics.Default.NewCounter("queue_lease_claims_total",
+		"Tickets claimed with a lease over the claim API.")
+	leaseExpirations = metrics.Default.NewCounter("queue_lease_expirations_total",
+		"Leased tickets requeued because their lease expired.")
+)
+
+// claimStrategies holds one strategy per queue for the claim API, so stateful
+// strategies (e.g. the call-out pattern) see every claim of the queue.
+type claimStrategies struct {
+	mu     sync.Mutex
+	queues map[int]*claimState
+}
+
+type claimState struct {
+	mu sync.Mutex // serializes Next on the shared strategy
+	strategyState
+}
+
+func (c *claimStrategies) get(queueID int) *claimState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.queues == nil {
+		c.queues = make(map[int]*claimState)
+	}
+	st, ok := c.queues[queueID]
+	if !ok {
+		st = &claimState{}
+		c.queues[queueID] = st
+	}
+	return st
+}
+
+// queueReserver applies queue-level reservation settings (a claim lease,
+// strict order) to every reservation a strategy makes.
+type queueReserver struct {
+	Reserver
+	workerID int64
+	lease    time.Duration
+	strict   bool
+}
+
+func (r queueReserver) Reserve(ctx context.Context, queueID int, opts repositories.ReserveOptions) (*models.Ticket, error) {
+	opts.WorkerID, opts.Lease, opts.Strict = r.workerID, r.lease, r.strict
+	return r.Reserver.Reserve(ctx, queueID, opts)
+}
+
+// ClaimTicket reserves the next ticket of a queue for a pull-based worker,
+// leased to workerID for lease. If none is waiting it blocks up to wait for
+// one to arrive; a nil ticket means wait elapsed with nothing to claim.
+// Claims go through the queue's dispatch strategy like pushed reservations,
+// and only for queues whose dispatch_mode is pull.
+func (s *TicketService) ClaimTicket(ctx context.Context, queueID int, workerID int64, lease, wait time.Duration) (*models.Ticket, error) {
+	if workerID <= 0 || lease <= 0 {
+		return nil, ErrInvalidClaim
+	}
+	var wake <-chan struct{} // nil channel: never fires
+	if s.Wakeups != nil {
+		ch, unsubscribe := s.Wakeups.Subscribe(int64(queueID))
+		defer unsubscribe()
+		wake = ch
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		t, err := s.claimOnce(ctx, queueID, workerID, lease)
+		if err != nil || t != nil {
+			return t, err
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, nil
+		}
+		timer := time.NewTimer(min(remaining, claimPollInterval))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *TicketService) claimOnce(ctx context.Context, queueID int, workerID int64, lease time.Duration) (*models.Ticket, error) {
+	st := s.claims.get(queueID)
+	st.mu.Lock()
+	if err := s.refreshStrategy(ctx, queueID, &st.strategyState); err != nil {
+		fmt.Printf("dispatch strategy error: %v\n", err)
+	}
+	if st.strategy == nil {
+		st.strategy = FIFOStrategy{}
+	}
+	if !st.pull {
+		st.mu.Unlock()
+		return nil, ErrPushDispatched
+	}
+	t, err := st.strategy.Next(ctx, queueReserver{Reserver: s.Repo, workerID: workerID, lease: lease, strict: st.strict}, queueID)
+	maxActive := st.maxActive
+	st.mu.Unlock()
+	if err != nil || t == nil {
+		return nil, err
+	}
+	if len(s.admit(ctx, []*models.Ticket{t}, maxActive)) == 0 {
+		// cancelled as over its customer's limit; claim the next one
+		return s.claimOnce(ctx, queueID, workerID, lease)
+	}
+
+	qidLabel := strconv.Itoa(queueID)
+	dispatchLatency.Observe(time.Since(t.UpdatedAt).Seconds(), "queue_id", qidLabel)
+	leaseClaims.Inc("queue_id", qidLabel)
+
+	t.Status = models.StatusProcessing
+	t.Version++ // Reserve returns the pre-update version
+	// claimed tickets are not stream work, so only tell the frontends
+	s.broadcast(ctx, t.QueueID, events.New(t.QueueID, ticketSnapshot(t),
+		events.Claimed{WorkerID: workerID, LeaseExpiresAt: t.LeaseExpiresAt.UTC()}))
+	return t, nil
+}
+
+// AckTicket completes a ticket claimed by workerID.
+func (s *TicketService) AckTicket(ctx context.Context, id, workerID int64) error {
+	t, err := s.Repo.GetByID(ctx, id)
+	if err == sql.ErrNoRows {
+		return ErrTicketNotFound
+	}
+	if err != nil {
+		return err
+	}
+	ok, newVersion, err := s.Repo.AckLease(ctx, id, workerID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrLeaseNotHeld
+	}
+	snap := ticketSnapshot(t)
+	snap.Status, snap.Version = string(models.StatusDone), newVersion
+	s.emitQueueEvent(ctx, t.QueueID, events.New(t.QueueID, snap, events.Completed{WorkerID: &workerID}))
+	return nil
+}
+
+// NackTicket gives a ticket claimed by workerID back to its queue, which
+// counts as a failed attempt under the retry policy.
+func (s *TicketService) NackTicket(ctx context.Context, id, workerID int64) error {
+	_, err := s.Repo.GetByID(ctx, id)
+	if err == sql.ErrNoRows {
+		return ErrTicketNotFound
+	}
+	if err != nil {
+		return err
+	}
+	requeued, err := s.Repo.NackLease(ctx, id, workerID, s.Retry)
+	if err != nil {
+		return err
+	}
+	if requeued == nil {
+		return ErrLeaseNotHeld
+	}
+	s.emitRequeued(ctx, requeued, "nack")
+	return nil
+}
+
+// ExtendLease renews the lease workerID holds on a ticket for another lease
+// from now and returns the new expiry.
+func (s *TicketService) ExtendLease(ctx context.Context, id, workerID int64, lease time.Duration) (time.Time, error) {
+	if workerID <= 0 || lease <= 0 {
+		return time.Time{}, ErrInvalidClaim
+	}
+	ok, expires, err := s.Repo.ExtendLease(ctx, id, workerID, lease)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !ok {
+		return time.Time{}, ErrLeaseNotHeld
+	}
+	return expires, nil
+}
+
+// StartLeaseReaper periodically requeues tickets whose lease expired, so a
+// crashed pull worker cannot hold tickets forever. Every replica may run
+// one; the requeue is a single idempotent statement.
+func (s *TicketService) StartLeaseReaper(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if _, err := s.ReapExpiredLeases(ctx); err != nil {
+				fmt.Printf("lease reaper error: %v\n", err)
+			}
+		}
+	}()
+}
+
+// ReapExpiredLeases runs one reaper pass and returns the requeued tickets.
+func (s *TicketService) ReapExpiredLeases(ctx context.Context) ([]*models.Ticket, error) {
+	tickets, err := s.Repo.RequeueExpiredLeases(ctx, s.Retry)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tickets {
+		leaseExpirations.Inc("queue_id", strconv.FormatInt(t.QueueID, 10))
+		s.emitRequeued(ctx, t, "lease_expired")
+	}
+	return tickets, nil
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"queue-core/internal/models"
+	"queue-core/internal/repositories"
+)
+
+// ErrCustomerIdentityRequired is returned when a customer carries no phone,
+// email or external id to be recognised by on a later visit.
+var ErrCustomerIdentityRequired = errors.New("customer requires phone, email or external_id")
+
+type CustomerService struct {
+	Repo *repositories.CustomerRepository
+}
+
+func NewCustomerService(repo *repositories.CustomerRepository) *CustomerService {
+	return &CustomerService{Repo: repo}
+}
+
+// Resolve returns the existing customer matching any identity key of c,
+// creating one when none matches.
+func (s *CustomerService) Resolve(ctx context.Context, c *models.Customer) (*models.Customer, error) {
+	if !c.HasIdentity() {
+		return nil, ErrCustomerIdentityRequired
+	}
+	existing, err := s.Repo.FindByIdentity(ctx, c.Phone, c.Email, c.ExternalID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return existing, nil
+	}
+	if err := s.Repo.Create(ctx, c); err != nil {
+		// a concurrent join may have created the same customer; prefer theirs
+		if existing, ferr := s.Repo.FindByIdentity(ctx, c.Phone, c.Email, c.ExternalID); ferr == nil && existing != nil {
+			return existing, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// History lists the customer's past visits, newest first.
+func (s *CustomerService) History(ctx context.Context, customerID int64, limit int) ([]*models.Visit, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 100
+	}
+	return s.Repo.ListVisits(ctx, customerID, limit)
+}
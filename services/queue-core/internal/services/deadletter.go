@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+
+	"queue-core/internal/metrics"
+	"queue-core/internal/models"
+	"queue-core/pkg/events"
+)
+
+var (
+	ErrNotDeadLettered   = errors.New("ticket is not dead-lettered")
+	ErrTicketNotReserved = errors.New("ticket is not reserved by the dispatcher")
+)
+
+var deadLettered = metrics.Default.NewCounter("queue_dead_lettered_total",
+	"Tickets moved to dead_letter after exhausting their attempts.")
+
+// RequeueTicket reports a failed delivery of a reserved ticket: it goes back
+// to waiting after a backoff, or to dead_letter once out of attempts.
+func (s *TicketService) RequeueTicket(ctx context.Context, id int64, reason string) (*models.Ticket, error) {
+	t, err := s.Repo.RequeueToWaiting(ctx, id, s.Retry)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		if _, err := s.Repo.GetByID(ctx, id); err == sql.ErrNoRows {
+			return nil, ErrTicketNotFound
+		} else if err != nil {
+			return nil, err
+		}
+		return nil, ErrTicketNotReserved
+	}
+	s.emitRequeued(ctx, t, reason)
+	return t, nil
+}
+
+// emitRequeued announces the outcome of a failed delivery, as returned by
+// the repository's requeue methods.
+func (s *TicketService) emitRequeued(ctx context.Context, t *models.Ticket, reason string) {
+	var p events.Payload = events.Requeued{Reason: reason, AvailableAt: t.AvailableAt}
+	if t.Status == models.StatusDeadLetter {
+		p = events.DeadLettered{Reason: reason}
+		deadLettered.Inc("queue_id", strconv.FormatInt(t.QueueID, 10))
+	}
+	s.emitQueueEvent(ctx, t.QueueID, events.New(t.QueueID, ticketSnapshot(t), p))
+}
+
+// ListDeadLetters returns the dead-lettered tickets of a queue, oldest first.
+func (s *TicketService) ListDeadLetters(ctx context.Context, queueID int) ([]*models.Ticket, error) {
+	return s.Repo.ListByStatus(ctx, queueID, string(models.StatusDeadLetter), nil)
+}
+
+// RetryDeadLetter puts a dead-lettered ticket back in its queue with a fresh
+// attempt count, at its original position.
+func (s *TicketService) RetryDeadLetter(ctx context.Context, id int64) (*models.Ticket, error) {
+	t, err := s.Repo.RetryDeadLetter(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, ErrNotDeadLettered
+	}
+	s.emitRequeued(ctx, t, "retry")
+	return t, nil
+}
+
+// DiscardDeadLetter drops a dead-lettered ticket into history as discarded.
+func (s *TicketService) DiscardDeadLetter(ctx context.Context, id int64) error {
+	t, err := s.Repo.DiscardDeadLetter(ctx, id)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return ErrNotDeadLettered
+	}
+	s.emitQueueEvent(ctx, t.QueueID, events.New(t.QueueID, ticketSnapshot(t), events.Discarded{}))
+	return nil
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"queue-core/internal/models"
+	"queue-core/internal/repositories"
+)
+
+var (
+	ErrInvalidRating        = errors.New("rating must be between 1 and 5")
+	ErrFeedbackTokenUnknown = errors.New("no completed ticket for this token")
+	ErrFeedbackWindowClosed = errors.New("feedback window has closed")
+	ErrFeedbackAlreadyGiven = errors.New("feedback already submitted for this ticket")
+)
+
+const (
+	DefaultFeedbackWindow    = 24 * time.Hour
+	maxFeedbackCommentLength = 2000
+)
+
+type FeedbackService struct {
+	Repo   *repositories.FeedbackRepository
+	Window time.Duration // how long after completion feedback is accepted
+}
+
+func NewFeedbackService(repo *repositories.FeedbackRepository) *FeedbackService {
+	return &FeedbackService{Repo: repo, Window: DefaultFeedbackWindow}
+}
+
+// Submit records the customer's rating for the ticket their token belongs to,
+// attributing it to the counter and staff member that served the ticket.
+func (s *FeedbackService) Submit(ctx context.Context, token string, rating int, comment string) (*models.Feedback, error) {
+	if rating < 1 || rating > 5 {
+		return nil, ErrInvalidRating
+	}
+	if len(comment) > maxFeedbackCommentLength {
+		comment = comment[:maxFeedbackCommentLength]
+	}
+
+	served, err := s.Repo.FindServedByToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if served == nil || token == "" {
+		return nil, ErrFeedbackTokenUnknown
+	}
+	if time.Since(served.ArchivedAt) > s.Window {
+		return nil, ErrFeedbackWindowClosed
+	}
+
+	f := &models.Feedback{
+		TicketID:  served.TicketID,
+		QueueID:   served.QueueID,
+		CounterID: served.CounterID,
+		StaffID:   served.AssignedWorker,
+		Rating:    rating,
+		Comment:   comment,
+	}
+	created, err := s.Repo.Create(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		return nil, ErrFeedbackAlreadyGiven
+	}
+	return f, nil
+}
+
+// Summary aggregates ratings per queue, counter and day.
+func (s *FeedbackService) Summary(ctx context.Context, queueID int64, from, to time.Time) ([]*models.FeedbackSummary, error) {
+	return s.Repo.Summarize(ctx, queueID, from, to)
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"queue-core/internal/models"
+)
+
+// ValidationError reports which intake fields were rejected and why.
+type ValidationError struct {
+	Fields map[string]string `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	names := make([]string, 0, len(e.Fields))
+	for name := range e.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, name+": "+e.Fields[name])
+	}
+	return "invalid attributes: " + strings.Join(parts, "; ")
+}
+
+// ValidateAttributes checks attrs against a queue's intake schema. Fields not
+// in the schema are rejected so typos do not silently drop data. An empty
+// schema accepts any attributes.
+func ValidateAttributes(fields []models.IntakeField, attrs models.Attributes) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	errs := map[string]string{}
+	known := make(map[string]bool, len(fields))
+
+	for _, f := range fields {
+		known[f.Name] = true
+		v, ok := attrs[f.Name]
+		if !ok || v == nil {
+			if f.Required {
+				errs[f.Name] = "is required"
+			}
+			continue
+		}
+		if msg := checkFieldValue(f, v); msg != "" {
+			errs[f.Name] = msg
+		}
+	}
+	for name := range attrs {
+		if !known[name] {
+			errs[name] = "is not a field of this queue"
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Fields: errs}
+	}
+	return nil
+}
+
+func checkFieldValue(f models.IntakeField, v interface{}) string {
+	switch f.Type {
+	case models.FieldString:
+		s, ok := v.(string)
+		if !ok {
+			return "must be a string"
+		}
+		if f.Required && strings.TrimSpace(s) == "" {
+			return "is required"
+		}
+	case models.FieldNumber:
+		if _, ok := v.(float64); !ok {
+			return "must be a number"
+		}
+	case models.FieldBoolean:
+		if _, ok := v.(bool); !ok {
+			return "must be a boolean"
+		}
+	case models.FieldEnum:
+		s, ok := v.(string)
+		if !ok {
+			return "must be a string"
+		}
+		for _, opt := range f.Options {
+			if s == opt {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of %s", strings.Join(f.Options, ", "))
+	}
+	return ""
+}
+
+// ValidateIntakeSchema checks an intake schema before it is stored.
+func ValidateIntakeSchema(fields []models.IntakeField) error {
+	errs := map[string]string{}
+	seen := map[string]bool{}
+	for _, f := range fields {
+		switch {
+		case f.Name == "":
+			errs["name"] = "fields need a name"
+		case seen[f.Name]:
+			errs[f.Name] = "is declared twice"
+		case f.Type != models.FieldString && f.Type != models.FieldNumber &&
+			f.Type != models.FieldBoolean && f.Type != models.FieldEnum:
+			errs[f.Name] = "has an unknown type"
+		case f.Type == models.FieldEnum && len(f.Options) == 0:
+			errs[f.Name] = "enum fields need options"
+		}
+		seen[f.Name] = true
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Fields: errs}
+	}
+	return nil
+}
+
+// validateIntake loads the queue's schema and validates the ticket against it.
+func (s *TicketService) validateIntake(ctx context.Context, ticket *models.Ticket) error {
+	if s.Queues == nil {
+		return nil
+	}
+	fields, err := s.Queues.ListIntakeFields(ctx, ticket.QueueID)
+	if err != nil {
+		return err
+	}
+	return ValidateAttributes(fields, ticket.Attributes)
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"queue-core/internal/metrics"
+	"queue-core/internal/repositories"
+)
+
+var (
+	dispatchLeader = metrics.Default.NewGauge("queue_dispatch_leader",
+		"1 when this replica leads the queue's dispatcher.")
+	dispatchLeadershipChanges = metrics.Default.NewCounter("queue_dispatch_leadership_changes_total",
+		"Leadership acquisitions and releases by this replica.")
+)
+
+// DispatchElector makes sure exactly one replica dispatches each queue.
+// Leadership is a Postgres advisory lock per queue; replicas heartbeat so
+// each leads at most ceil(queues / live replicas) queues, which spreads
+// queues across replicas and hands queues over when a replica joins.
+type DispatchElector struct {
+	Leases   *repositories.LeaderRepository
+	NodeID   string
+	QueueIDs func(ctx context.Context) ([]int64, error) // queues that need a dispatcher
+
+	Interval         time.Duration // election / heartbeat period; failover happens within one
+	DispatchInterval time.Duration // fallback poll interval handed to dispatchers
+
+	// StartDispatcher runs a dispatcher until its ctx is cancelled and
+	// returns a channel that is closed once the dispatcher has stopped.
+	StartDispatcher func(ctx context.Context, queueID int, interval time.Duration) <-chan struct{}
+
+	mu      sync.Mutex
+	leading map[int64]*dispatcher
+}
+
+// dispatcher is a running dispatcher this replica leads.
+type dispatcher struct {
+	cancel context.CancelFunc
+	done   <-chan struct{}
+}
+
+// stop cancels the dispatcher and waits until it no longer touches the queue.
+func (d *dispatcher) stop() {
+	d.cancel()
+	<-d.done
+}
+
+func NewDispatchElector(leases *repositories.LeaderRepository, svc *TicketService, nodeID string) *DispatchElector {
+	return &DispatchElector{
+		Leases:           leases,
+		NodeID:           nodeID,
+		Interval:         2 * time.Second,
+		DispatchInterval: 30 * time.Second,
+		StartDispatcher:  svc.StartDispatcher,
+		leading:          map[int64]*dispatcher{},
+	}
+}
+
+// Run elects until ctx is done, then releases every queue and deregisters.
+func (e *DispatchElector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.Interval)
+	defer ticker.Stop()
+	defer func() {
+		e.stopAll()
+		e.Leases.Close()
+		_ = e.Leases.Deregister(context.Background(), e.NodeID)
+	}()
+
+	for {
+		if err := e.Tick(ctx); err != nil && ctx.Err() == nil {
+			fmt.Printf("dispatch election error: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick runs one election round: heartbeat, verify the lock session, shed
+// queues above the fair share and try to lead queues below it.
+func (e *DispatchElector) Tick(ctx context.Context) error {
+	if err := e.Leases.Ping(ctx); err != nil {
+		// the session (and every lock on it) is gone: stop dispatching first
+		e.stopAll()
+		if err := e.Leases.Connect(ctx); err != nil {
+			return err
+		}
+	}
+	if err := e.Leases.Heartbeat(ctx, e.NodeID); err != nil {
+		return err
+	}
+	nodes, err := e.Leases.LiveNodes(ctx, 3*e.Interval)
+	if err != nil {
+		return err
+	}
+	queues, err := e.QueueIDs(ctx)
+	if err != nil {
+		return err
+	}
+	share := fairShare(len(queues), nodes)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// drop queues that no longer exist, then shed the highest ids above share
+	wanted := make(map[int64]bool, len(queues))
+	for _, q := range queues {
+		wanted[q] = true
+	}
+	held := make([]int64, 0, len(e.leading))
+	for q := range e.leading {
+		held = append(held, q)
+	}
+	sort.Slice(held, func(i, j int) bool { return held[i] < held[j] })
+	for i := len(held) - 1; i >= 0; i-- {
+		q := held[i]
+		if wanted[q] && len(e.leading) <= share {
+			continue
+		}
+		e.release(ctx, q)
+	}
+
+	for _, q := range queues {
+		if len(e.leading) >= share {
+			break
+		}
+		if _, ok := e.leading[q]; ok {
+			continue
+		}
+		ok, err := e.Leases.TryAcquire(ctx, q)
+		if err != nil {
+			return err
+		}
+		if ok {
+			e.lead(ctx, q)
+		}
+	}
+	return nil
+}
+
+// Leading returns the queues this replica currently dispatches.
+func (e *DispatchElector) Leading() []int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ids := make([]int64, 0, len(e.leading))
+	for q := range e.leading {
+		ids = append(ids, q)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// lead starts the dispatcher for q; caller holds e.mu.
+func (e *DispatchElector) lead(ctx context.Context, q int64) {
+	dctx, cancel := context.WithCancel(ctx)
+	done := e.StartDispatcher(dctx, int(q), e.DispatchInterval)
+	e.leading[q] = &dispatcher{cancel: cancel, done: done}
+	label := strconv.FormatInt(q, 10)
+	dispatchLeader.Set(1, "queue_id", label)
+	dispatchLeadershipChanges.Inc("queue_id", label, "change", "acquired")
+}
+
+// release waits for the dispatcher for q to exit before unlocking, so the
+// next leader never overlaps with it; caller holds e.mu.
+func (e *DispatchElector) release(ctx context.Context, q int64) {
+	e.leading[q].stop()
+	delete(e.leading, q)
+	_ = e.Leases.Release(ctx, q)
+	label := strconv.FormatInt(q, 10)
+	dispatchLeader.Set(0, "queue_id", label)
+	dispatchLeadershipChanges.Inc("queue_id", label, "change", "released")
+}
+
+// stopAll stops every dispatcher and waits for all of them to exit.
+func (e *DispatchElector) stopAll() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, d := range e.leading {
+		d.cancel()
+	}
+	for q, d := range e.leading {
+		<-d.done
+		delete(e.leading, q)
+		dispatchLeader.Set(0, "queue_id", strconv.FormatInt(q, 10))
+	}
+}
+
+// fairShare is ceil(queues / nodes), counting this node at least.
+func fairShare(queues, nodes int) int {
+	if nodes < 1 {
+		nodes = 1
+	}
+	return (queues + nodes - 1) / nodes
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"queue-core/internal/actor"
+	"queue-core/internal/models"
+)
+
+var (
+	ErrUnknownPriorityClass   = errors.New("unknown priority class")
+	ErrPriorityClassForbidden = errors.New("priority class cannot be assigned by this caller")
+	ErrInvalidPriorityPolicy  = errors.New("invalid priority policy")
+)
+
+// privileged roles may assign any priority class or raw priority.
+func privileged(role actor.Role) bool {
+	return role == actor.RoleStaff || role == actor.RoleSystem
+}
+
+// canAssign reports whether role may hand out class c. Staff may assign
+// every class; everyone else only classes marked assignable by kiosk.
+func canAssign(c models.PriorityClass, role actor.Role) bool {
+	return privileged(role) || c.AssignableBy == "kiosk"
+}
+
+// applyPriorityClass resolves ticket.PriorityClass against the queue policy
+// and overwrites Priority and Lane from it. Without a class, only staff keep
+// a caller-supplied raw priority on queues that define classes.
+func (s *TicketService) applyPriorityClass(ctx context.Context, ticket *models.Ticket) error {
+	if s.Queues == nil {
+		return nil
+	}
+	policy, err := s.Queues.GetPriorityPolicy(ctx, ticket.QueueID)
+	if err != nil {
+		return err
+	}
+	role := actor.FromContext(ctx).Role
+
+	if ticket.PriorityClass == "" {
+		if len(policy.Classes) > 0 && !privileged(role) {
+			ticket.Priority = 1
+		}
+		ticket.Lane = models.DefaultLane
+		return nil
+	}
+
+	for _, c := range policy.Classes {
+		if c.Name != ticket.PriorityClass {
+			continue
+		}
+		if !canAssign(c, role) {
+			return ErrPriorityClassForbidden
+		}
+		ticket.Priority = c.Priority
+		ticket.Lane = c.Lane
+		return nil
+	}
+	return ErrUnknownPriorityClass
+}
+
+// ValidatePriorityPolicy checks a policy before it is stored.
+func ValidatePriorityPolicy(p *models.PriorityPolicy) error {
+	seen := map[string]bool{}
+	for i := range p.Classes {
+		c := &p.Classes[i]
+		if c.Name == "" || seen[c.Name] {
+			return fmt.Errorf("%w: class names must be unique and non-empty", ErrInvalidPriorityPolicy)
+		}
+		seen[c.Name] = true
+		if c.Lane == "" {
+			c.Lane = models.DefaultLane
+		}
+		if c.AssignableBy == "" {
+			c.AssignableBy = "staff"
+		}
+		if c.AssignableBy != "kiosk" && c.AssignableBy != "staff" {
+			return fmt.Errorf("%w: assignable_by must be kiosk or staff", ErrInvalidPriorityPolicy)
+		}
+	}
+	lanes := map[string]bool{}
+	for _, l := range p.Lanes {
+		if l.Name == "" || l.Weight <= 0 || lanes[l.Name] {
+			return fmt.Errorf("%w: lanes need a unique name and a positive weight", ErrInvalidPriorityPolicy)
+		}
+		lanes[l.Name] = true
+	}
+	return nil
+}
+
+// LaneScheduler implements the call-out pattern with smooth weighted round
+// robin: over any window of sum(weights) calls each lane is called in
+// proportion to its weight, and calls are spread rather than bunched.
+type LaneScheduler struct {
+	mu      sync.Mutex
+	weights map[string]int
+	current map[string]int
+}
+
+func NewLaneScheduler() *LaneScheduler {
+	return &LaneScheduler{weights: map[string]int{}, current: map[string]int{}}
+}
+
+// SetWeights replaces the lane weights, keeping accumulated credit for lanes
+// that survive so a policy reload does not reset the pattern.
+func (l *LaneScheduler) SetWeights(lanes []models.Lane) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	weights := make(map[string]int, len(lanes))
+	current := make(map[string]int, len(lanes))
+	for _, ln := range lanes {
+		weights[ln.Name] = ln.Weight
+		current[ln.Name] = l.current[ln.Name]
+	}
+	l.weights, l.current = weights, current
+}
+
+// Empty reports whether no lanes are configured.
+func (l *LaneScheduler) Empty() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.weights) == 0
+}
+
+// Order returns the lanes by preference for the next call.
+func (l *LaneScheduler) Order() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lanes := make([]string, 0, len(l.weights))
+	for name := range l.weights {
+		lanes = append(lanes, name)
+	}
+	sort.Slice(lanes, func(i, j int) bool {
+		si := l.current[lanes[i]] + l.weights[lanes[i]]
+		sj := l.current[lanes[j]] + l.weights[lanes[j]]
+		if si != sj {
+			return si > sj
+		}
+		return lanes[i] < lanes[j]
+	})
+	return lanes
+}
+
+// Served records that a ticket of lane was called. A lane that is not
+// configured does not advance the pattern.
+func (l *LaneScheduler) Served(lane string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.weights[lane]; !ok {
+		return
+	}
+	total := 0
+	for name, w := range l.weights {
+		l.current[name] += w
+		total += w
+	}
+	l.current[lane] -= total
+}
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"queue-core/internal/metrics"
+	"queue-core/internal/models"
+	"queue-core/pkg/events"
+)
+
+// MissingEntryAction is what the reconciler does with a dispatcher
+// reservation whose ticket.reserved stream entry never reached Redis. The
+// zero value reports only.
+type MissingEntryAction string
+
+const (
+	MissingEntryReport  MissingEntryAction = "report"  // count it only
+	MissingEntryReemit  MissingEntryAction = "reemit"  // append the entry again
+	MissingEntryRequeue MissingEntryAction = "requeue" // put the ticket back to waiting
+)
+
+// reconcilePageSize bounds each stream read of a reconciler pass; pending
+// entries beyond one page per group wait for the next pass.
+const reconcilePageSize = 500
+
+var (
+	reconcileDivergence = metrics.Default.NewGauge("queue_reconcile_divergence",
+		"Divergences between Postgres and the queue stream found by the last reconciler pass.")
+	reconcileRepairs = metrics.Default.NewCounter("queue_reconcile_repairs_total",
+		"Divergences repaired by the reconciler, by action.")
+)
+
+// ReconcilePolicy configures the reconciler a dispatcher runs for its queue.
+// Redis writes are best-effort, so a reserved ticket can lack its stream
+// entry and a pending entry can outlive its archived ticket.
+type ReconcilePolicy struct {
+	Interval     time.Duration
+	Grace        time.Duration // younger reservations and idle entries are skipped; their other half may be in flight
+	Window       time.Duration // older reservations are no longer checked against the stream
+	MissingEntry MissingEntryAction
+	AckOrphans   bool // ack pending entries whose ticket was archived or whose entry was trimmed
+}
+
+// Valid reports whether a is a known action.
+func (a MissingEntryAction) Valid() bool {
+	switch a {
+	case "", MissingEntryReport, MissingEntryReemit, MissingEntryRequeue:
+		return true
+	}
+	return false
+}
+
+// ReconcileReport is the outcome of one reconciler pass over a queue.
+type ReconcileReport struct {
+	QueueID        int64 `json:"queue_id"`
+	MissingEntries int   `json:"missing_entries"`
+	Reemitted      int   `json:"reemitted"`
+	Requeued       int   `json:"requeued"`
+	Orphans        int   `json:"orphans"`
+	Acked          int   `json:"acked"`
+}
+
+// runReconciler reconciles queueID every policy interval until ctx is done.
+// It runs under the dispatcher so only the queue's leader repairs it.
+func (s *TicketService) runReconciler(ctx context.Context, queueID int64) {
+	ticker := time.NewTicker(s.Reconcile.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := s.ReconcileQueue(ctx, queueID); err != nil && ctx.Err() == nil {
+			fmt.Printf("reconcile error: %v\n", err)
+		}
+	}
+}
+
+// ReconcileQueue compares the queue's reservations and stream contents once
+// and repairs what the policy allows. Every repair is announced as a
+// ticket.reconciled event.
+func (s *TicketService) ReconcileQueue(ctx context.Context, queueID int64) (*ReconcileReport, error) {
+	p := s.Reconcile
+	if p == nil {
+		p = &ReconcilePolicy{}
+	}
+	report := &ReconcileReport{QueueID: queueID}
+	streamKey := fmt.Sprintf("%s.%d", s.StreamName, queueID)
+
+	var repairs []*events.Envelope
+	missing, err := s.missingEntries(ctx, streamKey, queueID, p)
+	if err != nil {
+		return nil, err
+	}
+	report.MissingEntries = len(missing)
+	for _, t := range missing {
+		switch p.MissingEntry {
+		case MissingEntryReemit:
+			event := reservedEvent(t)
+			event.Ticket.Version = t.Version // already the post-reservation version
+			event.Payload = events.Reserved{Redelivery: true}
+			repairs = append(repairs, event, events.New(queueID, ticketSnapshot(t), events.Reconciled{Action: "reemitted", Reason: "missing_entry"}))
+			report.Reemitted++
+		case MissingEntryRequeue:
+			ok, newVersion, err := s.Repo.ReleaseReservation(ctx, t.ID, t.Version)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue // completed or cancelled meanwhile
+			}
+			snap := ticketSnapshot(t)
+			snap.Status, snap.Version = string(models.StatusWaiting), newVersion
+			repairs = append(repairs, events.New(queueID, snap, events.Reconciled{Action: "requeued", Reason: "missing_entry"}))
+			report.Requeued++
+		}
+	}
+
+	if err := s.ackOrphans(ctx, streamKey, queueID, p, report, &repairs); err != nil {
+		return nil, err
+	}
+
+	label := strconv.FormatInt(queueID, 10)
+	reconcileDivergence.Set(float64(report.MissingEntries), "queue_id", label, "kind", "missing_entry")
+	reconcileDivergence.Set(float64(report.Orphans), "queue_id", label, "kind", "orphan")
+	for action, n := range map[string]int{"reemitted": report.Reemitted, "requeued": report.Requeued, "acked": report.Acked} {
+		if n > 0 {
+			reconcileRepairs.Add(float64(n), "queue_id", label, "action", action)
+		}
+	}
+	if len(repairs) > 0 {
+		s.emitQueueEvents(ctx, queueID, repairs)
+	}
+	return report, nil
+}
+
+// missingEntries returns the dispatcher reservations in the policy window
+// that have no ticket.reserved entry for their current version.
+func (s *TicketService) missingEntries(ctx context.Context, streamKey string, queueID int64, p *ReconcilePolicy) ([]*models.Ticket, error) {
+	if p.Window <= p.Grace {
+		return nil, nil
+	}
+	tickets, err := s.Repo.UnleasedReservations(ctx, queueID, p.Grace, p.Window)
+	if err != nil || len(tickets) == 0 {
+		return nil, err
+	}
+
+	// entries are appended after the reservation commits; the grace
+	// absorbs clock skew between Postgres and Redis
+	start := strconv.FormatInt(tickets[0].UpdatedAt.Add(-p.Grace).UnixMilli(), 10)
+	seen := map[string]bool{}
+	for {
+		entries, err := s.Bus.Range(ctx, streamKey, start, "+", reconcilePageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			e, err := events.FromFields(entry.Values)
+			if err != nil || e.Type != events.TicketReserved || e.Ticket == nil {
+				continue
+			}
+			seen[fmt.Sprintf("%d/%d", e.Ticket.ID, e.Ticket.Version)] = true
+		}
+		if len(entries) < reconcilePageSize {
+			break
+		}
+		start = "(" + entries[len(entries)-1].ID
+	}
+
+	var missing []*models.Ticket
+	for _, t := range tickets {
+		if !seen[fmt.Sprintf("%d/%d", t.ID, t.Version)] {
+			missing = append(missing, t)
+		}
+	}
+	return missing, nil
+}
+
+// ackOrphans finds pending entries, idle past the grace, whose ticket has
+// been archived or whose entry has been trimmed, and acks them when the
+// policy allows so consumer groups stop redelivering them.
+func (s *TicketService) ackOrphans(ctx context.Context, streamKey string, queueID int64, p *ReconcilePolicy, report *ReconcileReport, repairs *[]*events.Envelope) error {
+	groups, err := s.Bus.Groups(ctx, streamKey)
+	if err != nil {
+		return err
+	}
+
+	for _, g := range groups {
+		if g.Pending == 0 {
+			continue
+		}
+		pending, err := s.Bus.Pending(ctx, streamKey, g.Name, p.Grace, reconcilePageSize)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			continue
+		}
+
+		pendingIDs := make([]string, len(pending))
+		for i, pe := range pending {
+			pendingIDs[i] = pe.ID
+		}
+		entries, err := s.Bus.Entries(ctx, streamKey, pendingIDs)
+		if err != nil {
+			return err
+		}
+		ticketOf := make(map[string]int64, len(pending)) // entry id -> ticket id; 0 once trimmed
+		var ids []int64
+		for i, pe := range pending {
+			if entries[i] == nil {
+				ticketOf[pe.ID] = 0
+				continue
+			}
+			e, err := events.FromFields(entries[i].Values)
+			if err != nil || e.TicketID() == 0 {
+				continue // not about a ticket
+			}
+			ticketOf[pe.ID] = e.TicketID()
+			ids = append(ids, e.TicketID())
+		}
+		active, err := s.Repo.ActiveIDs(ctx, ids)
+		if err != nil {
+			return err
+		}
+
+		var orphans []string
+		for _, pe := range pending {
+			id, ok := ticketOf[pe.ID]
+			if !ok || active[id] {
+				continue
+			}
+			orphans = append(orphans, pe.ID)
+			if p.AckOrphans {
+				var snap *events.Ticket
+				if id != 0 {
+					snap = &events.Ticket{ID: id, QueueID: queueID}
+				}
+				*repairs = append(*repairs, events.New(queueID, snap,
+					events.Reconciled{Action: "acked", Reason: "orphaned_entry", EntryID: pe.ID, Group: g.Name}))
+			}
+		}
+		report.Orphans += len(orphans)
+		if !p.AckOrphans || len(orphans) == 0 {
+			continue
+		}
+		n, err := s.Bus.Ack(ctx, streamKey, g.Name, orphans...)
+		if err != nil {
+			return err
+		}
+		report.Acked += int(n)
+	}
+	return nil
+}
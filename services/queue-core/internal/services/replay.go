@@ -0,0 +1,204 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"queue-core/internal/metrics"
+	"queue-core/internal/models"
+	"queue-core/pkg/events"
+)
+
+var (
+	ErrInvalidReplayRange = errors.New("replay range must be non-empty and within the maximum")
+	ErrReplayInProgress   = errors.New("a stream replay of this queue is already running")
+)
+
+var replayedEvents = metrics.Default.NewCounter("queue_replayed_events_total",
+	"Events re-emitted from the ticket event log, by target.")
+
+// ReplayPolicy bounds replays of the ticket event log so a rebuilding
+// consumer cannot swamp Postgres or Redis.
+type ReplayPolicy struct {
+	Rate     int           // events per second; 0 is unthrottled
+	MaxRange time.Duration // longest from-to range one replay may cover
+	PageSize int           // log rows read per query
+}
+
+var DefaultReplayPolicy = ReplayPolicy{Rate: 500, MaxRange: 31 * 24 * time.Hour, PageSize: 500}
+
+// ReplayRequest selects the events of one queue to replay.
+type ReplayRequest struct {
+	QueueID int64
+	From    time.Time
+	To      time.Time
+	Types   []events.Type // empty replays every type
+}
+
+// Replay re-emits the queue's logged events in [From, To) to emit, oldest
+// first, at no more than the policy rate, and returns how many it emitted.
+//
+// Replayed envelopes are marked Replay and carry an id derived from their
+// log row: replaying a range twice yields the same ids, but they are not
+// the ids the live events carried, which the log does not keep. Consumers
+// that saw the live events must dedupe on (ticket id, ticket version)
+// instead; every snapshot carries the version, so applying an event only
+// when its version is newer than the one held rebuilds a projection
+// idempotently.
+func (s *TicketService) Replay(ctx context.Context, req ReplayRequest, emit func(*events.Envelope) error) (int, error) {
+	if !s.validReplayRange(req) {
+		return 0, ErrInvalidReplayRange
+	}
+	pageSize := s.Replays.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultReplayPolicy.PageSize
+	}
+	var tick <-chan time.Time
+	if s.Replays.Rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(s.Replays.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	emitted := 0
+	var after int64
+	for {
+		page, err := s.Repo.EventLog(ctx, req.QueueID, req.From, req.To, after, pageSize)
+		if err != nil {
+			return emitted, err
+		}
+		for _, row := range page {
+			after = row.ID
+			e := replayEnvelope(row)
+			if len(req.Types) > 0 && !slices.Contains(req.Types, e.Type) {
+				continue
+			}
+			if tick != nil {
+				select {
+				case <-ctx.Done():
+					return emitted, ctx.Err()
+				case <-tick:
+				}
+			}
+			if err := emit(e); err != nil {
+				return emitted, err
+			}
+			emitted++
+		}
+		if len(page) < pageSize {
+			return emitted, nil
+		}
+	}
+}
+
+// ReplayStream is the stream key a queue's stream replays are written to.
+// It is separate from the queue stream so workers never take a replayed
+// reservation as work.
+func (s *TicketService) ReplayStream(queueID int64) string {
+	return fmt.Sprintf("%s.%d.replay", s.StreamName, queueID)
+}
+
+// StartStreamReplay replays req onto the queue's replay stream in the
+// background and returns the stream key. One stream replay runs per queue
+// at a time.
+func (s *TicketService) StartStreamReplay(ctx context.Context, req ReplayRequest) (string, error) {
+	if !s.validReplayRange(req) {
+		return "", ErrInvalidReplayRange
+	}
+	if _, running := s.replaying.LoadOrStore(req.QueueID, struct{}{}); running {
+		return "", ErrReplayInProgress
+	}
+	stream := s.ReplayStream(req.QueueID)
+	// the replay outlives the request that started it
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer s.replaying.Delete(req.QueueID)
+		n, err := s.Replay(ctx, req, func(e *events.Envelope) error {
+			fields, err := s.Encoding.Fields(e)
+			if err != nil {
+				return err
+			}
+			if _, err := s.Bus.Publish(ctx, stream, fields, s.trimFor(stream)); err != nil {
+				return err
+			}
+			replayedEvents.Inc("target", "stream")
+			return nil
+		})
+		if err != nil {
+			fmt.Printf("replay of queue %d to %s stopped after %d events: %v\n", req.QueueID, stream, n, err)
+		}
+	}()
+	return stream, nil
+}
+
+// replayEnvelope rebuilds the event a ticket_events row records, with the
+// ticket snapshot logged alongside it. Kinds with no event type of their own
+// travel as ticket.<kind> with empty data.
+func replayEnvelope(row *models.TicketEvent) *events.Envelope {
+	var p events.Payload
+	switch row.Kind {
+	case "created":
+		p = events.Created{}
+	case "reserved":
+		p = events.Reserved{}
+	case "claimed":
+		p = events.Claimed{WorkerID: actorWorker(row.Actor)}
+	case string(models.StatusDone):
+		var worker *int64
+		if id := actorWorker(row.Actor); id != 0 {
+			worker = &id
+		}
+		p = events.Completed{WorkerID: worker}
+	case string(models.StatusCancelled):
+		p = events.Cancelled{Reason: row.Reason, CancelledBy: row.Actor}
+	case "requeued", "delayed", "retried":
+		p = events.Requeued{Reason: row.Kind, AvailableAt: row.AvailableAt}
+	case "dead_lettered":
+		p = events.DeadLettered{Reason: row.Reason}
+	case string(models.StatusDiscarded):
+		p = events.Discarded{}
+	default:
+		p = events.Unknown{Type: events.Type("ticket." + row.Kind), Data: json.RawMessage(`{}`)}
+	}
+	return &events.Envelope{
+		ID:            fmt.Sprintf("%032x", row.ID),
+		Type:          p.EventType(),
+		SchemaVersion: events.SchemaVersion,
+		OccurredAt:    row.OccurredAt.UTC(),
+		QueueID:       row.QueueID,
+		Ticket: &events.Ticket{
+			ID:             row.TicketID,
+			QueueID:        row.QueueID,
+			Status:         row.ToStatus,
+			Version:        row.Version,
+			Priority:       row.Priority,
+			Lane:           row.Lane,
+			Attributes:     row.Attributes,
+			GroupID:        row.GroupID,
+			Attempts:       row.Attempts,
+			AssignedWorker: row.AssignedWorker,
+		},
+		Payload: p,
+		Replay:  true,
+	}
+}
+
+// actorWorker returns the worker id of a "worker:<id>" actor, or 0.
+func actorWorker(actor string) int64 {
+	rest, ok := strings.CutPrefix(actor, "worker:")
+	if !ok {
+		return 0
+	}
+	id, _ := strconv.ParseInt(rest, 10, 64)
+	return id
+}
+
+func (s *TicketService) validReplayRange(req ReplayRequest) bool {
+	return req.From.Before(req.To) && (s.Replays.MaxRange <= 0 || req.To.Sub(req.From) <= s.Replays.MaxRange)
+}
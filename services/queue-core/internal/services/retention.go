@@ -0,0 +1,307 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"queue-core/internal/bus"
+	"queue-core/internal/metrics"
+)
+
+// StreamKind classifies the streams queue-core writes, for retention.
+type StreamKind string
+
+const (
+	StreamTickets       StreamKind = "tickets"        // <StreamName>: every created ticket
+	StreamQueue         StreamKind = "queue"          // <StreamName>.<queue>
+	StreamWorkerUpdates StreamKind = "worker_updates" // <StreamName>.worker.updates.<queue>
+	StreamReplay        StreamKind = "replay"         // <StreamName>.<queue>.replay
+)
+
+// retentionPageSize bounds each read of entries due for trimming.
+const retentionPageSize = 500
+
+var (
+	streamLength = metrics.Default.NewGauge("queue_stream_length",
+		"Entries in each event stream at the last retention pass.")
+	streamBytes = metrics.Default.NewGauge("queue_stream_bytes",
+		"Bytes used by each event stream at the last retention pass.")
+	streamTrimmed = metrics.Default.NewCounter("queue_stream_trimmed_total",
+		"Stream entries removed by the retention job.")
+	streamArchived = metrics.Default.NewCounter("queue_stream_archived_total",
+		"Stream entries archived by the retention job before trimming, by archiver.")
+)
+
+// RetentionPolicy bounds a stream by entry count, entry age, or both. The
+// zero value keeps everything.
+type RetentionPolicy struct {
+	MaxLen int64
+	MaxAge time.Duration
+}
+
+func (p RetentionPolicy) Enabled() bool {
+	return p.MaxLen > 0 || p.MaxAge > 0
+}
+
+// ParseRetentionPolicy parses "maxlen:100000", "age:168h" or both,
+// comma separated. Empty keeps everything.
+func ParseRetentionPolicy(spec string) (RetentionPolicy, error) {
+	var p RetentionPolicy
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(part, ":")
+		var err error
+		switch k {
+		case "maxlen":
+			p.MaxLen, err = strconv.ParseInt(v, 10, 64)
+		case "age":
+			p.MaxAge, err = time.ParseDuration(v)
+		default:
+			err = fmt.Errorf("unknown limit %q", k)
+		}
+		if err != nil || p.MaxLen < 0 || p.MaxAge < 0 {
+			return RetentionPolicy{}, fmt.Errorf("invalid retention %q: %v", spec, err)
+		}
+	}
+	return p, nil
+}
+
+// StreamArchiver keeps stream entries the retention job is about to trim.
+// Archive must be idempotent: a pass that fails to trim after archiving
+// archives the same entries again next time.
+type StreamArchiver interface {
+	Archive(ctx context.Context, stream string, entries []bus.Message) error
+	Name() string
+}
+
+// StreamRetention configures how long streams keep their entries.
+//
+// Without an Archiver every publish trims approximately (by length or
+// minimum id) and the retention job only enforces the rest. With one,
+// publishing never trims: the job archives entries before removing them.
+// Queue streams are never trimmed on publish either, since an approximate
+// trim cannot spare the entries consumer groups and the reconciler still
+// need.
+//
+// The job never trims an entry a consumer group still has pending or has
+// not yet been delivered, nor a queue stream entry the reconciler may still
+// check a reservation against.
+type StreamRetention struct {
+	Default  RetentionPolicy
+	Streams  map[string]RetentionPolicy // by StreamKind or exact stream key; the key wins
+	Archiver StreamArchiver             // optional; nil trims without keeping anything
+	Interval time.Duration
+}
+
+// streamKind classifies key, a stream under s.StreamName.
+func (s *TicketService) streamKind(key string) StreamKind {
+	rest := strings.TrimPrefix(strings.TrimPrefix(key, s.StreamName), ".")
+	switch {
+	case rest == "":
+		return StreamTickets
+	case strings.HasPrefix(rest, "worker.updates."):
+		return StreamWorkerUpdates
+	case strings.HasSuffix(rest, ".replay"):
+		return StreamReplay
+	}
+	return StreamQueue
+}
+
+// retentionFor returns the policy of stream key.
+func (s *TicketService) retentionFor(key string) RetentionPolicy {
+	if s.Retention == nil {
+		return RetentionPolicy{}
+	}
+	if p, ok := s.Retention.Streams[key]; ok {
+		return p
+	}
+	if p, ok := s.Retention.Streams[string(s.streamKind(key))]; ok {
+		return p
+	}
+	return s.Retention.Default
+}
+
+// trimFor is the trim of a publish to stream: approximate when retention
+// applies, nothing needs archiving and stream is not a queue stream.
+func (s *TicketService) trimFor(stream string) bus.Trim {
+	if s.Retention == nil || s.Retention.Archiver != nil || s.streamKind(stream) == StreamQueue {
+		return bus.Trim{}
+	}
+	p := s.retentionFor(stream)
+	switch {
+	case p.MaxLen > 0:
+		return bus.Trim{MaxLen: p.MaxLen}
+	case p.MaxAge > 0:
+		return bus.Trim{MinID: streamID(time.Now().Add(-p.MaxAge))}
+	}
+	return bus.Trim{}
+}
+
+// StartRetention runs a retention pass every policy interval until ctx is
+// done. Archiving is idempotent, so every replica may run it.
+func (s *TicketService) StartRetention(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.Retention.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if _, err := s.EnforceRetention(ctx); err != nil && ctx.Err() == nil {
+				fmt.Printf("stream retention error: %v\n", err)
+			}
+		}
+	}()
+}
+
+// EnforceRetention trims (archiving first, if configured) every stream
+// under s.StreamName and records stream sizes. Returns entries trimmed per
+// stream.
+func (s *TicketService) EnforceRetention(ctx context.Context) (map[string]int, error) {
+	keys, err := s.Bus.Streams(ctx, s.StreamName)
+	if err != nil {
+		return nil, err
+	}
+
+	trimmed := map[string]int{}
+	for _, key := range keys {
+		if p := s.retentionFor(key); p.Enabled() {
+			n, err := s.trimStream(ctx, key, p)
+			if n > 0 {
+				trimmed[key] = n
+			}
+			if err != nil {
+				return trimmed, fmt.Errorf("%s: %w", key, err)
+			}
+		}
+		if n, err := s.Bus.Len(ctx, key); err == nil {
+			streamLength.Set(float64(n), "stream", key)
+		}
+		if n, err := s.Bus.Size(ctx, key); err == nil {
+			streamBytes.Set(float64(n), "stream", key)
+		}
+	}
+	return trimmed, nil
+}
+
+// trimStream removes the oldest entries of key beyond p, a page at a time:
+// each page is archived, then trimmed with an exact MINID.
+func (s *TicketService) trimStream(ctx context.Context, key string, p RetentionPolicy) (int, error) {
+	floor, err := s.retentionFloor(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	var excess int64
+	if p.MaxLen > 0 {
+		n, err := s.Bus.Len(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		excess = n - p.MaxLen
+	}
+	ageLimit := ""
+	if p.MaxAge > 0 {
+		ageLimit = streamID(time.Now().Add(-p.MaxAge))
+	}
+
+	trimmed := 0
+	for {
+		page, err := s.Bus.Range(ctx, key, "-", "+", retentionPageSize)
+		if err != nil {
+			return trimmed, err
+		}
+		var due []bus.Message
+		for _, m := range page {
+			if excess <= 0 && (ageLimit == "" || !streamIDLess(m.ID, ageLimit)) {
+				break
+			}
+			if floor != "" && !streamIDLess(m.ID, floor) {
+				break // still owed to a consumer group
+			}
+			due = append(due, m)
+			excess--
+		}
+		if len(due) == 0 {
+			return trimmed, nil
+		}
+		if a := s.Retention.Archiver; a != nil {
+			if err := a.Archive(ctx, key, due); err != nil {
+				return trimmed, err
+			}
+			streamArchived.Add(float64(len(due)), "stream", key, "archiver", a.Name())
+		}
+		if _, err := s.Bus.TrimBefore(ctx, key, nextStreamID(due[len(due)-1].ID)); err != nil {
+			return trimmed, err
+		}
+		trimmed += len(due)
+		streamTrimmed.Add(float64(len(due)), "stream", key)
+		if len(due) < len(page) || len(page) < retentionPageSize {
+			return trimmed, nil
+		}
+	}
+}
+
+// retentionFloor returns the oldest entry id of key anyone still needs, or
+// "" when there is none: entries a consumer group has pending or not yet
+// delivered and, on queue streams, entries inside the reconcile window.
+func (s *TicketService) retentionFloor(ctx context.Context, key string) (string, error) {
+	groups, err := s.Bus.Groups(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	floor := ""
+	if p := s.Reconcile; p != nil && s.streamKind(key) == StreamQueue {
+		// missingEntries reads back to the oldest reservation in the
+		// window, less the grace
+		floor = streamID(time.Now().Add(-p.Window - p.Grace))
+	}
+	for _, g := range groups {
+		need := nextStreamID(g.LastDeliveredID)
+		if g.Pending > 0 {
+			oldest, err := s.Bus.Pending(ctx, key, g.Name, 0, 1)
+			if err != nil {
+				return "", err
+			}
+			if len(oldest) > 0 {
+				need = oldest[0].ID
+			}
+		}
+		if floor == "" || streamIDLess(need, floor) {
+			floor = need
+		}
+	}
+	return floor, nil
+}
+
+// streamID is the first stream entry id at t.
+func streamID(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10) + "-0"
+}
+
+// parseStreamID splits "<ms>-<seq>"; a bare "<ms>" has seq 0.
+func parseStreamID(id string) (ms, seq uint64) {
+	m, q, _ := strings.Cut(id, "-")
+	ms, _ = strconv.ParseUint(m, 10, 64)
+	seq, _ = strconv.ParseUint(q, 10, 64)
+	return ms, seq
+}
+
+func streamIDLess(a, b string) bool {
+	am, as := parseStreamID(a)
+	bm, bs := parseStreamID(b)
+	return am < bm || (am == bm && as < bs)
+}
+
+// nextStreamID is the smallest id after id.
+func nextStreamID(id string) string {
+	ms, seq := parseStreamID(id)
+	return fmt.Sprintf("%d-%d", ms, seq+1)
+}
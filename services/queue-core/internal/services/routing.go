@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"queue-core/internal/metrics"
+	"queue-core/internal/models"
+)
+
+var (
+	ErrUnknownQueueGroup = errors.New("unknown queue group")
+	ErrEmptyQueueGroup   = errors.New("queue group has no member queues")
+)
+
+const (
+	// routingStatsWindow is how far back completions count toward a
+	// queue's average service time.
+	routingStatsWindow = 24 * time.Hour
+	// defaultServiceTime is assumed when no member has recent completions,
+	// which makes the projection rank members by backlog per server.
+	defaultServiceTime = 5 * time.Minute
+)
+
+var ticketsRouted = metrics.Default.NewCounter("queue_group_routed_total",
+	"Tickets routed from a queue group to a member queue.")
+
+// GroupLoads returns a queue group and the projected wait of each member,
+// best first. The projection is waiting tickets times average service time,
+// divided by live servers when capacity tracking is on. Members without
+// recent completions borrow the group's average; with capacity tracking,
+// members nobody is serving rank last.
+func (s *TicketService) GroupLoads(ctx context.Context, groupID int64) (*models.QueueGroup, []models.QueueLoad, error) {
+	if s.Queues == nil {
+		return nil, nil, ErrUnknownQueueGroup
+	}
+	g, err := s.Queues.GetGroup(ctx, groupID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if g == nil {
+		return nil, nil, ErrUnknownQueueGroup
+	}
+	if len(g.QueueIDs) == 0 {
+		return g, nil, ErrEmptyQueueGroup
+	}
+
+	loads, err := s.Repo.QueueLoads(ctx, g.QueueIDs, routingStatsWindow)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var sum float64
+	var known int
+	for _, l := range loads {
+		if l.AvgServiceSeconds > 0 {
+			sum += l.AvgServiceSeconds
+			known++
+		}
+	}
+	fallback := defaultServiceTime.Seconds()
+	if known > 0 {
+		fallback = sum / float64(known)
+	}
+
+	tracked := s.CapacityPolicy != nil && s.CapacityPolicy.Workers != nil
+	for i := range loads {
+		l := &loads[i]
+		if l.AvgServiceSeconds <= 0 {
+			l.AvgServiceSeconds = fallback
+		}
+		l.Servers = 1
+		if tracked {
+			if l.Servers, err = s.CapacityPolicy.Workers.Capacity(ctx, l.QueueID, s.CapacityPolicy.HeartbeatTTL); err != nil {
+				return nil, nil, err
+			}
+		}
+		l.ProjectedWaitSeconds = float64(l.Waiting) * l.AvgServiceSeconds / float64(max(l.Servers, 1))
+	}
+
+	sort.SliceStable(loads, func(i, j int) bool {
+		a, b := loads[i], loads[j]
+		if (a.Servers == 0) != (b.Servers == 0) {
+			return b.Servers == 0
+		}
+		if a.ProjectedWaitSeconds != b.ProjectedWaitSeconds {
+			return a.ProjectedWaitSeconds < b.ProjectedWaitSeconds
+		}
+		return a.QueueID < b.QueueID
+	})
+	return g, loads, nil
+}
+
+// routeToGroup points a group ticket at the member queue with the lowest
+// projected wait and records the decision on the ticket.
+func (s *TicketService) routeToGroup(ctx context.Context, ticket *models.Ticket) error {
+	g, loads, err := s.GroupLoads(ctx, *ticket.GroupID)
+	if err != nil {
+		return err
+	}
+	ticket.QueueID = loads[0].QueueID
+	ticket.Routing = &models.RoutingDecision{
+		GroupID:    g.ID,
+		QueueID:    ticket.QueueID,
+		Candidates: loads,
+		DecidedAt:  time.Now().UTC(),
+	}
+	ticketsRouted.Inc("group_id", strconv.FormatInt(g.ID, 10), "queue_id", strconv.FormatInt(ticket.QueueID, 10))
+	return nil
+}
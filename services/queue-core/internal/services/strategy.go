@@ -0,0 +1,260 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"queue-core/internal/models"
+	"queue-core/internal/repositories"
+)
+
+// DispatchStrategy decides which waiting ticket a dispatcher reserves next.
+// Implementations may keep state between calls (e.g. a call-out pattern);
+// each dispatcher (and each queue's claim path) owns its strategy instance,
+// so calls are never concurrent.
+type DispatchStrategy interface {
+	Name() string
+	Next(ctx context.Context, repo Reserver, queueID int) (*models.Ticket, error)
+}
+
+// Reserver reserves the first waiting ticket matching opts. It is satisfied
+// by *repositories.TicketRepository; wrappers may adjust opts (e.g. to add a
+// lease) without the strategy knowing.
+type Reserver interface {
+	Reserve(ctx context.Context, queueID int, opts repositories.ReserveOptions) (*models.Ticket, error)
+}
+
+// batchable is implemented by strategies whose pick does not depend on
+// earlier picks, so the dispatcher may reserve a whole batch in one
+// statement with the same options.
+type batchable interface {
+	reserveOptions() repositories.ReserveOptions
+}
+
+// Built-in strategy names, as stored in queues.dispatch_strategy.
+const (
+	StrategyFIFO         = "fifo"
+	StrategyPriority     = "priority"
+	StrategyShortestJob  = "shortest_job"
+	StrategyWeightedFair = "weighted_fair"
+	StrategySkillMatch   = "skill_match"
+)
+
+// StrategyFactory builds a strategy from its per-queue JSON config.
+type StrategyFactory func(config json.RawMessage) (DispatchStrategy, error)
+
+var (
+	strategiesMu sync.RWMutex
+	strategies   = map[string]StrategyFactory{
+		StrategyFIFO:         func(json.RawMessage) (DispatchStrategy, error) { return FIFOStrategy{}, nil },
+		StrategyPriority:     func(json.RawMessage) (DispatchStrategy, error) { return PriorityStrategy{}, nil },
+		StrategyShortestJob:  func(json.RawMessage) (DispatchStrategy, error) { return ShortestJobStrategy{}, nil },
+		StrategyWeightedFair: newWeightedFairStrategy,
+		StrategySkillMatch:   newSkillMatchStrategy,
+	}
+)
+
+// strategyState is a dispatcher's current strategy and the settings it was
+// built from, so it is only rebuilt (losing its state) when they change.
+type strategyState struct {
+	strategy DispatchStrategy
+	key      string
+	strict   bool // queue's strict_order setting
+	pull     bool // queue's dispatch_mode is pull: only the claim API reserves
+	// queue's max_active_per_customer, re-checked at reservation
+	maxActive int
+}
+
+// refreshStrategy returns the strategy configured for queueID. Queues with no
+// explicit strategy use weighted_fair over their priority-policy lanes,
+// which is plain FIFO when no lanes are configured.
+func (s *TicketService) refreshStrategy(ctx context.Context, queueID int, cur *strategyState) error {
+	if s.Queues == nil {
+		if cur.strategy == nil {
+			cur.strategy = FIFOStrategy{}
+		}
+		return nil
+	}
+	q, err := s.Queues.GetOrDefault(ctx, int64(queueID))
+	if err != nil {
+		return err
+	}
+	cur.strict = q.StrictOrder
+	cur.pull = q.DispatchMode == models.DispatchPull
+	cur.maxActive = q.MaxActivePerCustomer
+	name := q.DispatchStrategy
+	if name == "" {
+		name = StrategyWeightedFair
+	}
+	key := name + "|" + string(q.DispatchConfig)
+	if cur.strategy == nil || cur.key != key {
+		strategy, err := NewDispatchStrategy(name, q.DispatchConfig)
+		if err != nil {
+			return err
+		}
+		cur.strategy, cur.key = strategy, key
+	}
+
+	if wf, ok := cur.strategy.(*WeightedFairStrategy); ok {
+		lanes, err := s.Queues.ListLanes(ctx, int64(queueID))
+		if err != nil {
+			return err
+		}
+		wf.UseQueueLanes(lanes)
+	}
+	return nil
+}
+
+// RegisterStrategy makes a custom strategy selectable by name.
+func RegisterStrategy(name string, factory StrategyFactory) {
+	strategiesMu.Lock()
+	defer strategiesMu.Unlock()
+	strategies[name] = factory
+}
+
+// StrategyNames lists every selectable strategy.
+func StrategyNames() []string {
+	strategiesMu.RLock()
+	defer strategiesMu.RUnlock()
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewDispatchStrategy builds the named strategy from its config.
+func NewDispatchStrategy(name string, config json.RawMessage) (DispatchStrategy, error) {
+	strategiesMu.RLock()
+	factory, ok := strategies[name]
+	strategiesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown dispatch strategy %q", name)
+	}
+	return factory(config)
+}
+
+// FIFOStrategy serves tickets strictly in arrival order.
+type FIFOStrategy struct{}
+
+func (FIFOStrategy) Name() string { return StrategyFIFO }
+
+func (s FIFOStrategy) Next(ctx context.Context, repo Reserver, queueID int) (*models.Ticket, error) {
+	return repo.Reserve(ctx, queueID, s.reserveOptions())
+}
+
+func (FIFOStrategy) reserveOptions() repositories.ReserveOptions {
+	return repositories.ReserveOptions{}
+}
+
+// PriorityStrategy serves the highest priority first, FIFO among equals.
+type PriorityStrategy struct{}
+
+func (PriorityStrategy) Name() string { return StrategyPriority }
+
+func (s PriorityStrategy) Next(ctx context.Context, repo Reserver, queueID int) (*models.Ticket, error) {
+	return repo.Reserve(ctx, queueID, s.reserveOptions())
+}
+
+func (PriorityStrategy) reserveOptions() repositories.ReserveOptions {
+	return repositories.ReserveOptions{Order: repositories.OrderPriority}
+}
+
+// ShortestJobStrategy serves the ticket with the shortest expected service
+// time (estimated_time) first. Tickets without an estimate go last.
+type ShortestJobStrategy struct{}
+
+func (ShortestJobStrategy) Name() string { return StrategyShortestJob }
+
+func (s ShortestJobStrategy) Next(ctx context.Context, repo Reserver, queueID int) (*models.Ticket, error) {
+	return repo.Reserve(ctx, queueID, s.reserveOptions())
+}
+
+func (ShortestJobStrategy) reserveOptions() repositories.ReserveOptions {
+	return repositories.ReserveOptions{Order: repositories.OrderShortestJob}
+}
+
+// WeightedFairStrategy shares calls across lanes by weight (the call-out
+// pattern). Weights come from its config, e.g. {"lanes": {"standard": 2,
+// "priority": 1}}, or else from the queue's priority policy lanes.
+type WeightedFairStrategy struct {
+	lanes *LaneScheduler
+	fixed bool
+}
+
+func newWeightedFairStrategy(config json.RawMessage) (DispatchStrategy, error) {
+	var cfg struct {
+		Lanes map[string]int `json:"lanes"`
+	}
+	if len(config) > 0 {
+		if err := json.Unmarshal(config, &cfg); err != nil {
+			return nil, fmt.Errorf("weighted_fair config: %w", err)
+		}
+	}
+	s := &WeightedFairStrategy{lanes: NewLaneScheduler(), fixed: len(cfg.Lanes) > 0}
+	weights := make([]models.Lane, 0, len(cfg.Lanes))
+	for name, w := range cfg.Lanes {
+		if w <= 0 {
+			return nil, fmt.Errorf("weighted_fair config: lane %q needs a positive weight", name)
+		}
+		weights = append(weights, models.Lane{Name: name, Weight: w})
+	}
+	s.lanes.SetWeights(weights)
+	return s, nil
+}
+
+func (s *WeightedFairStrategy) Name() string { return StrategyWeightedFair }
+
+// UseQueueLanes adopts the queue's configured lane weights unless the
+// strategy config fixed its own.
+func (s *WeightedFairStrategy) UseQueueLanes(lanes []models.Lane) {
+	if !s.fixed {
+		s.lanes.SetWeights(lanes)
+	}
+}
+
+func (s *WeightedFairStrategy) Next(ctx context.Context, repo Reserver, queueID int) (*models.Ticket, error) {
+	if s.lanes.Empty() {
+		return repo.Reserve(ctx, queueID, repositories.ReserveOptions{})
+	}
+	t, err := repo.Reserve(ctx, queueID, repositories.ReserveOptions{Lanes: s.lanes.Order()})
+	if t != nil {
+		s.lanes.Served(t.Lane)
+	}
+	return t, err
+}
+
+// SkillMatchStrategy only serves tickets the staffed skills can handle: a
+// ticket whose skill attribute is empty or in Skills. Config:
+// {"skills": ["passport", "visa"], "attribute": "service"}.
+type SkillMatchStrategy struct {
+	Skills    []string `json:"skills"`
+	Attribute string   `json:"attribute"` // defaults to "skill"
+}
+
+func newSkillMatchStrategy(config json.RawMessage) (DispatchStrategy, error) {
+	s := &SkillMatchStrategy{}
+	if len(config) > 0 {
+		if err := json.Unmarshal(config, s); err != nil {
+			return nil, fmt.Errorf("skill_match config: %w", err)
+		}
+	}
+	if len(s.Skills) == 0 {
+		return nil, fmt.Errorf("skill_match config: skills required")
+	}
+	return s, nil
+}
+
+func (s *SkillMatchStrategy) Name() string { return StrategySkillMatch }
+
+func (s *SkillMatchStrategy) Next(ctx context.Context, repo Reserver, queueID int) (*models.Ticket, error) {
+	return repo.Reserve(ctx, queueID, s.reserveOptions())
+}
+
+func (s *SkillMatchStrategy) reserveOptions() repositories.ReserveOptions {
+	return repositories.ReserveOptions{Skills: s.Skills, SkillAttribute: s.Attribute}
+}
@@ -2,25 +2,97 @@ package services
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"strconv"
+	"sync"
 	"time"
 
-	"github.com/redis/go-redis/v9"
+	"queue-core/internal/actor"
+	"queue-core/internal/bus"
+	"queue-core/internal/metrics"
 	"queue-core/internal/models"
 	"queue-core/internal/repositories"
+	"queue-core/pkg/events"
 )
 
 type TicketService struct {
-	Repo       *repositories.TicketRepository
-	Rdb        *redis.Client
-	StreamName string // e.g. "queue.jobs" OR per-queue "queue.<id>.events"
-	PubSubBase string // base channel for websocket broadcasts, e.g. "queue.%d.broadcast"
+	Repo    TicketStore
+	Queues  *repositories.QueueRepository // optional; nil disables per-queue rules
+	Wakeups WakeupSource                  // optional; nil leaves dispatchers polling only
+
+	CapacityPolicy *CapacityPolicy          // optional; nil reserves every waiting ticket
+	Retry          repositories.RetryPolicy // backoff and dead-lettering of failed tickets
+	Batch          *BatchPolicy             // optional; nil reserves one ticket at a time
+	Reconcile      *ReconcilePolicy         // optional; nil leaves Postgres/Redis divergence alone
+	Encoding       events.Encoding          // wire format of emitted events; zero is native
+	Webhooks       *WebhookService          // optional; nil sends no webhooks
+	Displays       EventSink                // optional; nil drives no display hardware
+	Replays        ReplayPolicy             // throttling of event log replays
+	Retention      *StreamRetention         // optional; nil keeps stream entries forever
+	claims         claimStrategies          // per-queue strategies of the claim API
+	replaying      sync.Map                 // queue id -> struct{} while a stream replay runs
+	Bus            bus.EventBus
+	StreamName     string // e.g. "queue.jobs" OR per-queue "queue.<id>.events"
+	PubSubBase     string // base channel for websocket broadcasts, e.g. "queue.%d.broadcast"
+}
+
+// TicketStore is where tickets live. It is satisfied by
+// *repositories.TicketRepository (Postgres) and
+// *repositories.MemoryTicketRepository (development and tests).
+type TicketStore interface {
+	Reserver
+	CreateWithCustomerLimit(ctx context.Context, t *models.Ticket, maxActive int) error
+	GetByID(ctx context.Context, id int64) (*models.Ticket, error)
+	ListByStatus(ctx context.Context, queueID int, status string, attrs map[string]string) ([]*models.Ticket, error)
+	CountByStatus(ctx context.Context, queueID int64, status string) (int, error)
+	QueueLoads(ctx context.Context, queueIDs []int64, window time.Duration) ([]models.QueueLoad, error)
+	ReserveMany(ctx context.Context, queueID, n int, opts repositories.ReserveOptions) ([]*models.Ticket, error)
+	ReleaseReservation(ctx context.Context, id, expectedVersion int64) (bool, int64, error)
+	Complete(ctx context.Context, id, expectedVersion int64, counterID, workerID *int64) (bool, int64, error)
+	AckLease(ctx context.Context, id, workerID int64) (bool, int64, error)
+	NackLease(ctx context.Context, id, workerID int64, p repositories.RetryPolicy) (*models.Ticket, error)
+	ExtendLease(ctx context.Context, id, workerID int64, lease time.Duration) (bool, time.Time, error)
+	RequeueExpiredLeases(ctx context.Context, p repositories.RetryPolicy) ([]*models.Ticket, error)
+	RequeueToWaiting(ctx context.Context, id int64, p repositories.RetryPolicy) (*models.Ticket, error)
+	RetryDeadLetter(ctx context.Context, id int64) (*models.Ticket, error)
+	DiscardDeadLetter(ctx context.Context, id int64) (*models.Ticket, error)
+	ActiveAhead(ctx context.Context, t *models.Ticket) (int, error)
+	Cancel(ctx context.Context, id int64, reason, cancelledBy string) (bool, int64, error)
+	CancelWaiting(ctx context.Context, queueID int64, reason, cancelledBy string) ([]*models.Ticket, error)
+	UnleasedReservations(ctx context.Context, queueID int64, grace, window time.Duration) ([]*models.Ticket, error)
+	ActiveIDs(ctx context.Context, ids []int64) (map[int64]bool, error)
+	AbandonmentByHour(ctx context.Context, queueID int64, from, to time.Time) ([]*models.AbandonmentRow, error)
+	Timeline(ctx context.Context, ticketID int64) ([]*models.TicketEvent, error)
+	EventLog(ctx context.Context, queueID int64, from, to time.Time, afterID int64, limit int) ([]*models.TicketEvent, error)
+}
+
+// WakeupSource signals that a queue has a ticket ready to reserve (e.g. a
+// Postgres LISTEN/NOTIFY listener). Signals may coalesce or be lost, so the
+// dispatcher keeps its ticker as a safety net.
+type WakeupSource interface {
+	Subscribe(queueID int64) (<-chan struct{}, func())
 }
 
-// NewTicketService requires repo and a configured redis client.
-func NewTicketService(repo *repositories.TicketRepository, rdb *redis.Client, streamName, pubSubBase string) *TicketService {
-	return &TicketService{Repo: repo, Rdb: rdb, StreamName: streamName, PubSubBase: pubSubBase}
+// EventSink receives every event the service emits once the bus has it,
+// e.g. to drive dispensers and display boards. Notify must not block.
+type EventSink interface {
+	Notify(ctx context.Context, event *events.Envelope)
+}
+
+var (
+	dispatchLatency = metrics.Default.NewHistogram("queue_dispatch_latency_seconds",
+		"Time from a ticket becoming ready to its reservation.", metrics.DefaultBuckets)
+	dispatchWakeups = metrics.Default.NewCounter("queue_dispatch_wakeups_total",
+		"Dispatcher passes by trigger (notify or poll).")
+)
+
+// NewTicketService requires repo and a connected event bus.
+func NewTicketService(repo TicketStore, b bus.EventBus, streamName, pubSubBase string) *TicketService {
+	return &TicketService{Repo: repo, Bus: b, StreamName: streamName, PubSubBase: pubSubBase, Retry: repositories.DefaultRetryPolicy, Replays: DefaultReplayPolicy}
 }
 
 // CreateTicket writes DB then publishes to Redis Stream and PubSub.
@@ -33,36 +105,50 @@ func (s *TicketService) CreateTicket(ctx context.Context, ticket *models.Ticket)
 	if ticket.Priority == 0 {
 		ticket.Priority = 1
 	}
+	token, err := newToken()
+	if err != nil {
+		return 0, err
+	}
+	ticket.Token = token
+
+	// a group ticket goes to the member queue with the shortest projected wait
+	if ticket.GroupID != nil {
+		if err := s.routeToGroup(ctx, ticket); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := s.applyPriorityClass(ctx, ticket); err != nil {
+		return 0, err
+	}
+	if err := s.validateIntake(ctx, ticket); err != nil {
+		return 0, err
+	}
 
-	if err := s.Repo.Create(ctx, ticket); err != nil {
+	maxActive := 0
+	if ticket.CustomerID != nil && s.Queues != nil {
+		q, err := s.Queues.GetOrDefault(ctx, ticket.QueueID)
+		if err != nil {
+			return 0, err
+		}
+		maxActive = q.MaxActivePerCustomer
+	}
+
+	if err := s.Repo.CreateWithCustomerLimit(ctx, ticket, maxActive); err != nil {
 		return 0, err
 	}
 
-	// Push to Redis stream (minimal payload)
+	// Push to the event stream, then broadcast for websocket clients (fast fanout)
 	streamKey := fmt.Sprintf("%s", s.StreamName) // you may choose fmt.Sprintf("%s.%d", s.StreamName, ticket.QueueID)
-	values := map[string]interface{}{
-		"ticket_id":  ticket.ID,
-		"queue_id":   ticket.QueueID,
-		"event":      "ticket.created",
-		"created_at": time.Now().UTC().Format(time.RFC3339),
-	}
-	_, err := s.Rdb.XAdd(ctx, &redis.XAddArgs{
-		Stream: streamKey,
-		Values: values,
-	}).Result()
-	if err != nil {
+	event := events.New(ticket.QueueID, ticketSnapshot(ticket), events.Created{Routed: ticket.Routing != nil})
+	if fields, err := s.Encoding.Fields(event); err != nil {
+		fmt.Printf("event encode error: %v\n", err)
+	} else if _, err := s.Bus.Publish(ctx, streamKey, fields, s.trimFor(streamKey)); err != nil {
 		// log and continue — creation already persisted.
 		// In production you may implement retry/poison queue.
+		fmt.Printf("stream publish error: %v\n", err)
 	}
-
-	// Publish to Pub/Sub for websocket clients (fast fanout)
-	pubChannel := fmt.Sprintf(s.PubSubBase, ticket.QueueID)
-	b, _ := json.Marshal(map[string]interface{}{
-		"event":     "ticket.created",
-		"ticket_id": ticket.ID,
-		"queue_id":  ticket.QueueID,
-	})
-	_ = s.Rdb.Publish(ctx, pubChannel, b).Err() // best-effort
+	s.broadcast(ctx, ticket.QueueID, event)
 
 	return ticket.ID, nil
 }
@@ -70,10 +156,32 @@ func (s *TicketService) CreateTicket(ctx context.Context, ticket *models.Ticket)
 // StartDispatcher starts a goroutine that continuously attempts to reserve tickets
 // for a given queueID and publishes reservation events to Redis Stream + PubSub.
 // This keeps workers decoupled: workers consume the stream and be sure a ticket was reserved.
-func (s *TicketService) StartDispatcher(ctx context.Context, queueID int, interval time.Duration) {
+// With a WakeupSource the dispatcher runs as soon as a ticket is ready and
+// interval only bounds how long a lost notification can delay a ticket.
+// With a ReconcilePolicy the queue's reconciler runs alongside it.
+// The returned channel is closed once both have stopped after ctx is done.
+func (s *TicketService) StartDispatcher(ctx context.Context, queueID int, interval time.Duration) <-chan struct{} {
+	var wg sync.WaitGroup
+	if s.Reconcile != nil && s.Reconcile.Interval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runReconciler(ctx, int64(queueID))
+		}()
+	}
+	wg.Add(1)
 	go func() {
+		defer wg.Done()
 		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
+		strategy := &strategyState{}
+		var wake <-chan struct{} // nil channel: never fires
+		if s.Wakeups != nil {
+			ch, unsubscribe := s.Wakeups.Subscribe(int64(queueID))
+			defer unsubscribe()
+			wake = ch
+		}
+		qidLabel := strconv.Itoa(queueID)
 		for {
 			select {
 			case <-ctx.Done():
@@ -81,70 +189,225 @@ func (s *TicketService) StartDispatcher(ctx context.Context, queueID int, interv
 			default:
 			}
 
-			// Attempt to reserve as many as available in tight loop until no ticket or until next tick
-			for {
-				t, err := s.Repo.ReserveNext(ctx, queueID)
+			// pick up strategy and call-out weight changes once per pass
+			if err := s.refreshStrategy(ctx, queueID, strategy); err != nil {
+				fmt.Printf("dispatch strategy error: %v\n", err)
+			}
+			if strategy.strategy == nil {
+				strategy.strategy = FIFOStrategy{}
+			}
+
+			// reserve only what workers can take right now; workers claim
+			// from pull queues themselves
+			var budget int
+			if strategy.pull {
+				budget = 0
+			} else if capacity, err := s.Capacity(ctx, int64(queueID)); err != nil {
+				fmt.Printf("capacity error: %v\n", err)
+				budget = 0
+			} else {
+				budget = capacity.Available
+			}
+
+			// Attempt to reserve up to the budget in tight loop until no ticket or until next tick
+			n := 1
+			if budget != 0 {
+				n = s.batchSize(ctx, queueID, strategy.strategy)
+			}
+			for budget != 0 {
+				if budget > 0 {
+					n = min(n, budget)
+				}
+				reserved, err := s.reserve(ctx, queueID, strategy, n)
 				if err != nil {
 					// Log and break to avoid tight error loop
 					// Use your logger; here we use fmt
 					fmt.Printf("ReserveNext error: %v\n", err)
 					break
 				}
-				if t == nil {
+				if len(reserved) == 0 {
 					// nothing waiting right now
 					break
 				}
 
-				// build event payload
-				event := map[string]interface{}{
-					"event":      "ticket.reserved",
-					"ticket_id":  t.ID,
-					"queue_id":   t.QueueID,
-					"status":     "processing",
-					"version":    t.Version + 1, // ReserveNext bumped the version
-					"reserved_at": time.Now().UTC().Format(time.RFC3339),
+				admitted := s.admit(ctx, reserved, strategy.maxActive)
+				batch := make([]*events.Envelope, 0, len(admitted))
+				for _, t := range admitted {
+					// updated_at is when the ticket last became waiting
+					dispatchLatency.Observe(time.Since(t.UpdatedAt).Seconds(), "queue_id", qidLabel)
+					batch = append(batch, reservedEvent(t))
 				}
+				s.emitQueueEvents(ctx, int64(queueID), batch)
 
-				// push to stream (for workers)
-				streamKey := fmt.Sprintf("%s.%d", s.StreamName, queueID)
-				_, err = s.Rdb.XAdd(ctx, &redis.XAddArgs{
-					Stream: streamKey,
-					Values: event,
-				}).Result()
-				if err != nil {
-					fmt.Printf("XAdd error: %v\n", err)
-					// you might want to requeue the ticket in DB or mark for reconciliation
+				if budget > 0 {
+					budget -= len(admitted)
+				}
+				if len(reserved) < n {
+					// a short batch drained the queue
+					break
 				}
-
-				// also publish to pubsub for websocket frontends
-				pubChannel := fmt.Sprintf(s.PubSubBase, queueID)
-				b, _ := json.Marshal(event)
-				_ = s.Rdb.Publish(ctx, pubChannel, b).Err()
 			}
 
-			// wait for next tick
+			// wait for a notification, or the next tick as a fallback
 			select {
 			case <-ctx.Done():
 				return
+			case <-wake:
+				dispatchWakeups.Inc("queue_id", qidLabel, "trigger", "notify")
 			case <-ticker.C:
+				dispatchWakeups.Inc("queue_id", qidLabel, "trigger", "poll")
 			}
 		}
 	}()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// reservedEvent is the stream event handing a reserved ticket to workers.
+func reservedEvent(t *models.Ticket) *events.Envelope {
+	snap := ticketSnapshot(t)
+	snap.Status = string(models.StatusProcessing)
+	snap.Version = t.Version + 1 // ReserveNext bumped the version
+	return events.New(t.QueueID, snap, events.Reserved{})
+}
+
+// emitQueueEvent pushes event to the queue's stream (for workers) and
+// publishes it on the queue's pub/sub channel (for websocket frontends).
+// Both are best-effort: the database is already the source of truth.
+func (s *TicketService) emitQueueEvent(ctx context.Context, queueID int64, event *events.Envelope) {
+	fields, err := s.Encoding.Fields(event)
+	if err != nil {
+		fmt.Printf("event encode error: %v\n", err)
+		return
+	}
+	streamKey := fmt.Sprintf("%s.%d", s.StreamName, queueID)
+	_, err = s.Bus.Publish(ctx, streamKey, fields, s.trimFor(streamKey))
+	if err != nil {
+		fmt.Printf("stream publish error: %v\n", err)
+		// you might want to requeue the ticket in DB or mark for reconciliation
+	}
+	s.broadcast(ctx, queueID, event)
+}
+
+// broadcast publishes event on the queue's pub/sub channel only, for events
+// frontends care about but stream workers must not treat as work.
+func (s *TicketService) broadcast(ctx context.Context, queueID int64, event *events.Envelope) {
+	pubChannel := fmt.Sprintf(s.PubSubBase, queueID)
+	b, _ := s.Encoding.JSON(event)
+	_ = s.Bus.Broadcast(ctx, pubChannel, b)
+	s.notify(ctx, event)
+}
+
+// notify hands event to the display sink and logs deliveries of it to
+// matching webhook subscriptions.
+func (s *TicketService) notify(ctx context.Context, event *events.Envelope) {
+	if s.Displays != nil {
+		s.Displays.Notify(ctx, event)
+	}
+	if s.Webhooks == nil {
+		return
+	}
+	if err := s.Webhooks.Enqueue(ctx, event); err != nil {
+		fmt.Printf("webhook enqueue error: %v\n", err)
+	}
+}
+
+// ErrNotTicketWorker is returned when the actor completing a ticket is
+// neither staff nor the worker serving it.
+var ErrNotTicketWorker = errors.New("only staff or the ticket's worker may complete it")
+
+// CompleteTicket marks a reserved ticket done (archiving it) and announces it
+// on behalf of the actor in ctx: staff and the system may complete any
+// ticket, a worker only one assigned to it. Dispatcher reservations record no
+// worker, so only staff and the system complete those. Returns
+// ErrTicketNotFound for unknown tickets and false when the version or status
+// no longer match.
+func (s *TicketService) CompleteTicket(ctx context.Context, id, expectedVersion int64, counterID, workerID *int64) (bool, error) {
+	t, err := s.Repo.GetByID(ctx, id)
+	if err == sql.ErrNoRows {
+		return false, ErrTicketNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+	if !mayComplete(actor.FromContext(ctx), t, workerID) {
+		return false, ErrNotTicketWorker
+	}
+	ok, newVersion, err := s.Repo.Complete(ctx, id, expectedVersion, counterID, workerID)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	snap := ticketSnapshot(t)
+	snap.Status, snap.Version = string(models.StatusDone), newVersion
+	s.emitQueueEvent(ctx, t.QueueID, events.New(t.QueueID, snap, events.Completed{WorkerID: workerID, CounterID: counterID}))
+	return true, nil
+}
+
+func mayComplete(a actor.Actor, t *models.Ticket, workerID *int64) bool {
+	switch a.Role {
+	case actor.RoleStaff, actor.RoleSystem:
+		return true
+	case actor.RoleWorker:
+		self, err := strconv.ParseInt(a.ID, 10, 64)
+		if err != nil {
+			return false
+		}
+		if workerID != nil && *workerID != self {
+			return false
+		}
+		return t.AssignedWorker != 0 && t.AssignedWorker == self
+	}
+	return false
+}
+
+// newToken returns a random customer token.
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ticketSnapshot is the event snapshot of t as loaded; callers adjust
+// status and version to the state the event leaves it in.
+func ticketSnapshot(t *models.Ticket) *events.Ticket {
+	return &events.Ticket{
+		ID:             t.ID,
+		QueueID:        t.QueueID,
+		Status:         string(t.Status),
+		Version:        t.Version,
+		Priority:       t.Priority,
+		Lane:           t.Lane,
+		Attributes:     t.Attributes,
+		GroupID:        t.GroupID,
+		Attempts:       t.Attempts,
+		AssignedWorker: t.AssignedWorker,
+	}
 }
 
 // PublishWorkerUpdate allows other components (e.g., a worker) to send back computed updates.
 // Useful when worker wants Core to persist estimated_time or other improvements.
-func (s *TicketService) PublishWorkerUpdate(ctx context.Context, queueID int, payload map[string]interface{}) error {
+func (s *TicketService) PublishWorkerUpdate(ctx context.Context, queueID int, ticketID int64, update events.WorkerUpdate) error {
+	event := events.New(int64(queueID), &events.Ticket{ID: ticketID, QueueID: int64(queueID)}, update)
+	fields, err := s.Encoding.Fields(event)
+	if err != nil {
+		return err
+	}
 	streamKey := fmt.Sprintf("%s.worker.updates.%d", s.StreamName, queueID)
-	_, err := s.Rdb.XAdd(ctx, &redis.XAddArgs{
-		Stream: streamKey,
-		Values: payload,
-	}).Result()
+	_, err = s.Bus.Publish(ctx, streamKey, fields, s.trimFor(streamKey))
 	if err != nil {
 		return err
 	}
+	s.notify(ctx, event)
 	// also publish to pubsub so WebSocket clients see it in real-time
 	pubChannel := fmt.Sprintf(s.PubSubBase, queueID)
-	b, _ := json.Marshal(payload)
-	return s.Rdb.Publish(ctx, pubChannel, b).Err()
+	b, _ := s.Encoding.JSON(event)
+	return s.Bus.Broadcast(ctx, pubChannel, b)
 }
@@ -0,0 +1,20 @@
+package services
+
+import (
+	"context"
+
+	"queue-core/internal/models"
+)
+
+// Timeline returns every recorded state change of a ticket, active or
+// archived, oldest first.
+func (s *TicketService) Timeline(ctx context.Context, id int64) ([]*models.TicketEvent, error) {
+	timeline, err := s.Repo.Timeline(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(timeline) == 0 {
+		return nil, ErrTicketNotFound
+	}
+	return timeline, nil
+}
@@ -0,0 +1,397 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"queue-core/internal/metrics"
+	"queue-core/internal/models"
+	"queue-core/internal/repositories"
+	"queue-core/pkg/events"
+	"queue-core/pkg/webhook"
+)
+
+var (
+	ErrInvalidWebhookURL       = errors.New("webhook url must be an absolute http(s) url")
+	ErrUnknownWebhookEventType = errors.New("unknown event type in webhook filter")
+	ErrPrivateWebhookTarget    = errors.New("webhook url must not resolve to a loopback, private, link-local or metadata address")
+)
+
+// blockedWebhookPrefixes are internal ranges netip has no predicate for:
+// "this network" and carrier-grade NAT (home of some cloud metadata services).
+var blockedWebhookPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+}
+
+var (
+	webhookDeliveries = metrics.Default.NewCounter("queue_webhook_deliveries_total",
+		"Webhook delivery attempts, by outcome.")
+	webhookDisabled = metrics.Default.NewCounter("queue_webhook_disabled_total",
+		"Webhook subscriptions disabled after consecutive failures.")
+)
+
+// subscriptionCacheTTL bounds how stale the subscription list Enqueue
+// matches against may be on replicas that did not make the change.
+const subscriptionCacheTTL = 10 * time.Second
+
+// WebhookPolicy configures delivery retries.
+type WebhookPolicy struct {
+	MaxAttempts  int           // a delivery fails for good after this many attempts
+	BaseDelay    time.Duration // first retry delay; doubles per attempt
+	MaxDelay     time.Duration
+	DisableAfter int           // consecutive failed attempts that disable a subscription; 0 never
+	Timeout      time.Duration // per request
+	BatchSize    int           // deliveries claimed per pass
+}
+
+var DefaultWebhookPolicy = WebhookPolicy{
+	MaxAttempts:  8,
+	BaseDelay:    10 * time.Second,
+	MaxDelay:     time.Hour,
+	DisableAfter: 20,
+	Timeout:      10 * time.Second,
+	BatchSize:    50,
+}
+
+// WebhookService fans events out to subscribed endpoints. Events are logged
+// as deliveries in Postgres when emitted and sent by a dispatcher, so a
+// slow or dead endpoint never holds up the queue and retries survive
+// restarts.
+type WebhookService struct {
+	Repo     *repositories.WebhookRepository
+	Client   *http.Client
+	Encoding events.Encoding // body format; the same as the stream's
+	Policy   WebhookPolicy
+
+	// AllowPrivate lists internal ranges endpoints may still resolve to;
+	// everything loopback, private or link-local is refused otherwise.
+	AllowPrivate []netip.Prefix
+	Resolver     interface {
+		LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+	}
+
+	mu       sync.Mutex
+	subs     []*models.WebhookSubscription
+	loadedAt time.Time
+}
+
+func NewWebhookService(repo *repositories.WebhookRepository) *WebhookService {
+	s := &WebhookService{Repo: repo, Policy: DefaultWebhookPolicy, Resolver: net.DefaultResolver}
+	// check the address actually dialed too, so DNS changed after subscribe
+	// time (or a redirect) cannot reach an internal address
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: s.controlDial}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	s.Client = &http.Client{Transport: transport}
+	return s
+}
+
+// Enqueue logs a delivery of e for every subscription matching it. Emitters
+// call it best-effort, like their Redis writes.
+func (s *WebhookService) Enqueue(ctx context.Context, e *events.Envelope) error {
+	subs, err := s.subscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for _, sub := range subs {
+		if sub.Matches(string(e.Type), e.QueueID) {
+			ids = append(ids, sub.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	body, err := s.Encoding.JSON(e)
+	if err != nil {
+		return err
+	}
+	return s.Repo.EnqueueDeliveries(ctx, ids, e.ID, string(e.Type), body)
+}
+
+// subscriptions returns the cached subscription list, reloading it once it
+// is older than subscriptionCacheTTL.
+func (s *WebhookService) subscriptions(ctx context.Context) ([]*models.WebhookSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subs != nil && time.Since(s.loadedAt) < subscriptionCacheTTL {
+		return s.subs, nil
+	}
+	subs, err := s.Repo.ListSubscriptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.subs, s.loadedAt = subs, time.Now()
+	return subs, nil
+}
+
+func (s *WebhookService) invalidate() {
+	s.mu.Lock()
+	s.subs = nil
+	s.mu.Unlock()
+}
+
+// StartDispatcher delivers due webhooks every interval until ctx is done.
+// Claims are row-locked, so every replica can run one.
+func (s *WebhookService) StartDispatcher(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if _, err := s.DeliverDue(ctx); err != nil && ctx.Err() == nil {
+				fmt.Printf("webhook dispatch error: %v\n", err)
+			}
+		}
+	}()
+}
+
+// DeliverDue sends one batch of due deliveries and returns how many it
+// attempted.
+func (s *WebhookService) DeliverDue(ctx context.Context) (int, error) {
+	// a claim outlives the request, so a crashed sender's deliveries come due again
+	lease := 2 * s.Policy.Timeout
+	if lease <= 0 {
+		lease = time.Minute
+	}
+	due, err := s.Repo.ClaimDueDeliveries(ctx, s.Policy.BatchSize, lease)
+	if err != nil {
+		return 0, err
+	}
+	var wg sync.WaitGroup
+	for _, d := range due {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.deliver(ctx, d); err != nil {
+				fmt.Printf("webhook delivery %d: %v\n", d.ID, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return len(due), nil
+}
+
+// deliver sends d once and records the outcome. Any 2xx response is success.
+func (s *WebhookService) deliver(ctx context.Context, d *models.WebhookDelivery) error {
+	status, sendErr := s.send(ctx, d)
+	if sendErr == nil && status >= 200 && status < 300 {
+		webhookDeliveries.Inc("outcome", "delivered")
+		return s.Repo.MarkDelivered(ctx, d, status)
+	}
+
+	var code *int
+	msg := ""
+	if sendErr != nil {
+		msg = sendErr.Error()
+	} else {
+		code = &status
+		msg = http.StatusText(status)
+	}
+	retryIn := s.backoff(d.Attempts)
+	outcome := "retrying"
+	if d.Attempts >= s.Policy.MaxAttempts {
+		retryIn, outcome = -1, "failed"
+	}
+	webhookDeliveries.Inc("outcome", outcome)
+	disabled, err := s.Repo.MarkFailed(ctx, d, code, msg, retryIn, s.Policy.DisableAfter)
+	if err != nil {
+		return err
+	}
+	if disabled {
+		webhookDisabled.Inc()
+		s.invalidate()
+	}
+	return nil
+}
+
+func (s *WebhookService) send(ctx context.Context, d *models.WebhookDelivery) (int, error) {
+	if s.Policy.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Policy.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", s.Encoding.ContentType())
+	req.Header.Set(webhook.SignatureHeader, webhook.Sign(d.Secret, time.Now(), d.Body))
+	req.Header.Set(webhook.EventIDHeader, d.EventID)
+	req.Header.Set(webhook.EventTypeHeader, d.EventType)
+	req.Header.Set(webhook.DeliveryHeader, strconv.FormatInt(d.ID, 10))
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return resp.StatusCode, nil
+}
+
+// backoff is the delay before the retry following attempt n (1-based).
+func (s *WebhookService) backoff(n int) time.Duration {
+	d := s.Policy.BaseDelay
+	for i := 1; i < n && d < s.Policy.MaxDelay; i++ {
+		d *= 2
+	}
+	if s.Policy.MaxDelay > 0 && d > s.Policy.MaxDelay {
+		d = s.Policy.MaxDelay
+	}
+	return d
+}
+
+// List returns every subscription, without secrets.
+func (s *WebhookService) List(ctx context.Context) ([]*models.WebhookSubscription, error) {
+	subs, err := s.Repo.ListSubscriptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range subs {
+		sub.Secret = ""
+	}
+	return subs, nil
+}
+
+// Get returns a subscription without its secret, or nil when there is none.
+func (s *WebhookService) Get(ctx context.Context, id int64) (*models.WebhookSubscription, error) {
+	sub, err := s.Repo.GetSubscription(ctx, id)
+	if sub != nil {
+		sub.Secret = ""
+	}
+	return sub, err
+}
+
+// Create validates and stores sub, generating its secret unless one is
+// given. The secret is returned this once.
+func (s *WebhookService) Create(ctx context.Context, sub *models.WebhookSubscription) error {
+	if err := s.validateSubscription(ctx, sub); err != nil {
+		return err
+	}
+	if sub.Secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		sub.Secret = hex.EncodeToString(b)
+	}
+	if err := s.Repo.CreateSubscription(ctx, sub); err != nil {
+		return err
+	}
+	s.invalidate()
+	return nil
+}
+
+// Update replaces a subscription's url, filters and enabled flag;
+// re-enabling it resets its failure count. Returns false when there is no
+// such subscription.
+func (s *WebhookService) Update(ctx context.Context, sub *models.WebhookSubscription) (bool, error) {
+	if err := s.validateSubscription(ctx, sub); err != nil {
+		return false, err
+	}
+	ok, err := s.Repo.UpdateSubscription(ctx, sub)
+	if err != nil || !ok {
+		return false, err
+	}
+	sub.Secret = ""
+	s.invalidate()
+	return true, nil
+}
+
+// Delete removes a subscription and its delivery log.
+func (s *WebhookService) Delete(ctx context.Context, id int64) (bool, error) {
+	ok, err := s.Repo.DeleteSubscription(ctx, id)
+	if ok {
+		s.invalidate()
+	}
+	return ok, err
+}
+
+// Deliveries returns a subscription's most recent deliveries.
+func (s *WebhookService) Deliveries(ctx context.Context, id int64, limit int) ([]*models.WebhookDelivery, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	return s.Repo.ListDeliveries(ctx, id, limit)
+}
+
+func (s *WebhookService) validateSubscription(ctx context.Context, sub *models.WebhookSubscription) error {
+	u, err := url.Parse(sub.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidWebhookURL
+	}
+	addrs, err := s.resolve(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidWebhookURL, err)
+	}
+	for _, ip := range addrs {
+		if !s.allowedTarget(ip) {
+			return ErrPrivateWebhookTarget
+		}
+	}
+	for _, t := range sub.EventTypes {
+		if !events.Type(t).Known() {
+			return fmt.Errorf("%w: %q", ErrUnknownWebhookEventType, t)
+		}
+	}
+	return nil
+}
+
+func (s *WebhookService) resolve(ctx context.Context, host string) ([]netip.Addr, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{ip}, nil
+	}
+	return s.Resolver.LookupNetIP(ctx, "ip", host)
+}
+
+// allowedTarget reports whether webhooks may be sent to ip.
+func (s *WebhookService) allowedTarget(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, p := range s.AllowPrivate {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, p := range blockedWebhookPrefixes {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// controlDial refuses connections to addresses allowedTarget rejects.
+func (s *WebhookService) controlDial(_, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !s.allowedTarget(ap.Addr()) {
+		return ErrPrivateWebhookTarget
+	}
+	return nil
+}
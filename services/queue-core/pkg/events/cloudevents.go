@@ -0,0 +1,151 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// CloudEventsSpecVersion is the CloudEvents version events are written in.
+	CloudEventsSpecVersion = "1.0"
+	// CloudEventsContentType is the media type of a structured CloudEvent.
+	CloudEventsContentType = "application/cloudevents+json"
+	// CloudEventTypePrefix namespaces event types, e.g. queuecore.ticket.created.
+	CloudEventTypePrefix = "queuecore."
+	// DefaultSource prefixes CloudEvent sources when an Encoding has none.
+	DefaultSource = "/queue-core"
+
+	// cloudEventField is the stream field holding a structured CloudEvent.
+	cloudEventField = "cloudevent"
+)
+
+// CloudEvent is an event in CloudEvents 1.0 structured JSON form. Source is
+// the queue, subject the ticket, and data the ticket snapshot and payload.
+type CloudEvent struct {
+	SpecVersion     string         `json:"specversion"`
+	ID              string         `json:"id"`
+	Source          string         `json:"source"`
+	Type            string         `json:"type"`
+	Subject         string         `json:"subject,omitempty"`
+	Time            time.Time      `json:"time"`
+	DataContentType string         `json:"datacontenttype"`
+	SchemaVersion   int            `json:"schemaversion"`    // extension attribute
+	Replay          bool           `json:"replay,omitempty"` // extension attribute
+	Data            CloudEventData `json:"data"`
+}
+
+// CloudEventData is the data of a CloudEvent.
+type CloudEventData struct {
+	QueueID int64           `json:"queue_id"`
+	Ticket  *Ticket         `json:"ticket,omitempty"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// CloudEvent converts the envelope. Ids carry over, so the same event has
+// the same id in either format. source prefixes the queue path and defaults
+// to DefaultSource.
+func (e *Envelope) CloudEvent(source string) (*CloudEvent, error) {
+	if source == "" {
+		source = DefaultSource
+	}
+	data, err := e.data()
+	if err != nil {
+		return nil, err
+	}
+	ce := &CloudEvent{
+		SpecVersion:     CloudEventsSpecVersion,
+		ID:              e.ID,
+		Source:          fmt.Sprintf("%s/queues/%d", strings.TrimSuffix(source, "/"), e.QueueID),
+		Type:            CloudEventTypePrefix + string(e.Type),
+		Time:            e.OccurredAt,
+		DataContentType: "application/json",
+		SchemaVersion:   e.SchemaVersion,
+		Replay:          e.Replay,
+		Data:            CloudEventData{QueueID: e.QueueID, Ticket: e.Ticket, Payload: data},
+	}
+	if id := e.TicketID(); id != 0 {
+		ce.Subject = "tickets/" + strconv.FormatInt(id, 10)
+	}
+	return ce, nil
+}
+
+// Envelope converts a CloudEvent written by this package back.
+func (ce *CloudEvent) Envelope() (*Envelope, error) {
+	if ce.SpecVersion != CloudEventsSpecVersion {
+		return nil, fmt.Errorf("%w: specversion %q", ErrMalformedEvent, ce.SpecVersion)
+	}
+	if !strings.HasPrefix(ce.Type, CloudEventTypePrefix) {
+		return nil, fmt.Errorf("%w: type %q", ErrMalformedEvent, ce.Type)
+	}
+	t := Type(strings.TrimPrefix(ce.Type, CloudEventTypePrefix))
+	p, err := decodePayload(ce.SchemaVersion, t, ce.Data.Payload)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{
+		ID:            ce.ID,
+		Type:          t,
+		SchemaVersion: ce.SchemaVersion,
+		OccurredAt:    ce.Time,
+		QueueID:       ce.Data.QueueID,
+		Ticket:        ce.Data.Ticket,
+		Payload:       p,
+		Replay:        ce.Replay,
+	}, nil
+}
+
+// Format selects the wire format of events.
+type Format string
+
+const (
+	FormatNative      Format = "native"
+	FormatCloudEvents Format = "cloudevents"
+)
+
+// Valid reports whether f is a known format; empty means native.
+func (f Format) Valid() bool {
+	return f == "" || f == FormatNative || f == FormatCloudEvents
+}
+
+// Encoding writes envelopes in the configured format. The zero value writes
+// the native format.
+type Encoding struct {
+	Format Format
+	Source string // CloudEvents source prefix; defaults to DefaultSource
+}
+
+// Fields encodes e as Redis stream fields: the native fields, or a single
+// field holding the structured CloudEvent.
+func (enc Encoding) Fields(e *Envelope) (map[string]interface{}, error) {
+	if enc.Format != FormatCloudEvents {
+		return e.Fields()
+	}
+	b, err := enc.JSON(e)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{cloudEventField: string(b)}, nil
+}
+
+// JSON encodes e for pub/sub channels and webhook bodies.
+func (enc Encoding) JSON(e *Envelope) ([]byte, error) {
+	if enc.Format != FormatCloudEvents {
+		return json.Marshal(e)
+	}
+	ce, err := e.CloudEvent(enc.Source)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(ce)
+}
+
+// ContentType is the media type of JSON from this encoding.
+func (enc Encoding) ContentType() string {
+	if enc.Format == FormatCloudEvents {
+		return CloudEventsContentType
+	}
+	return "application/json"
+}
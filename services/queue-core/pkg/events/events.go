@@ -0,0 +1,251 @@
+// Package events defines the events queue-core publishes on queue streams
+// and pub/sub channels. Every event travels in an Envelope carrying its
+// type, schema version, time, queue and a snapshot of the ticket, with a
+// typed payload per event type.
+//
+// Within a schema version fields are only ever added. Renaming, removing or
+// retyping a field is a breaking change and bumps SchemaVersion.
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SchemaVersion is the envelope schema this package writes and the newest
+// it reads.
+const SchemaVersion = 1
+
+var (
+	ErrUnsupportedSchema = errors.New("unsupported event schema version")
+	ErrMalformedEvent    = errors.New("malformed event")
+)
+
+// Envelope is the common wrapper of every event.
+type Envelope struct {
+	ID            string    `json:"id"`
+	Type          Type      `json:"type"`
+	SchemaVersion int       `json:"schema_version"`
+	OccurredAt    time.Time `json:"occurred_at"`
+	QueueID       int64     `json:"queue_id"`
+	Ticket        *Ticket   `json:"ticket,omitempty"`
+	Payload       Payload   `json:"-"`                // encoded as "data"
+	Replay        bool      `json:"replay,omitempty"` // re-emitted from the event log, not live
+}
+
+// Ticket is the ticket snapshot an event carries: its state right after the
+// event. Fields the emitter does not know are left zero.
+type Ticket struct {
+	ID             int64          `json:"id"`
+	QueueID        int64          `json:"queue_id"`
+	Status         string         `json:"status,omitempty"`
+	Version        int64          `json:"version,omitempty"`
+	Priority       int            `json:"priority,omitempty"`
+	Lane           string         `json:"lane,omitempty"`
+	Attributes     map[string]any `json:"attributes,omitempty"`
+	GroupID        *int64         `json:"group_id,omitempty"`
+	Attempts       int            `json:"attempts,omitempty"`
+	AssignedWorker int64          `json:"assigned_worker,omitempty"`
+}
+
+// New wraps p in an envelope with a fresh id, stamped now.
+func New(queueID int64, ticket *Ticket, p Payload) *Envelope {
+	return &Envelope{
+		ID:            newID(),
+		Type:          p.EventType(),
+		SchemaVersion: SchemaVersion,
+		OccurredAt:    time.Now().UTC(),
+		QueueID:       queueID,
+		Ticket:        ticket,
+		Payload:       p,
+	}
+}
+
+// TicketID returns the id of the event's ticket, or 0 for events without one.
+func (e *Envelope) TicketID() int64 {
+	if e.Ticket == nil {
+		return 0
+	}
+	return e.Ticket.ID
+}
+
+type envelopeJSON struct {
+	ID            string          `json:"id"`
+	Type          Type            `json:"type"`
+	SchemaVersion int             `json:"schema_version"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	QueueID       int64           `json:"queue_id"`
+	Ticket        *Ticket         `json:"ticket,omitempty"`
+	Data          json.RawMessage `json:"data"`
+	Replay        bool            `json:"replay,omitempty"`
+}
+
+func (e Envelope) MarshalJSON() ([]byte, error) {
+	data, err := e.data()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(envelopeJSON{
+		ID:            e.ID,
+		Type:          e.Type,
+		SchemaVersion: e.SchemaVersion,
+		OccurredAt:    e.OccurredAt,
+		QueueID:       e.QueueID,
+		Ticket:        e.Ticket,
+		Data:          data,
+		Replay:        e.Replay,
+	})
+}
+
+func (e *Envelope) UnmarshalJSON(b []byte) error {
+	var raw envelopeJSON
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	p, err := decodePayload(raw.SchemaVersion, raw.Type, raw.Data)
+	if err != nil {
+		return err
+	}
+	*e = Envelope{
+		ID:            raw.ID,
+		Type:          raw.Type,
+		SchemaVersion: raw.SchemaVersion,
+		OccurredAt:    raw.OccurredAt,
+		QueueID:       raw.QueueID,
+		Ticket:        raw.Ticket,
+		Payload:       p,
+		Replay:        raw.Replay,
+	}
+	return nil
+}
+
+// Decode parses a JSON encoded envelope, native or CloudEvent.
+func Decode(b []byte) (*Envelope, error) {
+	var probe struct {
+		SpecVersion string `json:"specversion"`
+	}
+	if err := json.Unmarshal(b, &probe); err != nil {
+		return nil, err
+	}
+	if probe.SpecVersion != "" {
+		ce := &CloudEvent{}
+		if err := json.Unmarshal(b, ce); err != nil {
+			return nil, err
+		}
+		return ce.Envelope()
+	}
+	e := &Envelope{}
+	if err := json.Unmarshal(b, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Fields flattens the envelope for a Redis stream entry, whose values are
+// flat strings: the ticket snapshot and payload are JSON encoded.
+func (e *Envelope) Fields() (map[string]interface{}, error) {
+	data, err := e.data()
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{
+		"id":             e.ID,
+		"type":           string(e.Type),
+		"schema_version": e.SchemaVersion,
+		"occurred_at":    e.OccurredAt.UTC().Format(time.RFC3339Nano),
+		"queue_id":       e.QueueID,
+		"data":           string(data),
+	}
+	if e.Ticket != nil {
+		b, err := json.Marshal(e.Ticket)
+		if err != nil {
+			return nil, err
+		}
+		fields["ticket"] = string(b)
+	}
+	if e.Replay {
+		fields["replay"] = "true"
+	}
+	return fields, nil
+}
+
+// FromFields decodes a stream entry written by Envelope.Fields or
+// Encoding.Fields, in either format.
+func FromFields(fields map[string]interface{}) (*Envelope, error) {
+	str := func(k string) string {
+		if v, ok := fields[k]; ok {
+			return fmt.Sprint(v)
+		}
+		return ""
+	}
+	if ce := str(cloudEventField); ce != "" {
+		return Decode([]byte(ce))
+	}
+	e := &Envelope{ID: str("id"), Type: Type(str("type")), Replay: str("replay") == "true"}
+	if e.ID == "" || e.Type == "" {
+		return nil, fmt.Errorf("%w: missing id or type", ErrMalformedEvent)
+	}
+	if _, err := fmt.Sscan(str("schema_version"), &e.SchemaVersion); err != nil {
+		return nil, fmt.Errorf("%w: schema_version: %v", ErrMalformedEvent, err)
+	}
+	if _, err := fmt.Sscan(str("queue_id"), &e.QueueID); err != nil {
+		return nil, fmt.Errorf("%w: queue_id: %v", ErrMalformedEvent, err)
+	}
+	var err error
+	if e.OccurredAt, err = time.Parse(time.RFC3339Nano, str("occurred_at")); err != nil {
+		return nil, fmt.Errorf("%w: occurred_at: %v", ErrMalformedEvent, err)
+	}
+	if t := str("ticket"); t != "" {
+		e.Ticket = &Ticket{}
+		if err := json.Unmarshal([]byte(t), e.Ticket); err != nil {
+			return nil, fmt.Errorf("%w: ticket: %v", ErrMalformedEvent, err)
+		}
+	}
+	if e.Payload, err = decodePayload(e.SchemaVersion, e.Type, json.RawMessage(str("data"))); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *Envelope) data() (json.RawMessage, error) {
+	if e.Payload == nil {
+		return json.RawMessage(`{}`), nil
+	}
+	if u, ok := e.Payload.(Unknown); ok {
+		return u.Data, nil
+	}
+	return json.Marshal(e.Payload)
+}
+
+// decodePayload decodes data into the payload type registered for t. Types
+// this version does not know decode to Unknown so newer producers do not
+// break older consumers.
+func decodePayload(version int, t Type, data json.RawMessage) (Payload, error) {
+	if version < 1 || version > SchemaVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedSchema, version)
+	}
+	if len(data) == 0 {
+		data = json.RawMessage(`{}`)
+	}
+	decode, ok := payloads[t]
+	if !ok {
+		return Unknown{Type: t, Data: data}, nil
+	}
+	p, err := decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s data: %v", ErrMalformedEvent, t, err)
+	}
+	return p, nil
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err) // crypto/rand does not fail on supported platforms
+	}
+	return hex.EncodeToString(b)
+}
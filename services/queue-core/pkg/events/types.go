@@ -0,0 +1,129 @@
+package events
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Type names an event.
+type Type string
+
+const (
+	TicketCreated      Type = "ticket.created"
+	TicketReserved     Type = "ticket.reserved"
+	TicketClaimed      Type = "ticket.claimed"
+	TicketCompleted    Type = "ticket.completed"
+	TicketCancelled    Type = "ticket.cancelled"
+	TicketRequeued     Type = "ticket.requeued"
+	TicketDeadLettered Type = "ticket.dead_lettered"
+	TicketDiscarded    Type = "ticket.discarded"
+	TicketReconciled   Type = "ticket.reconciled"
+	WorkerUpdated      Type = "worker.updated"
+)
+
+// Payload is the type-specific part of an event.
+type Payload interface {
+	EventType() Type
+}
+
+// Created: a ticket joined its queue (the stream of new tickets).
+type Created struct {
+	Routed bool `json:"routed,omitempty"` // the queue was picked from the ticket's group
+}
+
+// Reserved: the dispatcher handed the ticket to stream workers.
+type Reserved struct {
+	Redelivery bool `json:"redelivery,omitempty"` // re-emitted by the reconciler
+}
+
+// Claimed: a pull worker claimed the ticket under a lease. Broadcast only.
+type Claimed struct {
+	WorkerID       int64     `json:"worker_id"`
+	LeaseExpiresAt time.Time `json:"lease_expires_at"`
+}
+
+// Completed: the ticket was served and archived.
+type Completed struct {
+	WorkerID  *int64 `json:"worker_id,omitempty"`
+	CounterID *int64 `json:"counter_id,omitempty"`
+}
+
+// Cancelled: the ticket left the queue unserved.
+type Cancelled struct {
+	Reason      string `json:"reason"`
+	CancelledBy string `json:"cancelled_by"`
+}
+
+// Requeued: a failed delivery sent the ticket back to waiting.
+type Requeued struct {
+	Reason      string     `json:"reason"`
+	AvailableAt *time.Time `json:"available_at,omitempty"` // retry backoff
+}
+
+// DeadLettered: the ticket ran out of attempts and waits for staff.
+type DeadLettered struct {
+	Reason string `json:"reason"`
+}
+
+// Discarded: staff dropped a dead-lettered ticket.
+type Discarded struct{}
+
+// Reconciled: the reconciler repaired a divergence between Postgres and the
+// queue stream.
+type Reconciled struct {
+	Action  string `json:"action"` // reemitted, requeued or acked
+	Reason  string `json:"reason"`
+	EntryID string `json:"entry_id,omitempty"` // acked stream entry
+	Group   string `json:"group,omitempty"`    // consumer group of the acked entry
+}
+
+// WorkerUpdate: a worker reported computed values for a ticket, e.g. a
+// better service time estimate.
+type WorkerUpdate struct {
+	WorkerID      int64             `json:"worker_id"`
+	EstimatedTime *int              `json:"estimated_time,omitempty"` // seconds
+	Fields        map[string]string `json:"fields,omitempty"`         // anything else the worker computed
+}
+
+// Unknown carries the raw payload of a type this version does not know.
+type Unknown struct {
+	Type Type
+	Data json.RawMessage
+}
+
+func (Created) EventType() Type      { return TicketCreated }
+func (Reserved) EventType() Type     { return TicketReserved }
+func (Claimed) EventType() Type      { return TicketClaimed }
+func (Completed) EventType() Type    { return TicketCompleted }
+func (Cancelled) EventType() Type    { return TicketCancelled }
+func (Requeued) EventType() Type     { return TicketRequeued }
+func (DeadLettered) EventType() Type { return TicketDeadLettered }
+func (Discarded) EventType() Type    { return TicketDiscarded }
+func (Reconciled) EventType() Type   { return TicketReconciled }
+func (WorkerUpdate) EventType() Type { return WorkerUpdated }
+func (u Unknown) EventType() Type    { return u.Type }
+
+var payloads = map[Type]func(json.RawMessage) (Payload, error){
+	TicketCreated:      decodeAs[Created],
+	TicketReserved:     decodeAs[Reserved],
+	TicketClaimed:      decodeAs[Claimed],
+	TicketCompleted:    decodeAs[Completed],
+	TicketCancelled:    decodeAs[Cancelled],
+	TicketRequeued:     decodeAs[Requeued],
+	TicketDeadLettered: decodeAs[DeadLettered],
+	TicketDiscarded:    decodeAs[Discarded],
+	TicketReconciled:   decodeAs[Reconciled],
+	WorkerUpdated:      decodeAs[WorkerUpdate],
+}
+
+func decodeAs[T Payload](data json.RawMessage) (Payload, error) {
+	var p T
+	err := json.Unmarshal(data, &p)
+	return p, err
+}
+
+// Known reports whether t is an event type this package defines.
+func (t Type) Known() bool {
+	_, ok := payloads[t]
+	return ok
+}
@@ -0,0 +1,78 @@
+// Package webhook holds what receivers of queue-core webhooks need: the
+// request headers and signature verification.
+//
+// Every delivery carries SignatureHeader, "t=<unix seconds>,v1=<hex>", where
+// v1 is HMAC-SHA256 over "<t>.<body>" keyed with the subscription secret.
+// Signing the timestamp lets receivers reject replayed requests.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SignatureHeader = "X-Queue-Signature"
+	EventIDHeader   = "X-Queue-Event-Id"
+	EventTypeHeader = "X-Queue-Event-Type"
+	DeliveryHeader  = "X-Queue-Delivery-Id" // the same on every retry of a delivery
+
+	// DefaultTolerance is how old a signature Verify accepts by default.
+	DefaultTolerance = 5 * time.Minute
+)
+
+var (
+	ErrMissingSignature = errors.New("missing or malformed webhook signature")
+	ErrBadSignature     = errors.New("webhook signature does not match")
+	ErrStaleSignature   = errors.New("webhook signature timestamp outside tolerance")
+)
+
+// Sign returns the SignatureHeader value for body sent at ts.
+func Sign(secret string, ts time.Time, body []byte) string {
+	t := strconv.FormatInt(ts.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", t, mac(secret, t, body))
+}
+
+// Verify checks a SignatureHeader value against body. Signatures older or
+// newer than tolerance relative to now are rejected; tolerance 0 means
+// DefaultTolerance.
+func Verify(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	if tolerance == 0 {
+		tolerance = DefaultTolerance
+	}
+	var t, sig string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			t = v
+		case "v1":
+			sig = v
+		}
+	}
+	unix, err := strconv.ParseInt(t, 10, 64)
+	if err != nil || sig == "" {
+		return ErrMissingSignature
+	}
+	if d := now.Sub(time.Unix(unix, 0)); d > tolerance || d < -tolerance {
+		return ErrStaleSignature
+	}
+	if !hmac.Equal([]byte(sig), []byte(mac(secret, t, body))) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+func mac(secret, t string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(t))
+	h.Write([]byte("."))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"testing"
+	"queue-core/internal/bus"
 	"queue-core/internal/db"
 	"queue-core/internal/models"
 	"queue-core/internal/repositories"
@@ -22,7 +23,7 @@ func TestCreateAndReserveTicket(t *testing.T) {
 
 	rdb := db.NewRedisClient().Client
 	repo := repositories.NewTicketRepo(dbConn)
-	service := services.NewTicketService(repo, rdb, "queue.stream", "queue.%d.broadcast")
+	service := services.NewTicketService(repo, bus.NewRedis(rdb), "queue.stream", "queue.%d.broadcast")
 
 	ctx := context.Background()
 	ticket := &models.Ticket{
@@ -0,0 +1,88 @@
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"queue-core/internal/db"
+	"queue-core/internal/models"
+	"queue-core/internal/repositories"
+	"queue-core/internal/services"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// strategyQueueID keeps these tickets away from real queues.
+const strategyQueueID = 9004
+
+// TestDispatchStrategies_ReservationOrder drains the same set of tickets
+// with each strategy and checks the order they are called in.
+func TestDispatchStrategies_ReservationOrder(t *testing.T) {
+	connStr := os.Getenv("DATABASE_URL")
+	if connStr == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	dbConn, err := db.Connect(connStr)
+	assert.NoError(t, err)
+	defer dbConn.Close()
+	repo := repositories.NewTicketRepo(dbConn)
+	ctx := context.Background()
+
+	// created in this order
+	tickets := []*models.Ticket{
+		{CustomerName: "p1", Lane: "priority", Priority: 1, EstimatedTime: 200},
+		{CustomerName: "p2", Lane: "priority", Priority: 3, Attributes: models.Attributes{"service": "tax"}},
+		{CustomerName: "s1", Lane: "standard", Priority: 1, EstimatedTime: 300, Attributes: models.Attributes{"service": "visa"}},
+		{CustomerName: "s2", Lane: "standard", Priority: 3, EstimatedTime: 60},
+		{CustomerName: "s3", Lane: "standard", Priority: 2, EstimatedTime: 120},
+	}
+
+	tests := []struct {
+		strategy string
+		config   string
+		want     []string
+	}{
+		{strategy: services.StrategyFIFO, want: []string{"p1", "p2", "s1", "s2", "s3"}},
+		{strategy: services.StrategyPriority, want: []string{"p2", "s2", "s3", "p1", "s1"}},
+		// no estimate sorts last
+		{strategy: services.StrategyShortestJob, want: []string{"s2", "s3", "p1", "s1", "p2"}},
+		// two standard calls per priority call, by priority within a lane
+		{strategy: services.StrategyWeightedFair, config: `{"lanes": {"standard": 2, "priority": 1}}`, want: []string{"s2", "p2", "s3", "s1", "p1"}},
+		// the tax ticket stays waiting
+		{strategy: services.StrategySkillMatch, config: `{"skills": ["visa"], "attribute": "service"}`, want: []string{"p1", "s1", "s2", "s3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			clearQueue(t, dbConn, strategyQueueID)
+			for _, tk := range tickets {
+				tk := *tk
+				tk.QueueID, tk.Status = strategyQueueID, "waiting"
+				assert.NoError(t, repo.Create(ctx, &tk))
+			}
+			strategy, err := services.NewDispatchStrategy(tt.strategy, json.RawMessage(tt.config))
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			var got []string
+			for len(got) <= len(tickets) {
+				ticket, err := strategy.Next(ctx, repo, strategyQueueID)
+				if !assert.NoError(t, err) || ticket == nil {
+					break
+				}
+				got = append(got, ticket.CustomerName)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+	clearQueue(t, dbConn, strategyQueueID)
+}
+
+func clearQueue(t *testing.T, dbConn *sql.DB, queueID int64) {
+	_, err := dbConn.Exec(`DELETE FROM tickets WHERE queue_id=$1`, queueID)
+	assert.NoError(t, err)
+}
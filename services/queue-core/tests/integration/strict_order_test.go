@@ -0,0 +1,142 @@
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"queue-core/internal/db"
+	"queue-core/internal/models"
+	"queue-core/internal/repositories"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// strictQueueID keeps these tickets away from real queues.
+const strictQueueID = 9002
+
+// TestStrictOrder_UnderContention has several reservers drain a queue while
+// another writer keeps briefly locking the head ticket (as a cancel or
+// complete would). Without strict order a reserver skips the locked head and
+// calls a later ticket first; with it, no ticket is ever reserved while an
+// older one is still waiting.
+func TestStrictOrder_UnderContention(t *testing.T) {
+	connStr := os.Getenv("DATABASE_URL")
+	if connStr == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	dbConn, err := db.Connect(connStr)
+	assert.NoError(t, err)
+	defer dbConn.Close()
+	repo := repositories.NewTicketRepo(dbConn)
+	ctx := context.Background()
+
+	for _, strict := range []bool{false, true} {
+		clearStrictQueue(t, dbConn)
+		const n = 60
+		for i := 0; i < n; i++ {
+			assert.NoError(t, repo.Create(ctx, &models.Ticket{QueueID: strictQueueID, CustomerName: "Strict", Status: "waiting", Priority: 1}))
+		}
+
+		stop := make(chan struct{})
+		var interferer sync.WaitGroup
+		interferer.Add(1)
+		go func() {
+			defer interferer.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				lockHeadBriefly(ctx, dbConn)
+			}
+		}()
+
+		var (
+			mu         sync.Mutex
+			reserved   int
+			outOfOrder int
+			reservers  sync.WaitGroup
+		)
+		// without strict order a reserver finds nothing while the head is
+		// locked and only the rest are waiting behind it, so keep going
+		// until every ticket is reserved or the deadline passes
+		deadline := time.Now().Add(30 * time.Second)
+		for w := 0; w < 6; w++ {
+			reservers.Add(1)
+			go func() {
+				defer reservers.Done()
+				for {
+					ticket, err := repo.Reserve(ctx, strictQueueID, repositories.ReserveOptions{Strict: strict})
+					if !assert.NoError(t, err) {
+						return
+					}
+					if ticket == nil {
+						mu.Lock()
+						drained := reserved == n
+						mu.Unlock()
+						if drained || time.Now().After(deadline) {
+							return
+						}
+						time.Sleep(time.Millisecond)
+						continue
+					}
+					// an older ticket still waiting means this one was called first
+					var older int
+					assert.NoError(t, dbConn.QueryRow(`
+                        SELECT COUNT(*) FROM tickets
+                        WHERE queue_id=$1 AND status='waiting' AND (created_at, id) < ($2, $3)
+                    `, strictQueueID, ticket.CreatedAt, ticket.ID).Scan(&older))
+					mu.Lock()
+					reserved++
+					if older > 0 {
+						outOfOrder++
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+		reservers.Wait()
+		close(stop)
+		interferer.Wait()
+
+		assert.Equal(t, n, reserved)
+		if strict {
+			assert.Zero(t, outOfOrder, "strict order called a ticket before an older waiting one")
+		} else {
+			t.Logf("without strict order: %d of %d tickets called ahead of an older one", outOfOrder, n)
+		}
+	}
+	clearStrictQueue(t, dbConn)
+}
+
+// lockHeadBriefly holds a row lock on the queue's head ticket for a moment.
+func lockHeadBriefly(ctx context.Context, dbConn *sql.DB) {
+	tx, err := dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	var id int64
+	err = tx.QueryRow(`
+        SELECT id FROM tickets
+        WHERE queue_id=$1 AND status='waiting'
+        ORDER BY created_at, id
+        LIMIT 1
+        FOR UPDATE
+    `, strictQueueID).Scan(&id)
+	if err != nil {
+		time.Sleep(time.Millisecond)
+		return
+	}
+	time.Sleep(3 * time.Millisecond)
+}
+
+func clearStrictQueue(t *testing.T, dbConn *sql.DB) {
+	_, err := dbConn.Exec(`DELETE FROM tickets WHERE queue_id=$1`, strictQueueID)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,107 @@
+package integration
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"queue-core/internal/actor"
+	"queue-core/internal/db"
+	"queue-core/internal/models"
+	"queue-core/internal/repositories"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// timelineQueueID keeps these tickets away from real queues.
+const timelineQueueID = 9003
+
+// TestTimeline_SurvivesArchival walks a ticket through reserve, requeue,
+// reserve and complete, then reads its timeline after the archive trigger
+// has removed it from tickets.
+func TestTimeline_SurvivesArchival(t *testing.T) {
+	connStr := os.Getenv("DATABASE_URL")
+	if connStr == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	dbConn, err := db.Connect(connStr)
+	assert.NoError(t, err)
+	defer dbConn.Close()
+	repo := repositories.NewTicketRepo(dbConn)
+	ctx := context.Background()
+	_, err = dbConn.Exec(`DELETE FROM tickets WHERE queue_id=$1`, timelineQueueID)
+	assert.NoError(t, err)
+
+	ticket := &models.Ticket{QueueID: timelineQueueID, CustomerName: "Timeline", Status: "waiting", Priority: 1}
+	assert.NoError(t, repo.Create(ctx, ticket))
+	reserved, err := repo.ReserveNext(ctx, timelineQueueID)
+	assert.NoError(t, err)
+	_, err = repo.RequeueToWaiting(ctx, reserved.ID, repositories.RetryPolicy{})
+	assert.NoError(t, err)
+	reserved, err = repo.ReserveNext(ctx, timelineQueueID)
+	assert.NoError(t, err)
+	worker := int64(11)
+	ok, _, err := repo.Complete(ctx, reserved.ID, reserved.Version, nil, &worker)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	timeline, err := repo.Timeline(ctx, ticket.ID)
+	assert.NoError(t, err)
+	var kinds []string
+	for _, e := range timeline {
+		kinds = append(kinds, e.Kind)
+	}
+	assert.Equal(t, []string{"created", "reserved", "requeued", "reserved", "done"}, kinds)
+	last := timeline[len(timeline)-1]
+	assert.Equal(t, "worker:11", last.Actor)
+	assert.Equal(t, "processing", *last.FromStatus)
+
+	_, err = dbConn.Exec(`DELETE FROM ticket_events WHERE ticket_id=$1`, ticket.ID)
+	assert.Error(t, err, "ticket_events is append-only")
+}
+
+// TestTimeline_NamesTheCallingActor has staff complete a ticket a worker was
+// serving: the event names the staff member, not the assigned worker the
+// trigger would otherwise infer.
+func TestTimeline_NamesTheCallingActor(t *testing.T) {
+	connStr := os.Getenv("DATABASE_URL")
+	if connStr == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	dbConn, err := db.Connect(connStr)
+	assert.NoError(t, err)
+	defer dbConn.Close()
+	repo := repositories.NewTicketRepo(dbConn)
+	ctx := context.Background()
+	_, err = dbConn.Exec(`DELETE FROM tickets WHERE queue_id=$1`, timelineQueueID)
+	assert.NoError(t, err)
+
+	ticket := &models.Ticket{QueueID: timelineQueueID, CustomerName: "Timeline", Status: "waiting", Priority: 1}
+	assert.NoError(t, repo.Create(actor.WithContext(ctx, actor.Actor{Role: actor.RoleCustomer}), ticket))
+	reserved, err := repo.ReserveNext(ctx, timelineQueueID)
+	assert.NoError(t, err)
+	worker := int64(11)
+	staff := actor.WithContext(ctx, actor.Actor{Role: actor.RoleStaff, ID: "ops-1"})
+	ok, _, err := repo.Complete(staff, reserved.ID, reserved.Version, nil, &worker)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	timeline, err := repo.Timeline(ctx, ticket.ID)
+	assert.NoError(t, err)
+	if len(timeline) < 2 {
+		t.Fatalf("timeline has %d events", len(timeline))
+	}
+	assert.Equal(t, "customer", timeline[0].Actor)
+	last := timeline[len(timeline)-1]
+	assert.Equal(t, "done", last.Kind)
+	assert.Equal(t, "staff:ops-1", last.Actor)
+
+	// a kiosk-issued ticket names the kiosk, not the system
+	kiosk := &models.Ticket{QueueID: timelineQueueID, CustomerName: "Walk-in", Status: "waiting", Priority: 1}
+	assert.NoError(t, repo.Create(actor.WithContext(ctx, actor.Actor{Role: actor.RoleKiosk}), kiosk))
+	timeline, err = repo.Timeline(ctx, kiosk.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, timeline, 1) {
+		assert.Equal(t, "kiosk", timeline[0].Actor)
+	}
+}
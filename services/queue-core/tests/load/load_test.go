@@ -7,6 +7,7 @@ import (
 	"testing"
 	"strconv"
 	"time"
+	"queue-core/internal/bus"
 	"queue-core/internal/db"
 	"queue-core/internal/models"
 	"queue-core/internal/repositories"
@@ -28,7 +29,7 @@ func TestConcurrentTicketCreation(t *testing.T) {
 
 	rdb := db.NewRedisClient().Client
 	repo := repositories.NewTicketRepo(dbConn)
-	service := services.NewTicketService(repo, rdb, "queue.stream", "queue.%d.broadcast")
+	service := services.NewTicketService(repo, bus.NewRedis(rdb), "queue.stream", "queue.%d.broadcast")
 
 	ctx := context.Background()
 	const n = 100 // number of concurrent requests
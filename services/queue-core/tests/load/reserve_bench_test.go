@@ -0,0 +1,81 @@
+package load
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	"queue-core/internal/db"
+	"queue-core/internal/models"
+	"queue-core/internal/repositories"
+)
+
+// benchQueueID keeps benchmark tickets away from real queues.
+const benchQueueID = 9001
+
+// Compare reservation throughput one ticket per transaction against batches:
+//
+//	DATABASE_URL=... go test ./tests/load -run '^$' -bench Reserve
+func BenchmarkReserveNext(b *testing.B) {
+	benchmarkReserve(b, 1)
+}
+
+func BenchmarkReserveBatch10(b *testing.B) {
+	benchmarkReserve(b, 10)
+}
+
+func BenchmarkReserveBatch50(b *testing.B) {
+	benchmarkReserve(b, 50)
+}
+
+func benchmarkReserve(b *testing.B, batch int) {
+	connStr := os.Getenv("DATABASE_URL")
+	if connStr == "" {
+		b.Skip("DATABASE_URL not set, skipping benchmark")
+	}
+	dbConn, err := db.Connect(connStr)
+	if err != nil {
+		b.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer dbConn.Close()
+
+	repo := repositories.NewTicketRepo(dbConn)
+	ctx := context.Background()
+	clearBenchQueue(b, dbConn)
+	defer clearBenchQueue(b, dbConn)
+
+	// seed b.N waiting tickets outside the timer
+	for i := 0; i < b.N; i++ {
+		t := &models.Ticket{QueueID: benchQueueID, CustomerName: "Bench", Status: "waiting", Priority: 1}
+		if err := repo.Create(ctx, t); err != nil {
+			b.Fatalf("seed: %v", err)
+		}
+	}
+
+	b.ResetTimer()
+	reserved := 0
+	for reserved < b.N {
+		if batch == 1 {
+			t, err := repo.ReserveNext(ctx, benchQueueID)
+			if err != nil || t == nil {
+				b.Fatalf("reserve: %v", err)
+			}
+			reserved++
+			continue
+		}
+		tickets, err := repo.ReserveBatch(ctx, benchQueueID, batch)
+		if err != nil || len(tickets) == 0 {
+			b.Fatalf("reserve batch: %v", err)
+		}
+		reserved += len(tickets)
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(reserved)/b.Elapsed().Seconds(), "tickets/s")
+}
+
+func clearBenchQueue(b *testing.B, dbConn *sql.DB) {
+	if _, err := dbConn.Exec(`DELETE FROM tickets WHERE queue_id=$1`, benchQueueID); err != nil {
+		b.Fatalf("cleanup: %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"queue-core/internal/api"
+	"queue-core/internal/bus"
+	"queue-core/internal/repositories"
+	"queue-core/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// closeSession calls the staff-only session close from remoteAddr with the
+// given actor role header.
+func closeSession(handler http.Handler, remoteAddr, role string) int {
+	req := httptest.NewRequest(http.MethodPost, "/queues/7/close", nil)
+	req.RemoteAddr = remoteAddr
+	if role != "" {
+		req.Header.Set("X-Actor-Role", role)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestAPI_ActorHeadersOnlyFromTrustedProxies(t *testing.T) {
+	b := bus.NewMemory()
+	service := services.NewTicketService(repositories.NewMemoryTicketRepo(), b, "queue.stream", "queue.%d.broadcast")
+	a := api.NewAPI(service, b, "queue.stream", "queue.%d.broadcast")
+	a.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	handler := a.Router()
+
+	assert.Equal(t, http.StatusOK, closeSession(handler, "10.1.2.3:5000", "staff"))
+	assert.Equal(t, http.StatusForbidden, closeSession(handler, "203.0.113.9:5000", "staff"), "anyone else is a kiosk")
+	assert.Equal(t, http.StatusForbidden, closeSession(handler, "10.1.2.3:5000", ""))
+	assert.Equal(t, http.StatusForbidden, closeSession(handler, "10.1.2.3:5000", "system"), "the system role is never asserted by headers")
+	assert.Equal(t, http.StatusForbidden, closeSession(handler, "10.1.2.3:5000", "root"))
+
+	// with no trusted proxies configured, headers count for nothing
+	a.TrustedProxies = nil
+	assert.Equal(t, http.StatusForbidden, closeSession(a.Router(), "10.1.2.3:5000", "staff"))
+}
+
+func TestAPI_WorkerCapacityOnlyFromWorkerOrStaff(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	b := bus.NewMemory()
+	service := services.NewTicketService(repositories.NewMemoryTicketRepo(), b, "queue.stream", "queue.%d.broadcast")
+	service.CapacityPolicy = &services.CapacityPolicy{Workers: repositories.NewWorkerRepo(db), HeartbeatTTL: time.Minute}
+	a := api.NewAPI(service, b, "queue.stream", "queue.%d.broadcast")
+	a.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	handler := a.Router()
+
+	call := func(method, path, body, role, id string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.RemoteAddr = "10.1.2.3:5000"
+		req.Header.Set("X-Actor-Role", role)
+		req.Header.Set("X-Actor-ID", id)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	heartbeat := `{"worker_id": 11, "queue_id": 1, "capacity": 2}`
+
+	assert.Equal(t, http.StatusForbidden, call(http.MethodPost, "/workers/heartbeat", heartbeat, "kiosk", ""))
+	assert.Equal(t, http.StatusForbidden, call(http.MethodPost, "/workers/heartbeat", heartbeat, "worker", "12"), "a worker cannot offer another's capacity")
+	assert.Equal(t, http.StatusForbidden, call(http.MethodDelete, "/workers/11?queue_id=1", "", "worker", "12"), "nor take it offline")
+
+	mock.ExpectQuery("INSERT INTO workers").WithArgs(int64(11), int64(1), nil, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"last_seen_at"}).AddRow(time.Now()))
+	assert.Equal(t, http.StatusOK, call(http.MethodPost, "/workers/heartbeat", heartbeat, "worker", "11"))
+	mock.ExpectExec("DELETE FROM workers").WithArgs(int64(11), int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.Equal(t, http.StatusNoContent, call(http.MethodDelete, "/workers/11?queue_id=1", "", "staff", "ops-1"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAPI_ClaimsAndLeasesTakeTheCallingWorker(t *testing.T) {
+	b := bus.NewMemory()
+	service := services.NewTicketService(repositories.NewMemoryTicketRepo(), b, "queue.stream", "queue.%d.broadcast")
+	a := api.NewAPI(service, b, "queue.stream", "queue.%d.broadcast")
+	a.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	handler := a.Router()
+
+	call := func(path, role, id string) int {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.RemoteAddr = "10.1.2.3:5000"
+		req.Header.Set("X-Actor-Role", role)
+		req.Header.Set("X-Actor-ID", id)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, call("/queues/3/claim", "kiosk", ""))
+	assert.Equal(t, http.StatusForbidden, call("/queues/3/claim?worker_id=8", "worker", "7"), "a worker cannot claim for another")
+	assert.Equal(t, http.StatusForbidden, call("/tickets/5/ack?worker_id=8", "worker", "7"), "nor ack its lease")
+	assert.Equal(t, http.StatusForbidden, call("/tickets/5/nack", "staff", "ops-1"))
+
+	// the caller's own id gets through to the service (queue 3 is pushed)
+	assert.Equal(t, http.StatusConflict, call("/queues/3/claim?worker_id=7", "worker", "7"))
+	assert.Equal(t, http.StatusConflict, call("/queues/3/claim", "worker", "7"))
+}
@@ -0,0 +1,82 @@
+package unit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"queue-core/internal/bus"
+	"queue-core/internal/models"
+	"queue-core/internal/repositories"
+	"queue-core/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// batchRows returns mock rows shaped like ReserveMany's RETURNING: the
+// ticket columns plus the pick position, in the given (arbitrary) order.
+func batchRows(picks map[int64]int, tickets ...*models.Ticket) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "queue_id", "customer_id", "customer_name", "status", "priority",
+		"priority_class", "lane", "attributes", "counter_id", "assigned_worker", "token",
+		"created_at", "updated_at", "estimated_time", "version", "attempts", "available_at", "group_id", "routing", "pick"})
+	for _, t := range tickets {
+		rows.AddRow(t.ID, t.QueueID, nil, t.CustomerName, "processing", t.Priority,
+			"", "standard", []byte("{}"), 0, 0, "",
+			t.CreatedAt, t.UpdatedAt, 0, t.Version, 0, nil, nil, nil, picks[t.ID])
+	}
+	return rows
+}
+
+func TestReserveBatch_ReturnsTicketsInPickOrder(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	repo := repositories.NewTicketRepo(db)
+
+	now := time.Now()
+	mock.ExpectQuery("FOR UPDATE SKIP LOCKED\\s+LIMIT \\$2").WithArgs(1, 3).
+		WillReturnRows(batchRows(map[int64]int{10: 1, 11: 2, 12: 3},
+			&models.Ticket{ID: 12, QueueID: 1, CreatedAt: now, UpdatedAt: now, Version: 1},
+			&models.Ticket{ID: 10, QueueID: 1, CreatedAt: now, UpdatedAt: now, Version: 4},
+			&models.Ticket{ID: 11, QueueID: 1, CreatedAt: now, UpdatedAt: now, Version: 2}))
+
+	tickets, err := repo.ReserveBatch(context.Background(), 1, 3)
+	assert.NoError(t, err)
+	if assert.Len(t, tickets, 3) {
+		assert.Equal(t, []int64{10, 11, 12}, []int64{tickets[0].ID, tickets[1].ID, tickets[2].ID})
+		assert.Equal(t, int64(4), tickets[0].Version) // pre-update version, like ReserveNext
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStartDispatcher_BatchesDeepBacklog(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	rdb := redis.NewClient(&redis.Options{Addr: "localhost:99999", MaxRetries: -1})
+	defer rdb.Close()
+
+	service := services.NewTicketService(repositories.NewTicketRepo(db), bus.NewRedis(rdb), "queue.stream", "queue.%d.broadcast")
+	service.Batch = &services.BatchPolicy{Size: 3, Threshold: 4}
+
+	// four waiting reaches the threshold: a full batch, then a short one
+	now := time.Now()
+	mock.ExpectQuery("SELECT COUNT").WithArgs(int64(44), "waiting").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(4))
+	mock.ExpectQuery("FOR UPDATE SKIP LOCKED\\s+LIMIT \\$2").WithArgs(44, 3).
+		WillReturnRows(batchRows(map[int64]int{1: 1, 2: 2, 3: 3},
+			&models.Ticket{ID: 1, QueueID: 44, CreatedAt: now, UpdatedAt: now, Version: 1},
+			&models.Ticket{ID: 2, QueueID: 44, CreatedAt: now, UpdatedAt: now, Version: 1},
+			&models.Ticket{ID: 3, QueueID: 44, CreatedAt: now, UpdatedAt: now, Version: 1}))
+	mock.ExpectQuery("FOR UPDATE SKIP LOCKED\\s+LIMIT \\$2").WithArgs(44, 3).
+		WillReturnRows(batchRows(map[int64]int{4: 1},
+			&models.Ticket{ID: 4, QueueID: 44, CreatedAt: now, UpdatedAt: now, Version: 1}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	service.StartDispatcher(ctx, 44, time.Hour)
+
+	assert.Eventually(t, func() bool { return mock.ExpectationsWereMet() == nil }, 2*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,115 @@
+package unit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"queue-core/internal/bus"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRedisBus(t *testing.T) *bus.Redis {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return bus.NewRedis(rdb)
+}
+
+func TestRedisBus_BroadcastReachesSubscribers(t *testing.T) {
+	b := newRedisBus(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sub, err := b.Subscribe(ctx, "queue.7.broadcast")
+	assert.NoError(t, err)
+	defer sub.Close()
+
+	// the subscription is established asynchronously
+	assert.Eventually(t, func() bool {
+		assert.NoError(t, b.Broadcast(ctx, "queue.7.broadcast", []byte(`{"n":1}`)))
+		select {
+		case msg := <-sub.Messages():
+			return string(msg) == `{"n":1}`
+		case <-time.After(20 * time.Millisecond):
+			return false
+		}
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestRedisBus_EmitAndConsumerGroups(t *testing.T) {
+	b := newRedisBus(t)
+	ctx := context.Background()
+	assert.NoError(t, b.CreateGroup(ctx, "queue.stream.7", "workers", "0"))
+	assert.NoError(t, b.CreateGroup(ctx, "queue.stream.7", "workers", "0"), "creating twice is not an error")
+
+	assert.NoError(t, b.Emit(ctx, []bus.Event{
+		{Stream: "queue.stream.7", Fields: map[string]interface{}{"n": "1"}},
+		{Stream: "queue.stream.7", Fields: map[string]interface{}{"n": "2"}},
+		{Channel: "queue.7.broadcast", Payload: []byte("only broadcast")},
+	}))
+	n, err := b.Len(ctx, "queue.stream.7")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	msgs, err := b.ReadGroup(ctx, "queue.stream.7", "workers", "w1", 10, 0)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 2)
+	assert.Equal(t, "1", msgs[0].Values["n"])
+
+	groups, err := b.Groups(ctx, "queue.stream.7")
+	assert.NoError(t, err)
+	assert.Len(t, groups, 1)
+	assert.Equal(t, "workers", groups[0].Name)
+	assert.Equal(t, int64(2), groups[0].Pending)
+	assert.Equal(t, msgs[1].ID, groups[0].LastDeliveredID)
+
+	pending, err := b.Pending(ctx, "queue.stream.7", "workers", 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, pending, 2)
+	assert.Equal(t, "w1", pending[0].Consumer)
+
+	acked, err := b.Ack(ctx, "queue.stream.7", "workers", msgs[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), acked)
+
+	// nothing new to deliver
+	msgs2, err := b.ReadGroup(ctx, "queue.stream.7", "workers", "w1", 10, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, msgs2)
+
+	groups, err = b.Groups(ctx, "queue.stream.missing")
+	assert.NoError(t, err)
+	assert.Empty(t, groups)
+}
+
+func TestRedisBus_EntriesAndTrim(t *testing.T) {
+	b := newRedisBus(t)
+	ctx := context.Background()
+	var ids []string
+	for i := 0; i < 5; i++ {
+		id, err := b.Publish(ctx, "queue.stream.7", map[string]interface{}{"n": i}, bus.Trim{})
+		assert.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	removed, err := b.TrimBefore(ctx, "queue.stream.7", ids[2])
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), removed)
+
+	entries, err := b.Entries(ctx, "queue.stream.7", []string{ids[0], ids[3]})
+	assert.NoError(t, err)
+	assert.Nil(t, entries[0], "trimmed")
+	assert.Equal(t, ids[3], entries[1].ID)
+
+	page, err := b.Range(ctx, "queue.stream.7", "("+ids[2], "+", 10)
+	assert.NoError(t, err)
+	assert.Len(t, page, 2)
+
+	streams, err := b.Streams(ctx, "queue.stream")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"queue.stream.7"}, streams)
+}
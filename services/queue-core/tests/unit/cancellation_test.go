@@ -0,0 +1,101 @@
+package unit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"queue-core/internal/actor"
+	"queue-core/internal/bus"
+	"queue-core/internal/models"
+	"queue-core/internal/repositories"
+	"queue-core/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTicketService_CancelTicket(t *testing.T) {
+	tests := []struct {
+		name        string
+		actor       actor.Actor
+		token       string
+		reason      string
+		wantReason  string
+		wantBy      string
+		wantErr     error
+		expectWrite bool
+	}{
+		{name: "customer with token", actor: actor.Actor{Role: actor.RoleKiosk}, token: "secret", reason: "no_show",
+			wantReason: models.ReasonCustomerRequest, wantBy: "customer", expectWrite: true},
+		{name: "customer with wrong token", actor: actor.Actor{Role: actor.RoleKiosk}, token: "guess", wantErr: services.ErrInvalidTicketToken},
+		{name: "staff with reason", actor: actor.Actor{Role: actor.RoleStaff}, reason: models.ReasonNoShow,
+			wantReason: models.ReasonNoShow, wantBy: "staff", expectWrite: true},
+		{name: "staff without reason", actor: actor.Actor{Role: actor.RoleStaff}, wantErr: services.ErrInvalidCancelReason},
+		{name: "system admission failure", actor: actor.System, reason: models.ReasonAdmissionFailed,
+			wantReason: models.ReasonAdmissionFailed, wantBy: "system", expectWrite: true},
+		{name: "system session close", actor: actor.System, reason: models.ReasonSessionClosed,
+			wantReason: models.ReasonSessionClosed, wantBy: "system", expectWrite: true},
+		{name: "system with staff reason", actor: actor.System, reason: models.ReasonNoShow, wantErr: services.ErrInvalidCancelReason},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+			rdb := redis.NewClient(&redis.Options{Addr: "localhost:99999"})
+			defer rdb.Close()
+
+			service := services.NewTicketService(repositories.NewTicketRepo(db), bus.NewRedis(rdb), "queue.stream", "queue.%d.broadcast")
+
+			now := time.Now()
+			mock.ExpectQuery("FROM tickets WHERE id").WithArgs(int64(5)).
+				WillReturnRows(ticketRows(&models.Ticket{ID: 5, QueueID: 1, Status: "waiting", Lane: "standard",
+					Token: "secret", CreatedAt: now, UpdatedAt: now, Version: 1}))
+			if tt.expectWrite {
+				// the canceller is recorded in ticket_events via queue_core.actor
+				mock.ExpectBegin()
+				mock.ExpectExec("set_config\\('queue_core.actor'").WithArgs(tt.wantBy).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery("SET status='cancelled'").WithArgs(int64(5), tt.wantReason, tt.wantBy).
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2))
+				mock.ExpectCommit()
+			}
+
+			ctx, cancel := context.WithTimeout(actor.WithContext(context.Background(), tt.actor), 100*time.Millisecond)
+			defer cancel()
+			err = service.CancelTicket(ctx, 5, tt.token, tt.reason)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestTicketService_CloseSessionNamesStaff(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	rdb := redis.NewClient(&redis.Options{Addr: "localhost:99999", MaxRetries: -1})
+	defer rdb.Close()
+	service := services.NewTicketService(repositories.NewTicketRepo(db), bus.NewRedis(rdb), "queue.stream", "queue.%d.broadcast")
+
+	// cancelled_by stays system; ticket_events names who closed the session
+	now := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectExec("set_config\\('queue_core.actor'").WithArgs("staff:ops-1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SET status='cancelled'").WithArgs(int64(1), models.ReasonSessionClosed, "system").
+		WillReturnRows(ticketRows(&models.Ticket{ID: 5, QueueID: 1, Status: "cancelled", Lane: "standard", CreatedAt: now, UpdatedAt: now, Version: 2}))
+	mock.ExpectCommit()
+
+	ctx, cancel := context.WithTimeout(actor.WithContext(context.Background(), actor.Actor{Role: actor.RoleStaff, ID: "ops-1"}), 100*time.Millisecond)
+	defer cancel()
+	n, err := service.CloseSession(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,184 @@
+package unit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"queue-core/internal/bus"
+	"queue-core/internal/models"
+	"queue-core/internal/repositories"
+	"queue-core/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func newClaimService(t *testing.T) (*services.TicketService, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	rdb := redis.NewClient(&redis.Options{Addr: "localhost:99999", MaxRetries: -1})
+	t.Cleanup(func() { rdb.Close() })
+	service := services.NewTicketService(repositories.NewTicketRepo(db), bus.NewRedis(rdb), "queue.stream", "queue.%d.broadcast")
+	service.Queues = repositories.NewQueueRepo(db)
+	return service, mock
+}
+
+// expectQueueMode answers the claim's queue rules lookup for queue 3.
+func expectQueueMode(mock sqlmock.Sqlmock, mode string) {
+	now := time.Now()
+	mock.ExpectQuery("FROM queues").WithArgs(int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "max_active_per_customer", "dispatch_strategy", "dispatch_config", "strict_order", "dispatch_mode", "created_at", "updated_at"}).
+			AddRow(3, "Returns", 0, "fifo", []byte("{}"), false, mode, now, now))
+}
+
+func TestClaimTicket_WaitsThenLeases(t *testing.T) {
+	service, mock := newClaimService(t)
+	wakeups := &fakeWakeups{ch: make(chan struct{}, 1)}
+	service.Wakeups = wakeups
+
+	// nothing waiting at first
+	expectQueueMode(mock, models.DispatchPull)
+	mock.ExpectBegin()
+	mock.ExpectQuery("FOR UPDATE SKIP LOCKED").WillReturnRows(ticketRows())
+	mock.ExpectRollback()
+	// the notification brings a ticket, leased to worker 7 for 90s
+	now := time.Now()
+	expires := now.Add(90 * time.Second)
+	expectQueueMode(mock, models.DispatchPull)
+	mock.ExpectBegin()
+	mock.ExpectQuery("FOR UPDATE SKIP LOCKED").
+		WillReturnRows(ticketRows(&models.Ticket{ID: 5, QueueID: 3, Status: "waiting", Lane: "standard", CreatedAt: now, UpdatedAt: now, Version: 2}))
+	mock.ExpectQuery("lease_expires_at=NOW").WithArgs(int64(5), int64(7), 90.0).
+		WillReturnRows(sqlmock.NewRows([]string{"lease_expires_at"}).AddRow(expires))
+	mock.ExpectCommit()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		wakeups.ch <- struct{}{}
+	}()
+	got, err := service.ClaimTicket(context.Background(), 3, 7, 90*time.Second, time.Minute)
+	assert.NoError(t, err)
+	if assert.NotNil(t, got) {
+		assert.Equal(t, int64(5), got.ID)
+		assert.Equal(t, int64(7), got.AssignedWorker)
+		assert.Equal(t, models.StatusProcessing, got.Status)
+		assert.Equal(t, int64(3), got.Version)
+		assert.Equal(t, expires, *got.LeaseExpiresAt)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestClaimTicket_ReturnsNilWhenWaitElapses(t *testing.T) {
+	service, mock := newClaimService(t)
+
+	expectQueueMode(mock, models.DispatchPull)
+	mock.ExpectBegin()
+	mock.ExpectQuery("FOR UPDATE SKIP LOCKED").WillReturnRows(ticketRows())
+	mock.ExpectRollback()
+	expectQueueMode(mock, models.DispatchPull)
+	mock.ExpectBegin()
+	mock.ExpectQuery("FOR UPDATE SKIP LOCKED").WillReturnRows(ticketRows())
+	mock.ExpectRollback()
+
+	got, err := service.ClaimTicket(context.Background(), 3, 7, time.Minute, 20*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestClaimTicket_RejectsPushQueue(t *testing.T) {
+	service, mock := newClaimService(t)
+
+	// the queue's dispatcher owns it: no reservation is attempted
+	expectQueueMode(mock, models.DispatchPush)
+
+	got, err := service.ClaimTicket(context.Background(), 3, 7, time.Minute, time.Minute)
+	assert.ErrorIs(t, err, services.ErrPushDispatched)
+	assert.Nil(t, got)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestClaimTicket_CancelsTicketOverCustomerLimit(t *testing.T) {
+	service, mock := newClaimService(t)
+	now := time.Now()
+	customer := int64(9)
+
+	// the queue's limit was lowered to 1 while customer 9 held two tickets
+	mock.ExpectQuery("FROM queues").WithArgs(int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "max_active_per_customer", "dispatch_strategy", "dispatch_config", "strict_order", "dispatch_mode", "created_at", "updated_at"}).
+			AddRow(3, "Returns", 1, "fifo", []byte("{}"), false, models.DispatchPull, now, now))
+	mock.ExpectBegin()
+	mock.ExpectQuery("FOR UPDATE SKIP LOCKED").
+		WillReturnRows(ticketRows(&models.Ticket{ID: 6, QueueID: 3, CustomerID: &customer, Status: "waiting", Lane: "standard", CreatedAt: now, UpdatedAt: now, Version: 1}))
+	mock.ExpectQuery("lease_expires_at=NOW").WithArgs(int64(6), int64(7), 60.0).
+		WillReturnRows(sqlmock.NewRows([]string{"lease_expires_at"}).AddRow(now.Add(time.Minute)))
+	mock.ExpectCommit()
+	// ticket 5 is ahead of it, so the system cancels ticket 6
+	mock.ExpectQuery("id<\\$3").WithArgs(int64(3), customer, int64(6)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectBegin()
+	mock.ExpectExec("set_config\\('queue_core.actor'").WithArgs("system").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SET status='cancelled'").WithArgs(int64(6), models.ReasonAdmissionFailed, "system").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+	mock.ExpectCommit()
+	// and claims again, finding nothing else
+	mock.ExpectQuery("FROM queues").WithArgs(int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "max_active_per_customer", "dispatch_strategy", "dispatch_config", "strict_order", "dispatch_mode", "created_at", "updated_at"}).
+			AddRow(3, "Returns", 1, "fifo", []byte("{}"), false, models.DispatchPull, now, now))
+	mock.ExpectBegin()
+	mock.ExpectQuery("FOR UPDATE SKIP LOCKED").WillReturnRows(ticketRows())
+	mock.ExpectRollback()
+
+	got, err := service.ClaimTicket(context.Background(), 3, 7, time.Minute, 0)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestClaimTicket_RequiresWorkerAndLease(t *testing.T) {
+	service, _ := newClaimService(t)
+
+	_, err := service.ClaimTicket(context.Background(), 3, 0, time.Minute, 0)
+	assert.ErrorIs(t, err, services.ErrInvalidClaim)
+	_, err = service.ClaimTicket(context.Background(), 3, 7, 0, 0)
+	assert.ErrorIs(t, err, services.ErrInvalidClaim)
+}
+
+func TestAckTicket_RejectsWorkerWithoutLease(t *testing.T) {
+	service, mock := newClaimService(t)
+
+	now := time.Now()
+	mock.ExpectQuery("FROM tickets").WithArgs(int64(5)).
+		WillReturnRows(ticketRows(&models.Ticket{ID: 5, QueueID: 3, Status: "processing", AssignedWorker: 7, CreatedAt: now, UpdatedAt: now, Version: 3}))
+	mock.ExpectQuery("SET status='done'").WithArgs(int64(5), int64(8)).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}))
+
+	err := service.AckTicket(context.Background(), 5, 8)
+	assert.ErrorIs(t, err, services.ErrLeaseNotHeld)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReapExpiredLeases_RequeuesTickets(t *testing.T) {
+	service, mock := newClaimService(t)
+	service.Retry = repositories.RetryPolicy{MaxAttempts: 3, BaseDelay: 10 * time.Second, MaxDelay: time.Minute}
+
+	// one ticket backs off, the other has used up its attempts
+	availableAt := time.Now().Add(20 * time.Second)
+	mock.ExpectQuery("lease_expires_at <= NOW").WithArgs(3, 10.0, 60.0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "queue_id", "status", "attempts", "available_at", "version", "assigned_worker"}).
+			AddRow(5, 3, "waiting", 2, availableAt, 4, 7).
+			AddRow(6, 3, "dead_letter", 3, nil, 2, 9))
+
+	requeued, err := service.ReapExpiredLeases(context.Background())
+	assert.NoError(t, err)
+	if assert.Len(t, requeued, 2) {
+		assert.Equal(t, int64(7), requeued[0].AssignedWorker)
+		assert.Equal(t, availableAt, *requeued[0].AvailableAt)
+		assert.Equal(t, models.StatusDeadLetter, requeued[1].Status)
+		assert.Nil(t, requeued[1].AvailableAt)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,114 @@
+package unit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"queue-core/internal/api"
+	"queue-core/internal/bus"
+	"queue-core/internal/models"
+	"queue-core/internal/repositories"
+	"queue-core/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTicketRepository_CreateWithCustomerLimit_RejectsDuplicate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	repo := repositories.NewTicketRepo(db)
+	customerID := int64(7)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("pg_advisory_xact_lock").WithArgs(customerID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COUNT").WithArgs(int64(1), customerID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectRollback()
+
+	ticket := &models.Ticket{QueueID: 1, CustomerID: &customerID, CustomerName: "Bob", Status: "waiting", Priority: 1}
+	err = repo.CreateWithCustomerLimit(context.Background(), ticket, 1)
+	assert.ErrorIs(t, err, repositories.ErrActiveTicketLimit)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCustomerService_ResolveReturnsExisting(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	service := services.NewCustomerService(repositories.NewCustomerRepo(db))
+	phone := "+60123456789"
+	now := time.Now()
+
+	mock.ExpectQuery("FROM customers").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "phone", "email", "external_id", "created_at", "updated_at"}).
+			AddRow(3, "Bob", phone, nil, nil, now, now))
+
+	c, err := service.Resolve(context.Background(), &models.Customer{Phone: &phone})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), c.ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	_, err = service.Resolve(context.Background(), &models.Customer{Name: "anonymous"})
+	assert.ErrorIs(t, err, services.ErrCustomerIdentityRequired)
+}
+
+func TestAPI_CustomerRecordsAreStaffOnly(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	b := bus.NewMemory()
+	tickets := repositories.NewMemoryTicketRepo()
+	a := api.NewAPI(services.NewTicketService(tickets, b, "queue.stream", "queue.%d.broadcast"), b, "queue.stream", "queue.%d.broadcast")
+	a.CustomerService = services.NewCustomerService(repositories.NewCustomerRepo(db))
+	a.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	handler := a.Router()
+
+	call := func(method, path, body string, staff bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if staff {
+			req.RemoteAddr = "10.1.2.3:5000"
+			req.Header.Set("X-Actor-Role", "staff")
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// a kiosk cannot read customer records or visit history
+	assert.Equal(t, http.StatusForbidden, call(http.MethodGet, "/customers/3", "", false).Code)
+	assert.Equal(t, http.StatusForbidden, call(http.MethodGet, "/customers/3/history", "", false).Code)
+
+	// and resolving an identity tells it the id, not the stored record
+	now := time.Now()
+	for range 2 {
+		mock.ExpectQuery("FROM customers").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "phone", "email", "external_id", "created_at", "updated_at"}).
+				AddRow(3, "Bob", "+60123456789", "bob@example.org", nil, now, now))
+	}
+	rec := call(http.MethodPost, "/customers", `{"phone":"+60123456789"}`, false)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"id":3}`, rec.Body.String())
+	rec = call(http.MethodPost, "/customers", `{"phone":"+60123456789"}`, true)
+	assert.Contains(t, rec.Body.String(), "bob@example.org")
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// nor can it link a ticket to a customer or pick its status
+	rec = call(http.MethodPost, "/tickets", `{"queue_id":1,"customer_id":3,"status":"processing"}`, false)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	waiting, err := tickets.ListByStatus(context.Background(), 1, "waiting", nil)
+	assert.NoError(t, err)
+	if assert.Len(t, waiting, 1) {
+		assert.Nil(t, waiting[0].CustomerID)
+	}
+}
@@ -0,0 +1,87 @@
+package unit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"queue-core/internal/models"
+	"queue-core/internal/repositories"
+	"queue-core/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequeueTicket_BacksOffThenDeadLetters(t *testing.T) {
+	service, mock := newClaimService(t)
+	service.Retry = repositories.RetryPolicy{MaxAttempts: 2, BaseDelay: 5 * time.Second, MaxDelay: time.Minute}
+	requeued := []string{"id", "queue_id", "status", "attempts", "available_at", "version"}
+
+	// first failure: back to waiting, not reservable for 5s
+	availableAt := time.Now().Add(5 * time.Second)
+	mock.ExpectQuery("SET attempts=attempts\\+1").WithArgs(int64(5), 2, 5.0, 60.0).
+		WillReturnRows(sqlmock.NewRows(requeued).AddRow(5, 3, "waiting", 1, availableAt, 4))
+	// second failure: out of attempts
+	mock.ExpectQuery("SET attempts=attempts\\+1").WithArgs(int64(5), 2, 5.0, 60.0).
+		WillReturnRows(sqlmock.NewRows(requeued).AddRow(5, 3, "dead_letter", 2, nil, 6))
+
+	got, err := service.RequeueTicket(context.Background(), 5, "worker_failure")
+	assert.NoError(t, err)
+	assert.Equal(t, models.StatusWaiting, got.Status)
+	assert.Equal(t, availableAt, *got.AvailableAt)
+
+	got, err = service.RequeueTicket(context.Background(), 5, "worker_failure")
+	assert.NoError(t, err)
+	assert.Equal(t, models.StatusDeadLetter, got.Status)
+	assert.Equal(t, 2, got.Attempts)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRequeueTicket_TellsMissingFromUnreserved(t *testing.T) {
+	service, mock := newClaimService(t)
+	requeued := []string{"id", "queue_id", "status", "attempts", "available_at", "version"}
+
+	mock.ExpectQuery("lease_expires_at IS NULL").WithArgs(int64(5), 5, 5.0, 300.0).WillReturnRows(sqlmock.NewRows(requeued))
+	mock.ExpectQuery("FROM tickets").WithArgs(int64(5)).WillReturnRows(ticketRows())
+	_, err := service.RequeueTicket(context.Background(), 5, "worker_failure")
+	assert.ErrorIs(t, err, services.ErrTicketNotFound)
+
+	// a claimed (leased) ticket is nacked by its worker, not requeued
+	now := time.Now()
+	mock.ExpectQuery("lease_expires_at IS NULL").WithArgs(int64(6), 5, 5.0, 300.0).WillReturnRows(sqlmock.NewRows(requeued))
+	mock.ExpectQuery("FROM tickets").WithArgs(int64(6)).
+		WillReturnRows(ticketRows(&models.Ticket{ID: 6, QueueID: 3, Status: "processing", AssignedWorker: 7, CreatedAt: now, UpdatedAt: now, Version: 2}))
+	_, err = service.RequeueTicket(context.Background(), 6, "worker_failure")
+	assert.ErrorIs(t, err, services.ErrTicketNotReserved)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRetryDeadLetter(t *testing.T) {
+	service, mock := newClaimService(t)
+	requeued := []string{"id", "queue_id", "status", "attempts", "available_at", "version"}
+
+	mock.ExpectQuery("WHERE id=\\$1 AND status='dead_letter'").WithArgs(int64(5)).
+		WillReturnRows(sqlmock.NewRows(requeued).AddRow(5, 3, "waiting", 0, nil, 7))
+	got, err := service.RetryDeadLetter(context.Background(), 5)
+	assert.NoError(t, err)
+	assert.Equal(t, models.StatusWaiting, got.Status)
+	assert.Equal(t, 0, got.Attempts)
+
+	// not (or no longer) dead-lettered
+	mock.ExpectQuery("WHERE id=\\$1 AND status='dead_letter'").WithArgs(int64(6)).
+		WillReturnRows(sqlmock.NewRows(requeued))
+	_, err = service.RetryDeadLetter(context.Background(), 6)
+	assert.ErrorIs(t, err, services.ErrNotDeadLettered)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDiscardDeadLetter(t *testing.T) {
+	service, mock := newClaimService(t)
+
+	mock.ExpectQuery("SET status='discarded'").WithArgs(int64(5)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "queue_id", "status", "attempts", "available_at", "version"}).
+			AddRow(5, 3, "discarded", 5, nil, 8))
+	assert.NoError(t, service.DiscardDeadLetter(context.Background(), 5))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,119 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"queue-core/internal/bus"
+	"queue-core/internal/metrics"
+	"queue-core/internal/models"
+	"queue-core/internal/repositories"
+	"queue-core/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeWakeups hands the dispatcher a channel the test signals directly.
+type fakeWakeups struct{ ch chan struct{} }
+
+func (f *fakeWakeups) Subscribe(int64) (<-chan struct{}, func()) { return f.ch, func() {} }
+
+func TestStartDispatcher_WakesOnNotification(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	rdb := redis.NewClient(&redis.Options{Addr: "localhost:99999", MaxRetries: -1})
+	defer rdb.Close()
+
+	service := services.NewTicketService(repositories.NewTicketRepo(db), bus.NewRedis(rdb), "queue.stream", "queue.%d.broadcast")
+	wakeups := &fakeWakeups{ch: make(chan struct{}, 1)}
+	service.Wakeups = wakeups
+
+	// first pass on start: nothing waiting
+	mock.ExpectBegin()
+	mock.ExpectQuery("FOR UPDATE SKIP LOCKED").WillReturnRows(ticketRows())
+	mock.ExpectRollback()
+	// pass triggered by the notification: one ticket, then empty
+	now := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery("FOR UPDATE SKIP LOCKED").
+		WillReturnRows(ticketRows(&models.Ticket{ID: 8, QueueID: 42, Status: "waiting", Lane: "standard", CreatedAt: now, UpdatedAt: now, Version: 1}))
+	mock.ExpectExec("SET status='processing'").WithArgs(int64(8)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery("FOR UPDATE SKIP LOCKED").WillReturnRows(ticketRows())
+	mock.ExpectRollback()
+
+	wakeupsBefore := metricValue(`queue_dispatch_wakeups_total{queue_id="42",trigger="notify"}`)
+	latencyBefore := metricValue(`queue_dispatch_latency_seconds_count{queue_id="42"}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// the hour-long interval means only the notification can trigger the second pass
+	service.StartDispatcher(ctx, 42, time.Hour)
+
+	time.Sleep(50 * time.Millisecond)
+	wakeups.ch <- struct{}{}
+
+	assert.Eventually(t, func() bool { return mock.ExpectationsWereMet() == nil }, 2*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, 1.0, metricValue(`queue_dispatch_wakeups_total{queue_id="42",trigger="notify"}`)-wakeupsBefore)
+	assert.Equal(t, 1.0, metricValue(`queue_dispatch_latency_seconds_count{queue_id="42"}`)-latencyBefore)
+}
+
+// metricValue reads one series from the default registry, 0 if it is not
+// there yet. Counters are process-wide, so tests compare before and after.
+func metricValue(series string) float64 {
+	var out bytes.Buffer
+	metrics.Default.Write(&out)
+	for _, line := range strings.Split(out.String(), "\n") {
+		if v, ok := strings.CutPrefix(line, series+" "); ok {
+			f, _ := strconv.ParseFloat(v, 64)
+			return f
+		}
+	}
+	return 0
+}
+
+func TestStartDispatcher_ReservesOnlyUpToWorkerCapacity(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	rdb := redis.NewClient(&redis.Options{Addr: "localhost:99999", MaxRetries: -1})
+	defer rdb.Close()
+
+	service := services.NewTicketService(repositories.NewTicketRepo(db), bus.NewRedis(rdb), "queue.stream", "queue.%d.broadcast")
+	service.CapacityPolicy = &services.CapacityPolicy{Workers: repositories.NewWorkerRepo(db), HeartbeatTTL: time.Minute}
+
+	// three seats at live counters, one already serving -> room for two
+	mock.ExpectQuery("FROM workers").WithArgs(int64(43), 60.0).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(3))
+	mock.ExpectQuery("SELECT COUNT").WithArgs(int64(43), "processing").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	now := time.Now()
+	for id := int64(1); id <= 2; id++ {
+		mock.ExpectBegin()
+		mock.ExpectQuery("FOR UPDATE SKIP LOCKED").
+			WillReturnRows(ticketRows(&models.Ticket{ID: id, QueueID: 43, Status: "waiting", Lane: "standard", CreatedAt: now, UpdatedAt: now, Version: 1}))
+		mock.ExpectExec("SET status='processing'").WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+
+	latencyBefore := metricValue(`queue_dispatch_latency_seconds_count{queue_id="43"}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	service.StartDispatcher(ctx, 43, time.Hour)
+
+	assert.Eventually(t, func() bool { return mock.ExpectationsWereMet() == nil }, 2*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Equal(t, 2.0, metricValue(`queue_dispatch_latency_seconds_count{queue_id="43"}`)-latencyBefore)
+	assert.Equal(t, 2.0, metricValue(`queue_dispatch_capacity_available{queue_id="43"}`))
+}
@@ -0,0 +1,225 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"queue-core/internal/bus"
+	"queue-core/internal/repositories"
+	"queue-core/internal/services"
+	"queue-core/pkg/events"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenEvents has one fixed envelope per event type. The golden files pin
+// their wire format: a diff means consumers see a changed schema, which
+// must only ever add fields within a schema version.
+func goldenEvents() map[string]*events.Envelope {
+	at := time.Date(2025, 3, 14, 9, 26, 53, 0, time.UTC)
+	group := int64(2)
+	worker := int64(11)
+	counter := int64(4)
+	estimate := 240
+	snap := func(status string, version int64) *events.Ticket {
+		return &events.Ticket{ID: 42, QueueID: 7, Status: status, Version: version, Priority: 1, Lane: "standard",
+			Attributes: map[string]any{"seats": float64(2)}, GroupID: &group}
+	}
+	env := func(t *events.Ticket, p events.Payload) *events.Envelope {
+		return &events.Envelope{ID: "0f8fad5bd9cb469fa16570867728950e", Type: p.EventType(),
+			SchemaVersion: events.SchemaVersion, OccurredAt: at, QueueID: 7, Ticket: t, Payload: p}
+	}
+	return map[string]*events.Envelope{
+		"created":       env(snap("waiting", 1), events.Created{Routed: true}),
+		"reserved":      env(snap("processing", 2), events.Reserved{}),
+		"claimed":       env(snap("processing", 2), events.Claimed{WorkerID: worker, LeaseExpiresAt: at.Add(time.Minute)}),
+		"completed":     env(snap("done", 3), events.Completed{WorkerID: &worker, CounterID: &counter}),
+		"cancelled":     env(&events.Ticket{ID: 42, QueueID: 7, Status: "cancelled", Version: 2}, events.Cancelled{Reason: "no_show", CancelledBy: "staff"}),
+		"requeued":      env(&events.Ticket{ID: 42, QueueID: 7, Status: "waiting", Version: 3, Attempts: 1}, events.Requeued{Reason: "nack", AvailableAt: &at}),
+		"dead_lettered": env(&events.Ticket{ID: 42, QueueID: 7, Status: "dead_letter", Version: 6, Attempts: 5}, events.DeadLettered{Reason: "lease_expired"}),
+		"discarded":     env(&events.Ticket{ID: 42, QueueID: 7, Status: "discarded", Version: 7, Attempts: 5}, events.Discarded{}),
+		"reconciled":    env(&events.Ticket{ID: 42, QueueID: 7}, events.Reconciled{Action: "acked", Reason: "orphaned_entry", EntryID: "1710408413000-0", Group: "workers"}),
+		"worker_update": env(&events.Ticket{ID: 42, QueueID: 7}, events.WorkerUpdate{WorkerID: worker, EstimatedTime: &estimate, Fields: map[string]string{"desk": "3"}}),
+	}
+}
+
+func TestEvents_GoldenJSON(t *testing.T) {
+	for name, e := range goldenEvents() {
+		t.Run(name, func(t *testing.T) {
+			b, err := json.MarshalIndent(e, "", "  ")
+			assert.NoError(t, err)
+			want := golden(t, name+".json", b)
+			assert.JSONEq(t, string(want), string(b))
+
+			decoded, err := events.Decode(want)
+			assert.NoError(t, err)
+			assert.Equal(t, e, decoded)
+		})
+	}
+}
+
+func TestEvents_GoldenStreamFields(t *testing.T) {
+	for name, e := range goldenEvents() {
+		t.Run(name, func(t *testing.T) {
+			fields, err := e.Fields()
+			assert.NoError(t, err)
+			// Redis hands every field back as a string
+			strs := map[string]interface{}{}
+			for k, v := range fields {
+				b, _ := json.Marshal(v)
+				if s, ok := v.(string); ok {
+					b = []byte(s)
+				}
+				strs[k] = string(b)
+			}
+			b, err := json.MarshalIndent(strs, "", "  ")
+			assert.NoError(t, err)
+			want := golden(t, name+".fields.json", b)
+			assert.JSONEq(t, string(want), string(b))
+
+			var wantFields map[string]interface{}
+			assert.NoError(t, json.Unmarshal(want, &wantFields))
+			decoded, err := events.FromFields(wantFields)
+			assert.NoError(t, err)
+			assert.Equal(t, e, decoded)
+		})
+	}
+}
+
+func TestEvents_GoldenCloudEvents(t *testing.T) {
+	enc := events.Encoding{Format: events.FormatCloudEvents, Source: "/queue-core"}
+	for name, e := range goldenEvents() {
+		t.Run(name, func(t *testing.T) {
+			b, err := enc.JSON(e)
+			assert.NoError(t, err)
+			var indented bytes.Buffer
+			assert.NoError(t, json.Indent(&indented, b, "", "  "))
+			want := golden(t, name+".cloudevent.json", indented.Bytes())
+			assert.JSONEq(t, string(want), string(b))
+
+			decoded, err := events.Decode(want)
+			assert.NoError(t, err)
+			assert.Equal(t, e, decoded)
+		})
+	}
+}
+
+func TestEvents_CloudEventAttributes(t *testing.T) {
+	e := goldenEvents()["completed"]
+	ce, err := e.CloudEvent("https://queues.example.org/")
+	assert.NoError(t, err)
+	assert.Equal(t, "1.0", ce.SpecVersion)
+	assert.Equal(t, e.ID, ce.ID)
+	assert.Equal(t, "queuecore.ticket.completed", ce.Type)
+	assert.Equal(t, "https://queues.example.org/queues/7", ce.Source)
+	assert.Equal(t, "tickets/42", ce.Subject)
+
+	// stream entries hold the structured event in one field
+	fields, err := events.Encoding{Format: events.FormatCloudEvents}.Fields(e)
+	assert.NoError(t, err)
+	assert.Len(t, fields, 1)
+	decoded, err := events.FromFields(fields)
+	assert.NoError(t, err)
+	assert.Equal(t, e, decoded)
+}
+
+func TestEvents_UnknownTypeDecodesRaw(t *testing.T) {
+	e, err := events.Decode([]byte(`{"id":"a","type":"ticket.teleported","schema_version":1,
+		"occurred_at":"2025-03-14T09:26:53Z","queue_id":7,"ticket":{"id":42,"queue_id":7},"data":{"to":"mars"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, events.Unknown{Type: "ticket.teleported", Data: json.RawMessage(`{"to":"mars"}`)}, e.Payload)
+
+	// re-encoding keeps the payload intact
+	b, err := json.Marshal(e)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"data":{"to":"mars"}`)
+}
+
+func TestEvents_NewerSchemaIsRejected(t *testing.T) {
+	_, err := events.Decode([]byte(`{"id":"a","type":"ticket.created","schema_version":2,
+		"occurred_at":"2025-03-14T09:26:53Z","queue_id":7,"data":{}}`))
+	assert.ErrorIs(t, err, events.ErrUnsupportedSchema)
+
+	_, err = events.FromFields(map[string]interface{}{"type": "ticket.created"})
+	assert.ErrorIs(t, err, events.ErrMalformedEvent)
+}
+
+// golden returns testdata/events/name, first rewriting it with got under -update.
+func golden(t *testing.T, name string, got []byte) []byte {
+	t.Helper()
+	path := filepath.Join("testdata", "events", name)
+	if *updateGolden {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		assert.NoError(t, os.WriteFile(path, append(got, '\n'), 0o644))
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read golden file (run with -update to create it): %v", err)
+	}
+	return want
+}
+
+func TestEvents_ReplayMarkRoundTrips(t *testing.T) {
+	e := goldenEvents()["completed"]
+	e.Replay = true
+	for _, enc := range []events.Encoding{{}, {Format: events.FormatCloudEvents}} {
+		b, err := enc.JSON(e)
+		assert.NoError(t, err)
+		decoded, err := events.Decode(b)
+		assert.NoError(t, err)
+		assert.True(t, decoded.Replay)
+
+		fields, err := enc.Fields(e)
+		assert.NoError(t, err)
+		decoded, err = events.FromFields(fields)
+		assert.NoError(t, err)
+		assert.True(t, decoded.Replay)
+	}
+}
+
+func TestDiscardDeadLetter_EmitsCloudEvent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	service := services.NewTicketService(repositories.NewTicketRepo(db), bus.NewRedis(rdb), "queue.stream", "queue.%d.broadcast")
+	service.Encoding = events.Encoding{Format: events.FormatCloudEvents, Source: "/queue-core"}
+
+	sub := rdb.Subscribe(context.Background(), "queue.3.broadcast")
+	defer sub.Close()
+	_, err = sub.Receive(context.Background())
+	assert.NoError(t, err)
+
+	mock.ExpectQuery("SET status='discarded'").WithArgs(int64(5)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "queue_id", "status", "attempts", "available_at", "version"}).
+			AddRow(5, 3, "discarded", 5, nil, 8))
+	assert.NoError(t, service.DiscardDeadLetter(context.Background(), 5))
+
+	entries, err := rdb.XRange(context.Background(), "queue.stream.3", "-", "+").Result()
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		var ce events.CloudEvent
+		assert.NoError(t, json.Unmarshal([]byte(entries[0].Values["cloudevent"].(string)), &ce))
+		assert.Equal(t, "queuecore.ticket.discarded", ce.Type)
+		assert.Equal(t, "/queue-core/queues/3", ce.Source)
+		assert.Equal(t, "tickets/5", ce.Subject)
+		assert.Equal(t, int64(8), ce.Data.Ticket.Version)
+	}
+
+	msg, err := sub.ReceiveMessage(context.Background())
+	assert.NoError(t, err)
+	assert.Contains(t, msg.Payload, `"specversion":"1.0"`)
+}
@@ -0,0 +1,64 @@
+package unit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"queue-core/internal/repositories"
+	"queue-core/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeedbackService_Submit(t *testing.T) {
+	servedCols := []string{"id", "queue_id", "counter_id", "assigned_worker", "archived_at"}
+
+	tests := []struct {
+		name       string
+		rating     int
+		archivedAt time.Time
+		inserted   bool
+		wantErr    error
+	}{
+		{name: "stored against counter and staff", rating: 5, archivedAt: time.Now().Add(-time.Hour), inserted: true},
+		{name: "window closed", rating: 4, archivedAt: time.Now().Add(-48 * time.Hour), wantErr: services.ErrFeedbackWindowClosed},
+		{name: "second submission", rating: 3, archivedAt: time.Now(), inserted: false, wantErr: services.ErrFeedbackAlreadyGiven},
+		{name: "rating out of range", rating: 6, wantErr: services.ErrInvalidRating},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			service := services.NewFeedbackService(repositories.NewFeedbackRepo(db))
+
+			if tt.wantErr != services.ErrInvalidRating {
+				mock.ExpectQuery("FROM ticket_history").WithArgs("tok").
+					WillReturnRows(sqlmock.NewRows(servedCols).AddRow(9, 1, 2, 11, tt.archivedAt))
+			}
+			if tt.wantErr == nil || tt.wantErr == services.ErrFeedbackAlreadyGiven {
+				rows := sqlmock.NewRows([]string{"created_at"})
+				if tt.inserted {
+					rows.AddRow(time.Now())
+				}
+				mock.ExpectQuery("INSERT INTO ticket_feedback").
+					WithArgs(int64(9), int64(1), sqlmock.AnyArg(), sqlmock.AnyArg(), tt.rating, "great").
+					WillReturnRows(rows)
+			}
+
+			f, err := service.Submit(context.Background(), "tok", tt.rating, "great")
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, int64(2), *f.CounterID)
+				assert.Equal(t, int64(11), *f.StaffID)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package unit
+
+import (
+	"database/sql/driver"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"queue-core/internal/models"
+)
+
+// ticketRows returns mock rows shaped like the repository's ticket SELECTs,
+// one row per ticket.
+func ticketRows(tickets ...*models.Ticket) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "queue_id", "customer_id", "customer_name", "status", "priority",
+		"priority_class", "lane", "attributes", "counter_id", "assigned_worker", "token",
+		"created_at", "updated_at", "estimated_time", "version", "attempts", "available_at", "group_id", "routing"})
+	for _, t := range tickets {
+		attrs, _ := t.Attributes.Value()
+		rows.AddRow(t.ID, t.QueueID, t.CustomerID, t.CustomerName, string(t.Status), t.Priority,
+			t.PriorityClass, t.Lane, []byte(attrs.(string)), t.CounterID, t.AssignedWorker, t.Token,
+			t.CreatedAt, t.UpdatedAt, t.EstimatedTime, t.Version, t.Attempts, t.AvailableAt, t.GroupID, routingValue(t.Routing))
+	}
+	return rows
+}
+
+// routingValue renders a routing decision the way the JSONB column returns it.
+func routingValue(d *models.RoutingDecision) driver.Value {
+	v, _ := d.Value()
+	if s, ok := v.(string); ok {
+		return []byte(s)
+	}
+	return nil
+}
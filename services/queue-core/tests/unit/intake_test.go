@@ -0,0 +1,69 @@
+package unit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"queue-core/internal/models"
+	"queue-core/internal/repositories"
+	"queue-core/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateAttributes(t *testing.T) {
+	schema := []models.IntakeField{
+		{Name: "plate", Type: models.FieldString, Required: true},
+		{Name: "seats", Type: models.FieldNumber},
+		{Name: "reason", Type: models.FieldEnum, Options: []string{"renewal", "new"}},
+	}
+
+	tests := []struct {
+		name   string
+		attrs  models.Attributes
+		fields map[string]string
+	}{
+		{name: "valid", attrs: models.Attributes{"plate": "WXY 123", "seats": 2.0, "reason": "new"}},
+		{name: "missing required", attrs: models.Attributes{"seats": 2.0}, fields: map[string]string{"plate": "is required"}},
+		{name: "wrong type", attrs: models.Attributes{"plate": "A", "seats": "two"}, fields: map[string]string{"seats": "must be a number"}},
+		{name: "bad enum", attrs: models.Attributes{"plate": "A", "reason": "other"}, fields: map[string]string{"reason": "must be one of renewal, new"}},
+		{name: "unknown field", attrs: models.Attributes{"plate": "A", "colour": "red"}, fields: map[string]string{"colour": "is not a field of this queue"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := services.ValidateAttributes(schema, tt.attrs)
+			if tt.fields == nil {
+				assert.NoError(t, err)
+				return
+			}
+			var verr *services.ValidationError
+			assert.ErrorAs(t, err, &verr)
+			assert.Equal(t, tt.fields, verr.Fields)
+		})
+	}
+}
+
+func TestTicketRepository_ListByStatusFiltersAttributes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	repo := repositories.NewTicketRepo(db)
+	now := time.Now()
+
+	mock.ExpectQuery(`attributes->>\$3 = \$4`).
+		WithArgs(1, "waiting", "plate", "WXY 123").
+		WillReturnRows(ticketRows(&models.Ticket{
+			ID: 4, QueueID: 1, CustomerName: "Dan", Status: "waiting", Priority: 1, Lane: "standard",
+			Attributes: models.Attributes{"plate": "WXY 123"}, CreatedAt: now, UpdatedAt: now, Version: 1,
+		}))
+
+	tickets, err := repo.ListByStatus(context.Background(), 1, "waiting", map[string]string{"plate": "WXY 123"})
+	assert.NoError(t, err)
+	assert.Len(t, tickets, 1)
+	assert.Equal(t, "WXY 123", tickets[0].Attributes["plate"])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,68 @@
+package unit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"queue-core/internal/repositories"
+	"queue-core/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatchElector_LeadsFairShareAndRebalances(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	elector := services.NewDispatchElector(repositories.NewLeaderRepo(db), services.NewTicketService(nil, nil, "", ""), "node-a")
+	elector.QueueIDs = func(context.Context) ([]int64, error) { return []int64{1, 2, 3}, nil }
+
+	var mu sync.Mutex
+	running := map[int]context.Context{}
+	exited := map[int]bool{}
+	elector.StartDispatcher = func(ctx context.Context, queueID int, _ time.Duration) <-chan struct{} {
+		mu.Lock()
+		defer mu.Unlock()
+		running[queueID] = ctx
+		done := make(chan struct{})
+		go func() {
+			<-ctx.Done()
+			time.Sleep(20 * time.Millisecond) // a pass still in flight
+			mu.Lock()
+			exited[queueID] = true
+			mu.Unlock()
+			close(done)
+		}()
+		return done
+	}
+
+	// round 1: two live replicas, three queues -> lead two
+	mock.ExpectExec("INSERT INTO dispatcher_nodes").WithArgs("node-a").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("FROM dispatcher_nodes").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery("pg_try_advisory_lock").WithArgs(sqlmock.AnyArg(), int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"ok"}).AddRow(true))
+	mock.ExpectQuery("pg_try_advisory_lock").WithArgs(sqlmock.AnyArg(), int64(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"ok"}).AddRow(true))
+
+	ctx := context.Background()
+	assert.NoError(t, elector.Tick(ctx))
+	assert.Equal(t, []int64{1, 2}, elector.Leading())
+
+	// round 2: a third replica joined -> shed queue 2 so it can take it
+	mock.ExpectExec("INSERT INTO dispatcher_nodes").WithArgs("node-a").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("FROM dispatcher_nodes").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectExec("pg_advisory_unlock").WithArgs(sqlmock.AnyArg(), int64(2)).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	assert.NoError(t, elector.Tick(ctx))
+	assert.Equal(t, []int64{1}, elector.Leading())
+	assert.Error(t, running[2].Err(), "released queue's dispatcher must be stopped")
+	mu.Lock()
+	assert.True(t, exited[2], "the lock is released only after the dispatcher exits")
+	mu.Unlock()
+	assert.NoError(t, running[1].Err())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
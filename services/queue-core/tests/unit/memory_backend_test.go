@@ -0,0 +1,357 @@
+package unit
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	"queue-core/internal/actor"
+	"queue-core/internal/bus"
+	"queue-core/internal/models"
+	"queue-core/internal/repositories"
+	"queue-core/internal/services"
+	"queue-core/pkg/events"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryTicketRepo_ConcurrentReservationsNeverCollide(t *testing.T) {
+	repo := repositories.NewMemoryTicketRepo()
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, repo.Create(ctx, &models.Ticket{QueueID: 7, Status: models.StatusWaiting}))
+	}
+
+	var mu sync.Mutex
+	seen := map[int64]int{}
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				batch, err := repo.ReserveMany(ctx, 7, 3, repositories.ReserveOptions{})
+				assert.NoError(t, err)
+				one, err := repo.Reserve(ctx, 7, repositories.ReserveOptions{})
+				assert.NoError(t, err)
+				if one != nil {
+					batch = append(batch, one)
+				}
+				if len(batch) == 0 {
+					return
+				}
+				mu.Lock()
+				for _, tk := range batch {
+					seen[tk.ID]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, seen, 100)
+	for id, n := range seen {
+		assert.Equal(t, 1, n, "ticket %d reserved %d times", id, n)
+	}
+}
+
+func TestMemoryTicketRepo_ReserveOrderAndSkills(t *testing.T) {
+	repo := repositories.NewMemoryTicketRepo()
+	ctx := context.Background()
+	create := func(priority int, lane string, attrs models.Attributes) int64 {
+		tk := &models.Ticket{QueueID: 7, Status: models.StatusWaiting, Priority: priority, Lane: lane, Attributes: attrs}
+		assert.NoError(t, repo.Create(ctx, tk))
+		return tk.ID
+	}
+	plain := create(1, "", nil)
+	urgent := create(5, "", models.Attributes{"skill": "loans"})
+	vip := create(1, "vip", nil)
+	tax := create(9, "", models.Attributes{"skill": "tax"})
+
+	next := func(opts repositories.ReserveOptions) int64 {
+		tk, err := repo.Reserve(ctx, 7, opts)
+		assert.NoError(t, err)
+		if tk == nil {
+			return 0
+		}
+		return tk.ID
+	}
+	assert.Equal(t, vip, next(repositories.ReserveOptions{Lanes: []string{"vip"}}))
+	assert.Equal(t, urgent, next(repositories.ReserveOptions{Order: repositories.OrderPriority, Skills: []string{"loans"}}))
+	assert.Equal(t, plain, next(repositories.ReserveOptions{Skills: []string{"loans"}}))
+	assert.Zero(t, next(repositories.ReserveOptions{Skills: []string{"loans"}}), "tax tickets need the tax skill")
+	assert.Equal(t, tax, next(repositories.ReserveOptions{}))
+}
+
+func TestMemoryTicketRepo_VersionsArchivalAndTimeline(t *testing.T) {
+	repo := repositories.NewMemoryTicketRepo()
+	ctx := context.Background()
+	tk := &models.Ticket{QueueID: 7, Status: models.StatusWaiting}
+	assert.NoError(t, repo.Create(ctx, tk))
+	assert.Equal(t, int64(1), tk.Version)
+
+	reserved, err := repo.Reserve(ctx, 7, repositories.ReserveOptions{})
+	assert.NoError(t, err)
+	if !assert.NotNil(t, reserved) {
+		return
+	}
+	assert.Equal(t, int64(1), reserved.Version, "the version before the reservation")
+
+	// a stale version loses
+	ok, _, err := repo.Complete(ctx, tk.ID, 1, nil, nil)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	worker := int64(4)
+	ok, version, err := repo.Complete(ctx, tk.ID, 2, nil, &worker)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), version)
+
+	// done tickets leave the active set
+	_, err = repo.GetByID(ctx, tk.ID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	active, err := repo.ActiveIDs(ctx, []int64{tk.ID})
+	assert.NoError(t, err)
+	assert.False(t, active[tk.ID])
+	loads, err := repo.QueueLoads(ctx, []int64{7}, time.Hour)
+	assert.NoError(t, err)
+	if assert.Len(t, loads, 1) {
+		assert.Zero(t, loads[0].Waiting)
+	}
+
+	timeline, err := repo.Timeline(ctx, tk.ID)
+	assert.NoError(t, err)
+	var kinds []string
+	for _, e := range timeline {
+		kinds = append(kinds, e.Kind)
+	}
+	assert.Equal(t, []string{"created", "reserved", "done"}, kinds)
+	assert.Equal(t, "worker:4", timeline[2].Actor)
+}
+
+func TestMemoryTicketRepo_TimelineNamesTheCallingActor(t *testing.T) {
+	repo := repositories.NewMemoryTicketRepo()
+	ctx := context.Background()
+	kiosk := actor.WithContext(ctx, actor.Actor{Role: actor.RoleKiosk})
+	staff := actor.WithContext(ctx, actor.Actor{Role: actor.RoleStaff, ID: "ops-1"})
+
+	tk := &models.Ticket{QueueID: 7, Status: models.StatusWaiting}
+	assert.NoError(t, repo.Create(kiosk, tk))
+	_, err := repo.Reserve(ctx, 7, repositories.ReserveOptions{})
+	assert.NoError(t, err)
+	// a named actor wins over the worker the ticket is assigned to, as in Postgres
+	worker := int64(4)
+	ok, _, err := repo.Complete(staff, tk.ID, 2, nil, &worker)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	timeline, err := repo.Timeline(ctx, tk.ID)
+	assert.NoError(t, err)
+	var names []string
+	for _, e := range timeline {
+		names = append(names, e.Actor)
+	}
+	assert.Equal(t, []string{"kiosk", "system", "staff:ops-1"}, names)
+}
+
+func TestMemoryTicketRepo_SubscribeQueuesReportsReadyQueues(t *testing.T) {
+	repo := repositories.NewMemoryTicketRepo()
+	ctx := context.Background()
+	ready, stop := repo.SubscribeQueues()
+
+	for _, q := range []int64{7, 12} {
+		assert.NoError(t, repo.Create(ctx, &models.Ticket{QueueID: q, Status: models.StatusWaiting}))
+	}
+	var got []int64
+	for i := 0; i < 2; i++ {
+		select {
+		case q := <-ready:
+			got = append(got, q)
+		default:
+			t.Fatal("a new ticket reports its queue")
+		}
+	}
+	assert.Equal(t, []int64{7, 12}, got)
+
+	stop()
+	assert.NoError(t, repo.Create(ctx, &models.Ticket{QueueID: 7, Status: models.StatusWaiting}))
+	assert.Empty(t, ready)
+}
+
+func TestMemoryTicketRepo_LeasesRetriesAndCancellation(t *testing.T) {
+	repo := repositories.NewMemoryTicketRepo()
+	ctx := context.Background()
+	policy := repositories.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Hour}
+	tk := &models.Ticket{QueueID: 7, Status: models.StatusWaiting}
+	assert.NoError(t, repo.Create(ctx, tk))
+
+	wake, stop := repo.Subscribe(7)
+	defer stop()
+
+	_, err := repo.Reserve(ctx, 7, repositories.ReserveOptions{Lease: time.Minute, WorkerID: 3})
+	assert.NoError(t, err)
+	nacked, err := repo.NackLease(ctx, tk.ID, 3, policy)
+	assert.NoError(t, err)
+	if !assert.NotNil(t, nacked) {
+		return
+	}
+	assert.Equal(t, models.StatusWaiting, nacked.Status)
+	assert.Equal(t, 1, nacked.Attempts)
+	select {
+	case <-wake:
+	default:
+		t.Fatal("a requeue wakes the queue's dispatcher")
+	}
+	none, err := repo.Reserve(ctx, 7, repositories.ReserveOptions{})
+	assert.NoError(t, err)
+	assert.Nil(t, none, "backing off")
+
+	moved, err := repo.RequeueToWaiting(ctx, tk.ID, policy)
+	assert.NoError(t, err)
+	assert.Nil(t, moved, "only reserved tickets requeue")
+
+	ok, _, err := repo.Cancel(ctx, tk.ID, models.ReasonNoShow, "staff")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, _, err = repo.Cancel(ctx, tk.ID, models.ReasonNoShow, "staff")
+	assert.NoError(t, err)
+	assert.False(t, ok, "already archived")
+
+	report, err := repo.AbandonmentByHour(ctx, 7, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	if assert.Len(t, report, 1) {
+		assert.Equal(t, 1, report[0].Cancelled)
+		assert.Equal(t, 1.0, report[0].AbandonmentRate)
+	}
+}
+
+func TestMemoryBus_GroupsPendingAndFanOut(t *testing.T) {
+	b := bus.NewMemory()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subA, err := b.Subscribe(ctx, "queue.7.broadcast")
+	assert.NoError(t, err)
+	subB, err := b.Subscribe(ctx, "queue.7.broadcast")
+	assert.NoError(t, err)
+	assert.NoError(t, b.CreateGroup(ctx, "queue.stream.7", "workers", "$"))
+
+	// a blocked reader gets the entry as soon as it is emitted
+	read := make(chan []bus.Message, 1)
+	go func() {
+		msgs, err := b.ReadGroup(ctx, "queue.stream.7", "workers", "w1", 10, 2*time.Second)
+		assert.NoError(t, err)
+		read <- msgs
+	}()
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(t, b.Emit(ctx, []bus.Event{
+		{Stream: "queue.stream.7", Fields: map[string]interface{}{"n": 1}, Channel: "queue.7.broadcast", Payload: []byte("hi")},
+	}))
+	msgs := <-read
+	if !assert.Len(t, msgs, 1) {
+		return
+	}
+	assert.Equal(t, "1", msgs[0].Values["n"], "values read back as strings, as from Redis")
+	for _, sub := range []bus.Subscription{subA, subB} {
+		select {
+		case m := <-sub.Messages():
+			assert.Equal(t, "hi", string(m))
+		case <-time.After(time.Second):
+			t.Fatal("no broadcast received")
+		}
+	}
+
+	pending, err := b.Pending(ctx, "queue.stream.7", "workers", 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []bus.PendingEntry{{ID: msgs[0].ID, Consumer: "w1", Idle: pending[0].Idle, RetryCount: 1}}, pending)
+	acked, err := b.Ack(ctx, "queue.stream.7", "workers", msgs[0].ID, msgs[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), acked)
+	groups, err := b.Groups(ctx, "queue.stream.7")
+	assert.NoError(t, err)
+	assert.Equal(t, []bus.Group{{Name: "workers", Consumers: 1, LastDeliveredID: msgs[0].ID}}, groups)
+
+	var ids []string
+	for i := 0; i < 5; i++ {
+		id, err := b.Publish(ctx, "queue.stream.worker.updates.7", map[string]interface{}{"n": i}, bus.Trim{MaxLen: 3})
+		assert.NoError(t, err)
+		ids = append(ids, id)
+	}
+	page, err := b.Range(ctx, "queue.stream.worker.updates.7", "-", "+", 10)
+	assert.NoError(t, err)
+	assert.Len(t, page, 3)
+	page, err = b.Range(ctx, "queue.stream.worker.updates.7", "("+ids[2], "+", 10)
+	assert.NoError(t, err)
+	assert.Len(t, page, 2)
+	removed, err := b.TrimBefore(ctx, "queue.stream.worker.updates.7", ids[4])
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), removed)
+}
+
+func TestMemoryBackend_DispatchesWithoutExternalServices(t *testing.T) {
+	repo := repositories.NewMemoryTicketRepo()
+	b := bus.NewMemory()
+	service := services.NewTicketService(repo, b, "queue.stream", "queue.%d.broadcast")
+	service.Wakeups = repo
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sub, err := b.Subscribe(ctx, "queue.7.broadcast")
+	assert.NoError(t, err)
+	// the fallback poll is far off: only the store's wakeup can dispatch in time
+	service.StartDispatcher(ctx, 7, time.Hour)
+
+	id, err := service.CreateTicket(ctx, &models.Ticket{QueueID: 7, CustomerName: "Ada"})
+	assert.NoError(t, err)
+
+	var seen []events.Type
+	for len(seen) < 2 {
+		select {
+		case msg := <-sub.Messages():
+			e, err := events.Decode(msg)
+			assert.NoError(t, err)
+			seen = append(seen, e.Type)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("saw only %v", seen)
+		}
+	}
+	assert.Equal(t, []events.Type{events.TicketCreated, events.TicketReserved}, seen)
+
+	tk, err := repo.GetByID(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, models.StatusProcessing, tk.Status)
+	ok, err := service.CompleteTicket(actor.WithContext(ctx, actor.Actor{Role: actor.RoleStaff}), id, tk.Version, nil, nil)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	timeline, err := service.Timeline(ctx, id)
+	assert.NoError(t, err)
+	assert.Len(t, timeline, 3)
+}
+
+func TestCloseSession_EmitsFullSnapshots(t *testing.T) {
+	repo := repositories.NewMemoryTicketRepo()
+	b := bus.NewMemory()
+	service := services.NewTicketService(repo, b, "queue.stream", "queue.%d.broadcast")
+	ctx := context.Background()
+
+	id, err := service.CreateTicket(ctx, &models.Ticket{QueueID: 7, CustomerName: "Ada", Priority: 3, Lane: "priority",
+		Attributes: models.Attributes{"service": "visa"}})
+	assert.NoError(t, err)
+	n, err := service.CloseSession(ctx, 7)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	entries, err := b.Range(ctx, "queue.stream.7", "-", "+", 10)
+	assert.NoError(t, err)
+	if !assert.Len(t, entries, 1) {
+		return
+	}
+	e, err := events.FromFields(entries[0].Values)
+	assert.NoError(t, err)
+	assert.Equal(t, events.TicketCancelled, e.Type)
+	assert.Equal(t, &events.Ticket{ID: id, QueueID: 7, Status: "cancelled", Version: 2, Priority: 3, Lane: "priority",
+		Attributes: map[string]any{"service": "visa"}}, e.Ticket)
+}
@@ -0,0 +1,183 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"queue-core/internal/bus"
+	"queue-core/internal/db"
+	"queue-core/internal/mqttbridge"
+	"queue-core/internal/repositories"
+	"queue-core/internal/services"
+	"queue-core/pkg/events"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// runBroker starts an in-process MQTT broker on a random port.
+func runBroker(t *testing.T) (*mochi.Server, string) {
+	broker := mochi.New(&mochi.Options{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	assert.NoError(t, broker.AddHook(new(auth.AllowHook), nil))
+	tcp := listeners.NewTCP(listeners.Config{ID: "tcp", Address: "127.0.0.1:0"})
+	if err := broker.AddListener(tcp); err != nil {
+		t.Fatalf("mqtt broker: %v", err)
+	}
+	go broker.Serve()
+	t.Cleanup(func() { broker.Close() })
+	return broker, "tcp://" + tcp.Address()
+}
+
+// mqttClient connects a client that records what it receives on filter.
+func mqttClient(t *testing.T, url, id, filter string) (paho.Client, <-chan paho.Message) {
+	received := make(chan paho.Message, 16)
+	c := db.NewMQTTClient(url, id, nil)
+	if tok := c.Connect(); !tok.WaitTimeout(5*time.Second) || tok.Error() != nil {
+		t.Fatalf("mqtt connect: %v", tok.Error())
+	}
+	t.Cleanup(func() { c.Disconnect(100) })
+	if filter != "" {
+		tok := c.Subscribe(filter, 1, func(_ paho.Client, m paho.Message) { received <- m })
+		assert.True(t, tok.WaitTimeout(5*time.Second))
+		assert.NoError(t, tok.Error())
+	}
+	return c, received
+}
+
+func nextMessage(t *testing.T, ch <-chan paho.Message) paho.Message {
+	select {
+	case m := <-ch:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mqtt message received")
+		return nil
+	}
+}
+
+// newBridge wires a bridge for branch "north" into a ticket service.
+func newBridge(t *testing.T, url string, shareGroup string) (*mqttbridge.Bridge, sqlmock.Sqlmock) {
+	dbConn, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { dbConn.Close() })
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	service := services.NewTicketService(repositories.NewTicketRepo(dbConn), bus.NewRedis(rdb), "queue.stream", "queue.%d.broadcast")
+
+	bridge := mqttbridge.NewBridge(nil, service, "north")
+	bridge.ShareGroup = shareGroup
+	bridge.Client = db.NewMQTTClient(url, "queue-core", bridge.Subscribe)
+	if tok := bridge.Client.Connect(); !tok.WaitTimeout(5*time.Second) || tok.Error() != nil {
+		t.Fatalf("mqtt connect: %v", tok.Error())
+	}
+	t.Cleanup(func() { bridge.Client.Disconnect(100) })
+	service.Displays = bridge
+	return bridge, mock
+}
+
+func TestMQTTBridge_DispenseCreatesTicket(t *testing.T) {
+	broker, url := runBroker(t)
+	_, mock := newBridge(t, url, "queue-core")
+	_, issued := mqttClient(t, url, "board", "turn/north/7/issued")
+	dispenser, dispensed := mqttClient(t, url, "dispenser", "turn/north/7/dispensed")
+	// the bridge subscribes, as one of a share group, once connected
+	assert.Eventually(t, func() bool {
+		return len(broker.Topics.Subscribers("turn/north/7/dispense").Shared["$share/queue-core/turn/north/+/dispense"]) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("set_config\\('queue_core.actor'").WithArgs("kiosk:dispenser:lobby-1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("INSERT INTO tickets").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "lane", "created_at", "updated_at", "version"}).
+			AddRow(42, "standard", time.Now(), time.Now(), 1))
+	mock.ExpectCommit()
+	tok := dispenser.Publish("turn/north/7/dispense", 1, false, []byte(`{"dispenser":"lobby-1"}`))
+	assert.True(t, tok.WaitTimeout(5*time.Second))
+
+	got := map[string][]byte{
+		"dispensed": nextMessage(t, dispensed).Payload(),
+		"issued":    nextMessage(t, issued).Payload(),
+	}
+	var res mqttbridge.DispenseResult
+	assert.NoError(t, json.Unmarshal(got["dispensed"], &res))
+	assert.Equal(t, "lobby-1", res.Dispenser)
+	assert.Equal(t, int64(42), res.TicketID)
+	assert.NotEmpty(t, res.Token)
+	assert.Empty(t, res.Error)
+
+	var e events.Envelope
+	assert.NoError(t, json.Unmarshal(got["issued"], &e))
+	assert.Equal(t, events.TicketCreated, e.Type)
+	assert.Equal(t, int64(42), e.TicketID())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMQTTBridge_DispenseAnswersBusyPastLimit(t *testing.T) {
+	broker, url := runBroker(t)
+	bridge, mock := newBridge(t, url, "")
+	bridge.MaxDispensing = 1
+	dispenser, dispensed := mqttClient(t, url, "dispenser", "turn/north/7/dispensed")
+	assert.Eventually(t, func() bool {
+		return len(broker.Topics.Subscribers("turn/north/7/dispense").Subscriptions) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the first press holds the only slot while its insert runs
+	mock.ExpectBegin()
+	mock.ExpectExec("set_config\\('queue_core.actor'").WithArgs("kiosk:dispenser:lobby-1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("INSERT INTO tickets").WillDelayFor(500 * time.Millisecond).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "lane", "created_at", "updated_at", "version"}).
+			AddRow(42, "standard", time.Now(), time.Now(), 1))
+	mock.ExpectCommit()
+	for _, who := range []string{"lobby-1", "lobby-2"} {
+		tok := dispenser.Publish("turn/north/7/dispense", 1, false, []byte(`{"dispenser":"`+who+`"}`))
+		assert.True(t, tok.WaitTimeout(5*time.Second))
+	}
+
+	results := map[string]mqttbridge.DispenseResult{}
+	for i := 0; i < 2; i++ {
+		var res mqttbridge.DispenseResult
+		assert.NoError(t, json.Unmarshal(nextMessage(t, dispensed).Payload(), &res))
+		results[res.Dispenser] = res
+	}
+	assert.Equal(t, int64(42), results["lobby-1"].TicketID)
+	assert.Equal(t, "busy", results["lobby-2"].Error)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMQTTBridge_CalledKeepsRetainedNowServing(t *testing.T) {
+	_, url := runBroker(t)
+	bridge, _ := newBridge(t, url, "")
+	_, received := mqttClient(t, url, "board", "turn/north/7/called")
+
+	bridge.Notify(context.Background(), events.New(7, &events.Ticket{ID: 41, QueueID: 7}, events.Claimed{WorkerID: 3}))
+	bridge.Notify(context.Background(), events.New(7, &events.Ticket{ID: 41, QueueID: 7}, events.Completed{}))
+	bridge.Notify(context.Background(), events.New(7, &events.Ticket{ID: 42, QueueID: 7, AssignedWorker: 5}, events.Reserved{}))
+	bridge.Notify(context.Background(), events.New(7, &events.Ticket{ID: 1, QueueID: 7}, events.WorkerUpdate{WorkerID: 5}))
+
+	var called []events.Type
+	for i := 0; i < 2; i++ {
+		var e events.Envelope
+		assert.NoError(t, json.Unmarshal(nextMessage(t, received).Payload(), &e))
+		called = append(called, e.Type)
+	}
+	assert.ElementsMatch(t, []events.Type{events.TicketClaimed, events.TicketReserved}, called)
+
+	// a board coming online later gets the ticket being served at once
+	_, late := mqttClient(t, url, "late-board", "turn/north/7/now_serving")
+	m := nextMessage(t, late)
+	assert.True(t, m.Retained())
+	var serving mqttbridge.NowServing
+	assert.NoError(t, json.Unmarshal(m.Payload(), &serving))
+	assert.Equal(t, int64(42), serving.TicketID)
+	assert.Equal(t, int64(5), serving.WorkerID)
+}
@@ -0,0 +1,197 @@
+package unit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"queue-core/internal/bus"
+	"queue-core/internal/services"
+	"queue-core/pkg/events"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+// runNATS starts an in-process nats-server with JetStream on a random port.
+func runNATS(t *testing.T) string {
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir(), NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatalf("nats-server: %v", err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats-server did not start")
+	}
+	t.Cleanup(s.Shutdown)
+	return s.ClientURL()
+}
+
+func newNATSBus(t *testing.T, url string) *bus.NATS {
+	nc, err := nats.Connect(url)
+	if err != nil {
+		t.Fatalf("nats connect: %v", err)
+	}
+	t.Cleanup(nc.Close)
+	b, err := bus.NewNATS(nc)
+	assert.NoError(t, err)
+	return b
+}
+
+func TestNATSBus_BroadcastReachesSubscribers(t *testing.T) {
+	b := newNATSBus(t, runNATS(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sub, err := b.Subscribe(ctx, "queue.7.broadcast")
+	assert.NoError(t, err)
+	defer sub.Close()
+
+	assert.NoError(t, b.Broadcast(ctx, "queue.7.broadcast", []byte(`{"n":1}`)))
+	select {
+	case msg := <-sub.Messages():
+		assert.Equal(t, `{"n":1}`, string(msg))
+	case <-time.After(2 * time.Second):
+		t.Fatal("no broadcast received")
+	}
+}
+
+func TestNATSBus_DurableConsumerGroups(t *testing.T) {
+	url := runNATS(t)
+	b := newNATSBus(t, url)
+	ctx := context.Background()
+	assert.NoError(t, b.CreateGroup(ctx, "queue.stream.7", "workers", "0"))
+	assert.NoError(t, b.CreateGroup(ctx, "queue.stream.7", "workers", "0"), "creating twice is not an error")
+
+	assert.NoError(t, b.Emit(ctx, []bus.Event{
+		{Stream: "queue.stream.7", Fields: map[string]interface{}{"n": 1}},
+		{Stream: "queue.stream.7", Fields: map[string]interface{}{"n": 2}},
+		{Stream: "queue.stream.7", Fields: map[string]interface{}{"n": 3}},
+		{Channel: "queue.7.broadcast", Payload: []byte("only broadcast")},
+	}))
+	n, err := b.Len(ctx, "queue.stream.7")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+
+	msgs, err := b.ReadGroup(ctx, "queue.stream.7", "workers", "w1", 10, time.Second)
+	assert.NoError(t, err)
+	if !assert.Len(t, msgs, 3) {
+		return
+	}
+	assert.Equal(t, "1", msgs[0].Values["n"], "values read back as strings, as from Redis")
+
+	groups, err := b.Groups(ctx, "queue.stream.7")
+	assert.NoError(t, err)
+	if !assert.Len(t, groups, 1) {
+		return
+	}
+	assert.Equal(t, "workers", groups[0].Name)
+	assert.Equal(t, int64(3), groups[0].Pending)
+	assert.Equal(t, msgs[2].ID, groups[0].LastDeliveredID)
+	assert.Zero(t, groups[0].Lag)
+	assert.Zero(t, groups[0].Consumers, "pull consumers are not counted")
+
+	// entries just delivered are not idle
+	pending, err := b.Pending(ctx, "queue.stream.7", "workers", time.Minute, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+
+	// only the reader holds the deliveries to ack through
+	other := newNATSBus(t, url)
+	acked, err := other.Ack(ctx, "queue.stream.7", "workers", msgs[0].ID, msgs[1].ID)
+	assert.NoError(t, err)
+	assert.Zero(t, acked)
+	acked, err = b.Ack(ctx, "queue.stream.7", "workers", msgs[0].ID, msgs[1].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), acked)
+	assert.Eventually(t, func() bool {
+		pending, err := other.Pending(ctx, "queue.stream.7", "workers", 0, 10)
+		return err == nil && len(pending) == 1 && pending[0].ID == msgs[2].ID
+	}, 2*time.Second, 20*time.Millisecond)
+
+	// nothing new to deliver
+	more, err := b.ReadGroup(ctx, "queue.stream.7", "workers", "w1", 10, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, more)
+
+	groups, err = b.Groups(ctx, "queue.stream.missing")
+	assert.NoError(t, err)
+	assert.Empty(t, groups)
+}
+
+func TestNATSBus_RangeEntriesAndTrim(t *testing.T) {
+	b := newNATSBus(t, runNATS(t))
+	ctx := context.Background()
+	var ids []string
+	for i := 0; i < 5; i++ {
+		id, err := b.Publish(ctx, "queue.stream.7", map[string]interface{}{"n": i}, bus.Trim{})
+		assert.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	page, err := b.Range(ctx, "queue.stream.7", "-", "+", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, ids[:3], []string{page[0].ID, page[1].ID, page[2].ID})
+	page, err = b.Range(ctx, "queue.stream.7", "("+ids[2], "+", 10)
+	assert.NoError(t, err)
+	assert.Len(t, page, 2)
+	page, err = b.Range(ctx, "queue.stream.7", ids[1], ids[3], 10)
+	assert.NoError(t, err)
+	assert.Len(t, page, 3)
+	// a bare time starts at the first entry published at or after it
+	page, err = b.Range(ctx, "queue.stream.7", "0", "+", 10)
+	assert.NoError(t, err)
+	assert.Len(t, page, 5)
+
+	removed, err := b.TrimBefore(ctx, "queue.stream.7", ids[2])
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), removed)
+
+	entries, err := b.Entries(ctx, "queue.stream.7", []string{ids[0], ids[3]})
+	assert.NoError(t, err)
+	assert.Nil(t, entries[0], "trimmed")
+	assert.Equal(t, ids[3], entries[1].ID)
+
+	_, err = b.Publish(ctx, "queue.stream.worker.updates.7", map[string]interface{}{"n": 0}, bus.Trim{MaxLen: 2})
+	assert.NoError(t, err)
+	streams, err := b.Streams(ctx, "queue.stream")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"queue.stream.7", "queue.stream.worker.updates.7"}, streams)
+}
+
+func TestNATSBus_TicketServiceEvents(t *testing.T) {
+	b := newNATSBus(t, runNATS(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	service := services.NewTicketService(nil, b, "queue.stream", "queue.%d.broadcast")
+	service.Retention = &services.StreamRetention{
+		Streams: map[string]services.RetentionPolicy{string(services.StreamWorkerUpdates): {MaxLen: 5}},
+	}
+
+	sub, err := b.Subscribe(ctx, "queue.7.broadcast")
+	assert.NoError(t, err)
+	defer sub.Close()
+
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, service.PublishWorkerUpdate(ctx, 7, 42, events.WorkerUpdate{WorkerID: 11}))
+	}
+	select {
+	case msg := <-sub.Messages():
+		assert.Contains(t, string(msg), `"worker.updated"`)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no broadcast received")
+	}
+
+	// the stream limit trims as Redis' approximate MAXLEN does
+	n, err := b.Len(ctx, "queue.stream.worker.updates.7")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	entries, err := b.Range(ctx, "queue.stream.worker.updates.7", "-", "+", 1)
+	if !assert.NoError(t, err) || !assert.Len(t, entries, 1) {
+		return
+	}
+	e, err := events.FromFields(entries[0].Values)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), e.TicketID())
+}
@@ -0,0 +1,66 @@
+package unit
+
+import (
+	"context"
+	"testing"
+
+	"queue-core/internal/actor"
+	"queue-core/internal/models"
+	"queue-core/internal/repositories"
+	"queue-core/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLaneScheduler_EveryThirdCallGoesToPriorityLane(t *testing.T) {
+	lanes := services.NewLaneScheduler()
+	lanes.SetWeights([]models.Lane{
+		{Name: "standard", Weight: 2},
+		{Name: "priority", Weight: 1},
+	})
+
+	served := map[string]int{}
+	for i := 0; i < 9; i++ {
+		lane := lanes.Order()[0]
+		lanes.Served(lane)
+		served[lane]++
+	}
+	assert.Equal(t, 6, served["standard"])
+	assert.Equal(t, 3, served["priority"])
+}
+
+func TestTicketService_CreateTicket_PriorityClassRoles(t *testing.T) {
+	tests := []struct {
+		name    string
+		role    actor.Role
+		class   string
+		wantErr error
+	}{
+		{name: "kiosk cannot assign staff class", role: actor.RoleKiosk, class: "vip", wantErr: services.ErrPriorityClassForbidden},
+		{name: "unknown class", role: actor.RoleStaff, class: "nope", wantErr: services.ErrUnknownPriorityClass},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			service := services.NewTicketService(repositories.NewTicketRepo(db), nil, "queue.stream", "queue.%d.broadcast")
+			service.Queues = repositories.NewQueueRepo(db)
+
+			mock.ExpectQuery("FROM priority_classes").WithArgs(int64(1)).
+				WillReturnRows(sqlmock.NewRows([]string{"queue_id", "name", "priority", "lane", "assignable_by"}).
+					AddRow(1, "elderly", 5, "priority", "kiosk").
+					AddRow(1, "vip", 9, "priority", "staff"))
+			mock.ExpectQuery("FROM queue_lanes").WithArgs(int64(1)).
+				WillReturnRows(sqlmock.NewRows([]string{"queue_id", "lane", "weight"}))
+
+			ctx := actor.WithContext(context.Background(), actor.Actor{Role: tt.role})
+			_, err = service.CreateTicket(ctx, &models.Ticket{QueueID: 1, CustomerName: "Eve", PriorityClass: tt.class})
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
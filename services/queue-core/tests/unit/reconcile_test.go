@@ -0,0 +1,117 @@
+package unit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"queue-core/internal/bus"
+	"queue-core/internal/models"
+	"queue-core/internal/repositories"
+	"queue-core/internal/services"
+	"queue-core/pkg/events"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+const reconcileStream = "queue.stream.3"
+
+// newReconcileService wires a service to sqlmock and an in-memory Redis whose
+// queue 3 stream holds a delivered, still pending reservation of ticket 1
+// (version 2) and of the since archived ticket 9.
+func newReconcileService(t *testing.T, policy services.ReconcilePolicy) (*services.TicketService, sqlmock.Sqlmock, *redis.Client) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(arrayConverter{}))
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	ctx := context.Background()
+	for _, snap := range []*events.Ticket{
+		{ID: 1, QueueID: 3, Status: "processing", Version: 2},
+		{ID: 9, QueueID: 3, Status: "processing", Version: 4},
+	} {
+		fields, err := events.New(3, snap, events.Reserved{}).Fields()
+		assert.NoError(t, err)
+		assert.NoError(t, rdb.XAdd(ctx, &redis.XAddArgs{Stream: reconcileStream, Values: fields}).Err())
+	}
+	assert.NoError(t, rdb.XGroupCreate(ctx, reconcileStream, "workers", "0").Err())
+	assert.NoError(t, rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group: "workers", Consumer: "w1", Streams: []string{reconcileStream, ">"}, Count: 10,
+	}).Err())
+
+	service := services.NewTicketService(repositories.NewTicketRepo(db), bus.NewRedis(rdb), "queue.stream", "queue.%d.broadcast")
+	service.Reconcile = &policy
+	return service, mock, rdb
+}
+
+func expectUnleasedReservations(mock sqlmock.Sqlmock, tickets ...*models.Ticket) {
+	mock.ExpectQuery("lease_expires_at IS NULL").WithArgs(int64(3), 0.0, 3600.0).
+		WillReturnRows(ticketRows(tickets...))
+}
+
+func TestReconcileQueue_ReemitsMissingEntryAndAcksOrphans(t *testing.T) {
+	service, mock, rdb := newReconcileService(t, services.ReconcilePolicy{
+		Window: time.Hour, MissingEntry: services.MissingEntryReemit, AckOrphans: true,
+	})
+
+	reserved := time.Now().Add(-time.Minute)
+	expectUnleasedReservations(mock,
+		&models.Ticket{ID: 1, QueueID: 3, Status: "processing", CreatedAt: reserved, UpdatedAt: reserved, Version: 2},
+		&models.Ticket{ID: 2, QueueID: 3, Status: "processing", CreatedAt: reserved, UpdatedAt: reserved, Version: 5})
+	mock.ExpectQuery("id = ANY").WithArgs([]int64{1, 9}).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	report, err := service.ReconcileQueue(context.Background(), 3)
+	assert.NoError(t, err)
+	assert.Equal(t, &services.ReconcileReport{QueueID: 3, MissingEntries: 1, Reemitted: 1, Orphans: 1, Acked: 1}, report)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// ticket 2 got its entry, at the version the ticket already has
+	entries, err := rdb.XRange(context.Background(), reconcileStream, "-", "+").Result()
+	assert.NoError(t, err)
+	var reemitted *events.Envelope
+	for _, entry := range entries {
+		e, err := events.FromFields(entry.Values)
+		assert.NoError(t, err)
+		if e.Type == events.TicketReserved && e.TicketID() == 2 {
+			reemitted = e
+		}
+	}
+	if assert.NotNil(t, reemitted) {
+		assert.Equal(t, int64(5), reemitted.Ticket.Version)
+		assert.Equal(t, events.Reserved{Redelivery: true}, reemitted.Payload)
+	}
+	// only ticket 1's entry is still pending
+	pending, err := rdb.XPending(context.Background(), reconcileStream, "workers").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), pending.Count)
+}
+
+func TestReconcileQueue_RequeuesMissingEntry(t *testing.T) {
+	service, mock, rdb := newReconcileService(t, services.ReconcilePolicy{
+		Window: time.Hour, MissingEntry: services.MissingEntryRequeue,
+	})
+
+	reserved := time.Now().Add(-time.Minute)
+	expectUnleasedReservations(mock,
+		&models.Ticket{ID: 2, QueueID: 3, Status: "processing", CreatedAt: reserved, UpdatedAt: reserved, Version: 5})
+	mock.ExpectQuery("SET status='waiting'").WithArgs(int64(2), int64(5)).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(6))
+	mock.ExpectQuery("id = ANY").WithArgs([]int64{1, 9}).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	report, err := service.ReconcileQueue(context.Background(), 3)
+	assert.NoError(t, err)
+	// the orphan is reported but left pending without AckOrphans
+	assert.Equal(t, &services.ReconcileReport{QueueID: 3, MissingEntries: 1, Requeued: 1, Orphans: 1}, report)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	pending, err := rdb.XPending(context.Background(), reconcileStream, "workers").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), pending.Count)
+}
@@ -0,0 +1,172 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"queue-core/internal/api"
+	"queue-core/internal/bus"
+	"queue-core/internal/repositories"
+	"queue-core/internal/services"
+	"queue-core/pkg/events"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+var ticketEventCols = []string{"id", "ticket_id", "queue_id", "kind", "from_status", "to_status", "from_queue_id",
+	"version", "attempts", "available_at", "actor", "reason", "occurred_at",
+	"priority", "lane", "attributes", "group_id", "assigned_worker"}
+
+// expectEventLog serves the lifecycle of ticket 42 on queue 7 from the event
+// log, two rows per page.
+func expectEventLog(mock sqlmock.Sqlmock, from, to time.Time) {
+	at := time.Date(2025, 3, 14, 9, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("FROM ticket_events").WithArgs(int64(7), from, to, int64(0), 2).
+		WillReturnRows(sqlmock.NewRows(ticketEventCols).
+			AddRow(10, 42, 7, "created", nil, "waiting", nil, 1, 0, nil, "system", "", at, 2, "priority", []byte(`{"service":"visa"}`), nil, 0).
+			AddRow(11, 42, 7, "reserved", "waiting", "processing", nil, 2, 0, nil, "system", "", at.Add(time.Minute), 2, "priority", []byte(`{"service":"visa"}`), nil, 0))
+	mock.ExpectQuery("FROM ticket_events").WithArgs(int64(7), from, to, int64(11), 2).
+		WillReturnRows(sqlmock.NewRows(ticketEventCols).
+			AddRow(12, 42, 7, "done", "processing", "done", nil, 3, 0, nil, "worker:11", "", at.Add(2*time.Minute), 2, "priority", []byte(`{"service":"visa"}`), nil, 11))
+}
+
+func newReplayService(t *testing.T, rdb *redis.Client) (*services.TicketService, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	service := services.NewTicketService(repositories.NewTicketRepo(db), bus.NewRedis(rdb), "queue.stream", "queue.%d.broadcast")
+	service.Replays = services.ReplayPolicy{PageSize: 2, MaxRange: 24 * time.Hour}
+	return service, mock
+}
+
+func TestReplay_RebuildsEnvelopesFromLog(t *testing.T) {
+	service, mock := newReplayService(t, nil)
+	to := time.Date(2025, 3, 15, 0, 0, 0, 0, time.UTC)
+	from := to.Add(-24 * time.Hour)
+	req := services.ReplayRequest{QueueID: 7, From: from, To: to}
+
+	replay := func() []*events.Envelope {
+		expectEventLog(mock, from, to)
+		var got []*events.Envelope
+		n, err := service.Replay(context.Background(), req, func(e *events.Envelope) error {
+			got = append(got, e)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, n)
+		return got
+	}
+	first := replay()
+
+	assert.Equal(t, []events.Type{events.TicketCreated, events.TicketReserved, events.TicketCompleted},
+		[]events.Type{first[0].Type, first[1].Type, first[2].Type})
+	for _, e := range first {
+		assert.True(t, e.Replay)
+	}
+	worker := int64(11)
+	assert.Equal(t, events.Completed{WorkerID: &worker}, first[2].Payload)
+	assert.Equal(t, &events.Ticket{ID: 42, QueueID: 7, Status: "done", Version: 3, Priority: 2, Lane: "priority",
+		Attributes: map[string]any{"service": "visa"}, AssignedWorker: 11}, first[2].Ticket)
+
+	// replaying again yields the same ids, so consumers can dedupe
+	second := replay()
+	for i := range first {
+		assert.Equal(t, first[i].ID, second[i].ID)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReplay_FiltersTypesAndValidatesRange(t *testing.T) {
+	service, mock := newReplayService(t, nil)
+	to := time.Date(2025, 3, 15, 0, 0, 0, 0, time.UTC)
+	from := to.Add(-24 * time.Hour)
+
+	expectEventLog(mock, from, to)
+	var got []events.Type
+	n, err := service.Replay(context.Background(),
+		services.ReplayRequest{QueueID: 7, From: from, To: to, Types: []events.Type{events.TicketCompleted}},
+		func(e *events.Envelope) error {
+			got = append(got, e.Type)
+			return nil
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []events.Type{events.TicketCompleted}, got)
+
+	_, err = service.Replay(context.Background(), services.ReplayRequest{QueueID: 7, From: to, To: from}, nil)
+	assert.ErrorIs(t, err, services.ErrInvalidReplayRange)
+	_, err = service.Replay(context.Background(), services.ReplayRequest{QueueID: 7, From: from.Add(-time.Hour), To: to}, nil)
+	assert.ErrorIs(t, err, services.ErrInvalidReplayRange)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStartStreamReplay_WritesReplayStream(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	service, mock := newReplayService(t, rdb)
+	service.Replays.Rate = 20 // slow enough to still be running below
+	to := time.Date(2025, 3, 15, 0, 0, 0, 0, time.UTC)
+	from := to.Add(-24 * time.Hour)
+	req := services.ReplayRequest{QueueID: 7, From: from, To: to}
+
+	expectEventLog(mock, from, to)
+	stream, err := service.StartStreamReplay(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, "queue.stream.7.replay", stream)
+	_, err = service.StartStreamReplay(context.Background(), req)
+	assert.ErrorIs(t, err, services.ErrReplayInProgress)
+
+	assert.Eventually(t, func() bool {
+		n, _ := rdb.XLen(context.Background(), stream).Result()
+		return n == 3
+	}, 2*time.Second, 20*time.Millisecond)
+	entries, err := rdb.XRange(context.Background(), stream, "-", "+").Result()
+	assert.NoError(t, err)
+	e, err := events.FromFields(entries[2].Values)
+	assert.NoError(t, err)
+	assert.True(t, e.Replay)
+	assert.Equal(t, events.TicketCompleted, e.Type)
+
+	// the live queue stream is untouched
+	n, _ := rdb.Exists(context.Background(), "queue.stream.7").Result()
+	assert.Zero(t, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReplayEventsHandler_EndsFailedStreamWithErrorLine(t *testing.T) {
+	service, mock := newReplayService(t, nil)
+	a := api.NewAPI(service, nil, "queue.stream", "queue.%d.broadcast")
+	a.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	to := time.Date(2025, 3, 15, 0, 0, 0, 0, time.UTC)
+	from := to.Add(-24 * time.Hour)
+
+	at := time.Date(2025, 3, 14, 9, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("FROM ticket_events").WithArgs(int64(7), from, to, int64(0), 2).
+		WillReturnRows(sqlmock.NewRows(ticketEventCols).
+			AddRow(10, 42, 7, "created", nil, "waiting", nil, 1, 0, nil, "system", "", at, 1, "standard", nil, nil, 0).
+			AddRow(11, 42, 7, "reserved", "waiting", "processing", nil, 2, 0, nil, "system", "", at, 1, "standard", nil, nil, 0))
+	mock.ExpectQuery("FROM ticket_events").WithArgs(int64(7), from, to, int64(11), 2).
+		WillReturnError(errors.New("connection reset"))
+
+	req := httptest.NewRequest(http.MethodGet, "/queues/7/events?from="+from.Format(time.RFC3339)+"&to="+to.Format(time.RFC3339), nil)
+	req.RemoteAddr = "10.1.2.3:5000"
+	req.Header.Set("X-Actor-Role", "staff")
+	rec := httptest.NewRecorder()
+	a.Router().ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.JSONEq(t, `{"error":"failed replay"}`, lines[len(lines)-1])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -20,9 +20,9 @@ func TestTicketRepository_Create(t *testing.T) {
 
 	now := time.Now()
 	mock.ExpectQuery("INSERT INTO tickets").
-		WithArgs(1, "John Doe", "waiting", 1, 10).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at", "version"}).
-			AddRow(1, now, now, 1))
+		WithArgs(1, "John Doe", "waiting", 1, 10, nil, "", "", "{}", "", nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "lane", "created_at", "updated_at", "version"}).
+			AddRow(1, "standard", now, now, 1))
 
 	ticket := &models.Ticket{
 		QueueID:       1,
@@ -0,0 +1,185 @@
+package unit
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"queue-core/internal/actor"
+	"queue-core/internal/bus"
+	"queue-core/internal/models"
+	"queue-core/internal/repositories"
+	"queue-core/internal/services"
+	"queue-core/pkg/events"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRetentionService(t *testing.T, r *services.StreamRetention) (*services.TicketService, *redis.Client) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	service := services.NewTicketService(nil, bus.NewRedis(rdb), "queue.stream", "queue.%d.broadcast")
+	service.Retention = r
+	return service, rdb
+}
+
+// seedStream adds n entries to key, one second apart, ending an hour ago.
+func seedStream(t *testing.T, rdb *redis.Client, key string, n int) []string {
+	start := time.Now().Add(-time.Hour).Add(-time.Duration(n) * time.Second)
+	var ids []string
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("%d-0", start.Add(time.Duration(i)*time.Second).UnixMilli())
+		_, err := rdb.XAdd(context.Background(), &redis.XAddArgs{Stream: key, ID: id, Values: map[string]interface{}{"n": i}}).Result()
+		assert.NoError(t, err)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestParseRetentionPolicy(t *testing.T) {
+	p, err := services.ParseRetentionPolicy("maxlen:1000, age:168h")
+	assert.NoError(t, err)
+	assert.Equal(t, services.RetentionPolicy{MaxLen: 1000, MaxAge: 168 * time.Hour}, p)
+
+	p, err = services.ParseRetentionPolicy("")
+	assert.NoError(t, err)
+	assert.False(t, p.Enabled())
+
+	for _, bad := range []string{"maxlen:lots", "age:1week", "size:10mb", "maxlen:-1"} {
+		_, err := services.ParseRetentionPolicy(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+// trimRecorder records the trim of every publish, by stream.
+type trimRecorder struct {
+	bus.EventBus
+	trims map[string][]bus.Trim
+}
+
+func (r *trimRecorder) Publish(ctx context.Context, stream string, fields map[string]interface{}, trim bus.Trim) (string, error) {
+	r.trims[stream] = append(r.trims[stream], trim)
+	return r.EventBus.Publish(ctx, stream, fields, trim)
+}
+
+func TestRetention_TrimsOnAddWithoutArchiver(t *testing.T) {
+	rec := &trimRecorder{EventBus: bus.NewMemory(), trims: map[string][]bus.Trim{}}
+	service := services.NewTicketService(repositories.NewMemoryTicketRepo(), rec, "queue.stream", "queue.%d.broadcast")
+	service.Retention = &services.StreamRetention{
+		Default: services.RetentionPolicy{MaxLen: 5},
+		Streams: map[string]services.RetentionPolicy{string(services.StreamWorkerUpdates): {MaxAge: time.Hour}},
+	}
+	ctx := actor.WithContext(context.Background(), actor.Actor{Role: actor.RoleStaff})
+
+	id, err := service.CreateTicket(ctx, &models.Ticket{QueueID: 7, CustomerName: "Ada"})
+	assert.NoError(t, err)
+	assert.NoError(t, service.CancelTicket(ctx, id, "", models.ReasonNoShow))
+	assert.NoError(t, service.PublishWorkerUpdate(ctx, 7, id, events.WorkerUpdate{WorkerID: 11}))
+
+	assert.Equal(t, []bus.Trim{{MaxLen: 5}}, rec.trims["queue.stream"])
+	if assert.Len(t, rec.trims["queue.stream.worker.updates.7"], 1) {
+		assert.NotEmpty(t, rec.trims["queue.stream.worker.updates.7"][0].MinID)
+	}
+	// consumer groups and the reconciler read queue streams: only the job trims them
+	assert.Equal(t, []bus.Trim{{}}, rec.trims["queue.stream.7"])
+
+	// with an archiver nothing trims on publish
+	service.Retention.Archiver = &services.FileArchiver{Dir: t.TempDir()}
+	assert.NoError(t, service.PublishWorkerUpdate(ctx, 7, id, events.WorkerUpdate{WorkerID: 11}))
+	assert.Equal(t, bus.Trim{}, rec.trims["queue.stream.worker.updates.7"][1])
+}
+
+func TestEnforceRetention_KeepsReconcileWindow(t *testing.T) {
+	service, rdb := newRetentionService(t, &services.StreamRetention{Default: services.RetentionPolicy{MaxLen: 4}})
+	service.Reconcile = &services.ReconcilePolicy{Grace: 30 * time.Second, Window: 2 * time.Hour}
+	seedStream(t, rdb, "queue.stream.7", 10)                  // an hour old: inside the window
+	replay := seedStream(t, rdb, "queue.stream.7.replay", 10) // not reconciled
+
+	trimmed, err := service.EnforceRetention(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"queue.stream.7.replay": 6}, trimmed)
+	left, err := rdb.XRange(context.Background(), "queue.stream.7.replay", "-", "+").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, replay[6], left[0].ID)
+
+	// once the window has passed them, the queue stream is trimmed too
+	service.Reconcile.Window = 30 * time.Minute
+	trimmed, err = service.EnforceRetention(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"queue.stream.7": 6}, trimmed)
+}
+
+func TestEnforceRetention_ArchivesBeforeTrimming(t *testing.T) {
+	dir := t.TempDir()
+	service, rdb := newRetentionService(t, &services.StreamRetention{
+		Default: services.RetentionPolicy{MaxLen: 4},
+		Streams: map[string]services.RetentionPolicy{
+			"queue.stream.8":              {MaxAge: 24 * time.Hour}, // exact key beats the default
+			string(services.StreamReplay): {},
+		},
+		Archiver: &services.FileArchiver{Dir: dir},
+	})
+	ids := seedStream(t, rdb, "queue.stream.7", 10)
+	seedStream(t, rdb, "queue.stream.8", 10)
+	seedStream(t, rdb, "queue.stream.7.replay", 10)
+
+	trimmed, err := service.EnforceRetention(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"queue.stream.7": 6}, trimmed)
+
+	left, err := rdb.XRange(context.Background(), "queue.stream.7", "-", "+").Result()
+	assert.NoError(t, err)
+	assert.Len(t, left, 4)
+	assert.Equal(t, ids[6], left[0].ID)
+
+	files, err := filepath.Glob(filepath.Join(dir, "queue.stream.7", "*.jsonl.gz"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "queue.stream.7", ids[0]+"_"+ids[5]+".jsonl.gz")}, files)
+	f, err := os.Open(files[0])
+	assert.NoError(t, err)
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	assert.NoError(t, err)
+	lines := 0
+	for sc := bufio.NewScanner(zr); sc.Scan(); {
+		lines++
+	}
+	assert.Equal(t, 6, lines)
+
+	// nothing more is due
+	trimmed, err = service.EnforceRetention(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, trimmed)
+}
+
+func TestEnforceRetention_KeepsEntriesOwedToConsumerGroups(t *testing.T) {
+	service, rdb := newRetentionService(t, &services.StreamRetention{
+		Default: services.RetentionPolicy{MaxAge: time.Minute},
+	})
+	ctx := context.Background()
+	ids := seedStream(t, rdb, "queue.stream.7", 10)
+	assert.NoError(t, rdb.XGroupCreate(ctx, "queue.stream.7", "workers", "0").Err())
+	_, err := rdb.XReadGroup(ctx, &redis.XReadGroupArgs{Group: "workers", Consumer: "w1",
+		Streams: []string{"queue.stream.7", ">"}, Count: 5}).Result()
+	assert.NoError(t, err)
+	assert.NoError(t, rdb.XAck(ctx, "queue.stream.7", "workers", ids[0], ids[1]).Err())
+
+	// every entry is older than a minute, but entries 2..4 are pending and
+	// 5..9 were never delivered
+	trimmed, err := service.EnforceRetention(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"queue.stream.7": 2}, trimmed)
+
+	assert.NoError(t, rdb.XAck(ctx, "queue.stream.7", "workers", ids[2], ids[3], ids[4]).Err())
+	trimmed, err = service.EnforceRetention(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"queue.stream.7": 3}, trimmed)
+}
@@ -0,0 +1,111 @@
+package unit
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"queue-core/internal/bus"
+	"queue-core/internal/models"
+	"queue-core/internal/repositories"
+	"queue-core/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRoutingService(t *testing.T) (*services.TicketService, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(arrayConverter{}))
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	rdb := redis.NewClient(&redis.Options{Addr: "localhost:99999", MaxRetries: -1})
+	t.Cleanup(func() { rdb.Close() })
+
+	service := services.NewTicketService(repositories.NewTicketRepo(db), bus.NewRedis(rdb), "queue.stream", "queue.%d.broadcast")
+	service.Queues = repositories.NewQueueRepo(db)
+	return service, mock
+}
+
+func TestCreateTicket_RoutesGroupTicketToLowestProjectedWait(t *testing.T) {
+	service, mock := newRoutingService(t)
+
+	mock.ExpectQuery("FROM queue_groups").WithArgs(int64(5)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(5, "Passports"))
+	mock.ExpectQuery("FROM queue_group_members").WithArgs(int64(5)).
+		WillReturnRows(sqlmock.NewRows([]string{"queue_id"}).AddRow(1).AddRow(2).AddRow(3))
+	// projected: 1 -> 6x120=720; 2 -> 2x90 (group average)=180; 3 -> 3x60=180
+	mock.ExpectQuery(`unnest\(\$1::bigint\[\]\)`).WithArgs([]int64{1, 2, 3}, 86400.0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "waiting", "avg"}).
+			AddRow(1, 6, 120.0).
+			AddRow(2, 2, 0.0).
+			AddRow(3, 3, 60.0))
+	mock.ExpectQuery("FROM priority_classes").WithArgs(int64(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"queue_id", "name", "priority", "lane", "assignable_by"}))
+	mock.ExpectQuery("FROM queue_lanes").WithArgs(int64(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "weight"}))
+	mock.ExpectQuery("FROM queue_intake_fields").WithArgs(int64(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}))
+	group := int64(5)
+	mock.ExpectQuery("INSERT INTO tickets").
+		WithArgs(int64(2), "Ana", "waiting", 1, 0, nil, "", "standard", "{}", sqlmock.AnyArg(), &group, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "lane", "created_at", "updated_at", "version"}).
+			AddRow(70, "standard", time.Now(), time.Now(), 1))
+
+	ticket := &models.Ticket{GroupID: &group, CustomerName: "Ana"}
+	id, err := service.CreateTicket(context.Background(), ticket)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(70), id)
+	// 2 and 3 tie; the lower queue id wins
+	assert.Equal(t, int64(2), ticket.QueueID)
+	if assert.NotNil(t, ticket.Routing) {
+		assert.Equal(t, int64(5), ticket.Routing.GroupID)
+		assert.Equal(t, int64(2), ticket.Routing.QueueID)
+		if assert.Len(t, ticket.Routing.Candidates, 3) {
+			c := ticket.Routing.Candidates
+			assert.Equal(t, []int64{2, 3, 1}, []int64{c[0].QueueID, c[1].QueueID, c[2].QueueID})
+			assert.Equal(t, 90.0, c[0].AvgServiceSeconds)
+			assert.Equal(t, 720.0, c[2].ProjectedWaitSeconds)
+		}
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateTicket_UnknownQueueGroup(t *testing.T) {
+	service, mock := newRoutingService(t)
+
+	mock.ExpectQuery("FROM queue_groups").WithArgs(int64(9)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+
+	group := int64(9)
+	_, err := service.CreateTicket(context.Background(), &models.Ticket{GroupID: &group, CustomerName: "Ana"})
+	assert.ErrorIs(t, err, services.ErrUnknownQueueGroup)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSaveGroup_UpdatesOnlyExistingGroups(t *testing.T) {
+	service, mock := newRoutingService(t)
+
+	// an unknown id must not be inserted behind the id sequence's back
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE queue_groups").WithArgs(int64(42), "Passports").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	err := service.Queues.SaveGroup(context.Background(), &models.QueueGroup{ID: 42, Name: "Passports", QueueIDs: []int64{1}})
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListIDs_IncludesGroupMembersWithoutQueueRows(t *testing.T) {
+	service, mock := newRoutingService(t)
+
+	// queue 5 is only a group member, but routed tickets still need a dispatcher
+	mock.ExpectQuery("SELECT queue_id FROM queue_group_members").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2).AddRow(5))
+
+	ids, err := service.Queues.ListIDs(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1, 2, 5}, ids)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"testing"
 	"time"
+	"queue-core/internal/actor"
+	"queue-core/internal/bus"
 	"queue-core/internal/models"
 	"queue-core/internal/repositories"
 	"queue-core/internal/services"
@@ -28,7 +30,7 @@ func TestTicketService_CreateTicket(t *testing.T) {
 	})
 	defer rdb.Close()
 
-	service := services.NewTicketService(repo, rdb, "queue.stream", "queue.%d.broadcast")
+	service := services.NewTicketService(repo, bus.NewRedis(rdb), "queue.stream", "queue.%d.broadcast")
 
 	ticket := &models.Ticket{
 		QueueID:       1,
@@ -40,9 +42,9 @@ func TestTicketService_CreateTicket(t *testing.T) {
 
 	// Expect database INSERT
 	dbMock.ExpectQuery("INSERT INTO tickets").
-		WithArgs(ticket.QueueID, ticket.CustomerName, ticket.Status, ticket.Priority, ticket.EstimatedTime).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at", "version"}).
-			AddRow(1, time.Now(), time.Now(), 1))
+		WithArgs(ticket.QueueID, ticket.CustomerName, ticket.Status, ticket.Priority, ticket.EstimatedTime, nil, "", "", "{}", sqlmock.AnyArg(), nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "lane", "created_at", "updated_at", "version"}).
+			AddRow(1, "standard", time.Now(), time.Now(), 1))
 
 	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
 	defer cancel()
@@ -56,3 +58,45 @@ func TestTicketService_CreateTicket(t *testing.T) {
 	// Verify database expectations were met
 	assert.NoError(t, dbMock.ExpectationsWereMet())
 }
+
+func TestTicketService_CompleteTicketRequiresStaffOrTicketWorker(t *testing.T) {
+	repo := repositories.NewMemoryTicketRepo()
+	service := services.NewTicketService(repo, bus.NewMemory(), "queue.stream", "queue.%d.broadcast")
+	ctx := context.Background()
+	as := func(role actor.Role, id string) context.Context {
+		return actor.WithContext(ctx, actor.Actor{Role: role, ID: id})
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := service.CreateTicket(ctx, &models.Ticket{QueueID: 7})
+		assert.NoError(t, err)
+		_, err = repo.Reserve(ctx, 7, repositories.ReserveOptions{Lease: time.Minute, WorkerID: 4})
+		assert.NoError(t, err)
+	}
+
+	_, err := service.CompleteTicket(ctx, 1, 2, nil, nil)
+	assert.ErrorIs(t, err, services.ErrNotTicketWorker, "kiosks complete nothing")
+	_, err = service.CompleteTicket(as(actor.RoleWorker, "5"), 1, 2, nil, nil)
+	assert.ErrorIs(t, err, services.ErrNotTicketWorker, "not leased to worker 5")
+	four := int64(4)
+	ok, err := service.CompleteTicket(as(actor.RoleWorker, "4"), 1, 2, nil, &four)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = service.CompleteTicket(as(actor.RoleStaff, "s1"), 2, 2, nil, nil)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// a dispatcher reservation names no worker, so no worker may complete it
+	_, err = service.CreateTicket(ctx, &models.Ticket{QueueID: 7})
+	assert.NoError(t, err)
+	_, err = repo.Reserve(ctx, 7, repositories.ReserveOptions{})
+	assert.NoError(t, err)
+	_, err = service.CompleteTicket(as(actor.RoleWorker, "4"), 3, 2, nil, &four)
+	assert.ErrorIs(t, err, services.ErrNotTicketWorker, "not assigned to any worker")
+	ok, err = service.CompleteTicket(actor.WithContext(ctx, actor.System), 3, 2, nil, nil)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	_, err = service.CompleteTicket(as(actor.RoleStaff, "s1"), 99, 1, nil, nil)
+	assert.ErrorIs(t, err, services.ErrTicketNotFound)
+}
@@ -0,0 +1,123 @@
+package unit
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"queue-core/internal/models"
+	"queue-core/internal/repositories"
+	"queue-core/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// arrayConverter lets sqlmock accept the []string and []int64 args pgx sends
+// as text[] and bigint[].
+type arrayConverter struct{}
+
+func (arrayConverter) ConvertValue(v any) (driver.Value, error) {
+	switch v.(type) {
+	case []string, []int64:
+		return v, nil
+	}
+	return driver.DefaultParameterConverter.ConvertValue(v)
+}
+
+func TestDispatchStrategies(t *testing.T) {
+	type call struct {
+		sqlPattern string
+		args       []driver.Value
+		lane       string // lane of the ticket returned
+	}
+
+	tests := []struct {
+		name     string
+		strategy string
+		config   string
+		calls    []call
+		wantErr  bool
+	}{
+		{
+			name:     "fifo orders by arrival",
+			strategy: services.StrategyFIFO,
+			calls:    []call{{sqlPattern: `available_at <= NOW\(\)\)\s+ORDER BY created_at ASC`, args: []driver.Value{1}}},
+		},
+		{
+			name:     "priority orders by priority then arrival",
+			strategy: services.StrategyPriority,
+			calls:    []call{{sqlPattern: `ORDER BY priority DESC, created_at ASC`, args: []driver.Value{1}}},
+		},
+		{
+			name:     "shortest job puts unknown estimates last",
+			strategy: services.StrategyShortestJob,
+			calls:    []call{{sqlPattern: `ORDER BY NULLIF\(estimated_time, 0\) ASC NULLS LAST, created_at ASC`, args: []driver.Value{1}}},
+		},
+		{
+			name:     "weighted fair follows the call-out pattern",
+			strategy: services.StrategyWeightedFair,
+			config:   `{"lanes": {"standard": 2, "priority": 1}}`,
+			calls: []call{
+				{sqlPattern: `array_position\(\$2::text\[\], lane\)`, args: []driver.Value{1, []string{"standard", "priority"}}, lane: "standard"},
+				{sqlPattern: `array_position\(\$2::text\[\], lane\)`, args: []driver.Value{1, []string{"priority", "standard"}}, lane: "priority"},
+				{sqlPattern: `array_position\(\$2::text\[\], lane\)`, args: []driver.Value{1, []string{"standard", "priority"}}, lane: "standard"},
+			},
+		},
+		{
+			name:     "weighted fair without lanes is fifo",
+			strategy: services.StrategyWeightedFair,
+			calls:    []call{{sqlPattern: `available_at <= NOW\(\)\)\s+ORDER BY created_at ASC`, args: []driver.Value{1}}},
+		},
+		{
+			name:     "skill match filters on the configured attribute",
+			strategy: services.StrategySkillMatch,
+			config:   `{"skills": ["visa", "passport"], "attribute": "service"}`,
+			calls: []call{{
+				sqlPattern: `COALESCE\(attributes->>\$2, ''\) = '' OR attributes->>\$2 = ANY\(\$3::text\[\]\)`,
+				args:       []driver.Value{1, "service", []string{"visa", "passport"}},
+			}},
+		},
+		{name: "skill match requires skills", strategy: services.StrategySkillMatch, wantErr: true},
+		{name: "unknown strategy", strategy: "lottery", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy, err := services.NewDispatchStrategy(tt.strategy, json.RawMessage(tt.config))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.strategy, strategy.Name())
+
+			db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(arrayConverter{}))
+			assert.NoError(t, err)
+			defer db.Close()
+			repo := repositories.NewTicketRepo(db)
+
+			now := time.Now()
+			for i, c := range tt.calls {
+				lane := c.lane
+				if lane == "" {
+					lane = models.DefaultLane
+				}
+				mock.ExpectBegin()
+				mock.ExpectQuery(c.sqlPattern).WithArgs(c.args...).
+					WillReturnRows(ticketRows(&models.Ticket{ID: int64(i + 1), QueueID: 1, Status: "waiting", Lane: lane, CreatedAt: now, UpdatedAt: now, Version: 1}))
+				mock.ExpectExec("SET status='processing'").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			}
+
+			for i := range tt.calls {
+				ticket, err := strategy.Next(context.Background(), repo, 1)
+				assert.NoError(t, err)
+				assert.Equal(t, int64(i+1), ticket.ID)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
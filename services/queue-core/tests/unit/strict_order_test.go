@@ -0,0 +1,66 @@
+package unit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"queue-core/internal/bus"
+	"queue-core/internal/models"
+	"queue-core/internal/repositories"
+	"queue-core/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// queueHeadLockClass mirrors the repository's strict-order lock class.
+const queueHeadLockClass = 0x68656164
+
+func TestReserve_StrictLocksQueueHeadAndWaitsForLockedRows(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	repo := repositories.NewTicketRepo(db)
+
+	now := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectExec("pg_advisory_xact_lock").WithArgs(queueHeadLockClass, 7).WillReturnResult(sqlmock.NewResult(0, 0))
+	// plain FOR UPDATE: never skip past a locked head
+	mock.ExpectQuery(`ORDER BY created_at ASC, id ASC\s+FOR UPDATE\s+LIMIT 1`).WithArgs(7).
+		WillReturnRows(ticketRows(&models.Ticket{ID: 1, QueueID: 7, Status: "waiting", CreatedAt: now, UpdatedAt: now, Version: 1}))
+	mock.ExpectExec("SET status='processing'").WithArgs(int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	got, err := repo.Reserve(context.Background(), 7, repositories.ReserveOptions{Strict: true})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), got.ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStartDispatcher_UsesQueueStrictOrder(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	rdb := redis.NewClient(&redis.Options{Addr: "localhost:99999", MaxRetries: -1})
+	defer rdb.Close()
+
+	service := services.NewTicketService(repositories.NewTicketRepo(db), bus.NewRedis(rdb), "queue.stream", "queue.%d.broadcast")
+	service.Queues = repositories.NewQueueRepo(db)
+
+	now := time.Now()
+	mock.ExpectQuery("FROM queues").WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "max_active_per_customer", "dispatch_strategy", "dispatch_config", "strict_order", "dispatch_mode", "created_at", "updated_at"}).
+			AddRow(7, "Licensing", 0, "fifo", []byte("{}"), true, "push", now, now))
+	mock.ExpectBegin()
+	mock.ExpectExec("pg_advisory_xact_lock").WithArgs(queueHeadLockClass, 7).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`FOR UPDATE\s+LIMIT 1`).WithArgs(7).WillReturnRows(ticketRows())
+	mock.ExpectRollback()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	service.StartDispatcher(ctx, 7, time.Hour)
+
+	assert.Eventually(t, func() bool { return mock.ExpectationsWereMet() == nil }, 2*time.Second, 10*time.Millisecond)
+}
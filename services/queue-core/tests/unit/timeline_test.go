@@ -0,0 +1,80 @@
+package unit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"queue-core/internal/actor"
+	"queue-core/internal/models"
+	"queue-core/internal/repositories"
+	"queue-core/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeline(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	service := services.NewTicketService(repositories.NewTicketRepo(db), nil, "queue.stream", "queue.%d.broadcast")
+
+	cols := []string{"id", "ticket_id", "queue_id", "kind", "from_status", "to_status", "from_queue_id",
+		"version", "attempts", "available_at", "actor", "reason", "occurred_at",
+		"priority", "lane", "attributes", "group_id", "assigned_worker"}
+	now := time.Now()
+	mock.ExpectQuery("FROM ticket_events").WithArgs(int64(42)).WillReturnRows(sqlmock.NewRows(cols).
+		AddRow(1, 42, 7, "created", nil, "waiting", nil, 1, 0, nil, "system", "", now, 1, "standard", nil, nil, 0).
+		AddRow(2, 42, 7, "reserved", "waiting", "processing", nil, 2, 0, nil, "system", "", now, 1, "standard", nil, nil, 0).
+		AddRow(3, 42, 7, "cancelled", "processing", "cancelled", nil, 3, 0, nil, "staff", "no_show", now, 1, "standard", nil, nil, 0))
+
+	timeline, err := service.Timeline(context.Background(), 42)
+	assert.NoError(t, err)
+	assert.Len(t, timeline, 3)
+	assert.Nil(t, timeline[0].FromStatus)
+	assert.Equal(t, "waiting", *timeline[1].FromStatus)
+	assert.Equal(t, "staff", timeline[2].Actor)
+	assert.Equal(t, "no_show", timeline[2].Reason)
+
+	// a ticket that never existed has no events
+	mock.ExpectQuery("FROM ticket_events").WithArgs(int64(43)).WillReturnRows(sqlmock.NewRows(cols))
+	_, err = service.Timeline(context.Background(), 43)
+	assert.ErrorIs(t, err, services.ErrTicketNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTicketRepo_NamesActorForTimeline(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+	repo := repositories.NewTicketRepo(db)
+	ctx := actor.WithContext(context.Background(), actor.Actor{Role: actor.RoleWorker, ID: "11"})
+
+	mock.ExpectBegin()
+	mock.ExpectExec("set_config\\('queue_core.actor'").WithArgs("worker:11").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SET status='done'").WithArgs(int64(5), int64(2), nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+	mock.ExpectCommit()
+	ok, version, err := repo.Complete(ctx, 5, 2, nil, nil)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), version)
+
+	// a kiosk is named like any other actor
+	kiosk := actor.WithContext(context.Background(), actor.Actor{Role: actor.RoleKiosk})
+	mock.ExpectBegin()
+	mock.ExpectExec("set_config\\('queue_core.actor'").WithArgs("kiosk").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO tickets").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "lane", "created_at", "updated_at", "version"}).
+			AddRow(7, "standard", time.Now(), time.Now(), 1))
+	mock.ExpectCommit()
+	assert.NoError(t, repo.Create(kiosk, &models.Ticket{QueueID: 7, Status: "waiting"}))
+
+	// a caller that declares no actor leaves the trigger to infer it
+	mock.ExpectQuery("SET status='done'").WithArgs(int64(6), int64(2), nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+	_, _, err = repo.Complete(context.Background(), 6, 2, nil, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,218 @@
+package unit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"queue-core/internal/models"
+	"queue-core/internal/repositories"
+	"queue-core/internal/services"
+	"queue-core/pkg/events"
+	"queue-core/pkg/webhook"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	subscriptionCols = []string{"id", "url", "event_types", "queue_ids", "secret", "enabled", "consecutive_failures",
+		"disabled_at", "disabled_reason", "created_at", "updated_at"}
+	deliveryCols = []string{"id", "subscription_id", "event_id", "event_type", "body", "status", "attempts",
+		"next_attempt_at", "last_status_code", "last_error", "created_at", "delivered_at", "url", "secret"}
+)
+
+func newWebhookService(t *testing.T) (*services.WebhookService, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(arrayConverter{}))
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	svc := services.NewWebhookService(repositories.NewWebhookRepo(db))
+	svc.Policy = services.WebhookPolicy{MaxAttempts: 3, BaseDelay: 10 * time.Second, MaxDelay: time.Minute,
+		DisableAfter: 5, Timeout: time.Second, BatchSize: 10}
+	svc.Resolver = fakeResolver{"crm.example.org": "203.0.113.10", "internal.example.org": "10.1.2.3"}
+	// the httptest receivers listen on loopback
+	svc.AllowPrivate = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+	return svc, mock
+}
+
+// fakeResolver resolves the hosts the tests use without DNS.
+type fakeResolver map[string]string
+
+func (f fakeResolver) LookupNetIP(_ context.Context, _, host string) ([]netip.Addr, error) {
+	addr, ok := f[host]
+	if !ok {
+		return nil, fmt.Errorf("no such host %q", host)
+	}
+	return []netip.Addr{netip.MustParseAddr(addr)}, nil
+}
+
+// expectClaim hands out one delivery to url on its given attempt.
+func expectClaim(mock sqlmock.Sqlmock, url string, attempts int) {
+	mock.ExpectQuery("UPDATE webhook_deliveries d").WithArgs(10, 2.0).
+		WillReturnRows(sqlmock.NewRows(deliveryCols).AddRow(77, 3, "evt-1", "ticket.completed", `{"id":"evt-1"}`,
+			"pending", attempts, time.Now(), nil, "", time.Now(), nil, url, "s3cret"))
+}
+
+func TestWebhookService_DeliversSignedRequest(t *testing.T) {
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	got := make(chan received, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got <- received{r.Header, b}
+	}))
+	defer receiver.Close()
+
+	svc, mock := newWebhookService(t)
+	expectClaim(mock, receiver.URL, 1)
+	mock.ExpectBegin()
+	mock.ExpectExec("SET status='delivered'").WithArgs(int64(77), 200).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("SET consecutive_failures=0").WithArgs(int64(3)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	n, err := svc.DeliverDue(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	r := <-got
+	assert.Equal(t, `{"id":"evt-1"}`, string(r.body))
+	assert.Equal(t, "evt-1", r.header.Get(webhook.EventIDHeader))
+	assert.Equal(t, "ticket.completed", r.header.Get(webhook.EventTypeHeader))
+	assert.Equal(t, "77", r.header.Get(webhook.DeliveryHeader))
+	assert.NoError(t, webhook.Verify("s3cret", r.header.Get(webhook.SignatureHeader), r.body, 0, time.Now()))
+	assert.ErrorIs(t, webhook.Verify("wrong", r.header.Get(webhook.SignatureHeader), r.body, 0, time.Now()), webhook.ErrBadSignature)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWebhookService_FailedAttempts(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	tests := []struct {
+		name     string
+		attempts int
+		retryIn  any // seconds; nil fails the delivery for good
+		disabled bool
+	}{
+		{name: "first failure retries after base delay", attempts: 1, retryIn: 10.0},
+		{name: "backoff doubles per attempt", attempts: 2, retryIn: 20.0},
+		{name: "out of attempts", attempts: 3, retryIn: nil},
+		{name: "failing endpoint is disabled", attempts: 1, retryIn: 10.0, disabled: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, mock := newWebhookService(t)
+			expectClaim(mock, receiver.URL, tt.attempts)
+			mock.ExpectBegin()
+			mock.ExpectExec("UPDATE webhook_deliveries").
+				WithArgs(int64(77), http.StatusServiceUnavailable, "Service Unavailable", tt.retryIn).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery("UPDATE webhook_subscriptions").WithArgs(int64(3), 5).
+				WillReturnRows(sqlmock.NewRows([]string{"disabled"}).AddRow(tt.disabled))
+			mock.ExpectCommit()
+
+			_, err := svc.DeliverDue(context.Background())
+			assert.NoError(t, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestWebhookService_EnqueueMatchesFilters(t *testing.T) {
+	svc, mock := newWebhookService(t)
+	now := time.Now()
+	mock.ExpectQuery("FROM webhook_subscriptions").WillReturnRows(sqlmock.NewRows(subscriptionCols).
+		AddRow(1, "http://a", `[]`, `[]`, "x", true, 0, nil, "", now, now).
+		AddRow(2, "http://b", `["ticket.completed"]`, `[7]`, "x", true, 0, nil, "", now, now).
+		AddRow(3, "http://c", `["ticket.cancelled"]`, `[]`, "x", true, 0, nil, "", now, now).
+		AddRow(4, "http://d", `[]`, `[8]`, "x", true, 0, nil, "", now, now).
+		AddRow(5, "http://e", `[]`, `[]`, "x", false, 20, now, "disabled", now, now))
+	e := events.New(7, &events.Ticket{ID: 42, QueueID: 7}, events.Completed{})
+	mock.ExpectExec("INSERT INTO webhook_deliveries").
+		WithArgs([]int64{1, 2}, e.ID, "ticket.completed", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	assert.NoError(t, svc.Enqueue(context.Background(), e))
+
+	// the subscription list is cached between events
+	cancelled := events.New(9, &events.Ticket{ID: 43, QueueID: 9}, events.Cancelled{})
+	mock.ExpectExec("INSERT INTO webhook_deliveries").
+		WithArgs([]int64{1, 3}, cancelled.ID, "ticket.cancelled", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	assert.NoError(t, svc.Enqueue(context.Background(), cancelled))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWebhookService_CreateValidates(t *testing.T) {
+	svc, mock := newWebhookService(t)
+
+	err := svc.Create(context.Background(), &models.WebhookSubscription{URL: "ftp://crm.example.org"})
+	assert.ErrorIs(t, err, services.ErrInvalidWebhookURL)
+	err = svc.Create(context.Background(), &models.WebhookSubscription{URL: "https://crm.example.org", EventTypes: []string{"ticket.teleported"}})
+	assert.ErrorIs(t, err, services.ErrUnknownWebhookEventType)
+
+	mock.ExpectQuery("INSERT INTO webhook_subscriptions").
+		WithArgs("https://crm.example.org", `["ticket.completed"]`, `[]`, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(1, time.Now(), time.Now()))
+	sub := &models.WebhookSubscription{URL: "https://crm.example.org", EventTypes: []string{"ticket.completed"}}
+	assert.NoError(t, svc.Create(context.Background(), sub))
+	assert.Len(t, sub.Secret, 64)
+	assert.True(t, sub.Enabled)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWebhookService_RefusesInternalTargets(t *testing.T) {
+	svc, mock := newWebhookService(t)
+
+	for _, u := range []string{
+		"http://169.254.169.254/latest/meta-data",
+		"http://10.0.0.7/hook",
+		"https://internal.example.org/hook",
+		"http://[::1]:8080/hook",
+		"http://100.100.100.200/",
+	} {
+		err := svc.Create(context.Background(), &models.WebhookSubscription{URL: u})
+		assert.ErrorIs(t, err, services.ErrPrivateWebhookTarget, u)
+	}
+	err := svc.Create(context.Background(), &models.WebhookSubscription{URL: "https://nowhere.example.org"})
+	assert.ErrorIs(t, err, services.ErrInvalidWebhookURL, "unresolvable hosts are refused")
+
+	// a host that resolved publicly at subscribe time is checked again on dial
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached a loopback receiver")
+	}))
+	defer receiver.Close()
+	svc.AllowPrivate = nil
+	expectClaim(mock, receiver.URL, 1)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE webhook_deliveries").
+		WithArgs(int64(77), nil, sqlmock.AnyArg(), 10.0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("UPDATE webhook_subscriptions").WithArgs(int64(3), 5).
+		WillReturnRows(sqlmock.NewRows([]string{"disabled"}).AddRow(false))
+	mock.ExpectCommit()
+	_, err = svc.DeliverDue(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWebhookSignature_RejectsStaleAndTampered(t *testing.T) {
+	body := []byte(`{"id":"evt-1"}`)
+	sent := time.Date(2025, 3, 14, 9, 0, 0, 0, time.UTC)
+	sig := webhook.Sign("s3cret", sent, body)
+
+	assert.NoError(t, webhook.Verify("s3cret", sig, body, 0, sent.Add(time.Minute)))
+	assert.ErrorIs(t, webhook.Verify("s3cret", sig, body, 0, sent.Add(time.Hour)), webhook.ErrStaleSignature)
+	assert.ErrorIs(t, webhook.Verify("s3cret", sig, []byte(`{"id":"evt-2"}`), 0, sent), webhook.ErrBadSignature)
+	assert.ErrorIs(t, webhook.Verify("s3cret", "", body, 0, sent), webhook.ErrMissingSignature)
+}